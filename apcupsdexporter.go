@@ -4,9 +4,9 @@ package apcupsdexporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"time"
+	"net"
 
 	"github.com/mdlayher/apcupsd"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,7 +26,8 @@ const (
 // It implements the prometheus.Collector interface in order to register
 // with Prometheus.
 type Exporter struct {
-	clientFn ClientFunc
+	c  *UPSCollector
+	ss ContextStatusSource
 }
 
 var _ prometheus.Collector = &Exporter{}
@@ -36,60 +37,128 @@ var _ prometheus.Collector = &Exporter{}
 // can be short-lived and less likely to time out or fail.
 type ClientFunc func(ctx context.Context) (*apcupsd.Client, error)
 
+// NewClientFunc creates a ClientFunc which dials apcupsd at addr on network.
+// Unlike apcupsd.DialContext, the context passed to the ClientFunc bounds not
+// only the dial but also all I/O performed by the returned client, so that a
+// hung apcupsd is disconnected when a collection times out.
+//
+// Typically, network will be one of: "tcp", "tcp4", or "tcp6".
+func NewClientFunc(network, addr string) ClientFunc {
+	return func(ctx context.Context) (*apcupsd.Client, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// The binding is released when ctx is done.
+		_ = bindConn(ctx, c)
+
+		return apcupsd.New(c), nil
+	}
+}
+
 // New creates a new Exporter which collects metrics by creating a apcupsd
-// client using the input ClientFunc.
-func New(fn ClientFunc) *Exporter {
+// client using the input ClientFunc.  Options are applied to the collectors
+// created by the Exporter.
+func New(fn ClientFunc, opts ...Option) *Exporter {
+	return newExporter(&clientSource{fn: fn}, newOptions(opts))
+}
+
+// NewWithDialFunc is like New, but collects metrics by speaking the NIS
+// protocol over connections created using the input DialFunc.  Unlike New,
+// the Exporter can also retrieve the raw status using RawStatus.
+func NewWithDialFunc(fn DialFunc, opts ...Option) *Exporter {
+	o := newOptions(opts)
+	return newExporter(&dialSource{dial: fn, loc: o.location}, o)
+}
+
+// newExporter creates an Exporter which retrieves the status from ss.
+func newExporter(ss ContextStatusSource, o *options) *Exporter {
+	// The collectors are created once and reused for each scrape, so that
+	// their descriptors and cached label pairs need not be rebuilt.
 	return &Exporter{
-		clientFn: fn,
+		c:  newUPSCollector(ss, o),
+		ss: ss,
+	}
+}
+
+// RawStatus retrieves the current UPS status as a map of each field reported
+// by apcupsd to its unparsed value, so that fields which are not exported as
+// metrics remain accessible.  RawStatus is only supported by Exporters created
+// using NewWithDialFunc.
+func (e *Exporter) RawStatus(ctx context.Context) (map[string]string, error) {
+	rs, ok := e.ss.(RawStatusSource)
+	if !ok {
+		return nil, errors.New("raw status is not supported by this Exporter")
+	}
+
+	return rs.RawStatus(ctx)
+}
+
+// PowerQualityScore returns the power quality score of the UPS as of the most
+// recent collection, as exported by apcupsd_power_quality_score, so that it
+// can be aggregated across UPSes.  It reports false until a score has been
+// computed, or if the input line collector is disabled.
+func (e *Exporter) PowerQualityScore() (float64, bool) {
+	for _, sc := range e.c.cs {
+		if c, ok := sc.(*InputLineCollector); ok {
+			return c.quality.lastScore()
+		}
 	}
+
+	return 0, false
 }
 
 // Describe sends all the descriptors of the collectors included to
 // the provided channel.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	// Don't care, we'll get this error on Collect.
-	_ = e.withCollectors(func(cs []prometheus.Collector) {
-		for _, c := range cs {
-			c.Describe(ch)
-		}
-	})
+	e.c.Describe(ch)
 }
 
 // Collect sends the collected metrics from each of the collectors to
 // prometheus.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	err := e.withCollectors(func(cs []prometheus.Collector) {
-		for _, c := range cs {
-			c.Collect(ch)
-		}
-	})
-	// This is a hack but it allows us to report failure to dial without
-	// reworking significant portions of the code.
-	if err != nil {
-		log.Println(err)
-		ch <- prometheus.NewInvalidMetric(NewUPSCollector(nil).Info, err)
-		return
-	}
+	e.c.Collect(ch)
+}
+
+var _ ContextStatusSource = &clientSource{}
+
+// A clientSource is a StatusSource which sets up a short-lived apcupsd client
+// using a ClientFunc each time the status is retrieved.
+type clientSource struct {
+	fn ClientFunc
 }
 
-// withCollectors sets up an apcupsd client and creates a set of prometheus
-// collectors.  It invokes the input closure and then cleans up after the
-// closure returns.
-func (e *Exporter) withCollectors(fn func(cs []prometheus.Collector)) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// Status implements StatusSource.
+func (cs *clientSource) Status() (*apcupsd.Status, error) {
+	return cs.StatusContext(context.Background())
+}
 
-	c, err := e.clientFn(ctx)
+// StatusContext implements ContextStatusSource.  The context is passed to the
+// ClientFunc, which may use it to bound the client's I/O as NewClientFunc
+// does.  If the context is done first, the status retrieval is abandoned.
+func (cs *clientSource) StatusContext(ctx context.Context) (*apcupsd.Status, error) {
+	return statusContext(ctx, func() (*apcupsd.Status, error) {
+		return cs.status(ctx)
+	})
+}
+
+// status creates a client using the ClientFunc and retrieves the status.
+func (cs *clientSource) status(ctx context.Context) (*apcupsd.Status, error) {
+	c, err := cs.fn(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating apcupsd client: %v", err)
+		return nil, &reasonError{
+			reason: reasonConnect,
+			err:    fmt.Errorf("error creating apcupsd client: %w", err),
+		}
 	}
 	defer c.Close()
 
-	cs := []prometheus.Collector{
-		NewUPSCollector(c),
+	s, err := c.Status()
+	if err != nil {
+		return nil, statusError(err)
 	}
 
-	fn(cs)
-
-	return nil
+	return s, nil
 }
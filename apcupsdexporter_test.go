@@ -1,16 +1,396 @@
 package apcupsdexporter
 
 import (
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/mdlayher/apcupsd"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+func TestExporter(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	out := testCollector(t, New(s.Dial))
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 1`),
+		regexp.MustCompile(`apcupsd_info{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1`),
+		regexp.MustCompile(`apcupsd_battery_time_left_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 3150`),
+		regexp.MustCompile(`apcupsd_nominal_power_watts{hostname="apcupsd",model="Back-UPS RS 1500G",source="ups",ups_name="ups"} 865`),
+		regexp.MustCompile(`apcupsd_status{hostname="apcupsd",model="Back-UPS RS 1500G",status="ONLINE",ups_name="ups"} 1`),
+		regexp.MustCompile(`apcupsd_daemon_info{hostname="apcupsd",model="Back-UPS RS 1500G",status_format="001",ups_name="ups",version="3.14.14"} 1`),
+		regexp.MustCompile(`apcupsd_daemon_start_time_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1.6461252e\+09`),
+		regexp.MustCompile(`apcupsd_daemon_uptime_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1.1268e\+06`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
+func TestExporterDialFunc(t *testing.T) {
+	lines := append([]string{}, apcupsdtest.DefaultStatus...)
+	lines = append(lines, apcupsdtest.Line("OUTCURNT", "1.20 Amps"))
+
+	s := apcupsdtest.NewServer(apcupsdtest.Status(lines...))
+	defer s.Close()
+
+	e := NewWithDialFunc(func(_ context.Context) (net.Conn, error) {
+		return s.PipeConn(), nil
+	})
+
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 1`),
+		regexp.MustCompile(`apcupsd_battery_time_left_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 3150`),
+		regexp.MustCompile(`apcupsd_nis_latency_seconds{phase="dial"} \S+`),
+		regexp.MustCompile(`apcupsd_nis_latency_seconds{phase="exchange"} \S+`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	raw, err := e.RawStatus(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve raw status: %v", err)
+	}
+
+	for k, v := range map[string]string{
+		"UPSNAME":  "ups",
+		"TIMELEFT": "52.5 Minutes",
+		"OUTCURNT": "1.20 Amps",
+	} {
+		if got := raw[k]; got != v {
+			t.Fatalf("unexpected raw value for %q: %q", k, got)
+		}
+	}
+}
+
+func TestExporterPartialParse(t *testing.T) {
+	lines := append([]string{}, apcupsdtest.DefaultStatus...)
+	lines = append(lines, apcupsdtest.Line("LINEV", "foo Volts"))
+
+	s := apcupsdtest.NewServer(apcupsdtest.Status(lines...))
+	defer s.Close()
+
+	var (
+		errs   map[string]error
+		phases []Phase
+	)
+	e := NewWithDialFunc(func(_ context.Context) (net.Conn, error) {
+		return s.PipeConn(), nil
+	},
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithHooks(Hooks{
+			After: func(ctx context.Context, _ chan<- prometheus.Metric, _ *apcupsd.Status, _ error) {
+				errs = ParseErrors(ctx)
+				phases = Phases(ctx)
+			},
+		}),
+	)
+
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 1`),
+		regexp.MustCompile(`apcupsd_battery_time_left_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 3150`),
+		regexp.MustCompile(`apcupsd_exporter_parse_errors_total{field="LINEV"} 1`),
+		regexp.MustCompile(`apcupsd_exporter_collect_errors_total{reason="parse"} 0`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	if len(errs) != 1 || errs["LINEV"] == nil {
+		t.Fatalf("unexpected parse errors passed to hooks: %v", errs)
+	}
+
+	var names []string
+	for _, p := range phases {
+		if p.Start.IsZero() {
+			t.Fatalf("phase %q has no start time", p.Name)
+		}
+		names = append(names, p.Name)
+	}
+	if got, want := strings.Join(names, ","), "dial,request,parse"; got != want {
+		t.Fatalf("unexpected phases passed to hooks: got %q, want %q", got, want)
+	}
+}
+
+func TestExporterPowerQualityScore(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	e := New(s.Dial)
+	if _, ok := e.PowerQualityScore(); ok {
+		t.Fatal("power quality score is known before a collection")
+	}
+
+	out := testCollector(t, e)
+	m := regexp.MustCompile(`apcupsd_power_quality_score{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} (\S+)`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("power quality score was not exported")
+	}
+
+	score, ok := e.PowerQualityScore()
+	if !ok {
+		t.Fatal("power quality score is unknown after a collection")
+	}
+	if got := strconv.FormatFloat(score, 'g', -1, 64); got != string(m[1]) {
+		t.Fatalf("unexpected power quality score: want %s, got %s", m[1], got)
+	}
+
+	// Without the input line collector, no score is computed.
+	e = New(s.Dial, WithCollectors(CollectorBattery))
+	testCollector(t, e)
+	if _, ok := e.PowerQualityScore(); ok {
+		t.Fatal("power quality score is known without the input line collector")
+	}
+}
+func TestExporterEventsBackfill(t *testing.T) {
+	// apcupsd restarted after the power failure, so its status no longer
+	// reports the transfer.
+	lines := append([]string{}, apcupsdtest.DefaultStatus...)
+	lines = append(lines,
+		apcupsdtest.Line("XONBATT", "N/A"),
+		apcupsdtest.Line("XOFFBATT", "N/A"),
+	)
+
+	s := apcupsdtest.NewServer(func(cmd string) ([]string, error) {
+		if cmd != "events" {
+			return lines, nil
+		}
+
+		return []string{
+			"2022-03-13 22:10:00 +0000  UPS Self Test switch to battery.",
+			"2022-03-14 02:13:45 +0000  Power failure.",
+			"2022-03-14 02:13:51 +0000  Running on UPS batteries.",
+			"2022-03-14 02:20:00 +0000  Mains returned. No longer on UPS batteries.",
+			"2022-03-14 02:20:00 +0000  Power is back. UPS running on mains.",
+			"2022-03-14 02:25:00 +0000  apcupsd 3.14.14 (31 May 2016) debian startup succeeded",
+		}, nil
+	})
+	defer s.Close()
+
+	e := NewWithDialFunc(func(_ context.Context) (net.Conn, error) {
+		return s.PipeConn(), nil
+	})
+
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_power_failures_total{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1`),
+		regexp.MustCompile(`apcupsd_last_power_failure_time_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1.647224025e\+09`),
+		regexp.MustCompile(`apcupsd_last_transfer_on_battery_time_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1.647224025e\+09`),
+		regexp.MustCompile(`apcupsd_last_transfer_off_battery_time_seconds{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 1.6472244e\+09`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
+func TestExporterRawStatusUnsupported(t *testing.T) {
+	e := New(func(_ context.Context) (*apcupsd.Client, error) {
+		return nil, errors.New("unused")
+	})
+
+	if _, err := e.RawStatus(context.Background()); err == nil {
+		t.Fatal("expected an error retrieving raw status, but none occurred")
+	}
+}
+
+func TestExporterErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		fn      ClientFunc
+		dial    DialFunc
+		handler apcupsdtest.Handler
+		reason  string
+	}{
+		{
+			desc: "connect",
+			fn: func(_ context.Context) (*apcupsd.Client, error) {
+				return nil, errors.New("connection refused")
+			},
+			reason: "connect",
+		},
+		{
+			desc: "timeout",
+			fn: func(_ context.Context) (*apcupsd.Client, error) {
+				return nil, context.DeadlineExceeded
+			},
+			reason: "timeout",
+		},
+		{
+			desc: "dns",
+			fn: func(_ context.Context) (*apcupsd.Client, error) {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{
+					Err:        "no such host",
+					Name:       "ups.invalid",
+					IsNotFound: true,
+				}}
+			},
+			reason: "dns",
+		},
+		{
+			desc: "refused",
+			fn: func(_ context.Context) (*apcupsd.Client, error) {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+			},
+			reason: "refused",
+		},
+		{
+			desc: "read",
+			dial: func(_ context.Context) (net.Conn, error) {
+				c, _ := net.Pipe()
+				return &errConn{Conn: c, err: &net.OpError{
+					Op:  "read",
+					Net: "tcp",
+					Err: os.NewSyscallError("read", syscall.ECONNRESET),
+				}}, nil
+			},
+			reason: "read",
+		},
+		{
+			desc:    "protocol",
+			handler: apcupsdtest.Error(errors.New("reset")),
+			reason:  "protocol",
+		},
+		{
+			desc:    "parse",
+			handler: apcupsdtest.Status(apcupsdtest.Line("LINEV", "foo Volts")),
+			reason:  "parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			opt := WithLogHandler(slog.NewTextHandler(io.Discard, nil))
+			es := []*Exporter{New(tt.fn, opt)}
+			if tt.dial != nil {
+				es = []*Exporter{NewWithDialFunc(tt.dial, opt)}
+			}
+			if tt.handler != nil {
+				s := apcupsdtest.NewServer(tt.handler)
+				defer s.Close()
+
+				// Exercise both the apcupsd client and NIS implementations.
+				es = []*Exporter{
+					New(func(_ context.Context) (*apcupsd.Client, error) {
+						return s.Pipe(), nil
+					}, opt),
+					NewWithDialFunc(func(_ context.Context) (net.Conn, error) {
+						return s.PipeConn(), nil
+					}, opt),
+				}
+			}
+
+			for _, e := range es {
+				out := testCollector(t, e)
+
+				matches := []*regexp.Regexp{
+					regexp.MustCompile(`apcupsd_up 0`),
+					regexp.MustCompile(`apcupsd_exporter_collect_errors_total{reason="` + tt.reason + `"} 1`),
+				}
+				for _, m := range matches {
+					if !m.Match(out) {
+						t.Fatalf("output failed to match regex (regexp: %v)", m)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExporterTimeout(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Delay(
+		time.Second,
+		apcupsdtest.Status(apcupsdtest.DefaultStatus...),
+	))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	addr := s.Addr()
+	e := New(NewClientFunc(addr.Network(), addr.String()),
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithTimeout(100*time.Millisecond),
+	)
+
+	start := time.Now()
+	out := testCollector(t, e)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("collection was not canceled at its deadline, took %v", d)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 0`),
+		regexp.MustCompile(`apcupsd_exporter_collect_errors_total{reason="timeout"} 1`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
+func TestExporterInvalidMetricOnError(t *testing.T) {
+	e := New(func(_ context.Context) (*apcupsd.Client, error) {
+		return nil, context.DeadlineExceeded
+	},
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithInvalidMetricOnError(true),
+	)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	if _, err := reg.Gather(); err == nil {
+		t.Fatal("expected an error gathering metrics, but none occurred")
+	}
+}
+
+// An errConn is a net.Conn whose reads fail with err.
+type errConn struct {
+	net.Conn
+	err error
+}
+
+func (c *errConn) Read(_ []byte) (int, error)  { return 0, c.err }
+func (c *errConn) Write(b []byte) (int, error) { return len(b), nil }
+
 func testCollector(t *testing.T, collector prometheus.Collector) []byte {
 	t.Helper()
 
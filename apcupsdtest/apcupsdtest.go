@@ -0,0 +1,402 @@
+// Package apcupsdtest provides a fake apcupsd Network Information Server (NIS)
+// for use in tests.
+//
+// A Server speaks the NIS wire protocol over TCP, TLS, or an in-memory pipe,
+// and answers each command using a scriptable Handler, so that code which uses
+// an apcupsd client can be tested against realistic protocol behavior without
+// a real UPS.
+package apcupsdtest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// A Handler produces the lines sent in response to a NIS command, such as
+// "status" or "events".  If a Handler returns an error, the Server aborts its
+// response partway through a message and closes the connection, so that the
+// client observes an unexpected EOF.
+type Handler func(cmd string) ([]string, error)
+
+// Status returns a Handler which responds to the "status" command with lines,
+// and to any other command with no lines.
+func Status(lines ...string) Handler {
+	return func(cmd string) ([]string, error) {
+		if cmd != "status" {
+			return nil, nil
+		}
+
+		return lines, nil
+	}
+}
+
+// Sequence returns a Handler which invokes each of hs in turn on successive
+// commands.  Once all Handlers are exhausted, the last one is reused.
+func Sequence(hs ...Handler) Handler {
+	var (
+		mu sync.Mutex
+		i  int
+	)
+
+	return func(cmd string) ([]string, error) {
+		mu.Lock()
+		h := hs[i]
+		if i < len(hs)-1 {
+			i++
+		}
+		mu.Unlock()
+
+		return h(cmd)
+	}
+}
+
+// Delay returns a Handler which waits for d before invoking h, simulating a
+// slow or hung apcupsd.
+func Delay(d time.Duration, h Handler) Handler {
+	return func(cmd string) ([]string, error) {
+		time.Sleep(d)
+		return h(cmd)
+	}
+}
+
+// Error returns a Handler which always fails with err, causing the Server to
+// abort the connection.
+func Error(err error) Handler {
+	return func(_ string) ([]string, error) {
+		return nil, err
+	}
+}
+
+// Line formats a key/value pair as apcupsd does in its status output.
+func Line(key, value string) string {
+	return fmt.Sprintf("%-9s: %s\n", key, value)
+}
+
+// Lines formats a map of key/value pairs as status output lines, sorted by
+// key.  The APC and END APC records are always placed first and last.
+func Lines(kvs map[string]string) []string {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		if k == "APC" || k == "END APC" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(kvs))
+	if v, ok := kvs["APC"]; ok {
+		lines = append(lines, Line("APC", v))
+	}
+	for _, k := range keys {
+		lines = append(lines, Line(k, kvs[k]))
+	}
+	if v, ok := kvs["END APC"]; ok {
+		lines = append(lines, Line("END APC", v))
+	}
+
+	return lines
+}
+
+// DefaultStatus is a typical set of status lines reported by apcupsd for a
+// Back-UPS which is online.
+var DefaultStatus = []string{
+	Line("APC", "001,036,0879"),
+	Line("DATE", "2022-03-14 10:00:00 +0000"),
+	Line("HOSTNAME", "apcupsd"),
+	Line("VERSION", "3.14.14 (31 May 2016) debian"),
+	Line("UPSNAME", "ups"),
+	Line("CABLE", "USB Cable"),
+	Line("DRIVER", "USB UPS Driver"),
+	Line("UPSMODE", "Stand Alone"),
+	Line("STARTTIME", "2022-03-01 09:00:00 +0000"),
+	Line("MODEL", "Back-UPS RS 1500G"),
+	Line("STATUS", "ONLINE"),
+	Line("LINEV", "121.0 Volts"),
+	Line("LOADPCT", "16.0 Percent"),
+	Line("BCHARGE", "100.0 Percent"),
+	Line("TIMELEFT", "52.5 Minutes"),
+	Line("MBATTCHG", "5 Percent"),
+	Line("MINTIMEL", "3 Minutes"),
+	Line("MAXTIME", "0 Seconds"),
+	Line("SENSE", "Medium"),
+	Line("LOTRANS", "88.0 Volts"),
+	Line("HITRANS", "139.0 Volts"),
+	Line("ALARMDEL", "No alarm"),
+	Line("BATTV", "27.2 Volts"),
+	Line("LASTXFER", "Low line voltage"),
+	Line("NUMXFERS", "2"),
+	Line("XONBATT", "2022-03-10 12:00:00 +0000"),
+	Line("TONBATT", "0 Seconds"),
+	Line("CUMONBATT", "45 Seconds"),
+	Line("XOFFBATT", "2022-03-10 12:00:45 +0000"),
+	Line("LASTSTEST", "2022-03-01 09:01:00 +0000"),
+	Line("SELFTEST", "NO"),
+	Line("STATFLAG", "0x05000008"),
+	Line("SERIALNO", "3B1234X12345"),
+	Line("BATTDATE", "2020-01-01"),
+	Line("NOMINV", "120 Volts"),
+	Line("NOMBATTV", "24.0 Volts"),
+	Line("NOMPOWER", "865 Watts"),
+	Line("FIRMWARE", "878.L4 .D USB FW:L4"),
+	Line("END APC", "2022-03-14 10:00:01 +0000"),
+}
+
+// A Server is a fake apcupsd NIS.
+type Server struct {
+	mu sync.Mutex
+	h  Handler
+	l  net.Listener
+
+	wg     sync.WaitGroup
+	connMu sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewServer creates a Server which answers commands using h.  The Server
+// does not listen on any network until Listen is called, but can serve
+// in-memory connections created by Pipe.
+func NewServer(h Handler) *Server {
+	return &Server{
+		h:     h,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// NewTCPServer creates a Server which answers commands using h, and listens
+// for TCP connections on a random loopback port.
+func NewTCPServer(h Handler) (*Server, error) {
+	s := NewServer(h)
+	if err := s.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Listen begins serving NIS connections on a listener for network and addr.
+func (s *Server) Listen(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	s.listen(l)
+	return nil
+}
+
+// ListenTLS is like Listen, but serves NIS connections wrapped in TLS using
+// cfg, as by stunnel.
+func (s *Server) ListenTLS(network, addr string, cfg *tls.Config) error {
+	l, err := tls.Listen(network, addr, cfg)
+	if err != nil {
+		return err
+	}
+
+	s.listen(l)
+	return nil
+}
+
+// listen begins serving NIS connections accepted by l.
+func (s *Server) listen(l net.Listener) {
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.serve(l)
+	}()
+}
+
+// Addr returns the network address of the Server's listener, or nil if the
+// Server is not listening.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.l == nil {
+		return nil
+	}
+
+	return s.l.Addr()
+}
+
+// SetHandler replaces the Handler used to answer subsequent commands.
+func (s *Server) SetHandler(h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.h = h
+}
+
+// Dial creates an apcupsd client connected to the Server's listener.
+func (s *Server) Dial(ctx context.Context) (*apcupsd.Client, error) {
+	c, err := s.DialConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return apcupsd.New(c), nil
+}
+
+// DialConn dials a raw connection to the Server's listener.
+func (s *Server) DialConn(ctx context.Context) (net.Conn, error) {
+	addr := s.Addr()
+	if addr == nil {
+		return nil, errors.New("apcupsdtest: server is not listening")
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, addr.Network(), addr.String())
+}
+
+// Pipe creates an apcupsd client connected to the Server using an in-memory
+// connection.
+func (s *Server) Pipe() *apcupsd.Client {
+	return apcupsd.New(s.PipeConn())
+}
+
+// PipeConn creates a raw in-memory connection to the Server.
+func (s *Server) PipeConn() net.Conn {
+	c1, c2 := net.Pipe()
+	if !s.track(c2) {
+		_ = c2.Close()
+	}
+
+	return c1
+}
+
+// Close stops the Server's listener and closes all open connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	l := s.l
+	s.mu.Unlock()
+
+	var err error
+	if l != nil {
+		err = l.Close()
+	}
+
+	s.connMu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.connMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// serve accepts connections on l until it is closed.
+func (s *Server) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		if !s.track(c) {
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+// track begins handling c in a goroutine, unless the Server is closed.
+func (s *Server) track(c net.Conn) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			s.connMu.Lock()
+			delete(s.conns, c)
+			s.connMu.Unlock()
+
+			_ = c.Close()
+			s.wg.Done()
+		}()
+
+		_ = s.handle(c)
+	}()
+
+	return true
+}
+
+// handle answers NIS commands on rw until EOF or an error occurs.
+func (s *Server) handle(rw io.ReadWriter) error {
+	for {
+		cmd, err := readMessage(rw)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		h := s.h
+		s.mu.Unlock()
+
+		lines, err := h(string(cmd))
+		if err != nil {
+			// Truncate a message so the client cannot mistake the closed
+			// connection for the end of a response.
+			_, _ = rw.Write([]byte{0x00, 0x02, 'E'})
+			return err
+		}
+
+		for _, l := range lines {
+			if err := writeMessage(rw, []byte(l)); err != nil {
+				return err
+			}
+		}
+
+		// A zero length message terminates the response.
+		if err := writeMessage(rw, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads a single length-prefixed NIS message from r.
+func readMessage(r io.Reader) ([]byte, error) {
+	var lenb [2]byte
+	if _, err := io.ReadFull(r, lenb[:]); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint16(lenb[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// writeMessage writes a single length-prefixed NIS message to w.
+func writeMessage(w io.Writer, b []byte) error {
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,88 @@
+package apcupsdtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestServerTCP(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := s.Dial(ctx)
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer c.Close()
+
+	// Multiple commands may be issued on a single connection.
+	for i := 0; i < 2; i++ {
+		st, err := c.Status()
+		if err != nil {
+			t.Fatalf("failed to retrieve status: %v", err)
+		}
+
+		if st.Model != "Back-UPS RS 1500G" {
+			t.Fatalf("unexpected model: %q", st.Model)
+		}
+		if st.NominalPower != 865 {
+			t.Fatalf("unexpected nominal power: %d", st.NominalPower)
+		}
+	}
+}
+
+func TestServerPipeSequence(t *testing.T) {
+	s := apcupsdtest.NewServer(apcupsdtest.Sequence(
+		apcupsdtest.Status(apcupsdtest.Lines(map[string]string{
+			"UPSNAME": "first",
+			"STATUS":  "ONLINE",
+		})...),
+		apcupsdtest.Status(apcupsdtest.Lines(map[string]string{
+			"UPSNAME": "second",
+			"STATUS":  "ONBATT",
+		})...),
+	))
+	defer s.Close()
+
+	want := []*apcupsd.Status{
+		{UPSName: "first", Status: "ONLINE"},
+		{UPSName: "second", Status: "ONBATT"},
+		{UPSName: "second", Status: "ONBATT"},
+	}
+
+	for i, w := range want {
+		c := s.Pipe()
+		st, err := c.Status()
+		_ = c.Close()
+		if err != nil {
+			t.Fatalf("%d: failed to retrieve status: %v", i, err)
+		}
+
+		if st.UPSName != w.UPSName || st.Status != w.Status {
+			t.Fatalf("%d: unexpected status:\n- want: %+v\n-  got: %+v", i, w, st)
+		}
+	}
+}
+
+func TestServerError(t *testing.T) {
+	s := apcupsdtest.NewServer(apcupsdtest.Error(errors.New("boom")))
+	defer s.Close()
+
+	c := s.Pipe()
+	defer c.Close()
+
+	if _, err := c.Status(); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
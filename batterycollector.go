@@ -0,0 +1,614 @@
+package apcupsdexporter
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A BatteryCollector is a Prometheus collector for metrics regarding an APC
+// UPS battery.
+type BatteryCollector struct {
+	BatteryChargePercent                *prometheus.Desc
+	BatteryVolts                        *prometheus.Desc
+	BatteryNominalVolts                 *prometheus.Desc
+	BatteryVoltsNominalDeviationRatio   *prometheus.Desc
+	BatteryVoltsPerCell                 *prometheus.Desc
+	BatteryNominalEnergyWattHours       *prometheus.Desc
+	BatteryNumberTransfersTotal         *prometheus.Desc
+	BatteryTimeLeftSeconds              *prometheus.Desc
+	RuntimeMarginSeconds                *prometheus.Desc
+	BatteryTimeOnSeconds                *prometheus.Desc
+	BatteryCumulativeTimeOnSecondsTotal *prometheus.Desc
+	LastTransferOnBatteryTimeSeconds    *prometheus.Desc
+	LastTransferOffBatteryTimeSeconds   *prometheus.Desc
+	BatteryReplacementsTotal            *prometheus.Desc
+	BatteryLastReplacementTimeSeconds   *prometheus.Desc
+	BatteryCapacityEstimateRatio        *prometheus.Desc
+	BatteryTimeLeftPredictedSeconds     *prometheus.Desc
+	BatteryTimeToFullSeconds            *prometheus.Desc
+
+	ss           StatusSource
+	o            *options
+	replacements replacementTracker
+	capacity     capacityEstimator
+	curve        runtimeCurve
+	charge       chargeTracker
+}
+
+var _ statusCollector = &BatteryCollector{}
+
+// NewBatteryCollector creates a new BatteryCollector.
+func NewBatteryCollector(ss StatusSource, opts ...Option) *BatteryCollector {
+	return newBatteryCollector(ss, newOptions(opts))
+}
+
+// newBatteryCollector creates a new BatteryCollector using the input options.
+func newBatteryCollector(ss StatusSource, o *options) *BatteryCollector {
+	labels := []string{"ups_name", "hostname", "model"}
+
+	return &BatteryCollector{
+		BatteryChargePercent: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_charge_percent"),
+			"Current UPS battery charge percentage.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryVolts: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_volts"),
+			"Current UPS battery voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryNominalVolts: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_nominal_volts"),
+			"Nominal UPS battery voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryVoltsNominalDeviationRatio: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_volts_nominal_deviation_ratio"),
+			"Deviation of the UPS battery voltage from its nominal voltage, as a ratio of the nominal voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryVoltsPerCell: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_volts_per_cell"),
+			"Estimated UPS battery voltage per cell, assuming a lead-acid battery of 2 V cells in 12 V blocks.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryNominalEnergyWattHours: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_nominal_energy_watt_hours"),
+			"Nominal energy of a new UPS battery in watt-hours, as set by an override or specified for the UPS model.",
+			append(labels, "source"),
+			o.constLabels,
+		),
+
+		BatteryNumberTransfersTotal: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_number_transfers_total"),
+			"Total number of transfers to UPS battery power.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryTimeLeftSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_time_left_seconds"),
+			"Number of seconds remaining of UPS battery power.",
+			labels,
+			o.constLabels,
+		),
+
+		RuntimeMarginSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "runtime_margin_seconds"),
+			"Number of seconds of UPS battery power remaining beyond the runtime left at which apcupsd shuts down the system (MINTIMEL) and the time the system takes to shut down.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryTimeOnSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_time_on_seconds"),
+			"Number of seconds the UPS has been providing battery power due to an AC input line outage.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryCumulativeTimeOnSecondsTotal: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_cumulative_time_on_seconds_total"),
+			"Total number of seconds the UPS has provided battery power due to AC input line outages.",
+			labels,
+			o.constLabels,
+		),
+
+		LastTransferOnBatteryTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "last_transfer_on_battery_time_seconds"),
+			"UNIX timestamp of last transfer to battery since apcupsd startup.",
+			labels,
+			o.constLabels,
+		),
+
+		LastTransferOffBatteryTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "last_transfer_off_battery_time_seconds"),
+			"UNIX timestamp of last transfer from battery since apcupsd startup.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryReplacementsTotal: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_replacements_total"),
+			"Total number of UPS battery replacements detected from changes of the battery date since the exporter started.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryLastReplacementTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_last_replacement_time_seconds"),
+			"UNIX timestamp at which the last UPS battery replacement was detected.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryCapacityEstimateRatio: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_capacity_estimate_ratio"),
+			"Estimated remaining UPS battery capacity as a ratio of its nominal capacity, from the runtime left at full charge smoothed over weeks.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryTimeLeftPredictedSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_time_left_predicted_seconds"),
+			"Number of seconds of UPS battery power predicted at the current load and charge, from a runtime curve fitted to the runtimes reported at other loads.",
+			labels,
+			o.constLabels,
+		),
+
+		BatteryTimeToFullSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "battery_time_to_full_seconds"),
+			"Estimated number of seconds until the UPS battery is fully charged, from the rate at which it has been charging.",
+			labels,
+			o.constLabels,
+		),
+
+		ss: ss,
+		o:  o,
+		capacity: capacityEstimator{
+			nominal: o.nominalRuntime,
+			path:    o.statePath,
+			logger:  o.logger,
+		},
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *BatteryCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch,
+		c.BatteryChargePercent,
+		c.BatteryVolts,
+		c.BatteryNominalVolts,
+		c.BatteryVoltsNominalDeviationRatio,
+		c.BatteryVoltsPerCell,
+		c.BatteryNominalEnergyWattHours,
+		c.BatteryNumberTransfersTotal,
+		c.BatteryTimeLeftSeconds,
+		c.RuntimeMarginSeconds,
+		c.BatteryTimeOnSeconds,
+		c.BatteryCumulativeTimeOnSecondsTotal,
+		c.LastTransferOnBatteryTimeSeconds,
+		c.LastTransferOffBatteryTimeSeconds,
+		c.BatteryReplacementsTotal,
+		c.BatteryLastReplacementTimeSeconds,
+		c.BatteryCapacityEstimateRatio,
+		c.BatteryTimeLeftPredictedSeconds,
+		c.BatteryTimeToFullSeconds,
+	)
+}
+
+// Collect sends the metric values for each metric created by the
+// BatteryCollector to the provided prometheus Metric channel.
+func (c *BatteryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.o.collectFrom(ch, c.ss, c.BatteryChargePercent, c.collectStatus)
+}
+
+// collectStatus sends battery metrics derived from s to ch.
+func (c *BatteryCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	ch <- c.o.cache.metric(
+		c.BatteryChargePercent,
+		prometheus.GaugeValue,
+		s.BatteryChargePercent,
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.BatteryVolts,
+		prometheus.GaugeValue,
+		s.BatteryVoltage,
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.BatteryNominalVolts,
+		prometheus.GaugeValue,
+		s.NominalBatteryVoltage,
+		s,
+	)
+
+	if ratio, ok := nominalDeviation(s.BatteryVoltage, s.NominalBatteryVoltage); ok {
+		ch <- c.o.cache.metric(
+			c.BatteryVoltsNominalDeviationRatio,
+			prometheus.GaugeValue,
+			ratio,
+			s,
+		)
+	}
+
+	if vpc, ok := voltsPerCell(s.BatteryVoltage, s.NominalBatteryVoltage); ok {
+		ch <- c.o.cache.metric(
+			c.BatteryVoltsPerCell,
+			prometheus.GaugeValue,
+			vpc,
+			s,
+		)
+	}
+
+	if energy, source, ok := c.o.batteryEnergy(s); ok {
+		ch <- c.o.cache.metricWith(
+			c.BatteryNominalEnergyWattHours,
+			prometheus.GaugeValue,
+			energy,
+			s,
+			source,
+		)
+	}
+
+	ch <- c.o.cache.metric(
+		c.BatteryNumberTransfersTotal,
+		prometheus.CounterValue,
+		float64(s.NumberTransfers),
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.BatteryTimeLeftSeconds,
+		prometheus.GaugeValue,
+		s.TimeLeft.Seconds(),
+		s,
+	)
+
+	// A UPS which does not report its runtime left would otherwise appear to
+	// have a negative margin.
+	if s.TimeLeft > 0 {
+		ch <- c.o.cache.metric(
+			c.RuntimeMarginSeconds,
+			prometheus.GaugeValue,
+			(s.TimeLeft - s.MinimumTimeLeft - c.o.shutdownDuration).Seconds(),
+			s,
+		)
+	}
+
+	ch <- c.o.cache.metric(
+		c.BatteryTimeOnSeconds,
+		prometheus.GaugeValue,
+		s.TimeOnBattery.Seconds(),
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.BatteryCumulativeTimeOnSecondsTotal,
+		prometheus.CounterValue,
+		s.CumulativeTimeOnBattery.Seconds(),
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.LastTransferOnBatteryTimeSeconds,
+		prometheus.GaugeValue,
+		timestamp(s.XOnBattery),
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.LastTransferOffBatteryTimeSeconds,
+		prometheus.GaugeValue,
+		timestamp(s.XOffBattery),
+		s,
+	)
+
+	r := c.replacements.observe(s)
+
+	ch <- c.o.cache.metric(
+		c.BatteryReplacementsTotal,
+		prometheus.CounterValue,
+		float64(r.total),
+		s,
+	)
+
+	// Only report the time of a replacement once one has been detected.
+	if r.total > 0 {
+		ch <- c.o.cache.metric(
+			c.BatteryLastReplacementTimeSeconds,
+			prometheus.GaugeValue,
+			timestamp(r.last),
+			s,
+		)
+	}
+
+	// The capacity is unknown until the UPS has been observed fully charged,
+	// and without a nominal runtime, until enough history is known.
+	if ratio, ok := c.capacity.observe(s); ok {
+		ch <- c.o.cache.metric(
+			c.BatteryCapacityEstimateRatio,
+			prometheus.GaugeValue,
+			ratio,
+			s,
+		)
+	}
+
+	// A curve can only be fitted once runtimes at several loads are known.
+	if rt, ok := c.curve.observe(s); ok {
+		ch <- c.o.cache.metric(
+			c.BatteryTimeLeftPredictedSeconds,
+			prometheus.GaugeValue,
+			rt.Seconds(),
+			s,
+		)
+	}
+
+	// The time is unknown on battery, or until the charge rate is measured.
+	if d, ok := c.charge.observe(s); ok {
+		ch <- c.o.cache.metric(
+			c.BatteryTimeToFullSeconds,
+			prometheus.GaugeValue,
+			d.Seconds(),
+			s,
+		)
+	}
+}
+
+// capacityHalfLife is the time over which the runtime samples used to
+// estimate battery capacity lose half of their weight, so that the estimate
+// follows gradual battery wear rather than the fluctuations of the runtime
+// reported by the UPS.  Without a nominal runtime, it is also the history
+// needed before the highest runtime observed is a meaningful baseline.
+const capacityHalfLife = 7 * 24 * time.Hour
+
+// capacitySaveInterval is the minimum interval at which the state of a
+// capacityEstimator is persisted.
+const capacitySaveInterval = 10 * time.Minute
+
+// A capacityEstimator estimates the remaining battery capacity of each UPS
+// from the runtime it reports while fully charged and on line power.
+//
+// Runtime depends on load, so each sample is first scaled to the runtime at
+// full load, assuming runtime is inversely proportional to load.  This is
+// only an approximation for real batteries, but it is consistent over time
+// for a UPS whose load is steady, which is what matters for a trend.
+type capacityEstimator struct {
+	// nominal is the runtime at full load of a new battery, or 0 to compare
+	// against the highest smoothed runtime observed.
+	nominal float64
+
+	// path, if set, is the file in which the states are persisted.
+	path   string
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *capacityState]
+	loaded bool
+	saved  time.Time
+}
+
+// A capacityState is the smoothed runtime at full load of a single UPS, since
+// the time of its first sample.
+type capacityState struct {
+	runtime, best float64
+	first, last   time.Time
+}
+
+// runtimeAtFullLoad scales runtime d at a load of loadPercent to the runtime
+// at full load in seconds.
+func runtimeAtFullLoad(d time.Duration, loadPercent float64) float64 {
+	return d.Seconds() * loadPercent / 100
+}
+
+// observe records a runtime sample from s if the UPS is fully charged, and
+// returns the estimated capacity ratio of the UPS reporting s, if known.
+func (ce *capacityEstimator) observe(s *apcupsd.Status) (float64, bool) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	if !ce.loaded {
+		ce.load()
+		ce.loaded = true
+	}
+
+	id := identity(s)
+	st, _ := ce.states.get(id)
+
+	if s.BatteryChargePercent >= 99 && s.LoadPercent > 0 && s.TimeLeft > 0 &&
+		!strings.Contains(s.Status, "ONBATT") && !strings.Contains(s.Status, "CAL") {
+		rt := runtimeAtFullLoad(s.TimeLeft, s.LoadPercent)
+		now := statusTime(s)
+
+		if st == nil {
+			st = &capacityState{runtime: rt, first: now, last: now}
+			ce.states.put(id, st)
+		} else if dt := now.Sub(st.last); dt > 0 {
+			alpha := 1 - math.Exp2(-float64(dt)/float64(capacityHalfLife))
+			st.runtime += alpha * (rt - st.runtime)
+			st.last = now
+		}
+
+		if st.runtime > st.best {
+			st.best = st.runtime
+		}
+
+		if time.Since(ce.saved) >= capacitySaveInterval {
+			ce.save()
+		}
+	}
+
+	if st == nil {
+		return 0, false
+	}
+
+	baseline := ce.nominal
+	if baseline <= 0 {
+		// The highest runtime observed is only a baseline once it spans
+		// enough history, or the first sample is compared with itself.
+		if st.last.Sub(st.first) < capacityHalfLife {
+			return 0, false
+		}
+		baseline = st.best
+	}
+
+	return st.runtime / baseline, true
+}
+
+// A capacityRecord is the persisted form of the capacityState of a UPS.
+type capacityRecord struct {
+	UPSName        string    `json:"ups_name"`
+	Hostname       string    `json:"hostname"`
+	Model          string    `json:"model"`
+	RuntimeSeconds float64   `json:"runtime_seconds"`
+	BestSeconds    float64   `json:"best_seconds"`
+	First          time.Time `json:"first"`
+	Last           time.Time `json:"last"`
+}
+
+// load restores the states persisted at ce.path, if any.  The caller must
+// hold ce.mu.
+func (ce *capacityEstimator) load() {
+	if ce.path == "" {
+		return
+	}
+
+	b, err := os.ReadFile(ce.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			ce.logger.Warn("failed to load battery capacity state", "path", ce.path, "err", err)
+		}
+		return
+	}
+
+	var rs []capacityRecord
+	if err := json.Unmarshal(b, &rs); err != nil {
+		ce.logger.Warn("failed to load battery capacity state", "path", ce.path, "err", err)
+		return
+	}
+
+	for _, r := range rs {
+		ce.states.put(upsIdentity{upsName: r.UPSName, hostname: r.Hostname, model: r.Model}, &capacityState{
+			runtime: r.RuntimeSeconds,
+			best:    r.BestSeconds,
+			first:   r.First,
+			last:    r.Last,
+		})
+	}
+}
+
+// save persists the states to ce.path, if set, replacing the file atomically
+// so that a crash never leaves a partial file.  The caller must hold ce.mu.
+func (ce *capacityEstimator) save() {
+	if ce.path == "" {
+		return
+	}
+	ce.saved = time.Now()
+
+	rs := make([]capacityRecord, 0, ce.states.len())
+	ce.states.each(func(id upsIdentity, st *capacityState) {
+		rs = append(rs, capacityRecord{
+			UPSName:        id.upsName,
+			Hostname:       id.hostname,
+			Model:          id.model,
+			RuntimeSeconds: st.runtime,
+			BestSeconds:    st.best,
+			First:          st.first,
+			Last:           st.last,
+		})
+	})
+
+	if err := writeFileAtomic(ce.path, rs); err != nil {
+		ce.logger.Warn("failed to save battery capacity state", "path", ce.path, "err", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file next to path, and
+// renames it to path.
+func writeFileAtomic(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// A replacementTracker detects battery replacements from changes of the
+// battery date reported by each UPS between collections.
+type replacementTracker struct {
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *replacementState]
+}
+
+// A replacementState is the battery replacement history of a single UPS.
+type replacementState struct {
+	date  string
+	total int
+	last  time.Time
+}
+
+// observe records the battery date of s, and returns the replacement history
+// of the UPS reporting s.
+func (rt *replacementTracker) observe(s *apcupsd.Status) replacementState {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	id := identity(s)
+	st, ok := rt.states.get(id)
+	if !ok {
+		st = &replacementState{date: s.BatteryDate}
+		rt.states.put(id, st)
+	}
+
+	// A UPS which does not report its battery date, or the first report of
+	// it, says nothing about replacement.
+	if s.BatteryDate != "" && st.date != "" && s.BatteryDate != st.date {
+		st.total++
+		st.last = statusTime(s)
+	}
+	if s.BatteryDate != "" {
+		st.date = s.BatteryDate
+	}
+
+	return *st
+}
@@ -0,0 +1,79 @@
+package apcupsdexporter
+
+import "container/list"
+
+// A boundedMap maps the keys identifying a UPS to the state tracked for it
+// across collections.  It holds at most a fixed number of entries, so that a
+// UPS which continually changes its identity cannot grow the map without
+// limit.  When the map is full, the oldest entry is evicted to insert a new
+// one, keeping the state of all other UPSes.
+//
+// The zero value of a boundedMap is empty and holds at most maxCacheEntries
+// entries.
+type boundedMap[K comparable, V any] struct {
+	// limit, if set, replaces maxCacheEntries as the number of entries.
+	limit int
+
+	entries map[K]*list.Element
+	order   list.List
+}
+
+// A boundedEntry is an entry of a boundedMap, in the order of insertion.
+type boundedEntry[K comparable, V any] struct {
+	k K
+	v V
+}
+
+// get returns the value of k, if present.
+func (bm *boundedMap[K, V]) get(k K) (V, bool) {
+	e, ok := bm.entries[k]
+	if !ok {
+		var v V
+		return v, false
+	}
+
+	return e.Value.(*boundedEntry[K, V]).v, true
+}
+
+// put sets the value of k.  If k is not present and the map is full, the
+// oldest entry is evicted first.
+func (bm *boundedMap[K, V]) put(k K, v V) {
+	if e, ok := bm.entries[k]; ok {
+		e.Value.(*boundedEntry[K, V]).v = v
+		return
+	}
+
+	if bm.entries == nil {
+		bm.entries = make(map[K]*list.Element)
+	}
+
+	limit := bm.limit
+	if limit == 0 {
+		limit = maxCacheEntries
+	}
+	if len(bm.entries) >= limit {
+		oldest := bm.order.Front()
+		delete(bm.entries, bm.order.Remove(oldest).(*boundedEntry[K, V]).k)
+	}
+
+	bm.entries[k] = bm.order.PushBack(&boundedEntry[K, V]{k: k, v: v})
+}
+
+// delete removes k, if present.
+func (bm *boundedMap[K, V]) delete(k K) {
+	if e, ok := bm.entries[k]; ok {
+		bm.order.Remove(e)
+		delete(bm.entries, k)
+	}
+}
+
+// len returns the number of entries.
+func (bm *boundedMap[K, V]) len() int { return len(bm.entries) }
+
+// each calls fn for each entry, from the oldest to the newest.
+func (bm *boundedMap[K, V]) each(fn func(k K, v V)) {
+	for e := bm.order.Front(); e != nil; e = e.Next() {
+		be := e.Value.(*boundedEntry[K, V])
+		fn(be.k, be.v)
+	}
+}
@@ -0,0 +1,46 @@
+package apcupsdexporter
+
+import "testing"
+
+func TestBoundedMap(t *testing.T) {
+	bm := boundedMap[string, int]{limit: 2}
+
+	bm.put("a", 1)
+	bm.put("b", 2)
+
+	// Updating an entry neither grows the map nor evicts an entry.
+	bm.put("a", 3)
+	if v, ok := bm.get("a"); !ok || v != 3 {
+		t.Fatalf("unexpected value of a: %d, %v", v, ok)
+	}
+	if n := bm.len(); n != 2 {
+		t.Fatalf("unexpected number of entries: %d", n)
+	}
+
+	// A new entry evicts only the oldest one.
+	bm.put("c", 4)
+	if _, ok := bm.get("a"); ok {
+		t.Fatal("oldest entry was not evicted")
+	}
+	for k, want := range map[string]int{"b": 2, "c": 4} {
+		if v, ok := bm.get(k); !ok || v != want {
+			t.Fatalf("unexpected value of %s: %d, %v", k, v, ok)
+		}
+	}
+
+	// A deleted entry makes room for another without an eviction.
+	bm.delete("b")
+	bm.put("d", 5)
+	if n := bm.len(); n != 2 {
+		t.Fatalf("unexpected number of entries: %d", n)
+	}
+	if _, ok := bm.get("c"); !ok {
+		t.Fatal("entry was evicted while the map was not full")
+	}
+
+	var keys string
+	bm.each(func(k string, _ int) { keys += k })
+	if keys != "cd" {
+		t.Fatalf("unexpected order of entries: %q", keys)
+	}
+}
@@ -0,0 +1,338 @@
+package apcupsdexporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NIS captures record the exchanges made with apcupsd as text, so that they
+// can be attached to bug reports, redacted, and replayed to reproduce issues
+// with parsing exactly.  Each exchange consists of a line holding the time it
+// began and the command sent, a line holding each message of the response
+// quoted as a Go string, and a line holding a single period if the response
+// was complete.  Blank lines and lines beginning with "#" are ignored:
+//
+//	> 2024-01-02T15:04:05Z status
+//	< "APC      : 001,036,0879\n"
+//	< "STATUS   : ONLINE \n"
+//	.
+
+// NewRecordingDialFunc creates a DialFunc which dials apcupsd using dial, and
+// writes each exchange made over its connections to w in the NIS capture
+// format when the connection is closed.  Each exchange is written in a single
+// call to w, which may be called concurrently by multiple connections.
+func NewRecordingDialFunc(dial DialFunc, w io.Writer) DialFunc {
+	r := &nisRecorder{w: w}
+
+	return func(ctx context.Context) (net.Conn, error) {
+		start := time.Now()
+		c, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &recordingConn{Conn: c, r: r, start: start}, nil
+	}
+}
+
+// A nisRecorder serializes the writes of exchanges to a capture.
+type nisRecorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// A recordingConn is a net.Conn which records the bytes sent and received
+// over it, and writes the exchanges they hold to a capture when it is closed.
+type recordingConn struct {
+	net.Conn
+	r     *nisRecorder
+	start time.Time
+
+	mu         sync.Mutex
+	sent, recv []byte
+	closed     bool
+}
+
+// Read implements net.Conn.
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	c.recv = append(c.recv, b[:n]...)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	c.mu.Lock()
+	c.sent = append(c.sent, b[:n]...)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// Close implements net.Conn.  It returns any error writing the exchanges
+// made over the connection to the capture.
+func (c *recordingConn) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	sent, recv := c.sent, c.recv
+	c.mu.Unlock()
+
+	err := c.Conn.Close()
+	if closed {
+		return err
+	}
+
+	if werr := c.r.record(c.start, sent, recv); werr != nil && err == nil {
+		err = fmt.Errorf("error recording NIS exchange: %w", werr)
+	}
+
+	return err
+}
+
+// record writes the exchanges held by the bytes sent and received over a
+// connection dialed at start to the capture.
+func (r *nisRecorder) record(start time.Time, sent, recv []byte) error {
+	var buf bytes.Buffer
+	for {
+		cmd, rest, ok := nextMessage(sent)
+		if !ok {
+			break
+		}
+		sent = rest
+
+		fmt.Fprintf(&buf, "> %s %s\n", start.UTC().Format(time.RFC3339Nano), cmd)
+
+		complete := false
+		for {
+			m, rest, ok := nextMessage(recv)
+			if !ok {
+				break
+			}
+			recv = rest
+
+			if len(m) == 0 {
+				complete = true
+				break
+			}
+			fmt.Fprintf(&buf, "< %s\n", strconv.Quote(string(m)))
+		}
+
+		if !complete {
+			// The connection was closed before the response ended, so
+			// no further exchanges follow.
+			break
+		}
+		buf.WriteString(".\n")
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.w.Write(buf.Bytes())
+	return err
+}
+
+// nextMessage returns the first length-prefixed NIS message of b and the
+// bytes which follow it.  ok is false if b does not begin with a complete
+// message.
+func nextMessage(b []byte) (m, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return nil, b, false
+	}
+
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, b, false
+	}
+
+	return b[2 : 2+n], b[2+n:], true
+}
+
+// A nisResponse is a response recorded in a NIS capture.
+type nisResponse struct {
+	lines    []string
+	complete bool
+}
+
+// NewReplayDialFunc creates a DialFunc whose connections answer NIS commands
+// with the responses recorded in the NIS capture read from r, rather than
+// dialing apcupsd.  The responses to each command are replayed in the order
+// they were recorded, starting over once all have been replayed, and those
+// which were incomplete end with the connection being closed.  Commands which
+// were not recorded are answered with an empty response.
+func NewReplayDialFunc(r io.Reader) (DialFunc, error) {
+	responses, err := parseCapture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu   sync.Mutex
+		next = make(map[string]int)
+	)
+	respond := func(cmd string) nisResponse {
+		mu.Lock()
+		defer mu.Unlock()
+
+		rs := responses[cmd]
+		if len(rs) == 0 {
+			return nisResponse{complete: true}
+		}
+
+		res := rs[next[cmd]%len(rs)]
+		next[cmd]++
+		return res
+	}
+
+	return func(context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplay(server, respond)
+		return client, nil
+	}, nil
+}
+
+// NewReplaySource creates a StatusSource which retrieves the UPS status from
+// the responses recorded in the NIS capture read from r, as NewReplayDialFunc
+// replays them.  Timestamps reported without a UTC offset are interpreted in
+// loc, or in the local time zone if loc is nil.
+func NewReplaySource(r io.Reader, loc *time.Location) (StatusSource, error) {
+	dial, err := NewReplayDialFunc(r)
+	if err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	return &dialSource{dial: dial, loc: loc}, nil
+}
+
+// serveReplay answers the NIS commands read from c with the responses
+// returned by respond, until c is closed.
+func serveReplay(c net.Conn, respond func(cmd string) nisResponse) {
+	defer c.Close()
+
+	for {
+		cmd, err := readMessage(c)
+		if err != nil {
+			return
+		}
+
+		res := respond(string(cmd))
+		for _, l := range res.lines {
+			if err := writeMessage(c, []byte(l)); err != nil {
+				return
+			}
+		}
+		if !res.complete {
+			return
+		}
+
+		// A zero length message terminates the response.
+		if err := writeMessage(c, nil); err != nil {
+			return
+		}
+	}
+}
+
+// readMessage reads a single length-prefixed NIS message from r.
+func readMessage(r io.Reader) ([]byte, error) {
+	var lenb [2]byte
+	if _, err := io.ReadFull(r, lenb[:]); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint16(lenb[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// parseCapture parses the NIS capture read from r into the responses recorded
+// for each command, in order.
+func parseCapture(r io.Reader) (map[string][]nisResponse, error) {
+	var (
+		responses = make(map[string][]nisResponse)
+		cmd       string
+		res       *nisResponse
+		n         int
+	)
+	// finish appends the response being parsed to those of its command.
+	finish := func() {
+		if res != nil {
+			responses[cmd] = append(responses[cmd], *res)
+			res = nil
+		}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for i := 1; sc.Scan(); i++ {
+		l := sc.Text()
+		switch {
+		case l == "" || strings.HasPrefix(l, "#"):
+		case strings.HasPrefix(l, "> "):
+			finish()
+
+			ts, c, ok := strings.Cut(l[2:], " ")
+			if !ok || c == "" {
+				return nil, fmt.Errorf("line %d: missing command of NIS exchange", i)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+				return nil, fmt.Errorf("line %d: invalid time of NIS exchange: %v", i, err)
+			}
+
+			cmd, res = c, &nisResponse{}
+			n++
+		case strings.HasPrefix(l, "< "):
+			if res == nil || res.complete {
+				return nil, fmt.Errorf("line %d: NIS message outside of a response", i)
+			}
+
+			m, err := strconv.Unquote(l[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid NIS message %s: %v", i, l[2:], err)
+			}
+			res.lines = append(res.lines, m)
+		case l == ".":
+			if res == nil || res.complete {
+				return nil, fmt.Errorf("line %d: end of response outside of a response", i)
+			}
+			res.complete = true
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", i, l)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	finish()
+
+	if n == 0 {
+		return nil, fmt.Errorf("NIS capture holds no exchanges")
+	}
+
+	return responses, nil
+}
@@ -0,0 +1,115 @@
+package apcupsdexporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	s := apcupsdtest.NewServer(apcupsdtest.Sequence(
+		apcupsdtest.Status(append(apcupsdtest.DefaultStatus, apcupsdtest.Line("LINEV", "bogus"))...),
+		apcupsdtest.Error(errors.New("connection reset")),
+	))
+	defer s.Close()
+
+	var capture bytes.Buffer
+	ds := &dialSource{
+		dial: NewRecordingDialFunc(func(context.Context) (net.Conn, error) {
+			return s.PipeConn(), nil
+		}, &capture),
+		loc: time.UTC,
+	}
+
+	want, err := ds.Status()
+	if err != nil {
+		t.Fatalf("failed to retrieve status: %v", err)
+	}
+	if _, err := ds.Status(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF, but got: %v", err)
+	}
+
+	if !strings.Contains(capture.String(), `< "LINEV    : bogus\n"`) {
+		t.Fatalf("capture does not hold the status lines:\n%s", capture.String())
+	}
+
+	ss, err := NewReplaySource(strings.NewReader(capture.String()), time.UTC)
+	if err != nil {
+		t.Fatalf("failed to parse capture: %v", err)
+	}
+
+	// The responses are replayed in order, and then start over.
+	for i := 0; i < 2; i++ {
+		got, err := ss.Status()
+		if err != nil {
+			t.Fatalf("failed to replay status: %v", err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected replayed status:\n- want: %+v\n-  got: %+v", want, got)
+		}
+
+		if _, err := ss.Status(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected unexpected EOF, but got: %v", err)
+		}
+	}
+
+	// Commands which were not recorded have an empty response.
+	lines, err := ss.(*dialSource).command(context.Background(), "events")
+	if err != nil || len(lines) != 0 {
+		t.Fatalf("unexpected events response: %q, %v", lines, err)
+	}
+}
+
+func TestParseCaptureErrors(t *testing.T) {
+	tests := []struct {
+		desc, capture, err string
+	}{
+		{
+			desc:    "empty",
+			capture: "# nothing recorded\n",
+			err:     "holds no exchanges",
+		},
+		{
+			desc:    "missing command",
+			capture: "> 2024-01-02T15:04:05Z\n",
+			err:     "line 1: missing command",
+		},
+		{
+			desc:    "bad time",
+			capture: "> yesterday status\n",
+			err:     "line 1: invalid time",
+		},
+		{
+			desc:    "bad message",
+			capture: "> 2024-01-02T15:04:05Z status\n< STATUS : ONLINE\n",
+			err:     "line 2: invalid NIS message",
+		},
+		{
+			desc:    "message after end",
+			capture: "> 2024-01-02T15:04:05Z status\n.\n< \"STATUS : ONLINE\"\n",
+			err:     "line 3: NIS message outside of a response",
+		},
+		{
+			desc:    "unexpected line",
+			capture: "> 2024-01-02T15:04:05Z status\nSTATUS : ONLINE\n",
+			err:     "line 2: unexpected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := NewReplayDialFunc(strings.NewReader(tt.capture))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package apcupsdexporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// chargeSmoothing is the weight of each new charge rate measurement in the
+// smoothed charge rate.
+const chargeSmoothing = 0.5
+
+// A chargeTracker estimates the time until the battery of each UPS is fully
+// charged, from the rate at which its charge percentage has risen while on
+// line power.
+//
+// UPSes report charge in coarse steps, so the rate is measured between
+// successive changes of the charge rather than between collections.
+type chargeTracker struct {
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *chargeState]
+}
+
+// A chargeState is the charging progress of a single UPS.
+type chargeState struct {
+	// charge and at are the charge percentage at its last change, and the
+	// time of that change.
+	charge float64
+	at     time.Time
+
+	// rate is the smoothed charge rate in percent per second, or 0 if
+	// unknown.
+	rate float64
+}
+
+// observe records the charge of s, and returns the estimated time until the
+// battery of the UPS reporting s is fully charged, if known.
+func (ct *chargeTracker) observe(s *apcupsd.Status) (time.Duration, bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	id := identity(s)
+	now := statusTime(s)
+
+	// Discharging invalidates the charging progress.
+	if strings.Contains(s.Status, "ONBATT") {
+		ct.states.delete(id)
+		return 0, false
+	}
+	if s.BatteryChargePercent >= 100 {
+		ct.states.delete(id)
+		return 0, true
+	}
+
+	st, ok := ct.states.get(id)
+	if !ok || s.BatteryChargePercent < st.charge {
+		ct.states.put(id, &chargeState{charge: s.BatteryChargePercent, at: now})
+		return 0, false
+	}
+
+	if s.BatteryChargePercent > st.charge {
+		if dt := now.Sub(st.at).Seconds(); dt > 0 {
+			rate := (s.BatteryChargePercent - st.charge) / dt
+			if st.rate == 0 {
+				st.rate = rate
+			} else {
+				st.rate += chargeSmoothing * (rate - st.rate)
+			}
+		}
+
+		st.charge, st.at = s.BatteryChargePercent, now
+	}
+
+	if st.rate == 0 {
+		return 0, false
+	}
+
+	secs := (100 - s.BatteryChargePercent) / st.rate
+	return time.Duration(secs * float64(time.Second)), true
+}
@@ -0,0 +1,53 @@
+package apcupsdexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestChargeTracker(t *testing.T) {
+	var ct chargeTracker
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		status string
+		charge float64
+		at     time.Duration
+		want   time.Duration
+		ok     bool
+	}{
+		// Discharging, and then the first sample on line power.
+		{status: "ONBATT", charge: 50, at: 0},
+		{status: "ONLINE", charge: 50, at: time.Minute},
+		// No change in charge, so no rate yet.
+		{status: "ONLINE", charge: 50, at: 2 * time.Minute},
+		// 10% in 10 minutes leaves 40% in 40 minutes.
+		{status: "ONLINE", charge: 60, at: 11 * time.Minute, want: 40 * time.Minute, ok: true},
+		// The rate is kept between changes of charge.
+		{status: "ONLINE", charge: 60, at: 12 * time.Minute, want: 40 * time.Minute, ok: true},
+		// 20% in 5 minutes is averaged with the previous rate of 1% per
+		// minute to 2.5% per minute, leaving 20% in 8 minutes.
+		{status: "ONLINE", charge: 80, at: 16 * time.Minute, want: 8 * time.Minute, ok: true},
+		{status: "ONLINE", charge: 100, at: 20 * time.Minute, want: 0, ok: true},
+		// A new outage resets the rate.
+		{status: "ONBATT", charge: 90, at: 30 * time.Minute},
+		{status: "ONLINE", charge: 90, at: 31 * time.Minute},
+	}
+
+	for i, st := range steps {
+		got, ok := ct.observe(&apcupsd.Status{
+			UPSName:              "ups",
+			Status:               st.status,
+			BatteryChargePercent: st.charge,
+			Date:                 start.Add(st.at),
+		})
+		if ok != st.ok {
+			t.Fatalf("step %d: unexpected estimate availability: %v", i, ok)
+		}
+		if diff := got - st.want; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Fatalf("step %d: unexpected time to full: %v, want %v", i, got, st.want)
+		}
+	}
+}
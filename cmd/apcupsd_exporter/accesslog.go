@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+var (
+	accessLog       = flag.Bool("web.access-log", false, "log each HTTP request served by the exporter")
+	accessLogSample = flag.Float64("web.access-log-sample", 1, "fraction of successful HTTP requests to log, between 0 and 1; failed requests are always logged")
+)
+
+// withAccessLog wraps h with a handler which logs requests to logger, if
+// access logging is enabled.
+func withAccessLog(h http.Handler, logger *slog.Logger) (http.Handler, error) {
+	if !*accessLog {
+		return h, nil
+	}
+
+	sample := *accessLogSample
+	if sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("invalid access log sample rate %v: must be between 0 and 1", sample)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		if sw.status < http.StatusBadRequest && rand.Float64() >= sample {
+			return
+		}
+
+		logger.Info("http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent())
+	}), nil
+}
+
+// A statusWriter is an http.ResponseWriter which records the status and size
+// of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by
+// http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithAccessLog(t *testing.T) {
+	defer func(l bool, s float64) { *accessLog, *accessLogSample = l, s }(*accessLog, *accessLogSample)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("OK\n"))
+	})
+
+	tests := []struct {
+		desc   string
+		enable bool
+		sample float64
+		path   string
+		logged bool
+	}{
+		{desc: "disabled", sample: 1, path: "/metrics"},
+		{desc: "enabled", enable: true, sample: 1, path: "/metrics", logged: true},
+		{desc: "not sampled", enable: true, sample: 0, path: "/metrics"},
+		{desc: "failed not sampled", enable: true, sample: 0, path: "/missing", logged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			*accessLog, *accessLogSample = tt.enable, tt.sample
+
+			var buf bytes.Buffer
+			lh, err := withAccessLog(h, slog.New(slog.NewJSONHandler(&buf, nil)))
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set("User-Agent", "Prometheus/2.0")
+			lh.ServeHTTP(httptest.NewRecorder(), r)
+
+			if !tt.logged {
+				if buf.Len() > 0 {
+					t.Fatalf("unexpected log entry: %s", buf.String())
+				}
+				return
+			}
+
+			var entry struct {
+				Path      string `json:"path"`
+				Status    int    `json:"status"`
+				Bytes     int    `json:"bytes"`
+				UserAgent string `json:"user_agent"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("failed to decode log entry %q: %v", buf.String(), err)
+			}
+
+			want := http.StatusOK
+			if tt.path == "/missing" {
+				want = http.StatusNotFound
+			}
+			if entry.Path != tt.path || entry.Status != want || entry.Bytes == 0 || entry.UserAgent != "Prometheus/2.0" {
+				t.Fatalf("unexpected log entry: %+v", entry)
+			}
+		})
+	}
+}
+
+func TestWithAccessLogInvalidSample(t *testing.T) {
+	defer func(l bool, s float64) { *accessLog, *accessLogSample = l, s }(*accessLog, *accessLogSample)
+
+	*accessLog = true
+	for _, s := range []float64{-0.5, 1.5} {
+		*accessLogSample = s
+		if _, err := withAccessLog(http.NotFoundHandler(), slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+			t.Fatalf("expected an error for sample rate %v, but none occurred", s)
+		}
+	}
+}
+
+func TestStatusWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+	// Only the first status written is recorded, as by net/http.
+	sw.WriteHeader(http.StatusAccepted)
+	sw.WriteHeader(http.StatusInternalServerError)
+	_, _ = sw.Write([]byte("hello"))
+
+	if sw.status != http.StatusAccepted || sw.bytes != 5 {
+		t.Fatalf("unexpected status %d and size %d", sw.status, sw.bytes)
+	}
+	if sw.Unwrap() != w {
+		t.Fatal("statusWriter does not unwrap to the underlying writer")
+	}
+}
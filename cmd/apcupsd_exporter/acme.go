@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	acmeDomains   = flag.String("web.acme-domains", "", "comma-separated host names for which certificates are obtained and renewed automatically from an ACME CA such as Let's Encrypt, to serve HTTP over TLS; setting it accepts the terms of service of the CA")
+	acmeEmail     = flag.String("web.acme-email", "", "contact email address of the ACME account, to which the CA may send expiry notices")
+	acmeDirectory = flag.String("web.acme-directory", acme.LetsEncryptURL, "directory URL of the ACME CA used by -web.acme-domains")
+	acmeHTTPAddr  = flag.String("web.acme-http-addr", "", "address such as :80 on which ACME HTTP-01 challenges are answered, and other requests redirected to HTTPS (default: only answer TLS-ALPN-01 challenges on -telemetry.addr)")
+)
+
+// tlsEnabled reports whether HTTP is served over TLS.
+func tlsEnabled() bool {
+	return *tlsCertFile != "" || *acmeDomains != ""
+}
+
+// newACMEManager returns a manager of the certificates of the host names set
+// by -web.acme-domains, cached in the storage directory of st, or nil if ACME
+// is not enabled.
+func newACMEManager(st *storage) (*autocert.Manager, error) {
+	if *acmeDomains == "" {
+		return nil, nil
+	}
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		return nil, errors.New("-web.acme-domains cannot be set with -web.tls-cert-file or -web.tls-key-file")
+	}
+
+	var hosts []string
+	for _, h := range strings.Split(*acmeDomains, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("-web.acme-domains must name at least one host")
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(filepath.Join(st.path, "acme")),
+		Email:      *acmeEmail,
+		Client:     &acme.Client{DirectoryURL: *acmeDirectory},
+	}, nil
+}
+
+// acmeTLSConfig returns the TLS configuration of a server which serves the
+// certificates of m, and answers its TLS-ALPN-01 challenges.
+func acmeTLSConfig(m *autocert.Manager) *tls.Config {
+	cfg := m.TLSConfig()
+	cfg.MinVersion = tls.VersionTLS12
+
+	return cfg
+}
+
+// serveACMEHTTP answers the HTTP-01 challenges of m on -web.acme-http-addr, if
+// set, and redirects other requests to HTTPS.
+func serveACMEHTTP(logger *slog.Logger, m *autocert.Manager) error {
+	if *acmeHTTPAddr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", *acmeHTTPAddr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := http.Serve(l, m.HTTPHandler(nil))
+		logger.Error("failed to serve ACME HTTP-01 challenges", "addr", *acmeHTTPAddr, "err", err)
+	}()
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewACMEManager(t *testing.T) {
+	defer func(d, c string) { *acmeDomains, *tlsCertFile = d, c }(*acmeDomains, *tlsCertFile)
+	*acmeDomains = "ups1.example.com, ups2.example.com,"
+
+	m, err := newACMEManager(&storage{path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	for host, ok := range map[string]bool{
+		"ups1.example.com": true,
+		"ups2.example.com": true,
+		"ups3.example.com": false,
+	} {
+		if err := m.HostPolicy(context.Background(), host); ok != (err == nil) {
+			t.Fatalf("unexpected host policy error for %s: %v", host, err)
+		}
+	}
+
+	*tlsCertFile = "tls.crt"
+	if _, err := newACMEManager(&storage{path: t.TempDir()}); err == nil {
+		t.Fatal("expected an error with both ACME and a certificate file")
+	}
+}
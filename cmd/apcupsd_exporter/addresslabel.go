@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+var (
+	addressLabel         = flag.String("collector.address-label", "", `name of a label, such as "address", set on the metrics of each target to its address, to distinguish UPSes which report the same name at different sites (default: no label)`)
+	addressLabelHostOnly = flag.Bool("collector.address-label.host-only", false, "set the label of -collector.address-label to the host of each target without its port, like the host of an instance label")
+)
+
+// validateTargetLabels checks the labels set by -collector.address-label and
+// -collector.reverse-dns-label against each other, the labels used by the
+// exporter, and the group levels.
+func validateTargetLabels(levels map[string]bool) error {
+	if *addressLabel != "" && *addressLabel == *reverseDNSLabel {
+		return fmt.Errorf("address label and reverse DNS label %q must differ", *addressLabel)
+	}
+
+	for _, l := range []string{*addressLabel, *reverseDNSLabel} {
+		switch {
+		case l == "":
+		case !model.LabelName(l).IsValid() || strings.HasPrefix(l, "__"):
+			return fmt.Errorf("invalid target label %q", l)
+		case reservedLabels[l] || levels[l]:
+			return fmt.Errorf("target label %q conflicts with a label used by the exporter or a group level", l)
+		}
+	}
+
+	return nil
+}
+
+// addressLabelValue returns the value of the label set by
+// -collector.address-label for t.
+func (t targetConfig) addressLabelValue() string {
+	if !*addressLabelHostOnly {
+		return t.Address
+	}
+
+	host, _, err := net.SplitHostPort(t.Address)
+	if err != nil {
+		return t.Address
+	}
+
+	return host
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAddressLabel(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	defer func(addr, label string, hostOnly bool) {
+		*apcupsdAddr, *addressLabel, *addressLabelHostOnly = addr, label, hostOnly
+	}(*apcupsdAddr, *addressLabel, *addressLabelHostOnly)
+	*apcupsdAddr = s.Addr().String()
+	*addressLabel = "address"
+
+	tests := []struct {
+		hostOnly bool
+		want     string
+	}{
+		{want: s.Addr().String()},
+		{hostOnly: true, want: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		*addressLabelHostOnly = tt.hostOnly
+
+		ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+		if err != nil {
+			t.Fatalf("failed to create target set: %v", err)
+		}
+
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(ts)
+
+		want := fmt.Sprintf("# HELP apcupsd_up Whether the last collection of UPS metrics from apcupsd was successful.\n# TYPE apcupsd_up gauge\napcupsd_up{address=%q} 1\n", tt.want)
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_up"); err != nil {
+			t.Fatalf("unexpected metrics: %v", err)
+		}
+	}
+
+	// The label must not clash with those of the exporter.
+	*addressLabel = "target"
+	if _, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil); err == nil {
+		t.Fatal("expected an error with a reserved address label")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selftestPath is the URL path of the admin endpoint which starts a UPS self
+// test.
+const selftestPath = apiV1Path + "/selftest"
+
+const (
+	// selftestTimeout bounds the run time of -admin.selftest-command.
+	selftestTimeout = time.Minute
+
+	// maxSelftestOutput bounds the output of a failed
+	// -admin.selftest-command which is logged and returned.
+	maxSelftestOutput = 4096
+)
+
+var (
+	enableSelftest  = flag.Bool("admin.enable-selftest", false, "enable the HTTP admin endpoint "+selftestPath+", which starts a UPS self test using -admin.selftest-command")
+	selftestCommand = flag.String("admin.selftest-command", "", "command run to start a UPS self test, with the name and address of the target in the APCUPSD_TARGET and APCUPSD_ADDR environment variables, such as a script driving apctest")
+	adminTokenFile  = flag.String("admin.token-file", "", "path to a file containing a bearer token required to use the HTTP admin endpoints, which must be set to enable them")
+)
+
+// A selftester starts UPS self tests on request, one at a time.
+type selftester struct {
+	logger  *slog.Logger
+	ts      *targetSet
+	command string
+
+	mu sync.Mutex
+}
+
+// selftestHandler returns the handler of the self test endpoint, or an error
+// if it is misconfigured.
+func selftestHandler(logger *slog.Logger, ts *targetSet) (http.Handler, error) {
+	if *selftestCommand == "" {
+		return nil, errors.New("-admin.selftest-command must be set to enable self tests")
+	}
+	if *adminTokenFile == "" {
+		return nil, errors.New("-admin.token-file must be set to enable the admin endpoints")
+	}
+
+	token, err := readToken("admin", *adminTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &selftester{logger: logger, ts: ts, command: *selftestCommand}
+	return postHandler(token, st.start), nil
+}
+
+// start starts a self test of the target named by the target query parameter
+// of r, which may be omitted if there is only one target.
+func (st *selftester) start(r *http.Request) error {
+	t, _, err := st.ts.find(r.URL.Query().Get("target"))
+	if err != nil {
+		return err
+	}
+
+	// Self tests take the UPS to battery, so don't let them overlap.
+	if !st.mu.TryLock() {
+		return &httpError{code: http.StatusConflict, err: errors.New("a self test is already being started")}
+	}
+	defer st.mu.Unlock()
+
+	st.logger.Info("starting UPS self test", "target", t.Name, "remote_addr", r.RemoteAddr)
+
+	ctx, cancel := context.WithTimeout(r.Context(), selftestTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, st.command)
+	cmd.Env = append(os.Environ(),
+		"APCUPSD_TARGET="+t.Name,
+		"APCUPSD_ADDR="+t.Address,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > maxSelftestOutput {
+			out = out[:maxSelftestOutput]
+		}
+
+		st.logger.Error("failed to start UPS self test", "target", t.Name, "err", err, "output", string(out))
+		return fmt.Errorf("failed to start self test of target %q: %v: %s", t.Name, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelftestHandlerConfig(t *testing.T) {
+	defer func(c, f string) { *selftestCommand, *adminTokenFile = c, f }(*selftestCommand, *adminTokenFile)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	tests := []struct {
+		desc, command, tokenFile string
+	}{
+		{desc: "no command", tokenFile: token},
+		{desc: "no token file", command: "/bin/true"},
+		{desc: "missing token file", command: "/bin/true", tokenFile: token + ".missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			*selftestCommand, *adminTokenFile = tt.command, tt.tokenFile
+			if _, err := selftestHandler(logger, nil); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestSelftestHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping, the self test command is a shell script")
+	}
+
+	defer func(c, f, cf string) {
+		*selftestCommand, *adminTokenFile, *configFile = c, f, cf
+	}(*selftestCommand, *adminTokenFile, *configFile)
+
+	// The command records its environment, fails for the target "fail", and
+	// waits to be released for the target "slow".
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$APCUPSD_TARGET $APCUPSD_ADDR" >> "` + dir + `/started"
+case "$APCUPSD_TARGET" in
+fail) echo "apctest: UPS is busy"; exit 1 ;;
+slow) while [ ! -f "` + dir + `/release" ]; do sleep 0.01; done ;;
+esac
+`
+	*selftestCommand = filepath.Join(dir, "selftest.sh")
+	*adminTokenFile = filepath.Join(dir, "token")
+	*configFile = filepath.Join(dir, "config.yml")
+	for path, b := range map[string]string{
+		*selftestCommand: script,
+		*adminTokenFile:  "secret\n",
+		*configFile:      "targets: [{name: ups1, address: 'ups1:3551'}, {name: fail, address: 'ups2:3551'}, {name: slow, address: 'ups3:3551'}]",
+	} {
+		if err := os.WriteFile(path, []byte(b), 0o700); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	h, err := selftestHandler(logger, ts)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	post := func(target, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, selftestPath+"?target="+target, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		desc, target, auth string
+		code               int
+		body               string
+	}{
+		{desc: "no token", target: "ups1", code: http.StatusUnauthorized},
+		{desc: "wrong token", target: "ups1", auth: "Bearer wrong", code: http.StatusUnauthorized},
+		{desc: "no target", auth: "Bearer secret", code: http.StatusBadRequest},
+		{desc: "unknown target", target: "ups9", auth: "Bearer secret", code: http.StatusNotFound},
+		{desc: "OK", target: "ups1", auth: "Bearer secret", code: http.StatusOK},
+		{desc: "failed", target: "fail", auth: "Bearer secret", code: http.StatusInternalServerError, body: "apctest: UPS is busy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			w := post(tt.target, tt.auth)
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status code: want %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Fatalf("response does not contain %q: %s", tt.body, w.Body.String())
+			}
+		})
+	}
+
+	// Only the authorized requests for known targets ran the command.
+	b, err := os.ReadFile(filepath.Join(dir, "started"))
+	if err != nil {
+		t.Fatalf("failed to read command log: %v", err)
+	}
+	if got, want := string(b), "ups1 ups1:3551\nfail ups2:3551\n"; got != want {
+		t.Fatalf("unexpected command runs:\n got: %q\nwant: %q", got, want)
+	}
+
+	// A self test cannot be started while another is being started.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("slow", "Bearer secret") }()
+	for {
+		b, _ := os.ReadFile(filepath.Join(dir, "started"))
+		if strings.Contains(string(b), "slow") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if w := post("ups1", "Bearer secret"); w.Code != http.StatusConflict {
+		t.Fatalf("unexpected status code of an overlapping self test: %d", w.Code)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "release"), nil, 0o600); err != nil {
+		t.Fatalf("failed to release self test: %v", err)
+	}
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code of the slow self test: %d", w.Code)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var allowCIDRs cidrFlags
+
+func init() {
+	flag.Var(&allowCIDRs, "web.allow-cidr", "only serve HTTP requests from clients in this CIDR block, such as 192.0.2.0/24 (may be repeated; default: allow all clients)")
+}
+
+// cidrFlags is a flag.Value which accumulates IP prefixes.
+type cidrFlags []netip.Prefix
+
+// String implements flag.Value.
+func (cs *cidrFlags) String() string {
+	ss := make([]string, 0, len(*cs))
+	for _, c := range *cs {
+		ss = append(ss, c.String())
+	}
+
+	return strings.Join(ss, ", ")
+}
+
+// Set implements flag.Value.  A single IP address is treated as a prefix
+// containing only that address.
+func (cs *cidrFlags) Set(s string) error {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		ip, ierr := netip.ParseAddr(s)
+		if ierr != nil {
+			return fmt.Errorf("invalid CIDR block %q: %v", s, err)
+		}
+		p = netip.PrefixFrom(ip, ip.BitLen())
+	}
+
+	*cs = append(*cs, p.Masked())
+	return nil
+}
+
+// contains reports whether addr is contained by any prefix in cs.
+func (cs cidrFlags) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, c := range cs {
+		if c.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withAllowlist wraps h with a handler which rejects requests from clients
+// outside of the CIDR blocks set by -web.allow-cidr, if any.
+func withAllowlist(h http.Handler) http.Handler {
+	return withCIDRs(allowCIDRs, h)
+}
+
+// withCIDRs wraps h with a handler which rejects requests from clients
+// outside of the CIDR blocks of cs, if any.
+func withCIDRs(cs cidrFlags, h http.Handler) http.Handler {
+	if len(cs) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !cs.contains(addr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCIDRFlagsSet(t *testing.T) {
+	var cs cidrFlags
+	for _, s := range []string{"192.0.2.77/24", "2001:db8::1", "198.51.100.1"} {
+		if err := cs.Set(s); err != nil {
+			t.Fatalf("failed to set %q: %v", s, err)
+		}
+	}
+
+	// Prefixes are masked, and single addresses become single-address
+	// prefixes.
+	if got, want := cs.String(), "192.0.2.0/24, 2001:db8::1/128, 198.51.100.1/32"; got != want {
+		t.Fatalf("unexpected CIDR blocks:\n got: %s\nwant: %s", got, want)
+	}
+
+	for _, s := range []string{"", "192.0.2.0/33", "192.0.2.0/", "example.com", "192.0.2.256"} {
+		if err := cs.Set(s); err == nil {
+			t.Fatalf("expected an error setting %q, but none occurred", s)
+		}
+	}
+}
+
+func TestWithCIDRs(t *testing.T) {
+	var cs cidrFlags
+	for _, s := range []string{"192.0.2.0/24", "2001:db8::/32", "198.51.100.1"} {
+		if err := cs.Set(s); err != nil {
+			t.Fatalf("failed to set %q: %v", s, err)
+		}
+	}
+
+	tests := []struct {
+		remote string
+		code   int
+	}{
+		{remote: "192.0.2.10:1234", code: http.StatusOK},
+		{remote: "[2001:db8::10]:1234", code: http.StatusOK},
+		{remote: "198.51.100.1:1234", code: http.StatusOK},
+		// IPv4-mapped IPv6 addresses match IPv4 blocks.
+		{remote: "[::ffff:192.0.2.10]:1234", code: http.StatusOK},
+		{remote: "198.51.100.2:1234", code: http.StatusForbidden},
+		{remote: "203.0.113.1:1234", code: http.StatusForbidden},
+		{remote: "[2001:db9::1]:1234", code: http.StatusForbidden},
+		{remote: "192.0.2.10", code: http.StatusOK},
+		{remote: "invalid", code: http.StatusForbidden},
+		{remote: "@", code: http.StatusForbidden},
+	}
+
+	h := withCIDRs(cs, http.HandlerFunc(healthy))
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remote
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status code: want %d, got %d", tt.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestWithCIDRsAllowAll(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.RemoteAddr = "203.0.113.1:1234"
+	w := httptest.NewRecorder()
+	withCIDRs(nil, http.HandlerFunc(healthy)).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code without CIDR blocks: %d", w.Code)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// URL path prefixes of the versions of the HTTP API.
+//
+// The endpoints under apiV1Path are stable: they only change compatibly, such
+// as by adding query parameters or fields, and are only removed after being
+// deprecated in apiDeprecations for at least one release.  New endpoints are
+// added under apiExperimentalPath until their design settles, where they may
+// change or be removed in any release, and incompatible changes to stable
+// endpoints are made in a new version.
+const (
+	apiV1Path           = "/api/v1"
+	apiExperimentalPath = "/api/experimental"
+)
+
+// An apiDeprecation describes a deprecated endpoint of the HTTP API.
+type apiDeprecation struct {
+	// Since is the time at which the endpoint was deprecated, and Sunset the
+	// time after which it may be removed.
+	Since, Sunset time.Time
+
+	// Successor is the path of the endpoint which replaces it, if any.
+	Successor string
+}
+
+// apiDeprecations are the deprecated endpoints of the HTTP API, keyed by path.
+var apiDeprecations = map[string]apiDeprecation{}
+
+// setHeaders sets the headers of h which announce d to clients, as specified
+// by RFC 9745 and RFC 8594.
+func (d apiDeprecation) setHeaders(h http.Header) {
+	h.Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Successor != "" {
+		h.Add("Link", fmt.Sprintf("<%s>; rel=%q", d.Successor, "successor-version"))
+	}
+}
+
+// withAPIStability wraps h with a handler which announces the deprecated
+// endpoints of the HTTP API, and marks the responses of experimental
+// endpoints, so that clients notice before their automation breaks.
+func withAPIStability(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, ok := apiDeprecations[r.URL.Path]; ok {
+			d.setHeaders(w.Header())
+		}
+		if strings.HasPrefix(r.URL.Path, apiExperimentalPath+"/") {
+			w.Header().Set("X-API-Stability", "experimental")
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
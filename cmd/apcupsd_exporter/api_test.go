@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithAPIStability(t *testing.T) {
+	const (
+		old = apiV1Path + "/old"
+		exp = apiExperimentalPath + "/new"
+	)
+
+	defer func(ds map[string]apiDeprecation) { apiDeprecations = ds }(apiDeprecations)
+	apiDeprecations = map[string]apiDeprecation{
+		old: {
+			Since:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			Sunset:    time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC),
+			Successor: exp,
+		},
+	}
+
+	h := withAPIStability(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	get := func(path string) http.Header {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Header()
+	}
+
+	want := http.Header{
+		"Deprecation": {"@1767225600"},
+		"Sunset":      {"Wed, 01 Jul 2026 00:00:00 GMT"},
+		"Link":        {`<` + exp + `>; rel="successor-version"`},
+	}
+	if got := get(old); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected deprecation headers:\n got: %v\nwant: %v", got, want)
+	}
+
+	if got := get(exp).Get("X-API-Stability"); got != "experimental" {
+		t.Fatalf("unexpected stability of experimental endpoint: %q", got)
+	}
+	if got := get(historyCSVPath); len(got) != 0 {
+		t.Fatalf("unexpected headers of stable endpoint: %v", got)
+	}
+
+	// The specification marks deprecated endpoints too.
+	apiDeprecations[snapshotPath] = apiDeprecation{Since: time.Now()}
+	paths := openAPISpec(openAPIEndpoints{})["paths"].(map[string]map[string]openAPIOperation)
+	if !paths[snapshotPath]["get"].Deprecated || paths[statusPath]["get"].Deprecated {
+		t.Fatal("unexpected deprecated endpoints in the specification")
+	}
+}
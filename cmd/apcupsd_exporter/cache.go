@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var cacheTTL = flag.Duration("web.cache-ttl", 0, "reuse the metrics gathered for a scrape in subsequent scrapes within this duration, so that scrapes by multiple Prometheus servers query apcupsd only once; 0 disables caching")
+
+var _ prometheus.Gatherer = &cachedGatherer{}
+
+// A cachedGatherer is a prometheus.Gatherer which reuses the result of a
+// previous Gather until its TTL expires.
+type cachedGatherer struct {
+	g   prometheus.Gatherer
+	ttl time.Duration
+
+	mu  sync.Mutex
+	at  time.Time
+	mfs []*dto.MetricFamily
+	err error
+}
+
+// Gather implements prometheus.Gatherer.
+func (c *cachedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, _, err := c.gather()
+	return mfs, err
+}
+
+// gather returns the cached result, gathering again if it has expired, along
+// with the time at which the result was gathered.  Concurrent callers wait
+// for a single Gather to complete.
+func (c *cachedGatherer) gather() ([]*dto.MetricFamily, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.at) >= c.ttl {
+		c.mfs, c.err = c.g.Gather()
+		c.at = time.Now()
+	}
+
+	return c.mfs, c.at, c.err
+}
+
+// invalidate discards the cached result, so that the next Gather gathers
+// again.
+func (c *cachedGatherer) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.at = time.Time{}
+	c.mfs, c.err = nil, nil
+}
+
+// withCacheHeaders wraps h, which serves metrics gathered by c, with a handler
+// which advertises the age and remaining lifetime of the cached metrics.
+func (c *cachedGatherer) withCacheHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Refresh the cache if needed so that the headers describe the
+		// metrics served by h.
+		_, at, _ := c.gather()
+
+		age := time.Since(at)
+		maxAge := c.ttl - age
+		if maxAge < 0 {
+			maxAge = 0
+		}
+
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(math.Ceil(maxAge.Seconds()))))
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A countingGatherer is a prometheus.Gatherer which counts its calls.
+type countingGatherer struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+// Gather implements prometheus.Gatherer.
+func (g *countingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.calls++
+	return nil, g.err
+}
+
+func TestCachedGatherer(t *testing.T) {
+	g := &countingGatherer{}
+	c := &cachedGatherer{g: g, ttl: time.Hour}
+
+	// Concurrent scrapes within the TTL gather only once.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.Gather()
+		}()
+	}
+	wg.Wait()
+
+	if g.calls != 1 {
+		t.Fatalf("unexpected number of gathers within the TTL: %d", g.calls)
+	}
+
+	// Errors are cached as well, until the cache is invalidated.
+	c.invalidate()
+	g.err = errors.New("collection failed")
+	if _, err := c.Gather(); err == nil {
+		t.Fatal("expected a gather error, but none occurred")
+	}
+	g.err = nil
+	if _, err := c.Gather(); err == nil {
+		t.Fatal("expected the cached gather error, but none occurred")
+	}
+	if g.calls != 2 {
+		t.Fatalf("unexpected number of gathers after invalidation: %d", g.calls)
+	}
+
+	// An expired result is gathered again.
+	c.ttl = 0
+	if _, err := c.Gather(); err != nil {
+		t.Fatalf("failed to gather: %v", err)
+	}
+	if g.calls != 3 {
+		t.Fatalf("unexpected number of gathers after expiry: %d", g.calls)
+	}
+}
+
+func TestCachedGathererHeaders(t *testing.T) {
+	c := &cachedGatherer{g: prometheus.NewRegistry(), ttl: 15 * time.Second}
+	h := c.withCacheHeaders(http.HandlerFunc(healthy))
+
+	// Backdate the cached result to observe its age.
+	_, _ = c.Gather()
+	c.at = time.Now().Add(-10 * time.Second)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := w.Header().Get("Age"); got != "10" {
+		t.Fatalf("unexpected Age header: %q", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "max-age=5" {
+		t.Fatalf("unexpected Cache-Control header: %q", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+)
+
+var (
+	apcupsdRecord = flag.String("apcupsd.record", "", "path of a file to which each exchange with apcupsd is appended as a NIS capture, which can be attached to bug reports and replayed with -apcupsd.replay; captures include the serial number and hostname reported by apcupsd")
+	apcupsdReplay = flag.String("apcupsd.replay", "", "path of a NIS capture written by -apcupsd.record, whose responses are replayed in order instead of collecting from apcupsd, to reproduce issues with parsing")
+)
+
+// A captureFile is the path of a NIS capture to which exchanges are
+// appended.  The file is opened for each write, so that it can be rotated or
+// removed while the exporter runs.
+type captureFile string
+
+// Write implements io.Writer.
+func (f captureFile) Write(b []byte) (int, error) {
+	file, err := os.OpenFile(string(f), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := file.Write(b)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	return n, err
+}
+
+// newReplayDialFunc returns a DialFunc which replays the NIS capture at path.
+func newReplayDialFunc(path string) (apcupsdexporter.DialFunc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return apcupsdexporter.NewReplayDialFunc(f)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTargetRecordReplay(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	capture := filepath.Join(t.TempDir(), "apcupsd.nis")
+
+	prevAddr, prevRecord, prevReplay := *apcupsdAddr, *apcupsdRecord, *apcupsdReplay
+	*apcupsdAddr, *apcupsdRecord = s.Addr().String(), capture
+	defer func() { *apcupsdAddr, *apcupsdRecord, *apcupsdReplay = prevAddr, prevRecord, prevReplay }()
+
+	want := `
+# HELP apcupsd_ups_load_percent Current UPS load percentage.
+# TYPE apcupsd_ups_load_percent gauge
+apcupsd_ups_load_percent{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 16
+`
+	gather := func() {
+		t.Helper()
+
+		ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+		if err != nil {
+			t.Fatalf("failed to create target set: %v", err)
+		}
+
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(ts)
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_ups_load_percent"); err != nil {
+			t.Fatalf("unexpected metrics: %v", err)
+		}
+	}
+
+	gather()
+	s.Close()
+
+	// The recorded responses are replayed without apcupsd.
+	*apcupsdRecord, *apcupsdReplay = "", capture
+	gather()
+
+	*apcupsdRecord = capture
+	if _, err := newConfig(); err == nil {
+		t.Fatal("expected an error for recording while replaying")
+	}
+
+	*apcupsdRecord, *apcupsdReplay = "", filepath.Join(t.TempDir(), "missing.nis")
+	if _, err := newConfig(); err == nil {
+		t.Fatal("expected an error for a missing NIS capture")
+	}
+}
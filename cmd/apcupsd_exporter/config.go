@@ -0,0 +1,729 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	// Embed the time zone database, since container images often lack one.
+	_ "time/tzdata"
+
+	"github.com/mdlayher/apcupsd"
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// configPath is the URL path of the endpoint which serves the effective
+// configuration.
+const configPath = "/config"
+
+var configFile = flag.String("config.file", "", "path to a YAML configuration file describing the apcupsd targets to collect metrics from, instead of -apcupsd.addr")
+
+// A config is the configuration loaded from -config.file.
+type config struct {
+	// Version is the version of the schema of the configuration file,
+	// which is upgraded to configVersion when it is loaded.
+	Version int `yaml:"version,omitempty"`
+
+	// GroupLevels optionally names the levels of a hierarchy into which
+	// targets are grouped, from the outermost to the innermost, such as
+	// site, room, and rack.  Each level becomes a label.
+	GroupLevels []string       `yaml:"group_levels,omitempty"`
+	Targets     []targetConfig `yaml:"targets"`
+
+	// Discoverers optionally names Discoverers registered with
+	// apcupsdexporter.RegisterDiscoverer, whose targets are collected along
+	// with Targets.
+	Discoverers []string `yaml:"discoverers,omitempty"`
+
+	// ModelSpecs optionally adds the nominal values of UPS models which do
+	// not report them, or replaces those built into the exporter, keyed by
+	// the model name reported by apcupsd.
+	ModelSpecs map[string]modelSpecConfig `yaml:"model_specs,omitempty"`
+
+	// Overrides optionally correct the nominal values reported by UPSes
+	// whose firmware reports them wrongly, by serial number or model.
+	Overrides []overrideConfig `yaml:"overrides,omitempty"`
+
+	// Quirks optionally enable corrections of the values which the
+	// firmware of some UPSes reports wrongly, by model and firmware.
+	Quirks []quirkConfig `yaml:"quirks,omitempty"`
+
+	// SpikeFilters optionally suppress single-sample spikes of noisy fields
+	// of the status of each UPS.
+	SpikeFilters []spikeFilterConfig `yaml:"spike_filters,omitempty"`
+
+	// DerivedMetrics optionally adds metrics computed from the status of
+	// each UPS by arithmetic expressions.
+	DerivedMetrics []derivedMetricConfig `yaml:"derived_metrics,omitempty"`
+
+	// Listeners optionally adds HTTP listeners serving subsets of the
+	// metrics with their own access controls.
+	Listeners []listenerConfig `yaml:"listeners,omitempty"`
+}
+
+// A modelSpecConfig configures the nominal values of a UPS model.  Zero
+// values are unknown.
+type modelSpecConfig struct {
+	NominalPowerWatts      float64 `yaml:"nominal_power_watts,omitempty"`
+	NominalApparentPowerVA float64 `yaml:"nominal_apparent_power_va,omitempty"`
+	BatteryEnergyWattHours float64 `yaml:"battery_energy_watt_hours,omitempty"`
+}
+
+// An overrideConfig replaces the nominal values reported by the UPSes with a
+// serial number or model.  Zero values are left as reported.
+type overrideConfig struct {
+	Model        string `yaml:"model,omitempty"`
+	SerialNumber string `yaml:"serial_number,omitempty"`
+
+	NominalPowerWatts      float64 `yaml:"nominal_power_watts,omitempty"`
+	NominalInputVolts      float64 `yaml:"nominal_input_volts,omitempty"`
+	NominalBatteryVolts    float64 `yaml:"nominal_battery_volts,omitempty"`
+	BatteryEnergyWattHours float64 `yaml:"battery_energy_watt_hours,omitempty"`
+}
+
+// A quirkConfig enables the correction of a value which the firmware of the
+// UPSes with a model and firmware reports wrongly.  Empty fields match any
+// UPS.
+type quirkConfig struct {
+	Name     string `yaml:"name"`
+	Model    string `yaml:"model,omitempty"`
+	Firmware string `yaml:"firmware,omitempty"`
+}
+
+// A spikeFilterConfig suppresses spikes of a field of the status of each UPS,
+// either by the median of its last samples or by a maximum rate of change.
+type spikeFilterConfig struct {
+	Field              string  `yaml:"field"`
+	MedianOf           int     `yaml:"median_of,omitempty"`
+	MaxChangePerSecond float64 `yaml:"max_change_per_second,omitempty"`
+}
+
+// A derivedMetricConfig configures a metric computed from the status of each
+// UPS by an arithmetic expression over its fields, such as
+// "load_percent / 100 * nominal_power".
+type derivedMetricConfig struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help,omitempty"`
+	Expr string `yaml:"expr"`
+
+	expr *apcupsdexporter.Expr
+}
+
+// reservedLabels are the label names which cannot be used as group levels or
+// target labels, because the exporter already uses them as variable labels of
+// its metrics.
+var reservedLabels = map[string]bool{
+	"target":        true,
+	"ups_name":      true,
+	"ups":           true,
+	"hostname":      true,
+	"model":         true,
+	"status":        true,
+	"reason":        true,
+	"plugin":        true,
+	"level":         true,
+	"source":        true,
+	"phase":         true,
+	"field":         true,
+	"path":          true,
+	"mechanism":     true,
+	"version":       true,
+	"status_format": true,
+}
+
+// A targetConfig configures a single apcupsd NIS to collect metrics from.
+type targetConfig struct {
+	// Name optionally identifies the target in the target label and logs,
+	// instead of its address.  This distinguishes multiple apcupsd daemons
+	// on one host which report the same hostname and UPS name.
+	Name string `yaml:"name,omitempty"`
+
+	// Address and Network of the NIS, as in -apcupsd.addr and
+	// -apcupsd.network.
+	Address string `yaml:"address"`
+	Network string `yaml:"network"`
+
+	// IPProtocol and IPProtocolFallback select the IP protocol used to
+	// dial the NIS, as in -apcupsd.ip-protocol and
+	// -apcupsd.ip-protocol-fallback.
+	IPProtocol         string `yaml:"ip_protocol,omitempty"`
+	IPProtocolFallback *bool  `yaml:"ip_protocol_fallback,omitempty"`
+
+	// SSH optionally tunnels connections to the NIS through an SSH server,
+	// in which case Address is dialed from that server.
+	SSH *sshConfig `yaml:"ssh,omitempty"`
+
+	// TLS optionally wraps connections to the NIS in TLS, for a NIS whose
+	// port is wrapped in TLS by a proxy such as stunnel.
+	TLS *targetTLSConfig `yaml:"tls,omitempty"`
+
+	// Simulate optionally replaces the NIS with a simulated UPS following
+	// the named scenario, as in -test.simulate, in which case Address only
+	// identifies the target.
+	Simulate string `yaml:"simulate,omitempty"`
+
+	// Record optionally appends each exchange with the NIS to a capture
+	// file, and Replay replaces the NIS with the responses recorded in one,
+	// as in -apcupsd.record and -apcupsd.replay.
+	Record string `yaml:"record,omitempty"`
+	Replay string `yaml:"replay,omitempty"`
+
+	// Hostname optionally replaces the hostname reported by apcupsd.  It is
+	// a Go template executed with the UPS status, such as
+	// "{{ .UPSName }}.example.com", or simply a fixed name.
+	Hostname string `yaml:"hostname,omitempty"`
+
+	// Groups optionally places the target in a group at each level named by
+	// the group_levels of the configuration.  Only an outer part of the
+	// hierarchy may be set, such as the site and room but not the rack.
+	Groups map[string]string `yaml:"groups,omitempty"`
+
+	// NominalRuntime and NominalRuntimeLoad optionally set the runtime of a
+	// new battery of the UPS, as in -collector.battery.nominal-runtime and
+	// -collector.battery.nominal-runtime-load.
+	NominalRuntime     time.Duration `yaml:"nominal_runtime,omitempty"`
+	NominalRuntimeLoad float64       `yaml:"nominal_runtime_load_percent,omitempty"`
+
+	// ShutdownDuration optionally sets the time the systems protected by the
+	// UPS take to shut down, as in -collector.battery.shutdown-duration.
+	ShutdownDuration time.Duration `yaml:"shutdown_duration,omitempty"`
+
+	// Timezone optionally sets the time zone of timestamps reported by
+	// apcupsd without a UTC offset, as in -apcupsd.timezone.
+	Timezone string `yaml:"timezone,omitempty"`
+
+	// PollInterval optionally sets the interval at which the target is
+	// polled, instead of -collector.poll-interval, such as a longer
+	// interval for a UPS behind a metered link.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+
+	// Timeout optionally sets the deadline for each collection of metrics
+	// from the target, instead of -apcupsd.timeout, such as a longer
+	// deadline for an apcupsd reached through a tunnel.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Maintenance optionally sets windows during which the target is in
+	// maintenance, as reported by apcupsd_maintenance_mode.
+	Maintenance []maintenanceWindow `yaml:"maintenance,omitempty"`
+
+	hostname *template.Template
+	replay   apcupsdexporter.DialFunc
+	location *time.Location
+
+	// reverseName is the host name which the address resolves to, if
+	// enabled by -collector.reverse-dns-label.
+	reverseName string
+}
+
+// loadConfig loads and validates the configuration file at path, keeping only
+// the targets of the shard set by -shard.index and -shard.total.  Files of
+// older versions are migrated to the current version.
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := decodeConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	if err := c.shard(*shardIndex, *shardTotal); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// decodeConfig decodes and validates a configuration file from b, migrating
+// it to the current version first if needed.  Unknown options are rejected,
+// so that options which were renamed are not silently ignored.
+func decodeConfig(b []byte) (*config, error) {
+	doc, from, err := parseConfigDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	if from != configVersion {
+		if b, err = encodeConfigDocument(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+
+	var c config
+	if err := d.Decode(&c); err != nil {
+		return nil, err
+	}
+	c.Version = configVersion
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// validate checks c for errors and applies defaults.
+func (c *config) validate() error {
+	if len(c.Targets) == 0 && len(c.Discoverers) == 0 {
+		return errors.New("no targets configured")
+	}
+
+	for i, d := range c.Discoverers {
+		switch {
+		case d == "file" || d == "flags":
+			return fmt.Errorf("discoverer %d: name %q is reserved", i, d)
+		case slices.Contains(c.Discoverers[:i], d):
+			return fmt.Errorf("discoverer %d: duplicate discoverer %q", i, d)
+		case !slices.Contains(apcupsdexporter.DiscovererNames(), d):
+			return fmt.Errorf("discoverer %d: unknown discoverer %q, must be one of: %s", i, d, strings.Join(apcupsdexporter.DiscovererNames(), ", "))
+		}
+	}
+
+	levels := make(map[string]bool, len(c.GroupLevels))
+	for _, l := range c.GroupLevels {
+		switch {
+		case !model.LabelName(l).IsValid() || strings.HasPrefix(l, "__"):
+			return fmt.Errorf("invalid group level %q", l)
+		case reservedLabels[l]:
+			return fmt.Errorf("group level %q conflicts with a label used by the exporter", l)
+		case levels[l]:
+			return fmt.Errorf("duplicate group level %q", l)
+		}
+		levels[l] = true
+	}
+	if err := validateTargetLabels(levels); err != nil {
+		return err
+	}
+
+	for model, spec := range c.ModelSpecs {
+		if spec.NominalPowerWatts < 0 || spec.NominalApparentPowerVA < 0 || spec.BatteryEnergyWattHours < 0 {
+			return fmt.Errorf("model %q: nominal values must not be negative", model)
+		}
+	}
+
+	for i, o := range c.Overrides {
+		switch {
+		case (o.Model == "") == (o.SerialNumber == ""):
+			return fmt.Errorf("override %d: exactly one of model and serial_number must be set", i)
+		case o.NominalPowerWatts < 0 || o.NominalInputVolts < 0 || o.NominalBatteryVolts < 0 || o.BatteryEnergyWattHours < 0:
+			return fmt.Errorf("override %d: nominal values must not be negative", i)
+		}
+	}
+
+	for i, q := range c.Quirks {
+		if !slices.Contains(apcupsdexporter.QuirkNames(), q.Name) {
+			return fmt.Errorf("quirk %d: unknown quirk %q, must be one of: %s", i, q.Name, strings.Join(apcupsdexporter.QuirkNames(), ", "))
+		}
+	}
+
+	for i, f := range c.SpikeFilters {
+		if !slices.Contains(apcupsdexporter.SpikeFilterFields(), f.Field) {
+			return fmt.Errorf("spike filter %d: unknown field %q, must be one of: %s", i, f.Field, strings.Join(apcupsdexporter.SpikeFilterFields(), ", "))
+		}
+		if err := apcupsdexporter.SpikeFilter(f).Validate(); err != nil {
+			return fmt.Errorf("spike filter %d: %v", i, err)
+		}
+	}
+
+	names := make(map[string]bool, len(c.DerivedMetrics))
+	for i := range c.DerivedMetrics {
+		m := &c.DerivedMetrics[i]
+		if !model.IsValidMetricName(model.LabelValue("apcupsd_" + m.Name)) {
+			return fmt.Errorf("derived metric %d: invalid name %q", i, m.Name)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("derived metric %d: duplicate name %q", i, m.Name)
+		}
+		names[m.Name] = true
+
+		expr, err := apcupsdexporter.ParseExpr(m.Expr)
+		if err != nil {
+			return fmt.Errorf("derived metric %q: %v", m.Name, err)
+		}
+		m.expr = expr
+	}
+
+	addrs := make(map[string]bool, len(c.Listeners))
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("listener %d: %v", i, err)
+		}
+		if addrs[l.Address] || l.Address == *telemetryAddr {
+			return fmt.Errorf("listener %d: duplicate address %q", i, l.Address)
+		}
+		addrs[l.Address] = true
+	}
+
+	return c.validateTargets(0, make(map[string]bool, len(c.Targets)))
+}
+
+// addTargets validates ts and appends them to the targets of c, which must
+// already be valid.
+func (c *config) addTargets(ts []targetConfig) error {
+	seen := make(map[string]bool, len(c.Targets)+len(ts))
+	for _, t := range c.Targets {
+		seen[t.Address], seen[t.Name] = true, true
+	}
+
+	from := len(c.Targets)
+	c.Targets = append(c.Targets, ts...)
+	return c.validateTargets(from, seen)
+}
+
+// validateTargets checks the targets of c from the index from onward for
+// errors and applies defaults.  seen holds the addresses and names of the
+// targets before them.
+func (c *config) validateTargets(from int, seen map[string]bool) error {
+	levels := make(map[string]bool, len(c.GroupLevels))
+	for _, l := range c.GroupLevels {
+		levels[l] = true
+	}
+
+	for i := from; i < len(c.Targets); i++ {
+		t := &c.Targets[i]
+		if t.Address == "" {
+			return fmt.Errorf("target %d: address must be specified", i)
+		}
+		t.Address = targetAddress(t.Address)
+		if seen[t.Address] {
+			return fmt.Errorf("target %d: duplicate address %q", i, t.Address)
+		}
+		seen[t.Address] = true
+
+		if t.Name == "" {
+			t.Name = t.Address
+		} else if t.Name != t.Address && seen[t.Name] {
+			return fmt.Errorf("target %q: duplicate name %q", t.Address, t.Name)
+		}
+		seen[t.Name] = true
+
+		switch t.Network {
+		case "":
+			t.Network = "tcp"
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return fmt.Errorf("target %q: invalid network %q", t.Address, t.Network)
+		}
+
+		if t.IPProtocol == "" {
+			t.IPProtocol = *apcupsdIPProtocol
+		}
+		if t.IPProtocolFallback == nil {
+			t.IPProtocolFallback = apcupsdIPFallback
+		}
+		switch t.IPProtocol {
+		case "":
+		case "ip4", "ip6":
+			if t.Network != "tcp" {
+				return fmt.Errorf("target %q: IP protocol %q conflicts with network %q", t.Address, t.IPProtocol, t.Network)
+			}
+		default:
+			return fmt.Errorf("target %q: invalid IP protocol %q", t.Address, t.IPProtocol)
+		}
+
+		if t.SSH != nil {
+			if t.IPProtocol != "" || t.Network != "tcp" {
+				return fmt.Errorf("target %q: network and IP protocol cannot be set with SSH", t.Address)
+			}
+			if err := t.SSH.validate(); err != nil {
+				return fmt.Errorf("target %q: %v", t.Address, err)
+			}
+		}
+		if t.TLS != nil {
+			if err := t.TLS.validate(t.Address); err != nil {
+				return fmt.Errorf("target %q: %v", t.Address, err)
+			}
+		}
+		if t.Simulate != "" {
+			if _, ok := scenarios[t.Simulate]; !ok {
+				return fmt.Errorf("target %q: unknown scenario %q, must be one of %s", t.Address, t.Simulate, scenarioNames())
+			}
+			if t.SSH != nil || t.TLS != nil {
+				return fmt.Errorf("target %q: SSH and TLS cannot be set for a simulated UPS", t.Address)
+			}
+			if *simulateCycle <= 0 {
+				return fmt.Errorf("invalid simulation cycle %s", *simulateCycle)
+			}
+		}
+		if t.Replay != "" {
+			if t.SSH != nil || t.TLS != nil || t.Simulate != "" || t.Record != "" {
+				return fmt.Errorf("target %q: SSH, TLS, simulation, and recording cannot be set when replaying a NIS capture", t.Address)
+			}
+
+			dial, err := newReplayDialFunc(t.Replay)
+			if err != nil {
+				return fmt.Errorf("target %q: failed to load NIS capture: %v", t.Address, err)
+			}
+			t.replay = dial
+		}
+		if t.Record != "" {
+			// Catch unwritable capture files up front, since errors
+			// recording exchanges are otherwise not reported.
+			if _, err := captureFile(t.Record).Write(nil); err != nil {
+				return fmt.Errorf("target %q: failed to open NIS capture: %v", t.Address, err)
+			}
+		}
+
+		if t.Hostname != "" {
+			tmpl, err := template.New("hostname").Option("missingkey=error").Parse(t.Hostname)
+			if err == nil {
+				// Catch references to unknown status fields up front.
+				err = tmpl.Execute(io.Discard, &apcupsd.Status{})
+			}
+			if err != nil {
+				return fmt.Errorf("target %q: invalid hostname: %v", t.Address, err)
+			}
+			t.hostname = tmpl
+		}
+
+		if t.NominalRuntime == 0 {
+			t.NominalRuntime = *nominalRuntime
+		}
+		if t.NominalRuntimeLoad == 0 {
+			t.NominalRuntimeLoad = *nominalRuntimeLoad
+		}
+		if t.NominalRuntime < 0 || t.NominalRuntimeLoad <= 0 || t.NominalRuntimeLoad > 100 {
+			return fmt.Errorf("target %q: invalid nominal runtime %s at %v%% load", t.Address, t.NominalRuntime, t.NominalRuntimeLoad)
+		}
+
+		if t.ShutdownDuration == 0 {
+			t.ShutdownDuration = *shutdownDuration
+		}
+		if t.ShutdownDuration < 0 {
+			return fmt.Errorf("target %q: invalid shutdown duration %s", t.Address, t.ShutdownDuration)
+		}
+
+		if t.PollInterval == 0 {
+			t.PollInterval = *pollInterval
+		}
+		switch {
+		case t.PollInterval < 0:
+			return fmt.Errorf("target %q: invalid poll interval %s", t.Address, t.PollInterval)
+		case t.PollInterval > 0 && *pollInterval <= 0:
+			return fmt.Errorf("target %q: poll interval requires -collector.poll-interval", t.Address)
+		}
+
+		if t.Timeout == 0 {
+			t.Timeout = *apcupsdTimeout
+		}
+		if t.Timeout < 0 {
+			return fmt.Errorf("target %q: invalid timeout %s", t.Address, t.Timeout)
+		}
+
+		if t.Timezone == "" {
+			t.Timezone = *apcupsdTimezone
+		}
+		loc, err := targetLocation(t.Timezone)
+		if err != nil {
+			return fmt.Errorf("target %q: invalid timezone: %v", t.Address, err)
+		}
+		t.location = loc
+
+		for l := range t.Groups {
+			if !levels[l] {
+				return fmt.Errorf("target %q: group level %q is not one of the configured group_levels", t.Address, l)
+			}
+		}
+		for j := 1; j < len(c.GroupLevels); j++ {
+			if t.Groups[c.GroupLevels[j]] != "" && t.Groups[c.GroupLevels[j-1]] == "" {
+				return fmt.Errorf("target %q: group level %q is set without its parent %q", t.Address, c.GroupLevels[j], c.GroupLevels[j-1])
+			}
+		}
+
+		for j, w := range t.Maintenance {
+			if err := w.validate(); err != nil {
+				return fmt.Errorf("target %q: maintenance window %d: %v", t.Address, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// modelSpecs returns the nominal values of UPS models, including those built
+// into the exporter.
+func (c *config) modelSpecs() apcupsdexporter.ModelSpecs {
+	specs := make(apcupsdexporter.ModelSpecs, len(c.ModelSpecs))
+	for model, spec := range c.ModelSpecs {
+		specs[model] = apcupsdexporter.ModelSpec(spec)
+	}
+
+	return apcupsdexporter.DefaultModelSpecs.With(specs)
+}
+
+// quirks returns the corrections of the values reported by UPSes.
+func (c *config) quirks() []apcupsdexporter.Quirk {
+	quirks := make([]apcupsdexporter.Quirk, 0, len(c.Quirks))
+	for _, q := range c.Quirks {
+		quirks = append(quirks, apcupsdexporter.Quirk(q))
+	}
+
+	return quirks
+}
+
+// spikeFilters returns the filters of spikes of the fields of UPS statuses.
+func (c *config) spikeFilters() []apcupsdexporter.SpikeFilter {
+	filters := make([]apcupsdexporter.SpikeFilter, 0, len(c.SpikeFilters))
+	for _, f := range c.SpikeFilters {
+		filters = append(filters, apcupsdexporter.SpikeFilter(f))
+	}
+
+	return filters
+}
+
+// overrides returns the overrides of the nominal values reported by UPSes.
+func (c *config) overrides() []apcupsdexporter.Override {
+	overrides := make([]apcupsdexporter.Override, 0, len(c.Overrides))
+	for _, o := range c.Overrides {
+		overrides = append(overrides, apcupsdexporter.Override(o))
+	}
+
+	return overrides
+}
+
+// derivedMetrics returns the metrics derived from the status of each UPS.
+func (c *config) derivedMetrics() []apcupsdexporter.DerivedMetric {
+	ms := make([]apcupsdexporter.DerivedMetric, 0, len(c.DerivedMetrics))
+	for _, m := range c.DerivedMetrics {
+		ms = append(ms, apcupsdexporter.DerivedMetric{Name: m.Name, Help: m.Help, Expr: m.expr})
+	}
+
+	return ms
+}
+
+// defaultPort is the default port of the apcupsd NIS.
+const defaultPort = "3551"
+
+// targetAddress adds the default NIS port to addr if it has none.  Both
+// bracketed and bare IPv6 literals are accepted.
+func targetAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, defaultPort)
+}
+
+// targetLocation loads the time zone named tz, where an empty name is the
+// local time zone.
+func targetLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(tz)
+}
+
+// dialFunc returns the DialFunc used to dial t.
+func (t *targetConfig) dialFunc() apcupsdexporter.DialFunc {
+	var dial apcupsdexporter.DialFunc
+	switch {
+	case t.replay != nil:
+		dial = t.replay
+	case t.Simulate != "":
+		sim := &simulator{sc: scenarios[t.Simulate], start: simulationStart, cycle: *simulateCycle}
+		dial = sim.dialFunc()
+	case t.SSH != nil:
+		dial = newSSHTunnel(t.SSH).dialFunc(t.Address)
+	case t.IPProtocol == "":
+		dial = apcupsdexporter.NewDialFunc(t.Network, t.Address)
+	default:
+		dial = apcupsdexporter.NewIPDialFunc(t.Address, t.IPProtocol, *t.IPProtocolFallback)
+	}
+
+	if t.TLS != nil {
+		dial = withTLS(dial, t.TLS.config)
+	}
+	if t.Record != "" {
+		dial = apcupsdexporter.NewRecordingDialFunc(dial, captureFile(t.Record))
+	}
+
+	return dial
+}
+
+// hostnameFunc returns a function which produces the hostname label of t from
+// a UPS status, or nil if the hostname is not overridden.
+func (t *targetConfig) hostnameFunc() func(s *apcupsd.Status) string {
+	tmpl := t.hostname
+	if tmpl == nil {
+		return nil
+	}
+
+	return func(s *apcupsd.Status) string {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, s); err != nil {
+			// Fall back to the hostname reported by apcupsd.
+			return s.Hostname
+		}
+
+		return sb.String()
+	}
+}
+
+// configHandler serves the current effective configuration: the value of each flag,
+// and the targets and other sections loaded from -config.file or the apcupsd
+// flags.  Credentials such as SSH keys and the bearer tokens of listeners are
+// only configured by file path, so their contents are never displayed.  The
+// arguments of exec plugins may contain credentials, so only the names of
+// plugins are displayed.
+func configHandler(current func() *config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		c := current()
+
+		flags := make(map[string]string)
+		flag.VisitAll(func(f *flag.Flag) {
+			flags[f.Name] = f.Value.String()
+		})
+		flags["plugin.exec"] = strings.Join(plugins.names(), ", ")
+
+		b, err := yaml.Marshal(struct {
+			Version        int                        `yaml:"version,omitempty"`
+			Flags          map[string]string          `yaml:"flags"`
+			GroupLevels    []string                   `yaml:"group_levels,omitempty"`
+			Targets        []targetConfig             `yaml:"targets"`
+			Discoverers    []string                   `yaml:"discoverers,omitempty"`
+			ModelSpecs     map[string]modelSpecConfig `yaml:"model_specs,omitempty"`
+			Overrides      []overrideConfig           `yaml:"overrides,omitempty"`
+			Quirks         []quirkConfig              `yaml:"quirks,omitempty"`
+			SpikeFilters   []spikeFilterConfig        `yaml:"spike_filters,omitempty"`
+			DerivedMetrics []derivedMetricConfig      `yaml:"derived_metrics,omitempty"`
+			Listeners      []listenerConfig           `yaml:"listeners,omitempty"`
+		}{
+			Version:        c.Version,
+			Flags:          flags,
+			GroupLevels:    c.GroupLevels,
+			Targets:        c.Targets,
+			Discoverers:    c.Discoverers,
+			ModelSpecs:     c.ModelSpecs,
+			Overrides:      c.Overrides,
+			Quirks:         c.Quirks,
+			SpikeFilters:   c.SpikeFilters,
+			DerivedMetrics: c.DerivedMetrics,
+			Listeners:      c.Listeners,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(b)
+	})
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigDerivedMetrics(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+targets:
+  - name: rack1
+    address: %s
+derived_metrics:
+  - name: output_watts
+    help: Output power in watts.
+    expr: load_percent / 100 * nominal_power
+`, s.Addr())
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := `
+# HELP apcupsd_output_watts Output power in watts.
+# TYPE apcupsd_output_watts gauge
+apcupsd_output_watts{hostname="apcupsd",model="Back-UPS RS 1500G",target="rack1",ups_name="ups"} 138.4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_output_watts"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	for _, file := range []string{
+		"targets: [{address: ups1}]\nderived_metrics: [{name: output-watts, expr: nominal_power}]",
+		"targets: [{address: ups1}]\nderived_metrics: [{name: watts, expr: nominal_power}, {name: watts, expr: 1}]",
+		"targets: [{address: ups1}]\nderived_metrics: [{name: watts, expr: nominal_pwr}]",
+	} {
+		if _, err := decodeConfig([]byte(file)); err == nil {
+			t.Fatalf("expected an error decoding config %q, but none occurred", file)
+		}
+	}
+}
+
+func TestNewConfigHostname(t *testing.T) {
+	defer func(h string) { *apcupsdHostname = h }(*apcupsdHostname)
+	*apcupsdHostname = "{{ .UPSName }}.example.com"
+
+	c, err := newConfig()
+	if err != nil {
+		t.Fatalf("failed to configure targets: %v", err)
+	}
+
+	if got, want := c.Targets[0].hostnameFunc()(&apcupsd.Status{UPSName: "ups"}), "ups.example.com"; got != want {
+		t.Fatalf("unexpected hostname: want %q, got %q", want, got)
+	}
+
+	*apcupsdHostname = "{{ .NoSuchField }}"
+	if _, err := newConfig(); err == nil {
+		t.Fatal("expected an error for an invalid hostname template, but none occurred")
+	}
+}
+
+func TestConfigTargetNames(t *testing.T) {
+	c, err := decodeConfig([]byte("targets: [{address: ups1, name: rack1}, {address: ups2}]"))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	// Targets without a name are named by their address.
+	var names []string
+	for _, t := range c.Targets {
+		names = append(names, t.Name)
+	}
+	if got, want := strings.Join(names, ","), "rack1,ups2:3551"; got != want {
+		t.Fatalf("unexpected target names: want %q, got %q", want, got)
+	}
+
+	for _, file := range []string{
+		"targets: [{address: ups1, name: rack1}, {address: ups2, name: rack1}]",
+		"targets: [{address: ups1, name: rack1}, {address: ups2, name: 'ups1:3551'}]",
+		"targets: [{address: ups1}, {address: ups2, name: 'ups1:3551'}]",
+		"targets: [{address: ups1}, {address: 'ups1:3551'}]",
+	} {
+		if _, err := decodeConfig([]byte(file)); err == nil {
+			t.Fatalf("expected an error loading config %q, but none occurred", file)
+		}
+	}
+}
+
+func TestConfigGroups(t *testing.T) {
+	if _, err := decodeConfig([]byte("group_levels: [site, room]\ntargets: [{address: ups1, groups: {site: hq}}, {address: ups2}]")); err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	for _, file := range []string{
+		"group_levels: [site, site]\ntargets: [{address: ups1}]",
+		"group_levels: [1site]\ntargets: [{address: ups1}]",
+		"group_levels: [__site]\ntargets: [{address: ups1}]",
+		"group_levels: [target]\ntargets: [{address: ups1}]",
+		"group_levels: [site]\ntargets: [{address: ups1, groups: {room: a}}]",
+		"targets: [{address: ups1, groups: {site: hq}}]",
+		"group_levels: [site, room]\ntargets: [{address: ups1, groups: {room: a}}]",
+	} {
+		if _, err := decodeConfig([]byte(file)); err == nil {
+			t.Fatalf("expected an error loading config %q, but none occurred", file)
+		}
+	}
+}
+
+func TestConfigHandlerFlags(t *testing.T) {
+	defer func(ps pluginFlags) { plugins = ps }(plugins)
+
+	plugins = nil
+	if err := plugins.Set("battery=/usr/local/bin/battery --password=s3cret"); err != nil {
+		t.Fatalf("failed to set plugin: %v", err)
+	}
+
+	c, err := decodeConfig([]byte("version: 1\ntargets: [{address: ups1, name: rack1}]"))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	configHandler(func() *config { return c }).ServeHTTP(w, httptest.NewRequest(http.MethodGet, configPath, nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	var got struct {
+		Flags   map[string]string `yaml:"flags"`
+		Targets []targetConfig    `yaml:"targets"`
+	}
+	if err := yaml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode configuration: %v", err)
+	}
+
+	if len(got.Targets) != 1 || got.Targets[0].Name != "rack1" || got.Targets[0].Address != "ups1:3551" {
+		t.Fatalf("unexpected configuration:\n%s", w.Body.String())
+	}
+	if got.Flags["apcupsd.addr"] != *apcupsdAddr {
+		t.Fatalf("unexpected -apcupsd.addr flag: %q", got.Flags["apcupsd.addr"])
+	}
+
+	// Only the names of exec plugins are shown, as their arguments may
+	// contain secrets.
+	if p := got.Flags["plugin.exec"]; p != "battery" {
+		t.Fatalf("unexpected -plugin.exec flag: %q", p)
+	}
+}
+
+func TestNewConfigAddresses(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	defer func(as addressFlags, r string) { apcupsdAddrs, *apcupsdRecord = as, r }(apcupsdAddrs, *apcupsdRecord)
+	apcupsdAddrs = nil
+	if err := apcupsdAddrs.Set(s.Addr().String() + ", 127.0.0.1:1"); err != nil {
+		t.Fatalf("failed to set addresses: %v", err)
+	}
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := fmt.Sprintf(`
+# HELP apcupsd_up Whether the last collection of UPS metrics from apcupsd was successful.
+# TYPE apcupsd_up gauge
+apcupsd_up{target="127.0.0.1:1"} 0
+apcupsd_up{target=%q} 1
+`, s.Addr().String())
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_up"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	*apcupsdRecord = filepath.Join(t.TempDir(), "capture")
+	if _, err := newConfig(); err == nil {
+		t.Fatal("expected an error recording multiple targets, but none occurred")
+	}
+
+	var as addressFlags
+	if err := as.Set("ups1,,ups2"); err == nil {
+		t.Fatal("expected an error for an empty address, but none occurred")
+	}
+}
+
+func TestReservedLabels(t *testing.T) {
+	defer func(a, r string) { *addressLabel, *reverseDNSLabel = a, r }(*addressLabel, *reverseDNSLabel)
+
+	for l := range reservedLabels {
+		t.Run(l, func(t *testing.T) {
+			if _, err := decodeConfig(fmt.Appendf(nil, "group_levels: [%s]\ntargets: [{address: ups1}]", l)); err == nil {
+				t.Fatal("expected an error with a reserved group level, but none occurred")
+			}
+
+			*addressLabel, *reverseDNSLabel = l, ""
+			if err := validateTargetLabels(nil); err == nil {
+				t.Fatal("expected an error with a reserved address label, but none occurred")
+			}
+
+			*addressLabel, *reverseDNSLabel = "", l
+			if err := validateTargetLabels(nil); err == nil {
+				t.Fatal("expected an error with a reserved reverse DNS label, but none occurred")
+			}
+		})
+	}
+	*addressLabel, *reverseDNSLabel = "", ""
+
+	// Every label of the metrics of a target must be reserved.
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf("group_levels: [site]\ntargets:\n  - address: %s\n    groups: {site: hq}\n", s.Addr())
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if l := lp.GetName(); !reservedLabels[l] && l != "site" {
+					t.Errorf("%s: label %q is not reserved", mf.GetName(), l)
+				}
+			}
+		}
+	}
+}
+
+func TestConfigQuirks(t *testing.T) {
+	c, err := decodeConfig([]byte("targets: [{address: ups1}]\nquirks: [{name: line_volts_zero_online, model: Back-UPS RS 1500G, firmware: '878.L4'}]"))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	want := []apcupsdexporter.Quirk{{Name: "line_volts_zero_online", Model: "Back-UPS RS 1500G", Firmware: "878.L4"}}
+	if got := c.quirks(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected quirks:\n- want: %+v\n-  got: %+v", want, got)
+	}
+
+	if _, err := decodeConfig([]byte("targets: [{address: ups1}]\nquirks: [{name: bogus}]")); err == nil {
+		t.Fatal("expected an error for an unknown quirk, but none occurred")
+	}
+}
+
+func TestConfigSpikeFilters(t *testing.T) {
+	c, err := decodeConfig([]byte("targets: [{address: ups1}]\nspike_filters: [{field: time_left, max_change_per_second: 30}, {field: internal_temp, median_of: 5}]"))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	want := []apcupsdexporter.SpikeFilter{
+		{Field: "time_left", MaxChangePerSecond: 30},
+		{Field: "internal_temp", MedianOf: 5},
+	}
+	if got := c.spikeFilters(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected spike filters:\n- want: %+v\n-  got: %+v", want, got)
+	}
+
+	for _, b := range []string{
+		"spike_filters: [{field: status, median_of: 3}]",
+		"spike_filters: [{field: time_left}]",
+		"spike_filters: [{field: time_left, median_of: 3, max_change_per_second: 30}]",
+	} {
+		if _, err := decodeConfig([]byte("targets: [{address: ups1}]\n" + b)); err == nil {
+			t.Fatalf("%s: expected an error, but none occurred", b)
+		}
+	}
+}
+
+func TestConfigTimeout(t *testing.T) {
+	c, err := decodeConfig([]byte("targets: [{address: ups1, timeout: 30s}, {address: ups2}]"))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	if got, want := c.Targets[0].Timeout, 30*time.Second; got != want {
+		t.Fatalf("unexpected timeout: want %s, got %s", want, got)
+	}
+	if got, want := c.Targets[1].Timeout, *apcupsdTimeout; got != want {
+		t.Fatalf("unexpected default timeout: want %s, got %s", want, got)
+	}
+
+	if _, err := decodeConfig([]byte("targets: [{address: ups1, timeout: -1s}]")); err == nil {
+		t.Fatal("expected an error for a negative timeout, but none occurred")
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	if err := os.WriteFile(token, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	c, err := decodeConfig(fmt.Appendf(nil, `
+targets:
+  - address: ups1
+model_specs:
+  Back-UPS XS 1000M:
+    nominal_power_watts: 600
+overrides:
+  - serial_number: 3B1234X56789
+    nominal_input_volts: 230
+quirks:
+  - name: time_left_spike
+    firmware: 878.L4
+spike_filters:
+  - field: internal_temp
+    median_of: 3
+derived_metrics:
+  - name: output_watts
+    expr: load_percent / 100 * nominal_power
+listeners:
+  - address: ":9163"
+    token_file: %s
+`, token))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	configHandler(func() *config { return c }).ServeHTTP(w, httptest.NewRequest(http.MethodGet, configPath, nil))
+
+	body := w.Body.String()
+	for _, s := range []string{
+		"targets:",
+		"model_specs:",
+		"Back-UPS XS 1000M",
+		"overrides:",
+		"3B1234X56789",
+		"quirks:",
+		"time_left_spike",
+		"spike_filters:",
+		"internal_temp",
+		"derived_metrics:",
+		"output_watts",
+		"listeners:",
+		"token_file: " + token,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("configuration lacks %q", s)
+		}
+	}
+	if strings.Contains(body, "s3cret") {
+		t.Error("configuration contains the contents of a token file")
+	}
+}
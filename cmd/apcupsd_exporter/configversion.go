@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configVersion is the current version of the configuration file schema.
+// Files which do not set a version predate versioning, and are version 1.
+const configVersion = 1
+
+// configMigrations upgrade a configuration file from the version of their
+// index to the next, such as by renaming options.  They operate on the root
+// mapping of the YAML document, so that comments are preserved when a file
+// is migrated in place.
+var configMigrations = map[int]func(root *yaml.Node) error{}
+
+// migrateConfig upgrades the configuration file document doc to
+// configVersion, and returns the version it was upgraded from.
+func migrateConfig(doc *yaml.Node) (int, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		// Leave reporting malformed files to the decoder.
+		return configVersion, nil
+	}
+	root := doc.Content[0]
+
+	from := 1
+	v := mappingValue(root, "version")
+	if v != nil {
+		n, err := strconv.Atoi(v.Value)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid version %q", v.Value)
+		}
+		if n > configVersion {
+			return 0, fmt.Errorf("version %d is newer than the latest version %d supported by this exporter", n, configVersion)
+		}
+		from = n
+	}
+
+	for ver := from; ver < configVersion; ver++ {
+		if m := configMigrations[ver]; m != nil {
+			if err := m(root); err != nil {
+				return 0, fmt.Errorf("failed to migrate from version %d: %v", ver, err)
+			}
+		}
+	}
+
+	if v == nil {
+		// Set the version first, where it is easily found, below any
+		// comment heading the file.
+		k := &yaml.Node{Kind: yaml.ScalarNode, Value: "version"}
+		if len(root.Content) > 0 {
+			k.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+
+		v = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int"}
+		root.Content = append([]*yaml.Node{k, v}, root.Content...)
+	}
+	v.Value = strconv.Itoa(configVersion)
+
+	return from, nil
+}
+
+// mappingValue returns the value of key in the mapping node m, or nil if it
+// is not set.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// parseConfigDocument parses b into a YAML document migrated to
+// configVersion, and returns it along with the version it was upgraded from.
+func parseConfigDocument(b []byte) (*yaml.Node, int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, 0, err
+	}
+
+	from, err := migrateConfig(&doc)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &doc, from, nil
+}
+
+// encodeConfigDocument encodes the YAML document doc with the indentation
+// used in the documentation.
+func encodeConfigDocument(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// configCommand implements the "config" subcommand.  "config migrate"
+// upgrades the file set by -config.file to the current version in place.
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return errors.New(`usage: apcupsd_exporter -config.file FILE config migrate [migrate flags]`)
+	}
+
+	fs := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the migrated configuration file to stdout instead of replacing it")
+	_ = fs.Parse(args[1:])
+
+	if *configFile == "" {
+		return errors.New("-config.file must be set to migrate a configuration file")
+	}
+
+	b, err := os.ReadFile(*configFile)
+	if err != nil {
+		return err
+	}
+
+	doc, from, err := parseConfigDocument(b)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", *configFile, err)
+	}
+
+	out, err := encodeConfigDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	// Check that the migrated file is valid before replacing the original.
+	if _, err := decodeConfig(out); err != nil {
+		return fmt.Errorf("migrated config file %s is invalid: %v", *configFile, err)
+	}
+
+	if *dryRun {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+
+	if bytes.Equal(out, b) {
+		fmt.Printf("%s is already at version %d\n", *configFile, configVersion)
+		return nil
+	}
+
+	fi, err := os.Stat(*configFile)
+	if err != nil {
+		return err
+	}
+
+	tmp := *configFile + ".tmp"
+	if err := os.WriteFile(tmp, out, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *configFile); err != nil {
+		return err
+	}
+
+	if from == configVersion {
+		fmt.Printf("set the version of %s to %d\n", *configFile, configVersion)
+	} else {
+		fmt.Printf("migrated %s from version %d to %d\n", *configFile, from, configVersion)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeConfigVersion(t *testing.T) {
+	tests := []struct {
+		desc, file string
+		ok         bool
+	}{
+		{desc: "unversioned", file: "targets: [{address: ups1}]", ok: true},
+		{desc: "current", file: "version: 1\ntargets: [{address: ups1}]", ok: true},
+		{desc: "newer", file: "version: 2\ntargets: [{address: ups1}]"},
+		{desc: "invalid", file: "version: latest\ntargets: [{address: ups1}]"},
+		{desc: "unknown option", file: "targets: [{address: ups1, adress: ups2}]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, err := decodeConfig([]byte(tt.file))
+			if tt.ok != (err == nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && c.Version != configVersion {
+				t.Fatalf("unexpected version: %d", c.Version)
+			}
+		})
+	}
+}
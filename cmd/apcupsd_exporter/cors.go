@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	corsOrigins stringFlags
+	corsMethods = flag.String("web.cors-methods", "GET, POST", "comma-separated HTTP methods which pages on the origins set by -web.cors-origin may use")
+	corsHeaders = flag.String("web.cors-headers", "Authorization, Content-Type", "comma-separated HTTP request headers which pages on the origins set by -web.cors-origin may send")
+	corsMaxAge  = flag.Duration("web.cors-max-age", 0, "how long browsers may cache the response to a CORS preflight request; 0 leaves it to the browser")
+)
+
+func init() {
+	flag.Var(&corsOrigins, "web.cors-origin", `origin such as "https://dashboard.example.com" whose pages may call the HTTP APIs from the browser, or "*" for any origin (may be repeated; default: none)`)
+}
+
+// stringFlags is a flag.Value which accumulates strings.
+type stringFlags []string
+
+// String implements flag.Value.
+func (ss *stringFlags) String() string { return strings.Join(*ss, ", ") }
+
+// Set implements flag.Value.
+func (ss *stringFlags) Set(s string) error {
+	*ss = append(*ss, s)
+	return nil
+}
+
+// allowsOrigin reports whether the origin is set by -web.cors-origin.
+func (ss stringFlags) allowsOrigin(origin string) bool {
+	for _, s := range ss {
+		if s == "*" || strings.EqualFold(strings.TrimSuffix(s, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withCORS wraps h with a handler which allows the pages of the origins set
+// by -web.cors-origin, if any, to call h from the browser, and answers their
+// preflight requests.
+func withCORS(h http.Handler) http.Handler {
+	if len(corsOrigins) == 0 {
+		return h
+	}
+
+	origins := corsOrigins
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !origins.allowsOrigin(origin) {
+			// Serve the request without CORS headers, so that the browser
+			// withholds the response from the page.
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			// Let pages read the headers announcing the stability of the
+			// API.
+			w.Header().Set("Access-Control-Expose-Headers", "Deprecation, Sunset, Link, X-API-Stability")
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Answer the preflight request.
+		w.Header().Set("Access-Control-Allow-Methods", *corsMethods)
+		w.Header().Set("Access-Control-Allow-Headers", *corsHeaders)
+		if *corsMaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCORS(t *testing.T) {
+	defer func(origins stringFlags) { corsOrigins = origins }(corsOrigins)
+	corsOrigins = stringFlags{"https://dashboard.example.com"}
+
+	h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		desc, method, origin string
+		preflight            bool
+		code                 int
+		allow                string
+	}{
+		{desc: "same origin", method: http.MethodGet, code: http.StatusTeapot},
+		{desc: "allowed", method: http.MethodGet, origin: "https://dashboard.example.com", code: http.StatusTeapot, allow: "https://dashboard.example.com"},
+		{desc: "not allowed", method: http.MethodGet, origin: "https://evil.example.com", code: http.StatusTeapot},
+		{desc: "preflight", method: http.MethodOptions, origin: "https://dashboard.example.com", preflight: true, code: http.StatusNoContent, allow: "https://dashboard.example.com"},
+		{desc: "preflight not allowed", method: http.MethodOptions, origin: "https://evil.example.com", preflight: true, code: http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/grafana/query", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status: %d", w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Fatalf("unexpected allowed origin: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	discoveryTimeout         = flag.Duration("discovery.timeout", 10*time.Second, "deadline for each call of a discoverer of -config.file")
+	discoveryRefreshInterval = flag.Duration("discovery.refresh-interval", 5*time.Minute, "interval at which -config.file is reloaded to refresh the targets of its discoverers, if any; 0 refreshes them only when the configuration is reloaded")
+)
+
+var (
+	discoveryTargetsDesc = prometheus.NewDesc(
+		"apcupsd_exporter_discovery_targets",
+		"Number of targets discovered by the last successful refresh of each discovery mechanism.",
+		[]string{"mechanism"}, nil,
+	)
+	discoveryTargetsAddedDesc = prometheus.NewDesc(
+		"apcupsd_exporter_discovery_targets_added_total",
+		"Total number of targets added by refreshes of each discovery mechanism, including those of the first refresh.",
+		[]string{"mechanism"}, nil,
+	)
+	discoveryTargetsRemovedDesc = prometheus.NewDesc(
+		"apcupsd_exporter_discovery_targets_removed_total",
+		"Total number of targets removed by refreshes of each discovery mechanism.",
+		[]string{"mechanism"}, nil,
+	)
+	discoveryErrorsDesc = prometheus.NewDesc(
+		"apcupsd_exporter_discovery_errors_total",
+		"Total number of refreshes of each discovery mechanism which failed, keeping the targets of the last successful refresh.",
+		[]string{"mechanism"}, nil,
+	)
+	discoveryLastRefreshDesc = prometheus.NewDesc(
+		"apcupsd_exporter_discovery_last_refresh_timestamp_seconds",
+		"Unix timestamp of the last successful refresh of each discovery mechanism.",
+		[]string{"mechanism"}, nil,
+	)
+)
+
+// discoveryMechanism returns the name of the mechanism by which the targets
+// are discovered: "file" for -config.file, or "flags" for the apcupsd flags.
+func discoveryMechanism() string {
+	if *configFile != "" {
+		return "file"
+	}
+
+	return "flags"
+}
+
+// discoveryStats records the refreshes of the targets by each discovery
+// mechanism, so that a failing mechanism is noticed before its targets
+// silently vanish.
+type discoveryStats struct {
+	mu         sync.Mutex
+	mechanisms map[string]*mechanismStats
+}
+
+// mechanismStats are the statistics of a single discovery mechanism.
+type mechanismStats struct {
+	targets        int
+	added, removed int
+	errors         int
+	lastRefresh    time.Time
+}
+
+// get returns the statistics of the mechanism named name, adding them if
+// needed.  ds.mu must be held.
+func (ds *discoveryStats) get(name string) *mechanismStats {
+	if ds.mechanisms == nil {
+		ds.mechanisms = make(map[string]*mechanismStats)
+	}
+
+	ms, ok := ds.mechanisms[name]
+	if !ok {
+		ms = &mechanismStats{}
+		ds.mechanisms[name] = ms
+	}
+
+	return ms
+}
+
+// refreshed records a successful refresh of the mechanism named name, which
+// replaced the targets named prev by those named next.
+func (ds *discoveryStats) refreshed(name string, prev, next []targetConfig) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	current := make(map[string]bool, len(prev))
+	for _, t := range prev {
+		current[t.Name] = true
+	}
+
+	ms := ds.get(name)
+	for _, t := range next {
+		if !current[t.Name] {
+			ms.added++
+		}
+		delete(current, t.Name)
+	}
+	ms.removed += len(current)
+	ms.targets, ms.lastRefresh = len(next), time.Now()
+}
+
+// failed records a failed refresh of the mechanism named name.
+func (ds *discoveryStats) failed(name string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.get(name).errors++
+}
+
+// collect sends the statistics of each mechanism to ch.
+func (ds *discoveryStats) collect(ch chan<- prometheus.Metric) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	names := make([]string, 0, len(ds.mechanisms))
+	for n := range ds.mechanisms {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		ms := ds.mechanisms[n]
+		ch <- prometheus.MustNewConstMetric(discoveryTargetsDesc, prometheus.GaugeValue, float64(ms.targets), n)
+		ch <- prometheus.MustNewConstMetric(discoveryTargetsAddedDesc, prometheus.CounterValue, float64(ms.added), n)
+		ch <- prometheus.MustNewConstMetric(discoveryTargetsRemovedDesc, prometheus.CounterValue, float64(ms.removed), n)
+		ch <- prometheus.MustNewConstMetric(discoveryErrorsDesc, prometheus.CounterValue, float64(ms.errors), n)
+		if !ms.lastRefresh.IsZero() {
+			ch <- prometheus.MustNewConstMetric(discoveryLastRefreshDesc, prometheus.GaugeValue, float64(ms.lastRefresh.UnixNano())/1e9, n)
+		}
+	}
+}
+
+// refreshed records a successful refresh of the mechanism named name, which
+// discovered targets.  ts.reloadMu must be held.
+func (ts *targetSet) refreshed(name string, targets []targetConfig) {
+	ts.discovery.refreshed(name, ts.discovered[name], targets)
+
+	if ts.discovered == nil {
+		ts.discovered = make(map[string][]targetConfig)
+	}
+	ts.discovered[name] = slices.Clone(targets)
+}
+
+// discover adds the targets of each discoverer of cfg to its targets.  A
+// discoverer which fails keeps the targets of its last successful refresh,
+// if any.  Targets whose address or name is already used by an earlier target
+// are skipped.  ts.reloadMu must be held.
+func (ts *targetSet) discover(cfg *config) error {
+	if len(cfg.Discoverers) == 0 {
+		return nil
+	}
+
+	for _, name := range cfg.Discoverers {
+		targets, err := discoverTargets(name)
+		if err != nil {
+			ts.logger.Warn("failed to discover targets", "discoverer", name, "err", err)
+			ts.discovery.failed(name)
+			targets = ts.discovered[name]
+		} else {
+			ts.refreshed(name, targets)
+		}
+
+		used := make(map[string]bool, len(cfg.Targets))
+		for _, t := range cfg.Targets {
+			used[t.Address], used[t.Name] = true, true
+		}
+		targets = slices.DeleteFunc(slices.Clone(targets), func(t targetConfig) bool {
+			if used[t.Address] || used[t.Name] {
+				ts.logger.Debug("skipped discovered target which is already configured", "discoverer", name, "target", t.Name)
+				return true
+			}
+
+			return false
+		})
+
+		if err := cfg.addTargets(targets); err != nil {
+			return fmt.Errorf("discoverer %q: %v", name, err)
+		}
+	}
+
+	// Discovered targets are split among the shards like those of the file.
+	return cfg.shard(*shardIndex, *shardTotal)
+}
+
+// discoverTargets returns the validated targets of the discoverer named name.
+func discoverTargets(name string) ([]targetConfig, error) {
+	d, ok := apcupsdexporter.LookupDiscoverer(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown discoverer %q", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *discoveryTimeout)
+	defer cancel()
+
+	targets, err := d.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{Targets: make([]targetConfig, 0, len(targets))}
+	for _, t := range targets {
+		c.Targets = append(c.Targets, targetConfig{Name: t.Name, Address: t.Address})
+	}
+	if err := c.validateTargets(0, make(map[string]bool, len(targets))); err != nil {
+		return nil, err
+	}
+
+	return c.Targets, nil
+}
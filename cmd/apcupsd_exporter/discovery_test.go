@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDiscoveryStats(t *testing.T) {
+	defer func(c string) { *configFile = c }(*configFile)
+	*configFile = filepath.Join(t.TempDir(), "config.yml")
+
+	write := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(*configFile, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+	}
+
+	write("targets: [{name: ups1, address: '127.0.0.1:1'}, {name: ups2, address: '127.0.0.1:2'}]")
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	write("targets: [{name: ups1, address: '127.0.0.1:1'}, {name: ups3, address: '127.0.0.1:3'}]")
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	write("targets: [{name: ups1, address: ")
+	if err := ts.reload(); err == nil {
+		t.Fatal("expected an error reloading an invalid config, but none occurred")
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := `
+# HELP apcupsd_exporter_discovery_errors_total Total number of refreshes of each discovery mechanism which failed, keeping the targets of the last successful refresh.
+# TYPE apcupsd_exporter_discovery_errors_total counter
+apcupsd_exporter_discovery_errors_total{mechanism="file"} 1
+# HELP apcupsd_exporter_discovery_targets Number of targets discovered by the last successful refresh of each discovery mechanism.
+# TYPE apcupsd_exporter_discovery_targets gauge
+apcupsd_exporter_discovery_targets{mechanism="file"} 2
+# HELP apcupsd_exporter_discovery_targets_added_total Total number of targets added by refreshes of each discovery mechanism, including those of the first refresh.
+# TYPE apcupsd_exporter_discovery_targets_added_total counter
+apcupsd_exporter_discovery_targets_added_total{mechanism="file"} 3
+# HELP apcupsd_exporter_discovery_targets_removed_total Total number of targets removed by refreshes of each discovery mechanism.
+# TYPE apcupsd_exporter_discovery_targets_removed_total counter
+apcupsd_exporter_discovery_targets_removed_total{mechanism="file"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"apcupsd_exporter_discovery_errors_total",
+		"apcupsd_exporter_discovery_targets",
+		"apcupsd_exporter_discovery_targets_added_total",
+		"apcupsd_exporter_discovery_targets_removed_total",
+	); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	if n, err := testutil.GatherAndCount(reg, "apcupsd_exporter_discovery_last_refresh_timestamp_seconds"); err != nil || n != 1 {
+		t.Fatalf("unexpected number of last refresh timestamps: %d, %v", n, err)
+	}
+}
+
+// testDiscovered are the targets and error returned by the discoverer
+// registered as "test".
+var testDiscovered struct {
+	targets []apcupsdexporter.Target
+	err     error
+}
+
+func init() {
+	apcupsdexporter.RegisterDiscoverer("test", apcupsdexporter.DiscovererFunc(func(context.Context) ([]apcupsdexporter.Target, error) {
+		return testDiscovered.targets, testDiscovered.err
+	}))
+}
+
+func TestDiscoverers(t *testing.T) {
+	defer func(c string) { *configFile = c }(*configFile)
+	*configFile = filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(*configFile, []byte("targets: [{name: ups1, address: '127.0.0.1:1'}]\ndiscoverers: [test]"), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	defer func() { testDiscovered.targets, testDiscovered.err = nil, nil }()
+	testDiscovered.targets = []apcupsdexporter.Target{
+		{Name: "rack2", Address: "127.0.0.1:2"},
+		// Already configured by the file.
+		{Address: "127.0.0.1:1"},
+	}
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	names := func() string {
+		var names []string
+		for _, t := range ts.config().Targets {
+			names = append(names, t.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	if got, want := names(), "ups1,rack2"; got != want {
+		t.Fatalf("unexpected targets: want %q, got %q", want, got)
+	}
+
+	// A failing discoverer keeps its targets.
+	testDiscovered.err = errors.New("inventory unavailable")
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if got, want := names(), "ups1,rack2"; got != want {
+		t.Fatalf("unexpected targets after failed discovery: want %q, got %q", want, got)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := `
+# HELP apcupsd_exporter_discovery_errors_total Total number of refreshes of each discovery mechanism which failed, keeping the targets of the last successful refresh.
+# TYPE apcupsd_exporter_discovery_errors_total counter
+apcupsd_exporter_discovery_errors_total{mechanism="file"} 0
+apcupsd_exporter_discovery_errors_total{mechanism="test"} 1
+# HELP apcupsd_exporter_discovery_targets Number of targets discovered by the last successful refresh of each discovery mechanism.
+# TYPE apcupsd_exporter_discovery_targets gauge
+apcupsd_exporter_discovery_targets{mechanism="file"} 1
+apcupsd_exporter_discovery_targets{mechanism="test"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"apcupsd_exporter_discovery_errors_total",
+		"apcupsd_exporter_discovery_targets",
+	); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	for _, file := range []string{
+		"targets: []\ndiscoverers: [unknown]",
+		"targets: []\ndiscoverers: [test, test]",
+		"targets: []\ndiscoverers: [file]",
+	} {
+		if _, err := decodeConfig([]byte(file)); err == nil {
+			t.Fatalf("expected an error decoding config %q, but none occurred", file)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "log/slog"
+
+// newSystemLogHandler returns nil, as the Windows Event Log is the only
+// supported system log.
+func newSystemLogHandler(*slog.HandlerOptions) (slog.Handler, error) {
+	return nil, nil
+}
@@ -0,0 +1,166 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+var (
+	logEventLog       = flag.String("log.eventlog", "auto", `write warnings and errors to the Windows Event Log: "auto" when running as a Windows service, "on", or "off"`)
+	logEventLogSource = flag.String("log.eventlog.source", "apcupsd_exporter", "source of the messages written to the Windows Event Log, which is registered if needed")
+)
+
+const (
+	// eventSourcesKey is the registry key under which the event sources of
+	// the Application log are registered.
+	eventSourcesKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application`
+
+	// eventID is the ID of every event written, which only serves to select
+	// the generic message of the EventCreate message file.
+	eventID = 1
+)
+
+// newSystemLogHandler returns a handler which writes warnings and errors to
+// the Windows Event Log, if enabled by -log.eventlog.
+func newSystemLogHandler(opts *slog.HandlerOptions) (slog.Handler, error) {
+	switch *logEventLog {
+	case "on":
+	case "off":
+		return nil, nil
+	case "auto":
+		ok, err := svc.IsWindowsService()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine whether running as a Windows service: %v", err)
+		}
+		if !ok {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid Windows Event Log mode %q", *logEventLog)
+	}
+
+	if err := registerEventSource(*logEventLogSource); err != nil {
+		return nil, err
+	}
+
+	l, err := eventlog.Open(*logEventLogSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Windows Event Log: %v", err)
+	}
+
+	// Informational messages would flood the Event Log, so only write
+	// warnings and errors.
+	level := slog.LevelWarn
+	if l := opts.Level.Level(); l > level {
+		level = l
+	}
+
+	return newEventLogHandler(l, level), nil
+}
+
+// newEventLogHandler returns an eventLogHandler which writes records of at
+// least level to w.
+func newEventLogHandler(w eventWriter, level slog.Level) *eventLogHandler {
+	el := &eventLog{w: w}
+	return &eventLogHandler{
+		el: el,
+		h: slog.NewTextHandler(&el.buf, &slog.HandlerOptions{
+			Level: level,
+			// The Event Log records the time and type of each event.
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+					return slog.Attr{}
+				}
+
+				return a
+			},
+		}),
+	}
+}
+
+// registerEventSource registers src as an event source of the Application
+// log unless it is already registered, which requires administrator
+// privileges.
+func registerEventSource(src string) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, eventSourcesKey+`\`+src, registry.QUERY_VALUE)
+	if err == nil {
+		return k.Close()
+	}
+
+	if err := eventlog.InstallAsEventCreate(src, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		return fmt.Errorf("failed to register Windows Event Log source %q: %v", src, err)
+	}
+
+	return nil
+}
+
+// An eventWriter writes events to the Windows Event Log, as does
+// *eventlog.Log.
+type eventWriter interface {
+	Warning(eid uint32, msg string) error
+	Error(eid uint32, msg string) error
+}
+
+var _ eventWriter = &eventlog.Log{}
+
+// An eventLog is a Windows Event Log shared by an eventLogHandler and the
+// handlers derived from it.
+type eventLog struct {
+	mu  sync.Mutex
+	w   eventWriter
+	buf bytes.Buffer
+}
+
+// An eventLogHandler is a slog.Handler which writes each log record to the
+// Windows Event Log as a warning or error event.
+type eventLogHandler struct {
+	el *eventLog
+	h  slog.Handler
+}
+
+var _ slog.Handler = &eventLogHandler{}
+
+// Enabled implements slog.Handler.
+func (h *eventLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.el.mu.Lock()
+	defer h.el.mu.Unlock()
+
+	// Format the record into the buffer shared by the text handlers.
+	h.el.buf.Reset()
+	if err := h.h.Handle(ctx, r); err != nil {
+		return err
+	}
+
+	msg := strings.TrimSuffix(h.el.buf.String(), "\n")
+	if r.Level >= slog.LevelError {
+		return h.el.w.Error(eventID, msg)
+	}
+
+	return h.el.w.Warning(eventID, msg)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{el: h.el, h: h.h.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{el: h.el, h: h.h.WithGroup(name)}
+}
@@ -0,0 +1,65 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+// A fakeEventWriter is an eventWriter which records events in memory.
+type fakeEventWriter struct {
+	events []string
+}
+
+// Warning implements eventWriter.
+func (w *fakeEventWriter) Warning(eid uint32, msg string) error {
+	w.events = append(w.events, fmt.Sprintf("warning %d: %s", eid, msg))
+	return nil
+}
+
+// Error implements eventWriter.
+func (w *fakeEventWriter) Error(eid uint32, msg string) error {
+	w.events = append(w.events, fmt.Sprintf("error %d: %s", eid, msg))
+	return nil
+}
+
+func TestEventLogHandler(t *testing.T) {
+	w := &fakeEventWriter{}
+	logger := slog.New(newEventLogHandler(w, slog.LevelWarn)).With("target", "ups1")
+
+	logger.Info("starting apcupsd exporter")
+	logger.Warn("failed to export traces", "err", "timeout")
+	logger.WithGroup("collector").Error("failed to collect", "err", "connection refused")
+
+	// Informational messages are not written, and the Event Log records the
+	// time and level of each event itself.
+	want := []string{
+		`warning 1: msg="failed to export traces" target=ups1 err=timeout`,
+		`error 1: msg="failed to collect" target=ups1 collector.err="connection refused"`,
+	}
+	if len(w.events) != len(want) {
+		t.Fatalf("unexpected events: %q", w.events)
+	}
+	for i := range want {
+		if w.events[i] != want[i] {
+			t.Fatalf("unexpected event %d:\n got: %s\nwant: %s", i, w.events[i], want[i])
+		}
+	}
+}
+
+func TestNewSystemLogHandler(t *testing.T) {
+	defer func(m string) { *logEventLog = m }(*logEventLog)
+
+	*logEventLog = "off"
+	h, err := newSystemLogHandler(&slog.HandlerOptions{})
+	if err != nil || h != nil {
+		t.Fatalf("unexpected handler %v with the Event Log off: %v", h, err)
+	}
+
+	*logEventLog = "always"
+	if _, err := newSystemLogHandler(&slog.HandlerOptions{}); err == nil {
+		t.Fatal("expected an error for an invalid mode, but none occurred")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A targetStatus records the result of the most recent collection from a
+// target, so that it can be aggregated across targets and inspected for
+// troubleshooting.
+type targetStatus struct {
+	mu          sync.Mutex
+	s           *apcupsd.Status
+	err         error
+	succeeded   bool
+	parseErrors map[string]error
+
+	// collected and lastSuccess are the times of the most recent collection
+	// and of the most recent successful one, and last is the status
+	// retrieved by the latter.
+	collected, lastSuccess time.Time
+	last                   *apcupsd.Status
+}
+
+// set records the result of a collection, along with the errors parsing any
+// fields of the status.
+func (ts *targetStatus) set(s *apcupsd.Status, err error, parseErrors map[string]error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.s, ts.err, ts.parseErrors = s, err, parseErrors
+	ts.collected = time.Now()
+	if err == nil {
+		ts.succeeded = true
+		ts.last, ts.lastSuccess = s, ts.collected
+	}
+}
+
+// everSucceeded reports whether any collection has succeeded.
+func (ts *targetStatus) everSucceeded() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.succeeded
+}
+
+// get returns the status of the most recent successful collection, or nil if
+// it failed.
+func (ts *targetStatus) get() *apcupsd.Status {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.err != nil {
+		return nil
+	}
+
+	return ts.s
+}
+
+// withNominalPower returns s with the nominal power of its model in specs, if
+// the UPS does not report it, so that its output power can be estimated.
+func withNominalPower(s *apcupsd.Status, specs apcupsdexporter.ModelSpecs) *apcupsd.Status {
+	if s == nil || s.NominalPower > 0 {
+		return s
+	}
+
+	spec, ok := specs.Lookup(s.Model)
+	if !ok || spec.NominalPowerWatts <= 0 {
+		return s
+	}
+
+	sc := *s
+	sc.NominalPower = int(spec.NominalPowerWatts)
+	return &sc
+}
+
+// aggregateDescs are the descriptors of metrics aggregated across targets.
+type aggregateDescs struct {
+	Targets, UpTargets, OnBattery                 *prometheus.Desc
+	OutputPower, NominalPower, MinBatteryTimeLeft *prometheus.Desc
+	PowerQualityScore                             *prometheus.Desc
+}
+
+// fleetDescs describe the metrics aggregated across all targets.
+var fleetDescs = newAggregateDescs("fleet", "all", nil)
+
+// newAggregateDescs creates aggregateDescs for metrics of subsystem, which
+// aggregate the targets described by scope and carry labels.
+func newAggregateDescs(subsystem, scope string, labels []string) *aggregateDescs {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName("apcupsd", subsystem, name),
+			fmt.Sprintf(help, scope),
+			labels, nil,
+		)
+	}
+
+	return &aggregateDescs{
+		Targets:            desc("targets", "Number of %s configured apcupsd targets."),
+		UpTargets:          desc("up_targets", "Number of %s apcupsd targets whose metrics were collected successfully."),
+		OnBattery:          desc("on_battery_ups", "Number of %s UPSes running on battery."),
+		OutputPower:        desc("output_power_watts", "Total estimated output power of %s UPSes, from their load percentage and nominal power."),
+		NominalPower:       desc("nominal_power_watts", "Total nominal power output of %s UPSes."),
+		MinBatteryTimeLeft: desc("battery_time_left_min_seconds", "Lowest battery runtime left of %s UPSes."),
+		PowerQualityScore:  desc("power_quality_score", "Average quality of the AC input line power of %s UPSes over the last 24 hours, from 0 (worst) to 100 (best)."),
+	}
+}
+
+// A fleet aggregates the statuses of many UPSes.
+type fleet struct {
+	targets, up, onBattery    int
+	outputPower, nominalPower float64
+	minTimeLeft               time.Duration
+
+	// quality is the sum of the power quality scores of the qualityN UPSes
+	// which have one.
+	quality  float64
+	qualityN int
+}
+
+// add adds target t to f.
+func (f *fleet) add(t *target) {
+	f.targets++
+
+	s := t.status.get()
+	if s == nil {
+		return
+	}
+
+	if score, ok := t.c.PowerQualityScore(); ok {
+		f.quality += score
+		f.qualityN++
+	}
+
+	f.up++
+	if strings.Contains(s.Status, "ONBATT") {
+		f.onBattery++
+	}
+
+	f.outputPower += s.LoadPercent / 100 * float64(s.NominalPower)
+	f.nominalPower += float64(s.NominalPower)
+
+	if f.up == 1 || s.TimeLeft < f.minTimeLeft {
+		f.minTimeLeft = s.TimeLeft
+	}
+}
+
+// collect sends the metrics aggregated by f to ch, described by d with label
+// values lvs.
+func (f *fleet) collect(ch chan<- prometheus.Metric, d *aggregateDescs, lvs ...string) {
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, lvs...)
+	}
+
+	gauge(d.Targets, float64(f.targets))
+	gauge(d.UpTargets, float64(f.up))
+	gauge(d.OnBattery, float64(f.onBattery))
+	gauge(d.OutputPower, f.outputPower)
+	gauge(d.NominalPower, f.nominalPower)
+
+	// The lowest runtime is unknown if no UPS reported one.
+	if f.up > 0 {
+		gauge(d.MinBatteryTimeLeft, f.minTimeLeft.Seconds())
+	}
+	if f.qualityN > 0 {
+		gauge(d.PowerQualityScore, f.quality/float64(f.qualityN))
+	}
+}
+
+// collectGroups sends the metrics aggregated across the targets of each group
+// at each of levels to ch, described by d.  Each metric is labeled with the
+// level of its group and the names of the group and its parents, leaving the
+// labels of inner levels empty.
+func collectGroups(ch chan<- prometheus.Metric, d *aggregateDescs, levels []string, targets []*target) {
+	for i, l := range levels {
+		type group struct {
+			f   fleet
+			lvs []string
+		}
+
+		groups := make(map[string]*group)
+		for _, t := range targets {
+			if t.groups[i] == "" {
+				continue
+			}
+
+			key := strings.Join(t.groups[:i+1], "\xff")
+			g, ok := groups[key]
+			if !ok {
+				lvs := make([]string, 1+len(levels))
+				lvs[0] = l
+				copy(lvs[1:], t.groups[:i+1])
+
+				g = &group{lvs: lvs}
+				groups[key] = g
+			}
+
+			g.f.add(t)
+		}
+
+		for _, g := range groups {
+			g.f.collect(ch, d, g.lvs...)
+		}
+	}
+}
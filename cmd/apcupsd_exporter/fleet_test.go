@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFleetMetrics(t *testing.T) {
+	s1, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s1.Close()
+
+	onBattery := append(append([]string{}, apcupsdtest.DefaultStatus...),
+		apcupsdtest.Line("STATUS", "ONBATT"),
+		apcupsdtest.Line("TIMELEFT", "10.0 Minutes"),
+	)
+	s2, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(onBattery...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s2.Close()
+
+	// The third target is unreachable, and is only counted as configured.
+	s3, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	down := s3.Addr()
+	s3.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+targets:
+  - address: %s
+  - address: %s
+  - address: %s
+`, s1.Addr(), s2.Addr(), down)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := `
+# HELP apcupsd_fleet_battery_time_left_min_seconds Lowest battery runtime left of all UPSes.
+# TYPE apcupsd_fleet_battery_time_left_min_seconds gauge
+apcupsd_fleet_battery_time_left_min_seconds 600
+# HELP apcupsd_fleet_nominal_power_watts Total nominal power output of all UPSes.
+# TYPE apcupsd_fleet_nominal_power_watts gauge
+apcupsd_fleet_nominal_power_watts 1730
+# HELP apcupsd_fleet_on_battery_ups Number of all UPSes running on battery.
+# TYPE apcupsd_fleet_on_battery_ups gauge
+apcupsd_fleet_on_battery_ups 1
+# HELP apcupsd_fleet_output_power_watts Total estimated output power of all UPSes, from their load percentage and nominal power.
+# TYPE apcupsd_fleet_output_power_watts gauge
+apcupsd_fleet_output_power_watts 276.8
+# HELP apcupsd_fleet_targets Number of all configured apcupsd targets.
+# TYPE apcupsd_fleet_targets gauge
+apcupsd_fleet_targets 3
+# HELP apcupsd_fleet_up_targets Number of all apcupsd targets whose metrics were collected successfully.
+# TYPE apcupsd_fleet_up_targets gauge
+apcupsd_fleet_up_targets 2
+`
+	names := []string{
+		"apcupsd_fleet_battery_time_left_min_seconds",
+		"apcupsd_fleet_nominal_power_watts",
+		"apcupsd_fleet_on_battery_ups",
+		"apcupsd_fleet_output_power_watts",
+		"apcupsd_fleet_targets",
+		"apcupsd_fleet_up_targets",
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), names...); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+func TestGroupMetrics(t *testing.T) {
+	addrs := make([]any, 4)
+	for i := range addrs {
+		s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+		if err != nil {
+			t.Fatalf("failed to create fake NIS: %v", err)
+		}
+		defer s.Close()
+
+		addrs[i] = s.Addr()
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+group_levels: [site, room]
+targets:
+  - name: ups1
+    address: %s
+    groups: {site: hq, room: a}
+  - name: ups2
+    address: %s
+    groups: {site: hq, room: b}
+  - name: ups3
+    address: %s
+    groups: {site: branch}
+  - name: ups4
+    address: %s
+`, addrs...)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	// Targets outside of any group are only aggregated by the fleet, and the
+	// metrics of each target are labeled with its groups.
+	want := `
+# HELP apcupsd_group_targets Number of the group's configured apcupsd targets.
+# TYPE apcupsd_group_targets gauge
+apcupsd_group_targets{level="room",room="a",site="hq"} 1
+apcupsd_group_targets{level="room",room="b",site="hq"} 1
+apcupsd_group_targets{level="site",room="",site="branch"} 1
+apcupsd_group_targets{level="site",room="",site="hq"} 2
+# HELP apcupsd_ups_load_percent Current UPS load percentage.
+# TYPE apcupsd_ups_load_percent gauge
+# UNIT apcupsd_ups_load_percent percent
+apcupsd_ups_load_percent{hostname="apcupsd",model="Back-UPS RS 1500G",target="ups4",ups_name="ups"} 16
+apcupsd_ups_load_percent{hostname="apcupsd",model="Back-UPS RS 1500G",site="branch",target="ups3",ups_name="ups"} 16
+apcupsd_ups_load_percent{hostname="apcupsd",model="Back-UPS RS 1500G",room="a",site="hq",target="ups1",ups_name="ups"} 16
+apcupsd_ups_load_percent{hostname="apcupsd",model="Back-UPS RS 1500G",room="b",site="hq",target="ups2",ups_name="ups"} 16
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_group_targets", "apcupsd_ups_load_percent"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+func TestGroupPowerQualityScore(t *testing.T) {
+	s1, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s1.Close()
+
+	// TRIM throughout the window deducts its full penalty of 30.
+	trim := append(append([]string{}, apcupsdtest.DefaultStatus...), apcupsdtest.Line("STATUS", "ONLINE TRIM"))
+	s2, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(trim...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s2.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+group_levels: [site, room]
+targets:
+  - name: ups1
+    address: %s
+    groups: {site: hq, room: a}
+  - name: ups2
+    address: %s
+    groups: {site: hq}
+`, s1.Addr(), s2.Addr())
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := `
+# HELP apcupsd_fleet_power_quality_score Average quality of the AC input line power of all UPSes over the last 24 hours, from 0 (worst) to 100 (best).
+# TYPE apcupsd_fleet_power_quality_score gauge
+apcupsd_fleet_power_quality_score 85
+# HELP apcupsd_group_power_quality_score Average quality of the AC input line power of the group's UPSes over the last 24 hours, from 0 (worst) to 100 (best).
+# TYPE apcupsd_group_power_quality_score gauge
+apcupsd_group_power_quality_score{level="room",room="a",site="hq"} 100
+apcupsd_group_power_quality_score{level="site",room="",site="hq"} 85
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_fleet_power_quality_score", "apcupsd_group_power_quality_score"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
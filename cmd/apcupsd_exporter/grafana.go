@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// grafanaPath is the URL path under which the history store is served to the
+// Grafana JSON datasource.
+const grafanaPath = apiV1Path + "/grafana"
+
+// grafanaMetrics are the names of the values of each target served to
+// Grafana, and how they are derived from samples and rollups.
+var grafanaMetrics = []struct {
+	name   string
+	sample func(s sample) (float64, bool)
+	rollup func(r rollup) (float64, bool)
+}{
+	{
+		name:   "up",
+		sample: func(s sample) (float64, bool) { return boolFloat(s.up), true },
+		rollup: func(r rollup) (float64, bool) { return float64(r.up) / float64(r.samples), true },
+	},
+	{
+		name:   "on_battery",
+		sample: func(s sample) (float64, bool) { return boolFloat(s.onBattery()), s.up },
+		rollup: func(r rollup) (float64, bool) { return float64(r.onBattery) / float64(r.up), r.up > 0 },
+	},
+	{
+		name:   "line_volts",
+		sample: func(s sample) (float64, bool) { return s.lineVolts, s.up },
+		rollup: func(r rollup) (float64, bool) { return r.avg(0) },
+	},
+	{
+		name:   "load_percent",
+		sample: func(s sample) (float64, bool) { return s.loadPercent, s.up },
+		rollup: func(r rollup) (float64, bool) { return r.avg(1) },
+	},
+	{
+		name:   "battery_charge_percent",
+		sample: func(s sample) (float64, bool) { return s.chargePercent, s.up },
+		rollup: func(r rollup) (float64, bool) { return r.avg(2) },
+	},
+	{
+		name:   "battery_time_left_seconds",
+		sample: func(s sample) (float64, bool) { return s.left, s.up },
+		rollup: func(r rollup) (float64, bool) { return r.avg(3) },
+	},
+}
+
+// boolFloat returns 1 if b is true, and 0 otherwise.
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// A grafanaRange is the time range of a Grafana request.
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// A grafanaAPI serves the history store to the Grafana JSON datasource, so
+// that small installations can graph the history without Prometheus.  Series
+// are named "target:metric", and annotations mark the periods each target
+// spent on battery.
+type grafanaAPI struct {
+	ts *targetSet
+	h  *historyStore
+}
+
+// grafanaHandler returns the handler of the Grafana JSON datasource endpoints
+// under grafanaPath.
+func grafanaHandler(ts *targetSet, h *historyStore) http.Handler {
+	api := &grafanaAPI{ts: ts, h: h}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(grafanaPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		// The datasource tests its connection with a GET of the root.
+		if r.URL.Path != grafanaPath+"/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("OK\n"))
+	})
+	mux.Handle(grafanaPath+"/search", grafanaEndpoint(api.search))
+	mux.Handle(grafanaPath+"/metrics", grafanaEndpoint(api.search))
+	mux.Handle(grafanaPath+"/query", grafanaEndpoint(api.query))
+	mux.Handle(grafanaPath+"/annotations", grafanaEndpoint(api.annotations))
+
+	return mux
+}
+
+// grafanaEndpoint wraps fn with a handler which passes fn a decoder of the JSON
+// request body, and encodes the value returned by fn as JSON.
+func grafanaEndpoint(fn func(dec *json.Decoder) (any, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		res, err := fn(json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)))
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
+
+// decodeGrafanaRequest decodes the request body from dec into req.
+func decodeGrafanaRequest(dec *json.Decoder, req any) error {
+	if err := dec.Decode(req); err != nil {
+		return &httpError{code: http.StatusBadRequest, err: fmt.Errorf("invalid request: %v", err)}
+	}
+
+	return nil
+}
+
+// A grafanaSearchRequest is the body of a search request.
+type grafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// search returns the names of the series matching the substring in req.
+func (api *grafanaAPI) search(dec *json.Decoder) (any, error) {
+	var req grafanaSearchRequest
+	if err := decodeGrafanaRequest(dec, &req); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, t := range api.ts.config().Targets {
+		for _, m := range grafanaMetrics {
+			name := t.Name + ":" + m.name
+			if strings.Contains(name, req.Target) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// A grafanaQueryRequest is the body of a query request.
+type grafanaQueryRequest struct {
+	Range      grafanaRange `json:"range"`
+	IntervalMS int64        `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// A grafanaSeries is a time series in the response to a query request.
+type grafanaSeries struct {
+	Target string `json:"target"`
+
+	// Datapoints are pairs of values and times in Unix milliseconds.
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// query returns the series named by req over its range.  Rollups are used
+// when the interval of the panel is at least their resolution, so that
+// queries over long ranges return quickly.
+func (api *grafanaAPI) query(dec *json.Decoder) (any, error) {
+	var req grafanaQueryRequest
+	if err := decodeGrafanaRequest(dec, &req); err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(req.IntervalMS) * time.Millisecond
+	res := ""
+	for _, r := range rollupResolutions {
+		if interval >= r.d {
+			res = r.name
+		}
+	}
+
+	series := []grafanaSeries{}
+	for _, qt := range req.Targets {
+		if qt.Target == "" {
+			continue
+		}
+
+		// Target names are often addresses which contain colons themselves.
+		sep := strings.LastIndex(qt.Target, ":")
+		i := -1
+		if sep >= 0 {
+			i = grafanaMetricIndex(qt.Target[sep+1:])
+		}
+		if i < 0 {
+			return nil, &httpError{code: http.StatusBadRequest, err: fmt.Errorf("unknown series %q", qt.Target)}
+		}
+
+		t, _, err := api.ts.find(qt.Target[:sep])
+		if err != nil {
+			return nil, err
+		}
+
+		s := grafanaSeries{Target: qt.Target, Datapoints: [][2]float64{}}
+		add := func(at time.Time, v float64, ok bool) {
+			if ok {
+				s.Datapoints = append(s.Datapoints, [2]float64{v, float64(at.UnixMilli())})
+			}
+		}
+
+		m := grafanaMetrics[i]
+		if res == "" {
+			err = api.h.read(t.Name, req.Range.From, req.Range.To, func(smp sample) error {
+				v, ok := m.sample(smp)
+				add(smp.t, v, ok)
+				return nil
+			})
+		} else {
+			err = api.h.readRollups(t.Name, res, req.Range.From, req.Range.To, func(r rollup) error {
+				v, ok := m.rollup(r)
+				add(r.start, v, ok)
+				return nil
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		series = append(series, s)
+	}
+
+	return series, nil
+}
+
+// grafanaMetricIndex returns the index of the metric named name in
+// grafanaMetrics, or -1 if there is none.
+func grafanaMetricIndex(name string) int {
+	for i, m := range grafanaMetrics {
+		if m.name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// A grafanaAnnotationsRequest is the body of an annotations request.
+type grafanaAnnotationsRequest struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+// A grafanaAnnotation is an annotation in the response to an annotations
+// request, with times in Unix milliseconds.
+type grafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Title   string   `json:"title"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+}
+
+// annotations returns the periods spent on battery over the range of req by
+// the target named by its query, or by all targets if it is empty.
+func (api *grafanaAPI) annotations(dec *json.Decoder) (any, error) {
+	var req grafanaAnnotationsRequest
+	if err := decodeGrafanaRequest(dec, &req); err != nil {
+		return nil, err
+	}
+
+	var targets []targetConfig
+	if q := strings.TrimSpace(req.Annotation.Query); q != "" {
+		t, _, err := api.ts.find(q)
+		if err != nil {
+			return nil, err
+		}
+		targets = []targetConfig{t}
+	} else {
+		targets = api.ts.config().Targets
+	}
+
+	anns := []grafanaAnnotation{}
+	for _, t := range targets {
+		// Downsampling keeps the samples at which the UPS status changed,
+		// so the samples bound each period on battery.
+		var start, last time.Time
+		end := func() {
+			if !start.IsZero() {
+				anns = append(anns, grafanaAnnotation{
+					Time:    start.UnixMilli(),
+					TimeEnd: last.UnixMilli(),
+					Title:   "On battery",
+					Text:    fmt.Sprintf("%s ran on battery for %s", t.Name, last.Sub(start).Round(time.Second)),
+					Tags:    []string{t.Name, "on_battery"},
+				})
+			}
+			start = time.Time{}
+		}
+
+		err := api.h.read(t.Name, req.Range.From, req.Range.To, func(s sample) error {
+			switch {
+			case s.up && s.onBattery():
+				if start.IsZero() {
+					start = s.t
+				}
+				last = s.t
+			case s.up:
+				// Back on line power.
+				last = s.t
+				end()
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		end()
+	}
+
+	return anns, nil
+}
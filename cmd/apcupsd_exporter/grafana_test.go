@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestGrafanaAPI(t *testing.T) {
+	prev := *apcupsdAddr
+	*apcupsdAddr = "127.0.0.1:3551"
+	defer func() { *apcupsdAddr = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	h, err := openHistory(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, status := range []string{"ONLINE", "ONBATT", "ONBATT", "ONLINE"} {
+		s := newSample(start.Add(time.Duration(i)*time.Minute), &apcupsd.Status{Status: status, LoadPercent: 20}, nil)
+		if err := h.record("127.0.0.1:3551", s); err != nil {
+			t.Fatalf("failed to record sample: %v", err)
+		}
+	}
+
+	srv := httptest.NewServer(grafanaHandler(ts, h))
+	defer srv.Close()
+
+	post := func(path, body string, v any) {
+		t.Helper()
+
+		res, err := http.Post(srv.URL+grafanaPath+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to post: %v", err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", res.StatusCode)
+		}
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+
+	const rng = `"range": {"from": "2024-01-01T00:00:00Z", "to": "2024-01-02T00:00:00Z"}`
+
+	var series []grafanaSeries
+	post("/query", `{`+rng+`, "intervalMs": 1000, "targets": [{"target": "127.0.0.1:3551:on_battery"}]}`, &series)
+	if len(series) != 1 || len(series[0].Datapoints) != 4 || series[0].Datapoints[1][0] != 1 {
+		t.Fatalf("unexpected series: %+v", series)
+	}
+
+	var anns []grafanaAnnotation
+	post("/annotations", `{`+rng+`, "annotation": {"query": ""}}`, &anns)
+	want := grafanaAnnotation{
+		Time:    start.Add(time.Minute).UnixMilli(),
+		TimeEnd: start.Add(3 * time.Minute).UnixMilli(),
+	}
+	if len(anns) != 1 || anns[0].Time != want.Time || anns[0].TimeEnd != want.TimeEnd {
+		t.Fatalf("unexpected annotations: %+v", anns)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+)
+
+// healthyPath is the URL path of the exporter's liveness endpoint.
+const healthyPath = "/-/healthy"
+
+// healthy is an HTTP handler which reports that the exporter is running.
+func healthy(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("OK\n"))
+}
+
+// readyPath is the URL path of the exporter's readiness endpoint.
+const readyPath = "/-/ready"
+
+var readyMetrics = flag.Bool("web.metrics-require-ready", false, "respond to requests for metrics with 503 Service Unavailable until the exporter is ready, as reported by "+readyPath)
+
+// readiness returns an HTTP handler which reports whether the exporter is
+// ready, as determined by ready.
+func readiness(ready func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Not ready: waiting for the first successful collection from apcupsd\n"))
+			return
+		}
+
+		_, _ = w.Write([]byte("OK\n"))
+	})
+}
+
+// withReadiness wraps h with a handler which responds with 503 Service
+// Unavailable until ready reports true.
+func withReadiness(h http.Handler, ready func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			http.Error(w, "exporter is not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// healthcheckCommand implements the "healthcheck" subcommand, which checks
+// the health of a running exporter and returns an error if it is unhealthy.
+// It is intended for use with container health checks, where tools such as
+// curl may not be available.
+func healthcheckCommand(args []string) error {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	var (
+		url     = fs.String("url", "", "URL of the exporter health endpoint (default: derived from -telemetry.addr)")
+		ups     = fs.Bool("apcupsd", false, "also check that the apcupsd NIS at -apcupsd.addr is reachable and returns a status")
+		timeout = fs.Duration("timeout", 5*time.Second, "timeout for the health check")
+	)
+	_ = fs.Parse(args)
+
+	client := http.DefaultClient
+	if *url == "" {
+		*url = healthURL(*telemetryAddr, tlsEnabled())
+
+		if tlsEnabled() {
+			// The certificate of the exporter rarely names localhost, and
+			// the check only connects to the local exporter.  ACME
+			// certificates are only served to clients naming their host.
+			host, _, _ := strings.Cut(*acmeDomains, ",")
+			client = &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					ServerName:         strings.TrimSpace(host),
+					InsecureSkipVerify: true,
+				},
+			}}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("exporter is unhealthy: %v", err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("exporter is unhealthy: %s returned %s", *url, res.Status)
+	}
+
+	if !*ups {
+		return nil
+	}
+
+	c, err := apcupsdexporter.NewClientFunc(*apcupsdNetwork, *apcupsdAddr)(ctx)
+	if err != nil {
+		return fmt.Errorf("apcupsd is unreachable: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Status(); err != nil {
+		return fmt.Errorf("apcupsd is unhealthy: %v", err)
+	}
+
+	return nil
+}
+
+// healthURL derives the URL of the local health endpoint from a listen
+// address, served over TLS if tlsEnabled is set.
+func healthURL(addr string, tlsEnabled bool) string {
+	scheme := "http://"
+	if tlsEnabled {
+		scheme = "https://"
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Let the HTTP client report the invalid address.
+		return scheme + addr + healthyPath
+	}
+
+	// Wildcard listen addresses are reachable via loopback.
+	switch host {
+	case "", "0.0.0.0", "::":
+		host = "localhost"
+	}
+
+	return scheme + net.JoinHostPort(host, port) + healthyPath
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestHealthcheckCommand(t *testing.T) {
+	exporter := httptest.NewServer(http.HandlerFunc(healthy))
+	defer exporter.Close()
+
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	if err := healthcheckCommand([]string{"-url", exporter.URL + healthyPath, "-apcupsd"}); err != nil {
+		t.Fatalf("failed health check: %v", err)
+	}
+
+	unhealthy := httptest.NewServer(readiness(func() bool { return false }))
+	defer unhealthy.Close()
+
+	if err := healthcheckCommand([]string{"-url", unhealthy.URL}); err == nil {
+		t.Fatal("expected an error for an unhealthy exporter, but none occurred")
+	}
+
+	s.Close()
+	if err := healthcheckCommand([]string{"-url", exporter.URL, "-apcupsd", "-timeout", "1s"}); err == nil {
+		t.Fatal("expected an error for an unreachable apcupsd, but none occurred")
+	}
+}
+
+func TestHealthURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		tls  bool
+		want string
+	}{
+		{addr: ":9162", want: "http://localhost:9162/-/healthy"},
+		{addr: "0.0.0.0:9162", want: "http://localhost:9162/-/healthy"},
+		{addr: "[::]:9162", want: "http://localhost:9162/-/healthy"},
+		{addr: "[::1]:9162", tls: true, want: "https://[::1]:9162/-/healthy"},
+		{addr: "192.0.2.1:9162", want: "http://192.0.2.1:9162/-/healthy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := healthURL(tt.addr, tt.tls); got != tt.want {
+				t.Fatalf("unexpected URL: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWithReadiness(t *testing.T) {
+	var ready bool
+	h := withReadiness(http.HandlerFunc(healthy), func() bool { return ready })
+
+	for _, tt := range []struct {
+		ready bool
+		code  int
+	}{
+		{ready: false, code: http.StatusServiceUnavailable},
+		{ready: true, code: http.StatusOK},
+	} {
+		ready = tt.ready
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if w.Code != tt.code {
+			t.Fatalf("unexpected status code when ready is %v: want %d, got %d", tt.ready, tt.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var heartbeatDesc = prometheus.NewDesc(
+	"apcupsd_exporter_heartbeat_timestamp_seconds",
+	"Unix timestamp of the most recent successful collection from any target, absent until the first one.  Alert when it is absent or falls behind time(), which catches both the death of the exporter and the loss of power at its site.",
+	nil, nil,
+)
+
+// collectHeartbeat collects the time of the most recent successful collection
+// from any of targets, as a dead man's switch.  It is collected after the
+// targets, so that it includes the collection of the current scrape or poll.
+func collectHeartbeat(ch chan<- prometheus.Metric, targets []*target) {
+	var last time.Time
+	for _, t := range targets {
+		if ls := t.status.lastSuccessTime(); ls.After(last) {
+			last = ls
+		}
+	}
+	if last.IsZero() {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(heartbeatDesc, prometheus.GaugeValue, float64(last.UnixNano())/1e9)
+}
+
+// lastSuccessTime returns the time of the most recent successful collection,
+// or the zero time if none succeeded.
+func (ts *targetStatus) lastSuccessTime() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.lastSuccess
+}
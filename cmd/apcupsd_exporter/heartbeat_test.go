@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHeartbeat(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Error(errors.New("not yet")))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	heartbeat := func() (float64, bool) {
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == "apcupsd_exporter_heartbeat_timestamp_seconds" {
+				return mf.GetMetric()[0].GetGauge().GetValue(), true
+			}
+		}
+
+		return 0, false
+	}
+
+	// The heartbeat is absent until a collection succeeds.
+	if _, ok := heartbeat(); ok {
+		t.Fatal("unexpected heartbeat before a successful collection")
+	}
+
+	s.SetHandler(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	before := time.Now()
+	v, ok := heartbeat()
+	if !ok || v < float64(before.Unix()) {
+		t.Fatalf("heartbeat was not updated by a successful collection: %v", v)
+	}
+
+	// A failed collection does not update it.
+	s.SetHandler(apcupsdtest.Error(errors.New("gone")))
+	if got, _ := heartbeat(); got != v {
+		t.Fatalf("heartbeat was updated by a failed collection: %v", got)
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/common/model"
+)
+
+// historyCSVPath is the URL path of the endpoint which exports the stored
+// history of a target as CSV.
+const historyCSVPath = apiV1Path + "/history.csv"
+
+// historyCompactInterval is the interval at which the history store is
+// compacted.
+const historyCompactInterval = time.Hour
+
+var (
+	enableHistory = flag.Bool("history.enable", false, "store a history of the key values of each target, such as transfers to battery, in the storage directory, for export from "+historyCSVPath)
+
+	historyRetention          = model.Duration(90 * 24 * time.Hour)
+	historyMaxSize            byteSize
+	historyDownsampleAfter    = model.Duration(7 * 24 * time.Hour)
+	historyDownsampleInterval = flag.Duration("history.downsample-interval", 5*time.Minute, "interval to which samples older than -history.downsample-after are thinned; samples at which a target's health or UPS status changed are always kept")
+)
+
+func init() {
+	flag.Var(&historyRetention, "history.retention", "how long samples are kept in the history, such as 90d; 0 keeps them forever")
+	flag.Var(&historyMaxSize, "history.max-size", "maximum size of the history, such as 512MB, beyond which the oldest samples are removed; 0 is unlimited")
+	flag.Var(&historyDownsampleAfter, "history.downsample-after", "age after which samples in the history are thinned to one per -history.downsample-interval, such as 7d; 0 keeps all samples")
+}
+
+// A historyStore stores a history of the samples collected from each target,
+// in a file per target in its directory.
+//
+// Each file holds one CSV record per sample, in the order the samples were
+// collected, so that samples can be appended cheaply and read back without
+// loading the whole file.
+//
+// The store is compacted periodically: samples older than the retention are
+// removed, older samples are downsampled, and if the store exceeds its maximum
+// size, the oldest samples of each target are removed.  This bounds its size
+// and the writes to flash storage of small devices.
+type historyStore struct {
+	dir string
+
+	// retention, maxSize, downsampleAfter, and downsampleInterval configure
+	// compaction.  Zero values disable each of them.
+	retention, downsampleAfter, downsampleInterval time.Duration
+	maxSize                                        int64
+
+	// mu serializes writes to the files of the store, and guards rollups.
+	mu sync.Mutex
+
+	// rollups are the rollups of each target, at each of rollupResolutions,
+	// whose buckets have not ended yet.
+	rollups map[string][]rollup
+}
+
+// A sample holds the key values of a target at a point in time.
+type sample struct {
+	t  time.Time
+	up bool
+
+	// The fields below are only set if up is true.
+	status                                      string
+	lineVolts, loadPercent, chargePercent, left float64
+}
+
+// newSample creates a sample from the result of a collection at t.
+func newSample(t time.Time, s *apcupsd.Status, err error) sample {
+	if err != nil || s == nil {
+		return sample{t: t}
+	}
+
+	return sample{
+		t:             t,
+		up:            true,
+		status:        s.Status,
+		lineVolts:     s.LineVoltage,
+		loadPercent:   s.LoadPercent,
+		chargePercent: s.BatteryChargePercent,
+		left:          s.TimeLeft.Seconds(),
+	}
+}
+
+// onBattery reports whether the UPS was running on battery.
+func (s sample) onBattery() bool {
+	return strings.Contains(s.status, "ONBATT")
+}
+
+// openHistory opens the history store in dir, creating it if needed.
+func openHistory(dir string) (*historyStore, error) {
+	for _, res := range rollupResolutions {
+		if err := os.MkdirAll(filepath.Join(dir, res.name), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create history directory: %v", err)
+		}
+	}
+
+	return &historyStore{
+		dir:                dir,
+		retention:          time.Duration(historyRetention),
+		maxSize:            int64(historyMaxSize),
+		downsampleAfter:    time.Duration(historyDownsampleAfter),
+		downsampleInterval: *historyDownsampleInterval,
+	}, nil
+}
+
+// path returns the path of the file holding the samples of target, or its
+// rollups at resolution res if set.  Target names are escaped, since they are
+// often addresses.
+func (h *historyStore) path(target, res string) string {
+	return filepath.Join(h.dir, res, url.QueryEscape(target)+".csv")
+}
+
+// record appends a sample to the history of target, along with the rollups of
+// any buckets which ended before it.
+func (h *historyStore) record(target string, smp sample) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.rollups == nil {
+		h.rollups = make(map[string][]rollup)
+	}
+	rs, ok := h.rollups[target]
+	if !ok {
+		rs = make([]rollup, len(rollupResolutions))
+		h.rollups[target] = rs
+	}
+
+	var errs []error
+	for i, res := range rollupResolutions {
+		start := smp.t.Truncate(res.d)
+		if rs[i].samples > 0 && !rs[i].start.Equal(start) {
+			errs = append(errs, appendRecord(h.path(target, res.name), rs[i].record()))
+			rs[i] = rollup{}
+		}
+		if rs[i].samples == 0 {
+			rs[i].start = start
+		}
+		rs[i].add(smp)
+	}
+
+	return errors.Join(append(errs, appendRecord(h.path(target, ""), smp.record()))...)
+}
+
+// appendRecord appends the CSV record rec to the file at path.
+func appendRecord(path string, rec []string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	_ = w.Write(rec)
+	w.Flush()
+
+	return errors.Join(w.Error(), f.Close())
+}
+
+// read calls fn with each sample of target collected within [from, to], in
+// the order they were collected.
+func (h *historyStore) read(target string, from, to time.Time, fn func(sample) error) error {
+	return readRecords(h.path(target, ""), func(rec []string) error {
+		smp, err := parseSample(rec)
+		if err != nil || smp.t.Before(from) || smp.t.After(to) {
+			return nil
+		}
+
+		return fn(smp)
+	})
+}
+
+// readRecords calls fn with each CSV record of the file at path, if it exists.
+// Records are only ever appended, so readRecords does not block record while
+// fn is slow.  Records truncated by a crash, or still being written, must be
+// skipped by fn.
+func readRecords(path string, fn func(rec []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// record returns the stored CSV record of s.
+func (s sample) record() []string {
+	if !s.up {
+		return []string{strconv.FormatInt(s.t.Unix(), 10), "0"}
+	}
+
+	return []string{
+		strconv.FormatInt(s.t.Unix(), 10), "1", s.status,
+		formatFloat(s.lineVolts), formatFloat(s.loadPercent), formatFloat(s.chargePercent), formatFloat(s.left),
+	}
+}
+
+// parseSample parses a stored CSV record created by sample.record.
+func parseSample(rec []string) (sample, error) {
+	if len(rec) < 2 {
+		return sample{}, errors.New("short record")
+	}
+
+	sec, err := strconv.ParseInt(rec[0], 10, 64)
+	if err != nil {
+		return sample{}, err
+	}
+
+	s := sample{t: time.Unix(sec, 0), up: rec[1] == "1"}
+	if !s.up {
+		return s, nil
+	}
+	if len(rec) != 7 {
+		return sample{}, errors.New("short record")
+	}
+
+	s.status = rec[2]
+	for i, v := range []*float64{&s.lineVolts, &s.loadPercent, &s.chargePercent, &s.left} {
+		if *v, err = strconv.ParseFloat(rec[3+i], 64); err != nil {
+			return sample{}, err
+		}
+	}
+
+	return s, nil
+}
+
+// historyTimeLayout is the layout of times in exported CSV, which spreadsheet
+// applications parse as a date and time.
+const historyTimeLayout = "2006-01-02 15:04:05"
+
+// historyCSVHandler serves the history of the target named by the target query
+// parameter, which may be omitted if there is only one target, as CSV.  The
+// from and to query parameters optionally bound the times of the samples, as
+// RFC 3339 times or dates, and the resolution parameter selects the rollups
+// at one of rollupResolutions instead of the samples.
+func historyCSVHandler(ts *targetSet, h *historyStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		t, _, err := ts.find(q.Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		from, err := parseHistoryTime(q.Get("from"), time.Time{}, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+		to, err := parseHistoryTime(q.Get("to"), time.Now(), true)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		res := q.Get("resolution")
+		if res == "raw" {
+			res = ""
+		}
+		if res != "" && !validResolution(res) {
+			http.Error(w, fmt.Sprintf("invalid resolution %q", res), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "history-"+url.QueryEscape(t.Name)+".csv"))
+
+		cw := csv.NewWriter(w)
+		if res == "" {
+			err = writeSamplesCSV(cw, h, t.Name, from, to)
+		} else {
+			err = writeRollupsCSV(cw, h, t.Name, res, from, to)
+		}
+		cw.Flush()
+		if err != nil {
+			// The response has already begun, so abort it to signal to the
+			// client that it is incomplete.
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// writeSamplesCSV writes the samples of target within [from, to] to cw.
+func writeSamplesCSV(cw *csv.Writer, h *historyStore, target string, from, to time.Time) error {
+	_ = cw.Write([]string{"time_utc", "target", "up", "status", "on_battery", "line_volts", "load_percent", "battery_charge_percent", "battery_time_left_seconds"})
+
+	return h.read(target, from, to, func(s sample) error {
+		rec := []string{s.t.UTC().Format(historyTimeLayout), target, "0", "", "", "", "", "", ""}
+		if s.up {
+			stored := s.record()
+			rec[2], rec[3] = "1", s.status
+			rec[4] = "0"
+			if s.onBattery() {
+				rec[4] = "1"
+			}
+			copy(rec[5:], stored[3:])
+		}
+
+		return cw.Write(rec)
+	})
+}
+
+// parseHistoryTime parses a time given as an RFC 3339 time or a date in UTC,
+// returning def if s is empty.  A date is the start of the day, or its end if
+// end is set, so that a range of dates includes the last one.
+func parseHistoryTime(s string, def time.Time, end bool) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	return t, nil
+}
+
+// run compacts the store at each historyCompactInterval, and never returns.
+func (h *historyStore) run(logger *slog.Logger) {
+	t := time.NewTicker(historyCompactInterval)
+	defer t.Stop()
+
+	for {
+		if err := h.compact(time.Now()); err != nil {
+			logger.Warn("failed to compact history", "err", err)
+		}
+		<-t.C
+	}
+}
+
+// compact compacts the file of each target as of now.
+func (h *historyStore) compact(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Only the samples are downsampled, since rollups already are.
+	dirs := []string{""}
+	for _, res := range rollupResolutions {
+		dirs = append(dirs, res.name)
+	}
+
+	var paths []string
+	for _, d := range dirs {
+		ps, err := filepath.Glob(filepath.Join(h.dir, d, "*.csv"))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, ps...)
+	}
+
+	// Share the maximum size equally among the files.
+	var budget int64
+	if h.maxSize > 0 && len(paths) > 0 {
+		budget = h.maxSize / int64(len(paths))
+	}
+
+	var errs []error
+	for _, p := range paths {
+		downsample := filepath.Dir(p) == filepath.Clean(h.dir)
+		if err := h.compactFile(p, now, budget, downsample); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", p, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// compactFile rewrites the file at path without the records removed by
+// retention, and by downsampling if downsample is set, and without its oldest
+// records beyond budget bytes if budget is set.  The file is replaced
+// atomically, so that a crash while compacting loses no records.
+func (h *historyStore) compactFile(path string, now time.Time, budget int64, downsample bool) error {
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		out        []byte
+		prev       sample
+		lastBucket int64 = -1
+	)
+
+	for _, line := range strings.SplitAfter(string(in), "\n") {
+		rec, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			continue
+		}
+
+		// Samples and rollups both begin with their time.
+		sec, err := strconv.ParseInt(rec[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		age := now.Sub(time.Unix(sec, 0))
+		if h.retention > 0 && age > h.retention {
+			continue
+		}
+		if !downsample {
+			out = append(out, line...)
+			continue
+		}
+
+		smp, err := parseSample(rec)
+		if err != nil {
+			continue
+		}
+
+		changed := smp.up != prev.up || smp.status != prev.status
+		prev = smp
+
+		if h.downsampleAfter > 0 && h.downsampleInterval > 0 && age > h.downsampleAfter {
+			// Keep the first sample of each interval, and each change of
+			// health or status, so that outages are never lost.
+			bucket := smp.t.UnixNano() / int64(h.downsampleInterval)
+			if bucket == lastBucket && !changed {
+				continue
+			}
+			lastBucket = bucket
+		}
+
+		out = append(out, line...)
+	}
+
+	if budget > 0 && int64(len(out)) > budget {
+		// Remove whole lines from the start, down to the budget.
+		cut := len(out) - int(budget)
+		if i := strings.IndexByte(string(out[cut:]), '\n'); i >= 0 {
+			out = out[cut+i+1:]
+		} else {
+			out = nil
+		}
+	}
+
+	if len(out) == len(in) {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// A byteSize is a flag.Value for a size in bytes, with an optional unit of KB,
+// MB, GB, or TB in powers of 1024.
+type byteSize int64
+
+// String implements flag.Value.
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+
+// Set implements flag.Value.
+func (b *byteSize) Set(s string) error {
+	units := []struct {
+		suffix string
+		n      int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+
+	num, n := strings.ToUpper(s), int64(1)
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, n = v, u.n
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+
+	*b = byteSize(v * n)
+	return nil
+}
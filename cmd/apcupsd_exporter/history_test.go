@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestHistoryStore(t *testing.T) {
+	h, err := openHistory(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+
+	start := time.Unix(1_700_000_000, 0)
+	samples := []sample{
+		newSample(start, &apcupsd.Status{Status: "ONLINE", LineVoltage: 230.5, LoadPercent: 16, BatteryChargePercent: 100, TimeLeft: time.Hour}, nil),
+		newSample(start.Add(time.Minute), nil, errors.New("timeout")),
+		newSample(start.Add(2*time.Minute), &apcupsd.Status{Status: "ONBATT", LoadPercent: 17, BatteryChargePercent: 95, TimeLeft: 50 * time.Minute}, nil),
+	}
+	for _, s := range samples {
+		if err := h.record("127.0.0.1:3551", s); err != nil {
+			t.Fatalf("failed to record sample: %v", err)
+		}
+	}
+
+	var got []sample
+	err = h.read("127.0.0.1:3551", start.Add(time.Second), start.Add(time.Hour), func(s sample) error {
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+
+	if len(got) != 2 || got[0].up || !got[1].onBattery() || got[1].chargePercent != 95 || !got[1].t.Equal(samples[2].t) {
+		t.Fatalf("unexpected samples: %+v", got)
+	}
+}
+
+func TestHistoryStoreCompact(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	h, err := openHistory(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+	h.retention = 30 * 24 * time.Hour
+	h.downsampleAfter = 24 * time.Hour
+	h.downsampleInterval = 5 * time.Minute
+
+	online := &apcupsd.Status{Status: "ONLINE"}
+	record := func(t0 time.Time, n int, s *apcupsd.Status) {
+		for i := 0; i < n; i++ {
+			if err := h.record("ups", newSample(t0.Add(time.Duration(i)*time.Minute), s, nil)); err != nil {
+				t.Fatalf("failed to record sample: %v", err)
+			}
+		}
+	}
+
+	// Expired samples, old samples at one per minute with a transfer to
+	// battery, and recent samples.
+	record(now.Add(-40*24*time.Hour), 10, online)
+	old := now.Add(-2 * 24 * time.Hour).Truncate(5 * time.Minute)
+	record(old, 7, online)
+	record(old.Add(7*time.Minute), 1, &apcupsd.Status{Status: "ONBATT"})
+	record(old.Add(8*time.Minute), 2, online)
+	record(now.Add(-time.Hour), 3, online)
+
+	if err := h.compact(now); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+
+	var got []time.Duration
+	err = h.read("ups", time.Time{}, now, func(s sample) error {
+		got = append(got, s.t.Sub(old))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+
+	// The first sample of each 5 minutes and each change of status are kept.
+	want := []time.Duration{0, 5 * time.Minute, 7 * time.Minute, 8 * time.Minute}
+	if len(got) != len(want)+3 {
+		t.Fatalf("unexpected number of samples: %d", len(got))
+	}
+	for i, d := range want {
+		if got[i] != d {
+			t.Fatalf("unexpected sample %d at %v, want %v", i, got[i], d)
+		}
+	}
+}
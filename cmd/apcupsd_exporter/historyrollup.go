@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// rollupResolutions are the resolutions at which the history store keeps
+// rollups of the samples of each target, named as in the directories which
+// hold them.
+var rollupResolutions = []struct {
+	name string
+	d    time.Duration
+}{
+	{name: "1m", d: time.Minute},
+	{name: "1h", d: time.Hour},
+}
+
+// validResolution reports whether res names one of rollupResolutions.
+func validResolution(res string) bool {
+	for _, r := range rollupResolutions {
+		if r.name == res {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A rollup aggregates the samples of a target within a bucket of time, so
+// that queries over long ranges need not read every sample.
+type rollup struct {
+	start                  time.Time
+	samples, up, onBattery int
+
+	// values aggregates the line voltage, load, battery charge, and battery
+	// runtime left of the samples of which up is true.
+	values [4]rollupValue
+}
+
+// A rollupValue aggregates a single value of samples.
+type rollupValue struct {
+	min, max, sum float64
+}
+
+// add adds s to r.
+func (r *rollup) add(s sample) {
+	r.samples++
+	if !s.up {
+		return
+	}
+
+	r.up++
+	if s.onBattery() {
+		r.onBattery++
+	}
+
+	for i, v := range []float64{s.lineVolts, s.loadPercent, s.chargePercent, s.left} {
+		rv := &r.values[i]
+		if r.up == 1 {
+			*rv = rollupValue{min: v, max: v}
+		}
+
+		rv.min = math.Min(rv.min, v)
+		rv.max = math.Max(rv.max, v)
+		rv.sum += v
+	}
+}
+
+// avg returns the average of the value at index i of r.values, if any samples
+// were collected successfully.
+func (r rollup) avg(i int) (float64, bool) {
+	if r.up == 0 {
+		return 0, false
+	}
+
+	return r.values[i].sum / float64(r.up), true
+}
+
+// record returns the stored CSV record of r.
+func (r rollup) record() []string {
+	rec := []string{
+		strconv.FormatInt(r.start.Unix(), 10),
+		strconv.Itoa(r.samples), strconv.Itoa(r.up), strconv.Itoa(r.onBattery),
+	}
+	for _, v := range r.values {
+		rec = append(rec, formatFloat(v.min), formatFloat(v.max), formatFloat(v.sum))
+	}
+
+	return rec
+}
+
+// parseRollup parses a stored CSV record created by rollup.record.
+func parseRollup(rec []string) (rollup, error) {
+	if len(rec) != 4+3*len(rollup{}.values) {
+		return rollup{}, errors.New("short record")
+	}
+
+	sec, err := strconv.ParseInt(rec[0], 10, 64)
+	if err != nil {
+		return rollup{}, err
+	}
+
+	r := rollup{start: time.Unix(sec, 0)}
+	for i, n := range []*int{&r.samples, &r.up, &r.onBattery} {
+		if *n, err = strconv.Atoi(rec[1+i]); err != nil {
+			return rollup{}, err
+		}
+	}
+
+	for i := range r.values {
+		v := &r.values[i]
+		for j, f := range []*float64{&v.min, &v.max, &v.sum} {
+			if *f, err = strconv.ParseFloat(rec[4+3*i+j], 64); err != nil {
+				return rollup{}, err
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// readRollups calls fn with each rollup of target at resolution res whose
+// bucket begins within [from, to], in order.
+func (h *historyStore) readRollups(target, res string, from, to time.Time, fn func(rollup) error) error {
+	return readRecords(h.path(target, res), func(rec []string) error {
+		r, err := parseRollup(rec)
+		if err != nil || r.start.Before(from) || r.start.After(to) {
+			return nil
+		}
+
+		return fn(r)
+	})
+}
+
+// writeRollupsCSV writes the rollups of target at resolution res within
+// [from, to] to cw.
+func writeRollupsCSV(cw *csv.Writer, h *historyStore, target, res string, from, to time.Time) error {
+	header := []string{"time_utc", "target", "samples", "up_ratio", "on_battery_ratio"}
+	for _, v := range []string{"line_volts", "load_percent", "battery_charge_percent", "battery_time_left_seconds"} {
+		header = append(header, v+"_min", v+"_max", v+"_avg")
+	}
+	_ = cw.Write(header)
+
+	return h.readRollups(target, res, from, to, func(r rollup) error {
+		rec := []string{
+			r.start.UTC().Format(historyTimeLayout), target, strconv.Itoa(r.samples),
+			formatFloat(float64(r.up) / float64(r.samples)), "",
+		}
+		if r.up == 0 {
+			rec = append(rec, make([]string, 3*len(r.values))...)
+			return cw.Write(rec)
+		}
+
+		rec[4] = formatFloat(float64(r.onBattery) / float64(r.up))
+		for i, v := range r.values {
+			avg, _ := r.avg(i)
+			rec = append(rec, formatFloat(v.min), formatFloat(v.max), formatFloat(avg))
+		}
+
+		return cw.Write(rec)
+	})
+}
+
+// formatFloat formats v compactly for CSV.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestHistoryStoreRollups(t *testing.T) {
+	h, err := openHistory(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+
+	// Samples every 20 seconds for 2 minutes, with a failed collection.
+	start := time.Unix(1_700_000_000, 0).Truncate(time.Hour)
+	for i := 0; i < 7; i++ {
+		var s sample
+		if i != 1 {
+			s = newSample(start.Add(time.Duration(i)*20*time.Second), &apcupsd.Status{LoadPercent: float64(10 + i)}, nil)
+		} else {
+			s = newSample(start.Add(20*time.Second), nil, errors.New("timeout"))
+		}
+
+		if err := h.record("ups", s); err != nil {
+			t.Fatalf("failed to record sample: %v", err)
+		}
+	}
+
+	// Only the buckets which have ended are stored.
+	var got []rollup
+	err = h.readRollups("ups", "1m", time.Time{}, start.Add(time.Hour), func(r rollup) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read rollups: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("unexpected number of rollups: %d", len(got))
+	}
+
+	load := got[0].values[1]
+	if r := got[0]; !r.start.Equal(start) || r.samples != 3 || r.up != 2 || load.min != 10 || load.max != 12 || load.sum != 22 {
+		t.Fatalf("unexpected first rollup: %+v", r)
+	}
+	if r := got[1]; r.samples != 3 || r.up != 3 || r.values[1].sum != 13+14+15 {
+		t.Fatalf("unexpected second rollup: %+v", r)
+	}
+}
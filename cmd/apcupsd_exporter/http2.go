@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var enableH2C = flag.Bool("web.h2c", false, "also serve HTTP/2 without TLS (h2c) for clients such as proxies which multiplex scrapes over HTTP/2, with prior knowledge or by upgrading; HTTP/2 is always negotiated when serving TLS")
+
+// configureH2C wraps the handler of srv with a handler which also serves HTTP/2
+// without TLS, if enabled by -web.h2c.
+func configureH2C(srv *http.Server) error {
+	if !*enableH2C {
+		return nil
+	}
+	if srv.TLSConfig != nil {
+		return errors.New("-web.h2c cannot be set when serving TLS")
+	}
+
+	srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestConfigureH2C(t *testing.T) {
+	defer func(v bool) { *enableH2C = v }(*enableH2C)
+	*enableH2C = true
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	if err := configureH2C(srv.Config); err != nil {
+		t.Fatalf("failed to configure h2c: %v", err)
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// Dial HTTP/2 over plain TCP with prior knowledge.
+	c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	res, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(res.Body)
+	if got := string(b); got != "HTTP/2.0" {
+		t.Fatalf("unexpected protocol: %s", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// inventoryPath is the URL path of the API endpoint which serves the hardware
+// inventory of the targets.
+const inventoryPath = apiExperimentalPath + "/inventory"
+
+// An inventoryItem describes the UPS of a target for asset management, as
+// served by the inventory API.
+type inventoryItem struct {
+	Target  string            `json:"target"`
+	Address string            `json:"address"`
+	Groups  map[string]string `json:"groups,omitempty"`
+
+	// LastSuccessAt is the time of the collection which reported the fields
+	// below, which are empty if no collection succeeded.
+	LastSuccessAt *time.Time `json:"last_success_at"`
+
+	Model        string `json:"model"`
+	SerialNumber string `json:"serial_number"`
+	Firmware     string `json:"firmware"`
+
+	// BatteryDate is the date on which the battery was installed, as
+	// reported by apcupsd.
+	BatteryDate       string `json:"battery_date"`
+	NominalPowerWatts int    `json:"nominal_power_watts"`
+}
+
+// inventory returns the inventory of each target, along with the configured
+// group levels.
+func (ts *targetSet) inventory() ([]inventoryItem, []string) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	specs := ts.cfg.modelSpecs()
+	items := make([]inventoryItem, 0, len(ts.targets))
+	for i, t := range ts.targets {
+		snap := t.status.snapshot(ts.cfg.Targets[i])
+		item := inventoryItem{
+			Target:        snap.Target,
+			Address:       snap.Address,
+			Groups:        ts.cfg.Targets[i].Groups,
+			LastSuccessAt: snap.LastSuccessAt,
+		}
+
+		// The nominal power of the UPS's model is used if it does not
+		// report its own.
+		if s := withNominalPower(snap.Status, specs); s != nil {
+			item.Model = s.Model
+			item.SerialNumber = s.SerialNumber
+			item.Firmware = s.Firmware
+			item.BatteryDate = s.BatteryDate
+			item.NominalPowerWatts = s.NominalPower
+		}
+
+		items = append(items, item)
+	}
+
+	return items, ts.cfg.GroupLevels
+}
+
+// inventoryHandler serves the model, serial number, firmware, battery date,
+// nominal power, and groups of each target, as JSON or, with the query
+// parameter format=csv, as CSV, so that asset management systems can import
+// them without scraping metrics.
+func inventoryHandler(ts *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		items, levels := ts.inventory()
+
+		switch f := r.URL.Query().Get("format"); f {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(struct {
+				Targets []inventoryItem `json:"targets"`
+			}{Targets: items})
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="inventory.csv"`)
+
+			cw := csv.NewWriter(w)
+			writeInventoryCSV(cw, items, levels)
+			cw.Flush()
+		default:
+			http.Error(w, fmt.Sprintf("invalid format %q", f), http.StatusBadRequest)
+		}
+	})
+}
+
+// writeInventoryCSV writes items to cw, with a column for the group of each
+// target at each of levels.
+func writeInventoryCSV(cw *csv.Writer, items []inventoryItem, levels []string) {
+	_ = cw.Write(append([]string{"target", "address", "last_success_utc", "model", "serial_number", "firmware", "battery_date", "nominal_power_watts"}, levels...))
+
+	for _, item := range items {
+		var lastSuccess, power string
+		if item.LastSuccessAt != nil {
+			lastSuccess = item.LastSuccessAt.UTC().Format(time.RFC3339)
+		}
+		if item.NominalPowerWatts > 0 {
+			power = strconv.Itoa(item.NominalPowerWatts)
+		}
+
+		rec := []string{item.Target, item.Address, lastSuccess, item.Model, item.SerialNumber, item.Firmware, item.BatteryDate, power}
+		for _, l := range levels {
+			rec = append(rec, item.Groups[l])
+		}
+
+		_ = cw.Write(rec)
+	}
+}
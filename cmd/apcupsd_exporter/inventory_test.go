@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInventoryHandler(t *testing.T) {
+	s1, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s1.Close()
+
+	// The second UPS does not report its nominal power, which is taken from
+	// the model database instead.
+	var lines []string
+	for _, l := range apcupsdtest.DefaultStatus {
+		if !strings.HasPrefix(l, "NOMPOWER") {
+			lines = append(lines, l)
+		}
+	}
+	s2, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(lines...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s2.Close()
+
+	down, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	down.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+group_levels: [site, room]
+model_specs:
+  Back-UPS RS 1500G:
+    nominal_power_watts: 900
+targets:
+  - name: ups1
+    address: %s
+    groups: {site: hq, room: a}
+  - name: ups2
+    address: %s
+    groups: {site: branch}
+  - name: ups3
+    address: %s
+`, s1.Addr(), s2.Addr(), down.Addr())
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+	_, _ = reg.Gather()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		inventoryHandler(ts).ServeHTTP(w, httptest.NewRequest(http.MethodGet, inventoryPath+query, nil))
+		return w
+	}
+
+	w := get("")
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	var res struct {
+		Targets []inventoryItem `json:"targets"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode inventory: %v", err)
+	}
+	if len(res.Targets) != 3 {
+		t.Fatalf("unexpected number of targets: %d", len(res.Targets))
+	}
+
+	got := res.Targets[0]
+	if got.Target != "ups1" || got.Model != "Back-UPS RS 1500G" || got.SerialNumber != "3B1234X12345" ||
+		got.Firmware != "878.L4 .D USB FW:L4" || got.BatteryDate != "2020-01-01" || got.NominalPowerWatts != 865 ||
+		got.Groups["site"] != "hq" || got.Groups["room"] != "a" || got.LastSuccessAt == nil {
+		t.Fatalf("unexpected inventory of ups1: %+v", got)
+	}
+	if got := res.Targets[1].NominalPowerWatts; got != 900 {
+		t.Fatalf("unexpected nominal power of ups2: %d", got)
+	}
+	if got := res.Targets[2]; got.Model != "" || got.LastSuccessAt != nil {
+		t.Fatalf("unexpected inventory of the unreachable ups3: %+v", got)
+	}
+
+	w = get("?format=csv")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	// Omit the time of the last success, which varies.
+	var rows []string
+	for _, l := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		f := strings.Split(l, ",")
+		rows = append(rows, strings.Join(append(f[:2:2], f[3:]...), ","))
+	}
+
+	want := []string{
+		"target,address,model,serial_number,firmware,battery_date,nominal_power_watts,site,room",
+		"ups1," + s1.Addr().String() + ",Back-UPS RS 1500G,3B1234X12345,878.L4 .D USB FW:L4,2020-01-01,865,hq,a",
+		"ups2," + s2.Addr().String() + ",Back-UPS RS 1500G,3B1234X12345,878.L4 .D USB FW:L4,2020-01-01,900,branch,",
+		"ups3," + down.Addr().String() + ",,,,,,,",
+	}
+	if got, want := strings.Join(rows, "\n"), strings.Join(want, "\n"); got != want {
+		t.Fatalf("unexpected CSV inventory:\n got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if w := get("?format=xml"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code for an invalid format: %d", w.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// URL paths of the lifecycle endpoints, which reload the configuration, shut
+// down the exporter, and change its log level.
+const (
+	reloadPath   = "/-/reload"
+	quitPath     = "/-/quit"
+	logLevelPath = "/-/loglevel"
+)
+
+// shutdownTimeout bounds the time spent waiting for in-flight requests to
+// complete when the exporter shuts down.
+const shutdownTimeout = 30 * time.Second
+
+var (
+	enableLifecycle    = flag.Bool("web.enable-lifecycle", false, "enable the HTTP lifecycle endpoints "+reloadPath+", "+quitPath+", and "+logLevelPath)
+	lifecycleTokenFile = flag.String("web.lifecycle-token-file", "", "path to a file containing a bearer token required to use the HTTP lifecycle endpoints, which must be set to enable them")
+)
+
+// lifecycleToken reads the token set by -web.lifecycle-token-file.  The
+// lifecycle endpoints change the state of the exporter, so unlike the
+// read-only endpoints they are never served without a token, which would
+// allow any page permitted by -web.cors-origin to forge requests to them.
+func lifecycleToken() (string, error) {
+	if *lifecycleTokenFile == "" {
+		return "", errors.New("-web.lifecycle-token-file must be set to enable the lifecycle endpoints")
+	}
+
+	return readToken("lifecycle", *lifecycleTokenFile)
+}
+
+// readToken reads the bearer token of the kind of endpoints described by what
+// from the file at path.
+func readToken(what, path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s token: %v", what, err)
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s token file %s is empty", what, path)
+	}
+
+	return token, nil
+}
+
+// lifecycleHandler wraps fn with a handler which only accepts POST requests
+// bearing token, and reports the error returned by fn.
+func lifecycleHandler(token string, fn func() error) http.Handler {
+	return postHandler(token, func(_ *http.Request) error { return fn() })
+}
+
+// An httpError is an error which is reported with an HTTP status code other
+// than 500 Internal Server Error.
+type httpError struct {
+	code int
+	err  error
+}
+
+// Error implements error.
+func (e *httpError) Error() string { return e.err.Error() }
+
+// httpStatus returns the HTTP status code with which err is reported.
+func httpStatus(err error) int {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.code
+	}
+
+	return http.StatusInternalServerError
+}
+
+// postHandler wraps fn with a handler which only accepts POST requests bearing
+// token, if it is set, and reports the error returned by fn.
+func postHandler(token string, fn func(r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(w, r, token) {
+			return
+		}
+
+		if err := fn(r); err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("OK\n"))
+	})
+}
+
+// quitHandler returns a lifecycle handler which only accepts POST requests
+// bearing token, and a channel which is closed on the first such request to
+// shut down the exporter.
+func quitHandler(token string) (http.Handler, <-chan struct{}) {
+	var (
+		quit = make(chan struct{})
+		once sync.Once
+	)
+
+	return lifecycleHandler(token, func() error {
+		once.Do(func() { close(quit) })
+		return nil
+	}), quit
+}
+
+// authorized reports whether r bears token, and replies to r with 401
+// Unauthorized if not.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// logLevelHandler returns a lifecycle handler which serves the current log
+// level on GET requests, and sets it to the value of the level query
+// parameter on PUT requests bearing token, so that verbosity can be raised
+// while diagnosing an exporter without restarting it.
+func logLevelHandler(logger *slog.Logger, token string, level *slog.LevelVar) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			if !authorized(w, r, token) {
+				return
+			}
+
+			l, err := parseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			// Log the change at the less verbose of the two levels, which
+			// both of them enable.
+			from := level.Level()
+			logger.Log(r.Context(), max(from, l), "changed log level", "from", levelName(from), "to", levelName(l), "remote_addr", r.RemoteAddr)
+			level.Set(l)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, "only GET, HEAD, and PUT requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(levelName(level.Level()) + "\n"))
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLifecycleToken(t *testing.T) {
+	defer func(f string) { *lifecycleTokenFile = f }(*lifecycleTokenFile)
+
+	*lifecycleTokenFile = ""
+	if _, err := lifecycleToken(); err == nil {
+		t.Fatal("expected an error without a token file, but none occurred")
+	}
+
+	dir := t.TempDir()
+	*lifecycleTokenFile = filepath.Join(dir, "missing")
+	if _, err := lifecycleToken(); err == nil {
+		t.Fatal("expected an error for a missing token file, but none occurred")
+	}
+
+	*lifecycleTokenFile = filepath.Join(dir, "token")
+	if err := os.WriteFile(*lifecycleTokenFile, []byte(" \n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+	if _, err := lifecycleToken(); err == nil {
+		t.Fatal("expected an error for an empty token file, but none occurred")
+	}
+
+	if err := os.WriteFile(*lifecycleTokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+	token, err := lifecycleToken()
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+	if token != "secret" {
+		t.Fatalf("unexpected token %q", token)
+	}
+}
+
+func TestLifecycleHandler(t *testing.T) {
+	tests := []struct {
+		desc   string
+		token  string
+		method string
+		auth   string
+		err    error
+		code   int
+		called bool
+	}{
+		{
+			desc:   "GET",
+			token:  "secret",
+			method: http.MethodGet,
+			auth:   "Bearer secret",
+			code:   http.StatusMethodNotAllowed,
+		},
+		{
+			desc:   "no token",
+			token:  "secret",
+			method: http.MethodPost,
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "wrong token",
+			token:  "secret",
+			method: http.MethodPost,
+			auth:   "Bearer wrong",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "basic auth",
+			token:  "secret",
+			method: http.MethodPost,
+			auth:   "Basic secret",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "no configured token",
+			method: http.MethodPost,
+			auth:   "Bearer ",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "OK",
+			token:  "secret",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			code:   http.StatusOK,
+			called: true,
+		},
+		{
+			desc:   "error",
+			token:  "secret",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			err:    errors.New("invalid config"),
+			code:   http.StatusInternalServerError,
+			called: true,
+		},
+		{
+			desc:   "HTTP error",
+			token:  "secret",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			err:    &httpError{code: http.StatusConflict, err: errors.New("busy")},
+			code:   http.StatusConflict,
+			called: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var called bool
+			h := lifecycleHandler(tt.token, func() error {
+				called = true
+				return tt.err
+			})
+
+			r := httptest.NewRequest(tt.method, reloadPath, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status code: want %d, got %d", tt.code, w.Code)
+			}
+			if called != tt.called {
+				t.Fatalf("unexpected call: want %v, got %v", tt.called, called)
+			}
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatal("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestQuitHandler(t *testing.T) {
+	h, quit := quitHandler("secret")
+
+	quitting := func() bool {
+		select {
+		case <-quit:
+			return true
+		default:
+			return false
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, quitPath, nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if quitting() {
+		t.Fatal("unauthorized request shut down the exporter")
+	}
+
+	// Repeated requests to quit are accepted while shutting down.
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, quitPath, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status code: %d", i, w.Code)
+		}
+		if !quitting() {
+			t.Fatalf("request %d: exporter is not shutting down", i)
+		}
+	}
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	level := new(slog.LevelVar)
+	h := logLevelHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), "secret", level)
+
+	tests := []struct {
+		desc, method, query, auth string
+		code                      int
+		level                     string
+	}{
+		{desc: "GET", method: http.MethodGet, code: http.StatusOK, level: "info"},
+		{desc: "no token", method: http.MethodPut, query: "level=debug", code: http.StatusUnauthorized, level: "info"},
+		{desc: "wrong token", method: http.MethodPut, query: "level=debug", auth: "Bearer wrong", code: http.StatusUnauthorized, level: "info"},
+		{desc: "invalid level", method: http.MethodPut, query: "level=verbose", auth: "Bearer secret", code: http.StatusBadRequest, level: "info"},
+		{desc: "POST", method: http.MethodPost, query: "level=debug", auth: "Bearer secret", code: http.StatusMethodNotAllowed, level: "info"},
+		{desc: "debug", method: http.MethodPut, query: "level=debug", auth: "Bearer secret", code: http.StatusOK, level: "debug"},
+		{desc: "trace", method: http.MethodPut, query: "level=trace", auth: "Bearer secret", code: http.StatusOK, level: "trace"},
+		{desc: "error", method: http.MethodPut, query: "level=ERROR", auth: "Bearer secret", code: http.StatusOK, level: "error"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, logLevelPath+"?"+tt.query, nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.code {
+			t.Fatalf("%s: unexpected status code: want %d, got %d", tt.desc, tt.code, w.Code)
+		}
+		if got := levelName(level.Level()); got != tt.level {
+			t.Fatalf("%s: unexpected level: want %q, got %q", tt.desc, tt.level, got)
+		}
+		if tt.code == http.StatusOK && strings.TrimSpace(w.Body.String()) != tt.level {
+			t.Fatalf("%s: unexpected body: %q", tt.desc, w.Body.String())
+		}
+	}
+}
+
+func TestLogLevelHandlerLogsChange(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		level = new(slog.LevelVar)
+	)
+	h := logLevelHandler(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level})), "secret", level)
+
+	// A change is logged whether it makes the log more or less verbose.
+	for _, l := range []string{"error", "debug"} {
+		buf.Reset()
+
+		r := httptest.NewRequest(http.MethodPut, logLevelPath+"?level="+l, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		if got := buf.String(); !strings.Contains(got, "changed log level") || !strings.Contains(got, "to="+l) {
+			t.Fatalf("change to %s was not logged: %q", l, got)
+		}
+	}
+}
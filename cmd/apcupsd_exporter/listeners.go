@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A listenerConfig configures an additional HTTP listener which serves only
+// the metrics, or a subset of them, with its own access controls, such as a
+// minimal set on a port exposed to a DMZ.  Listeners are started with the
+// exporter, and changes to them take effect on restart.
+type listenerConfig struct {
+	// Address is the address on which the listener serves the metrics
+	// path, as in -telemetry.addr.
+	Address string `yaml:"address"`
+
+	// Metrics optionally allows only the metrics whose names fully match
+	// any of these regular expressions.
+	Metrics []string `yaml:"metrics,omitempty"`
+
+	// TokenFile optionally names a file containing a bearer token required
+	// by the listener, and AllowCIDRs optionally restricts its clients, as
+	// -web.allow-cidr does for the main listener.
+	TokenFile  string   `yaml:"token_file,omitempty"`
+	AllowCIDRs []string `yaml:"allow_cidrs,omitempty"`
+
+	// TLS serves the listener over TLS with the certificate of the main
+	// listener.
+	TLS bool `yaml:"tls,omitempty"`
+
+	metrics *regexp.Regexp
+	allow   cidrFlags
+}
+
+// validate checks the listener and compiles its allowlists.
+func (l *listenerConfig) validate() error {
+	if l.Address == "" {
+		return fmt.Errorf("address must be specified")
+	}
+
+	if len(l.Metrics) > 0 {
+		for _, m := range l.Metrics {
+			if _, err := regexp.Compile(m); err != nil {
+				return fmt.Errorf("invalid metrics pattern %q: %v", m, err)
+			}
+		}
+		l.metrics = regexp.MustCompile("^(?:" + strings.Join(l.Metrics, "|") + ")$")
+	}
+
+	l.allow = nil
+	for _, c := range l.AllowCIDRs {
+		if err := l.allow.Set(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allows reports whether the listener serves the metric family name.
+func (l *listenerConfig) allows(name string) bool {
+	return l.metrics == nil || l.metrics.MatchString(name)
+}
+
+// newListenerHandler returns a handler which serves the metrics of l from
+// those gathered by g on the metrics path.
+func newListenerHandler(l listenerConfig, g prometheus.Gatherer, logger *slog.Logger) (http.Handler, error) {
+	var token string
+	if l.TokenFile != "" {
+		var err error
+		token, err = readToken("listener", l.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The scrapes of the listener are instrumented on a registry of its
+	// own, so that its handler metrics replace those of the main listener.
+	reg := prometheus.NewRegistry()
+	h, err := apcupsdexporter.Register(reg, prometheus.Gatherers{
+		filteredGatherer{g: g, allow: func(name string) bool {
+			return !strings.HasPrefix(name, "promhttp_metric_handler_") && l.allows(name)
+		}},
+		filteredGatherer{g: reg, allow: l.allows},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, h)
+
+	return withAccessLog(withCIDRs(l.allow, withBearerToken(token, mux)), logger.With("listener", l.Address))
+}
+
+var _ prometheus.Gatherer = filteredGatherer{}
+
+// A filteredGatherer is a prometheus.Gatherer which only gathers the metric
+// families of g whose names are allowed.  The families gathered by g are not
+// modified, since they may be cached.
+type filteredGatherer struct {
+	g     prometheus.Gatherer
+	allow func(name string) bool
+}
+
+// Gather implements prometheus.Gatherer.
+func (g filteredGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.g.Gather()
+
+	out := make([]*dto.MetricFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		if g.allow(mf.GetName()) {
+			out = append(out, mf)
+		}
+	}
+
+	return out, err
+}
+
+// withBearerToken wraps h with a handler which rejects requests which do not
+// bear token, if it is set.
+func withBearerToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// startListener serves the metrics gathered by g on the listener l, over TLS
+// with tlsConfig if l requires it.  Errors serving the listener are sent to
+// errC.
+func startListener(l listenerConfig, g prometheus.Gatherer, tlsConfig *tls.Config, logger *slog.Logger, errC chan<- error) (*http.Server, error) {
+	if l.TLS && tlsConfig == nil {
+		return nil, fmt.Errorf("listener %q: TLS requires -web.tls-cert-file or -web.acme-domains", l.Address)
+	}
+
+	h, err := newListenerHandler(l, g, logger)
+	if err != nil {
+		return nil, fmt.Errorf("listener %q: %v", l.Address, err)
+	}
+
+	ln, err := net.Listen("tcp", l.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: h}
+	if l.TLS {
+		srv.TLSConfig = tlsConfig
+	}
+
+	logger.Info("starting listener", "addr", ln.Addr().String(), "tls", l.TLS, "metrics", strings.Join(l.Metrics, ","))
+
+	go func() {
+		if l.TLS {
+			errC <- fmt.Errorf("listener %q: %w", l.Address, srv.ServeTLS(ln, "", ""))
+			return
+		}
+
+		errC <- fmt.Errorf("listener %q: %w", l.Address, srv.Serve(ln))
+	}()
+
+	return srv, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestListener(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	cfg := &config{
+		Targets: []targetConfig{{Address: "localhost:3551"}},
+		Listeners: []listenerConfig{{
+			Address:    ":19162",
+			Metrics:    []string{"apcupsd_up", "promhttp_.*"},
+			TokenFile:  token,
+			AllowCIDRs: []string{"192.0.2.0/24"},
+		}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("failed to validate config: %v", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "apcupsd_up"}))
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "apcupsd_secret"}))
+
+	h, err := newListenerHandler(cfg.Listeners[0], reg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to create listener handler: %v", err)
+	}
+
+	get := func(remote, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, *metricsPath, nil)
+		r.RemoteAddr = remote
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("198.51.100.1:1234", "Bearer secret"); w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a disallowed client: %d", w.Code)
+	}
+	if w := get("192.0.2.1:1234", "Bearer wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code for a wrong token: %d", w.Code)
+	}
+
+	// The scrapes of the listener are counted separately.
+	get("192.0.2.1:1234", "Bearer secret")
+	w := get("192.0.2.1:1234", "Bearer secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d: %s", w.Code, w.Body)
+	}
+
+	body := w.Body.String()
+	for _, s := range []string{"apcupsd_up 0", `promhttp_metric_handler_requests_total{code="200"} 1`} {
+		if !strings.Contains(body, s) {
+			t.Fatalf("metrics do not contain %q:\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, "apcupsd_secret") {
+		t.Fatalf("metrics contain a metric which is not allowed:\n%s", body)
+	}
+
+	cfg.Listeners[0].Metrics = []string{"apcupsd_("}
+	if err := cfg.validate(); err == nil {
+		t.Fatal("expected an error for an invalid metrics pattern")
+	}
+}
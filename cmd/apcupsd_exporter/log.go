@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+)
+
+var (
+	logLevel  = flag.String("log.level", "info", `minimum level of log messages: one of "trace", "debug", "info", "warn", or "error"; "trace" logs each exchange with apcupsd, which may also be enabled for a single target at runtime`)
+	logFormat = flag.String("log.format", "text", `format of log messages: one of "text" or "json"`)
+
+	logErrorInterval = flag.Duration("log.error-interval", 5*time.Minute, "log repetitions of the same collection error at most once per interval, with a summary of the suppressed errors; 0 logs every error")
+
+	logTraceMaxBytes = flag.Int("log.trace-max-bytes", 4096, "maximum number of bytes sent to and received from apcupsd which are logged for each exchange at the trace level")
+	logTraceInterval = flag.Duration("log.trace-interval", time.Second, "log the exchanges with each target at the trace level at most once per interval, with the number of exchanges which were not logged; 0 logs every exchange")
+)
+
+// levelTrace is the level of -log.level=trace, at which each exchange with
+// apcupsd is logged.
+const levelTrace = apcupsdexporter.LevelTrace
+
+// parseLevel parses a level of -log.level or of the log level endpoint.
+func parseLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "trace") {
+		return levelTrace, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: %v", s, err)
+	}
+
+	return level, nil
+}
+
+// levelName returns the name of level as parsed by parseLevel.
+func levelName(level slog.Level) string {
+	if level == levelTrace {
+		return "trace"
+	}
+
+	return strings.ToLower(level.String())
+}
+
+// replaceLevel names levelTrace "TRACE" in log records, rather than
+// "DEBUG-4".
+func replaceLevel(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && a.Value.Any() == levelTrace {
+		a.Value = slog.StringValue("TRACE")
+	}
+
+	return a
+}
+
+// newLogger creates a logger configured by the log flags, along with the
+// variable holding its level, which may be changed at runtime.
+func newLogger() (*slog.Logger, *slog.LevelVar, error) {
+	l, err := parseLevel(*logLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	level := new(slog.LevelVar)
+	level.Set(l)
+
+	// The handlers accept records of every level, which are filtered by the
+	// levelHandler instead, so that tracing can be enabled for a single
+	// target.
+	opts := &slog.HandlerOptions{Level: levelTrace, ReplaceAttr: replaceLevel}
+
+	var h slog.Handler
+	switch *logFormat {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return nil, nil, fmt.Errorf("invalid log format %q", *logFormat)
+	}
+
+	// Also write to the system log on platforms which have one, such as the
+	// Windows Event Log, where stderr is invisible.
+	sh, err := newSystemLogHandler(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sh != nil {
+		h = teeHandler{h, sh}
+	}
+
+	return slog.New(&levelHandler{h: h, level: level}), level, nil
+}
+
+// A levelHandler is a slog.Handler which passes log records of at least its
+// level to its handler, and those of every level down to levelTrace while
+// trace reports true.
+type levelHandler struct {
+	h     slog.Handler
+	level slog.Leveler
+	trace func() bool
+}
+
+var _ slog.Handler = &levelHandler{}
+
+// withTrace returns logger with records of every level down to levelTrace
+// enabled while trace reports true, if its handler is a levelHandler.
+func withTrace(logger *slog.Logger, trace func() bool) *slog.Logger {
+	lh, ok := logger.Handler().(*levelHandler)
+	if !ok {
+		return logger
+	}
+
+	return slog.New(&levelHandler{h: lh.h, level: lh.level, trace: trace})
+}
+
+// Enabled implements slog.Handler.
+func (lh *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if level >= lh.level.Level() {
+		return true
+	}
+
+	return level >= levelTrace && lh.trace != nil && lh.trace()
+}
+
+// Handle implements slog.Handler.
+func (lh *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return lh.h.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (lh *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h: lh.h.WithAttrs(attrs), level: lh.level, trace: lh.trace}
+}
+
+// WithGroup implements slog.Handler.
+func (lh *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h: lh.h.WithGroup(name), level: lh.level, trace: lh.trace}
+}
+
+// A teeHandler is a slog.Handler which passes log records to each of its
+// handlers.
+type teeHandler []slog.Handler
+
+var _ slog.Handler = teeHandler{}
+
+// Enabled implements slog.Handler.
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handle implements slog.Handler.
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WithAttrs implements slog.Handler.
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(teeHandler, 0, len(t))
+	for _, h := range t {
+		hs = append(hs, h.WithAttrs(attrs))
+	}
+
+	return hs
+}
+
+// WithGroup implements slog.Handler.
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	hs := make(teeHandler, 0, len(t))
+	for _, h := range t {
+		hs = append(hs, h.WithGroup(name))
+	}
+
+	return hs
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestTeeHandler(t *testing.T) {
+	var all, warn bytes.Buffer
+	logger := slog.New(teeHandler{
+		slog.NewTextHandler(&all, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewTextHandler(&warn, &slog.HandlerOptions{Level: slog.LevelWarn}),
+	}).With("target", "ups1").WithGroup("collector")
+
+	logger.Debug("collected", "duration", "10ms")
+	logger.Warn("failed to collect", "err", "timeout")
+
+	if n := strings.Count(all.String(), "\n"); n != 2 {
+		t.Fatalf("unexpected number of records in the first handler: %d\n%s", n, all.String())
+	}
+
+	// Only the records enabled by each handler are passed to it, with the
+	// attributes and groups of the logger.
+	got := warn.String()
+	if strings.Count(got, "\n") != 1 || !strings.Contains(got, `msg="failed to collect" target=ups1 collector.err=timeout`) {
+		t.Fatalf("unexpected records in the second handler:\n%s", got)
+	}
+
+	if (teeHandler{}).Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("empty teeHandler is enabled")
+	}
+}
+
+func TestNewLoggerInvalid(t *testing.T) {
+	defer func(l, f string) { *logLevel, *logFormat = l, f }(*logLevel, *logFormat)
+
+	for _, tt := range []struct{ level, format string }{
+		{level: "verbose", format: "text"},
+		{level: "info", format: "logfmt"},
+	} {
+		*logLevel, *logFormat = tt.level, tt.format
+		if _, _, err := newLogger(); err == nil {
+			t.Fatalf("expected an error for level %q and format %q, but none occurred", tt.level, tt.format)
+		}
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	var buf bytes.Buffer
+	var trace bool
+	logger := withTrace(slog.New(&levelHandler{
+		h:     slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: levelTrace, ReplaceAttr: replaceLevel}),
+		level: slog.LevelInfo,
+	}), func() bool { return trace }).With("target", "ups1")
+
+	logger.Debug("collected")
+	logger.Log(context.Background(), levelTrace, "exchanged")
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected records below the level:\n%s", buf.String())
+	}
+
+	// Tracing enables the records of every level.
+	trace = true
+	logger.Debug("collected")
+	logger.Log(context.Background(), levelTrace, "exchanged")
+	if got := buf.String(); strings.Count(got, "\n") != 2 || !strings.Contains(got, "level=TRACE msg=exchanged target=ups1") {
+		t.Fatalf("unexpected records while tracing:\n%s", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{
+		"trace": levelTrace,
+		"TRACE": levelTrace,
+		"debug": slog.LevelDebug,
+		"warn":  slog.LevelWarn,
+	} {
+		got, err := parseLevel(s)
+		if err != nil {
+			t.Fatalf("failed to parse level %q: %v", s, err)
+		}
+		if got != want {
+			t.Fatalf("unexpected level for %q: want %v, got %v", s, want, got)
+		}
+	}
+}
@@ -3,50 +3,485 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/mdlayher/apcupsd"
 	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	telemetryAddr = flag.String("telemetry.addr", ":9162", "address for apcupsd exporter")
 	metricsPath   = flag.String("telemetry.path", "/metrics", "URL path for surfacing collected metrics")
 
-	apcupsdAddr    = flag.String("apcupsd.addr", ":3551", "address of apcupsd Network Information Server (NIS)")
-	apcupsdNetwork = flag.String("apcupsd.network", "tcp", `network of apcupsd Network Information Server (NIS): typically "tcp", "tcp4", or "tcp6"`)
+	apcupsdAddr       = flag.String("apcupsd.addr", ":3551", "address of apcupsd Network Information Server (NIS)")
+	apcupsdHostname   = flag.String("apcupsd.hostname", "", `replace the hostname reported by apcupsd with this Go template executed with the UPS status, such as "{{ .UPSName }}.example.com", or simply a fixed name`)
+	apcupsdNetwork    = flag.String("apcupsd.network", "tcp", `network of apcupsd Network Information Server (NIS): typically "tcp", "tcp4", or "tcp6"`)
+	apcupsdIPProtocol = flag.String("apcupsd.ip-protocol", "", `preferred IP protocol used to dial apcupsd: "ip4" or "ip6" (default: dial as -apcupsd.network)`)
+	apcupsdIPFallback = flag.Bool("apcupsd.ip-protocol-fallback", true, "fall back to the other IP protocol if apcupsd has no address of, or cannot be reached using, the protocol set by -apcupsd.ip-protocol")
+	apcupsdTimezone   = flag.String("apcupsd.timezone", "", `time zone in which timestamps reported by apcupsd without a UTC offset are interpreted, such as "Europe/Berlin" (default: the local time zone)`)
+	apcupsdTimeout    = flag.Duration("apcupsd.timeout", 5*time.Second, "deadline for each collection of metrics from apcupsd, including dialing and reading its status")
+
+	collectors           = collectorFlags()
+	serveStale           = flag.Duration("collector.serve-stale", 0, "when metrics cannot be collected from apcupsd, serve those of the last successful collection for up to this long, marked by apcupsd_data_stale 1; 0 disables serving stale metrics")
+	invalidMetricOnError = flag.Bool("collector.invalid-metric-on-error", false, "fail the entire scrape when metrics cannot be collected from apcupsd, instead of reporting apcupsd_up 0 (legacy behavior)")
+
+	upstreamNames = flag.Bool("compat.upstream-names", false, "export the metrics which the original mdlayher/apcupsd_exporter also exports with its labels, a single ups label holding the UPS name, instead of ups_name, hostname, and model")
+
+	nominalRuntime     = flag.Duration("collector.battery.nominal-runtime", 0, "runtime of a new UPS battery at the load set by -collector.battery.nominal-runtime-load, against which the remaining battery capacity is estimated (default: the highest runtime observed over at least a week)")
+	nominalRuntimeLoad = flag.Float64("collector.battery.nominal-runtime-load", 100, "load percentage at which -collector.battery.nominal-runtime is specified")
+	shutdownDuration   = flag.Duration("collector.battery.shutdown-duration", 0, "time the systems protected by a UPS take to shut down once apcupsd initiates their shutdown, which apcupsd_runtime_margin_seconds subtracts from the battery runtime left")
+
+	compactStatus = flag.Bool("collector.status.compact", false, "export apcupsd_status only for the status flags which are set, rather than for every flag with a value of 0 or 1, so that absent flags must be treated as clear")
+
+	confCollector = flag.Bool("collector.conf", false, "enable the collector of directives in the local apcupsd configuration file")
+	confPath      = flag.String("collector.conf.path", apcupsdexporter.DefaultConfigPath, "path of the local apcupsd configuration file read by -collector.conf")
+
+	apcupsdAddrs addressFlags
 )
 
+func init() {
+	flag.Var(&apcupsdAddrs, "apcupsd.addresses", "comma-separated addresses of apcupsd Network Information Servers (NIS) to collect metrics from, each as a target named by its address, instead of -apcupsd.addr (may be repeated)")
+}
+
+// addressFlags is a flag.Value which accumulates comma-separated addresses.
+type addressFlags []string
+
+// String implements flag.Value.
+func (as *addressFlags) String() string { return strings.Join(*as, ",") }
+
+// Set implements flag.Value.
+func (as *addressFlags) Set(s string) error {
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			return errors.New("addresses must not be empty")
+		}
+		*as = append(*as, a)
+	}
+
+	return nil
+}
+
+// collectorFlags registers a flag to enable or disable each collector.
+func collectorFlags() map[string]*bool {
+	m := make(map[string]*bool)
+	for _, n := range apcupsdexporter.CollectorNames() {
+		m[n] = flag.Bool("collector."+n, true, fmt.Sprintf("enable the %s collector", n))
+	}
+
+	return m
+}
+
+// enabledCollectors returns the names of the collectors enabled by flags.
+func enabledCollectors() []string {
+	var names []string
+	for _, n := range apcupsdexporter.CollectorNames() {
+		if *collectors[n] {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
 func main() {
 	flag.Parse()
 
-	if *apcupsdAddr == "" {
-		log.Fatal("address of apcupsd Network Information Server (NIS) must be specified with '-apcupsd.addr' flag")
+	logger, level, err := newLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Also route messages from the standard library logger through logger.
+	slog.SetDefault(logger)
+
+	if flag.NArg() > 0 {
+		if err := command(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	fn := newClient(*apcupsdNetwork, *apcupsdAddr)
+	st, err := openStorage(logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts, err := newTargetSet(logger, st)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	prometheus.MustRegister(apcupsdexporter.New(fn))
+	if *enableHistory {
+		ts.history, err = openHistory(filepath.Join(st.path, "history"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go ts.history.run(logger)
+	}
+
+	ts.tracer, err = newTracer(logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ts.tracer != nil {
+		go ts.tracer.run()
+	}
+
+	// Collect from the targets on each scrape, or in the background.
+	var (
+		c prometheus.Collector = ts
+		p *poller
+	)
+	if *pollInterval > 0 {
+		p = &poller{c: ts, interval: ts.pollInterval}
+		c = p
+		go p.run()
+	}
+
+	// When polling, the exporter is ready once each target has been
+	// collected successfully, so that early scrapes do not lack its series.
+	ready := func() bool { return p == nil || ts.ready() }
+
+	var g prometheus.Gatherer = prometheus.DefaultGatherer
+	if *renamedMetrics {
+		g = renamedGatherer{g: g, renames: metricRenames}
+	}
+
+	var cg *cachedGatherer
+	if *cacheTTL > 0 {
+		cg = &cachedGatherer{g: g, ttl: *cacheTTL}
+		g = cg
+	}
+
+	certs, err := newCertReloader(logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	acmeManager, err := newACMEManager(st)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	refresh := func() error {
+		if err := ts.reload(); err != nil {
+			return err
+		}
+		if p != nil {
+			// Don't serve the metrics of removed targets until the next
+			// poll.
+			p.poll()
+		}
+		if cg != nil {
+			// Don't serve the metrics of removed targets from the cache.
+			cg.invalidate()
+		}
+
+		return nil
+	}
+
+	reload := func() error {
+		if certs != nil {
+			if err := certs.reload(); err != nil {
+				logger.Error("failed to reload TLS certificate", "err", err)
+				return err
+			}
+		}
+		if err := refresh(); err != nil {
+			logger.Error("failed to reload configuration", "err", err)
+			return err
+		}
+
+		logger.Info("reloaded configuration")
+		return nil
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = reload()
+		}
+	}()
+
+	if *configFile != "" && *discoveryRefreshInterval > 0 {
+		// Refresh the targets of the discoverers periodically, which
+		// may be added by later reloads, without logging each refresh.
+		go func() {
+			for range time.Tick(*discoveryRefreshInterval) {
+				if len(ts.config().Discoverers) == 0 {
+					continue
+				}
+				if err := refresh(); err != nil {
+					logger.Warn("failed to refresh discovered targets", "err", err)
+				}
+			}
+		}()
+	}
+
+	cs := []prometheus.Collector{c, st}
+	if *confCollector {
+		cs = append(cs, apcupsdexporter.NewConfCollector(*confPath,
+			apcupsdexporter.WithLogger(logger),
+		))
+	}
+	for _, p := range plugins {
+		cs = append(cs, apcupsdexporter.NewExecCollector(p.name, p.args,
+			apcupsdexporter.WithLogger(logger),
+			apcupsdexporter.WithTimeout(*pluginTimeout),
+		))
+	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	h, err := apcupsdexporter.Register(prometheus.DefaultRegisterer, g, cs...)
+	if err != nil {
+		log.Fatalf("cannot register collectors: %s", err)
+	}
+	if cg != nil {
+		h = cg.withCacheHeaders(h)
+	}
+	if ts.tracer != nil && p == nil {
+		// Only scrapes collect from the targets when not polling.
+		h = ts.tracer.withTraceParent(h)
+	}
+	if *readyMetrics {
+		h = withReadiness(h, ready)
+	}
+
+	http.Handle(*metricsPath, h)
+	http.HandleFunc(healthyPath, healthy)
+	http.Handle(readyPath, readiness(ready))
+	http.Handle(configPath, configHandler(ts.config))
+	http.Handle(snapshotPath, snapshotHandler(ts))
+	http.Handle(statusAPIPath, statusAPIHandler(ts))
+	http.Handle(inventoryPath, inventoryHandler(ts))
+	if ts.history != nil {
+		http.Handle(historyCSVPath, historyCSVHandler(ts, ts.history))
+		http.Handle(grafanaPath+"/", grafanaHandler(ts, ts.history))
+	}
+	http.Handle(statusPath, statusHandler(ts, func() time.Time {
+		if p == nil {
+			return time.Time{}
+		}
+
+		return p.next()
+	}))
+
+	var quit <-chan struct{}
+	if *enableLifecycle {
+		token, err := lifecycleToken()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var qh http.Handler
+		qh, quit = quitHandler(token)
+		http.Handle(reloadPath, lifecycleHandler(token, reload))
+		http.Handle(quitPath, qh)
+		http.Handle(logLevelPath, logLevelHandler(logger, token, level))
+	}
+	if *enableSelftest {
+		h, err := selftestHandler(logger, ts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		http.Handle(selftestPath, h)
+	}
+	if *enableMaintenance {
+		h, err := maintenanceHandler(logger, ts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		http.Handle(maintenancePath, h)
+	}
+	if *enableNISTrace {
+		h, err := nisTraceHandler(logger, ts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		http.Handle(nisTracePath, h)
+	}
+	if *enableProbe {
+		http.Handle(probePath, probeHandler(logger, ts))
+	}
+	http.Handle(openAPIPath, openAPIHandler(openAPIEndpoints{
+		history:     ts.history != nil,
+		lifecycle:   *enableLifecycle,
+		selftest:    *enableSelftest,
+		maintenance: *enableMaintenance,
+		nisTrace:    *enableNISTrace,
+		probe:       *enableProbe,
+	}))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, *metricsPath, http.StatusMovedPermanently)
 	})
 
-	log.Printf("starting apcupsd exporter on %q for server %s://%s",
-		*telemetryAddr, *apcupsdNetwork, *apcupsdAddr)
+	handler, err := withAccessLog(withAllowlist(withRateLimit(withCORS(withAPIStability(http.DefaultServeMux)))), logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := listen(*telemetryAddr)
+	if err != nil {
+		log.Fatalf("cannot start apcupsd exporter: %s", err)
+	}
+
+	apcupsds := make([]string, 0, len(ts.config().Targets))
+	for _, t := range ts.config().Targets {
+		apcupsds = append(apcupsds, fmt.Sprintf("%s://%s", t.Network, t.Address))
+	}
+
+	srv := &http.Server{Handler: handler}
+	switch {
+	case certs != nil:
+		srv.TLSConfig = certs.tlsConfig()
+	case acmeManager != nil:
+		srv.TLSConfig = acmeTLSConfig(acmeManager)
+		if err := serveACMEHTTP(logger, acmeManager); err != nil {
+			log.Fatalf("cannot serve ACME challenges: %s", err)
+		}
+	}
+	if err := configureH2C(srv); err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("starting apcupsd exporter",
+		"addr", l.Addr().String(),
+		"tls", srv.TLSConfig != nil,
+		"apcupsd", strings.Join(apcupsds, ","))
+
+	errC := make(chan error, 1)
+	var listeners []*http.Server
+	for _, lc := range ts.config().Listeners {
+		lsrv, err := startListener(lc, g, srv.TLSConfig, logger, errC)
+		if err != nil {
+			log.Fatalf("cannot start apcupsd exporter: %s", err)
+		}
+		listeners = append(listeners, lsrv)
+	}
+	go func() {
+		if srv.TLSConfig != nil {
+			// Certificates are served by TLSConfig.GetCertificate.
+			errC <- srv.ServeTLS(l, "", "")
+			return
+		}
+
+		errC <- srv.Serve(l)
+	}()
 
-	if err := http.ListenAndServe(*telemetryAddr, nil); err != nil {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	if err := notifyService(stop); err != nil {
+		log.Fatalf("cannot run as a service: %s", err)
+	}
+
+	select {
+	case err := <-errC:
 		log.Fatalf("cannot start apcupsd exporter: %s", err)
+	case sig := <-stop:
+		logger.Info("shutting down apcupsd exporter", "signal", sig.String())
+	case <-quit:
+		logger.Info("shutting down apcupsd exporter", "reason", "quit requested")
+	}
+
+	// Allow in-flight scrapes to complete before exiting.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range append(listeners, srv) {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("failed to shut down gracefully", "err", err)
+		}
+	}
+}
+
+// newConfig returns the configuration loaded from -config.file, or otherwise
+// a configuration of the targets set by the apcupsd flags.
+func newConfig() (*config, error) {
+	if *configFile != "" {
+		if len(apcupsdAddrs) > 0 {
+			return nil, errors.New("-apcupsd.addresses cannot be combined with -config.file, whose targets replace it")
+		}
+
+		return loadConfig(*configFile)
 	}
+
+	addrs := []string(apcupsdAddrs)
+	switch {
+	case len(addrs) == 0 && *apcupsdAddr == "":
+		return nil, errors.New("address of apcupsd Network Information Server (NIS) must be specified with '-apcupsd.addr' flag")
+	case len(addrs) == 0:
+		addrs = []string{*apcupsdAddr}
+	case len(addrs) > 1 && (*apcupsdRecord != "" || *apcupsdReplay != ""):
+		// A capture holds the exchanges with a single target.
+		return nil, errors.New("-apcupsd.record and -apcupsd.replay cannot be combined with multiple -apcupsd.addresses")
+	}
+
+	c := &config{Targets: make([]targetConfig, 0, len(addrs))}
+	for _, addr := range addrs {
+		c.Targets = append(c.Targets, targetConfig{
+			Address:  addr,
+			Network:  *apcupsdNetwork,
+			Hostname: *apcupsdHostname,
+			Simulate: *simulate,
+			Record:   *apcupsdRecord,
+			Replay:   *apcupsdReplay,
+		})
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
 }
 
-func newClient(network, addr string) apcupsdexporter.ClientFunc {
-	return func(ctx context.Context) (*apcupsd.Client, error) {
-		return apcupsd.DialContext(ctx, network, addr)
+// multiTarget reports whether the targets are set by -config.file or
+// -apcupsd.addresses, rather than being the single target of -apcupsd.addr,
+// so that the metrics of each target are distinguished by a target label.
+func multiTarget() bool {
+	return *configFile != "" || len(apcupsdAddrs) > 0
+}
+
+// command runs the subcommand named by args[0].
+func command(args []string) error {
+	switch args[0] {
+	case "config":
+		return configCommand(args[1:])
+	case "healthcheck":
+		return healthcheckCommand(args[1:])
+	case "systemd":
+		return systemdCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// listen returns the listener passed by systemd socket activation, if any,
+// or otherwise listens on addr.
+func listen(addr string) (net.Listener, error) {
+	// See sd_listen_fds(3): the first passed file descriptor is always 3.
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || fds < 1 {
+		return net.Listen("tcp", addr)
 	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	return net.FileListener(os.NewFile(3, "systemd"))
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+)
+
+func TestMetricsLint(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	h, err := apcupsdexporter.Register(reg, reg, ts)
+	if err != nil {
+		t.Fatalf("failed to register collectors: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.Bytes()
+
+	// The nominal energy of batteries is specified in watt-hours, as on
+	// their ratings, rather than in joules.
+	allowed := map[string]bool{
+		"apcupsd_battery_nominal_energy_watt_hours": true,
+	}
+
+	problems, err := promlint.New(bytes.NewReader(out)).Lint()
+	if err != nil {
+		t.Fatalf("failed to lint metrics: %v", err)
+	}
+	for _, p := range problems {
+		if !allowed[p.Metric] {
+			t.Errorf("%s: %s", p.Metric, p.Text)
+		}
+	}
+
+	// promtool applies the same linter, but is also run if it is installed,
+	// so that its checks which are missing from the linter are covered.
+	promtool, err := exec.LookPath("promtool")
+	if err != nil {
+		t.Log("promtool is not installed, skipping promtool check metrics")
+		return
+	}
+
+	cmd := exec.Command(promtool, "check", "metrics")
+	cmd.Stdin = bytes.NewReader(out)
+	b, _ := cmd.CombinedOutput()
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if name, _, _ := strings.Cut(l, " "); l != "" && !allowed[name] {
+			t.Errorf("promtool: %s", l)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maintenancePath is the URL path of the admin endpoint which places a target
+// in maintenance.
+const maintenancePath = apiExperimentalPath + "/maintenance"
+
+var enableMaintenance = flag.Bool("admin.enable-maintenance", false, "enable the HTTP admin endpoint "+maintenancePath+", which places a target in maintenance for a duration")
+
+var maintenanceModeDesc = prometheus.NewDesc(
+	"apcupsd_maintenance_mode",
+	"Whether the target is in a maintenance window, during which alerts on its UPS should be silenced (1 for yes, 0 for no).",
+	[]string{"target"}, nil,
+)
+
+// A maintenanceWindow is a period during which a target is in maintenance,
+// such as for a planned battery replacement.
+type maintenanceWindow struct {
+	Start  time.Time `yaml:"start"`
+	End    time.Time `yaml:"end"`
+	Reason string    `yaml:"reason,omitempty"`
+}
+
+// validate checks w for errors.
+func (w maintenanceWindow) validate() error {
+	if w.Start.IsZero() || w.End.IsZero() {
+		return errors.New("start and end must be specified")
+	}
+	if !w.End.After(w.Start) {
+		return fmt.Errorf("end %s is not after start %s", w.End.Format(time.RFC3339), w.Start.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// A maintenanceSet records the targets placed in maintenance at runtime by
+// the maintenance endpoint, by name, so that it outlives reloads of the
+// configuration.
+type maintenanceSet struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// set places the target named name in maintenance until the time until, or
+// ends its maintenance if until has passed.
+func (ms *maintenanceSet) set(name string, until time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !until.After(time.Now()) {
+		delete(ms.until, name)
+		return
+	}
+
+	if ms.until == nil {
+		ms.until = make(map[string]time.Time)
+	}
+	ms.until[name] = until
+}
+
+// active reports whether the target t is in maintenance at now, either in one
+// of its configured windows or as set at runtime.
+func (ms *maintenanceSet) active(t targetConfig, now time.Time) bool {
+	for _, w := range t.Maintenance {
+		if !now.Before(w.Start) && now.Before(w.End) {
+			return true
+		}
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	until, ok := ms.until[t.Name]
+	if ok && !now.Before(until) {
+		delete(ms.until, t.Name)
+		return false
+	}
+
+	return ok
+}
+
+// collect sends whether each of targets is in maintenance to ch.
+func (ms *maintenanceSet) collect(ch chan<- prometheus.Metric, targets []targetConfig) {
+	now := time.Now()
+	for _, t := range targets {
+		var v float64
+		if ms.active(t, now) {
+			v = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(maintenanceModeDesc, prometheus.GaugeValue, v, t.Name)
+	}
+}
+
+// maintenanceHandler returns the handler of the maintenance endpoint, or an
+// error if it is misconfigured.
+func maintenanceHandler(logger *slog.Logger, ts *targetSet) (http.Handler, error) {
+	if *adminTokenFile == "" {
+		return nil, errors.New("-admin.token-file must be set to enable the admin endpoints")
+	}
+
+	token, err := readToken("admin", *adminTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return postHandler(token, func(r *http.Request) error {
+		t, _, err := ts.find(r.URL.Query().Get("target"))
+		if err != nil {
+			return err
+		}
+
+		d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || d < 0 {
+			return &httpError{code: http.StatusBadRequest, err: errors.New(`the duration query parameter must be a non-negative duration, such as "2h", or "0" to end maintenance`)}
+		}
+
+		if d == 0 {
+			logger.Info("ended maintenance", "target", t.Name, "remote_addr", r.RemoteAddr)
+		} else {
+			logger.Info("started maintenance", "target", t.Name, "duration", d,
+				"reason", r.URL.Query().Get("reason"), "remote_addr", r.RemoteAddr)
+		}
+
+		ts.maintenance.set(t.Name, time.Now().Add(d))
+		return nil
+	}), nil
+}
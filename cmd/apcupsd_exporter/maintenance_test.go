@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMaintenance(t *testing.T) {
+	defer func(c, f string) { *configFile, *adminTokenFile = c, f }(*configFile, *adminTokenFile)
+
+	dir := t.TempDir()
+	now := time.Now()
+	*configFile = filepath.Join(dir, "config.yml")
+	*adminTokenFile = filepath.Join(dir, "token")
+	config := fmt.Sprintf(`
+targets:
+  - name: current
+    address: '127.0.0.1:1'
+    maintenance:
+      - start: %s
+        end: %s
+        reason: battery replacement
+  - name: past
+    address: '127.0.0.1:2'
+    maintenance:
+      - start: %s
+        end: %s
+  - name: runtime
+    address: '127.0.0.1:3'
+`,
+		now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339),
+		now.Add(-2*time.Hour).Format(time.RFC3339), now.Add(-time.Hour).Format(time.RFC3339),
+	)
+	for path, b := range map[string]string{*configFile: config, *adminTokenFile: "secret\n"} {
+		if err := os.WriteFile(path, []byte(b), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	h, err := maintenanceHandler(logger, ts)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	check := func(runtime int) {
+		t.Helper()
+
+		want := fmt.Sprintf(`
+# HELP apcupsd_maintenance_mode Whether the target is in a maintenance window, during which alerts on its UPS should be silenced (1 for yes, 0 for no).
+# TYPE apcupsd_maintenance_mode gauge
+apcupsd_maintenance_mode{target="current"} 1
+apcupsd_maintenance_mode{target="past"} 0
+apcupsd_maintenance_mode{target="runtime"} %d
+`, runtime)
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_maintenance_mode"); err != nil {
+			t.Fatalf("unexpected metrics: %v", err)
+		}
+	}
+
+	post := func(query, auth string) int {
+		r := httptest.NewRequest(http.MethodPost, maintenancePath+"?"+query, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	check(0)
+
+	tests := []struct {
+		desc, query, auth string
+		code              int
+	}{
+		{desc: "no token", query: "target=runtime&duration=2h", code: http.StatusUnauthorized},
+		{desc: "wrong token", query: "target=runtime&duration=2h", auth: "Bearer wrong", code: http.StatusUnauthorized},
+		{desc: "no target", query: "duration=2h", auth: "Bearer secret", code: http.StatusBadRequest},
+		{desc: "unknown target", query: "target=ups9&duration=2h", auth: "Bearer secret", code: http.StatusNotFound},
+		{desc: "no duration", query: "target=runtime", auth: "Bearer secret", code: http.StatusBadRequest},
+		{desc: "negative duration", query: "target=runtime&duration=-1h", auth: "Bearer secret", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := post(tt.query, tt.auth); code != tt.code {
+			t.Fatalf("%s: unexpected status code: want %d, got %d", tt.desc, tt.code, code)
+		}
+	}
+	check(0)
+
+	if code := post("target=runtime&duration=2h&reason=battery", "Bearer secret"); code != http.StatusOK {
+		t.Fatalf("failed to start maintenance: %d", code)
+	}
+	check(1)
+
+	// Maintenance set at runtime outlives reloads.
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	check(1)
+
+	if code := post("target=runtime&duration=0", "Bearer secret"); code != http.StatusOK {
+		t.Fatalf("failed to end maintenance: %d", code)
+	}
+	check(0)
+}
+
+func TestMaintenanceSetExpires(t *testing.T) {
+	var ms maintenanceSet
+	tc := targetConfig{Name: "ups1"}
+
+	now := time.Now()
+	ms.set(tc.Name, now.Add(time.Minute))
+	if !ms.active(tc, now) {
+		t.Fatal("target is not in maintenance")
+	}
+	if ms.active(tc, now.Add(time.Minute)) {
+		t.Fatal("target is still in maintenance after it ended")
+	}
+	if len(ms.until) != 0 {
+		t.Fatalf("expired maintenance was not discarded: %v", ms.until)
+	}
+}
+
+func TestMaintenanceHandlerConfig(t *testing.T) {
+	defer func(f string) { *adminTokenFile = f }(*adminTokenFile)
+
+	*adminTokenFile = ""
+	if _, err := maintenanceHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), nil); err == nil {
+		t.Fatal("expected an error without a token file, but none occurred")
+	}
+}
+
+func TestConfigMaintenanceWindows(t *testing.T) {
+	for _, file := range []string{
+		"targets: [{address: ups1, maintenance: [{start: 2026-01-01T10:00:00Z}]}]",
+		"targets: [{address: ups1, maintenance: [{start: 2026-01-01T10:00:00Z, end: 2026-01-01T09:00:00Z}]}]",
+		"targets: [{address: ups1, maintenance: [{start: tomorrow, end: 2026-01-01T09:00:00Z}]}]",
+	} {
+		if _, err := decodeConfig([]byte(file)); err == nil {
+			t.Fatalf("expected an error decoding config %q, but none occurred", file)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+)
+
+// A targetTLSConfig configures TLS for a NIS whose port is wrapped in TLS, such
+// as by stunnel.
+type targetTLSConfig struct {
+	// CAFile is the path of the PEM certificates of the CAs which verify the
+	// certificate of the NIS, by default those of the system.
+	CAFile string `yaml:"ca_file,omitempty"`
+
+	// CertFile and KeyFile are the paths of a PEM client certificate and its
+	// key, presented to a NIS which requires one.
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+
+	// ServerName is the name of the NIS sent in SNI and verified against its
+	// certificate, by default the host of the address of the target.
+	ServerName string `yaml:"server_name,omitempty"`
+
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
+
+	config *tls.Config
+}
+
+// validate checks c for errors and loads its certificates.  addr is the
+// address of the target.
+func (c *targetTLSConfig) validate(addr string) error {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		cfg.ServerName = host
+	}
+
+	if c.CAFile != "" {
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates found in TLS CA file %s", c.CAFile)
+		}
+	}
+
+	switch {
+	case (c.CertFile == "") != (c.KeyFile == ""):
+		return errors.New("TLS cert_file and key_file must be set together")
+	case c.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	c.config = cfg
+	return nil
+}
+
+// withTLS wraps the connections dialed by dial in TLS using cfg.
+func withTLS(dial apcupsdexporter.DialFunc, cfg *tls.Config) apcupsdexporter.DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %v", err)
+		}
+
+		return tc, nil
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestTargetTLS(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	now := time.Now()
+	writeTestCert(t, path("server.crt"), path("server.key"), "127.0.0.1", now)
+	writeTestCert(t, path("client.crt"), path("client.key"), "exporter", now)
+
+	serverCert, err := tls.LoadX509KeyPair(path("server.crt"), path("server.key"))
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	b, err := os.ReadFile(path("client.crt"))
+	if err != nil {
+		t.Fatalf("failed to read client certificate: %v", err)
+	}
+	clientCAs.AppendCertsFromPEM(b)
+
+	// Serve the NIS wrapped in TLS, requiring a client certificate, as
+	// stunnel does with verify = 2.
+	s := apcupsdtest.NewServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	err = s.ListenTLS("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		desc, tls string
+		ok        bool
+	}{
+		{
+			desc: "verified",
+			tls:  fmt.Sprintf("{ca_file: %s, cert_file: %s, key_file: %s}", path("server.crt"), path("client.crt"), path("client.key")),
+			ok:   true,
+		},
+		{
+			desc: "insecure",
+			tls:  fmt.Sprintf("{insecure_skip_verify: true, cert_file: %s, key_file: %s}", path("client.crt"), path("client.key")),
+			ok:   true,
+		},
+		{
+			desc: "unknown CA",
+			tls:  fmt.Sprintf("{cert_file: %s, key_file: %s}", path("client.crt"), path("client.key")),
+		},
+		{
+			desc: "no client certificate",
+			tls:  fmt.Sprintf("{ca_file: %s}", path("server.crt")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, err := decodeConfig([]byte(fmt.Sprintf("targets: [{address: %q, tls: %s}]", s.Addr(), tt.tls)))
+			if err != nil {
+				t.Fatalf("failed to decode config: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var status *apcupsd.Status
+			conn, err := c.Targets[0].dialFunc()(ctx)
+			if err == nil {
+				defer conn.Close()
+				status, err = apcupsd.New(conn).Status()
+			}
+			if tt.ok != (err == nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && status.Model != "Back-UPS RS 1500G" {
+				t.Fatalf("unexpected model: %q", status.Model)
+			}
+		})
+	}
+
+	if _, err := decodeConfig([]byte(fmt.Sprintf("targets: [{address: ups1, tls: {cert_file: %s}}]", path("client.crt")))); err == nil {
+		t.Fatal("expected an error with a certificate but no key")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// nisTracePath is the URL path of the admin endpoint which logs the exchanges
+// with a target at the trace level.
+const nisTracePath = apiExperimentalPath + "/trace"
+
+var enableNISTrace = flag.Bool("admin.enable-trace", false, "enable the HTTP admin endpoint "+nisTracePath+", which logs the exchanges with a target at the trace level for a duration, regardless of -log.level")
+
+// A nisTraceSet records the targets whose exchanges are logged at the trace
+// level, by name, so that tracing outlives reloads of the configuration.
+type nisTraceSet struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// set traces the target named name until the time until, or stops tracing it
+// if until has passed.
+func (ns *nisTraceSet) set(name string, until time.Time) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if !until.After(time.Now()) {
+		delete(ns.until, name)
+		return
+	}
+
+	if ns.until == nil {
+		ns.until = make(map[string]time.Time)
+	}
+	ns.until[name] = until
+}
+
+// enabled reports whether the target named name is traced.
+func (ns *nisTraceSet) enabled(name string) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	until, ok := ns.until[name]
+	if ok && !time.Now().Before(until) {
+		delete(ns.until, name)
+		return false
+	}
+
+	return ok
+}
+
+// nisTraceHandler returns the handler of the trace endpoint, or an error if it
+// is misconfigured.
+func nisTraceHandler(logger *slog.Logger, ts *targetSet) (http.Handler, error) {
+	if *adminTokenFile == "" {
+		return nil, errors.New("-admin.token-file must be set to enable the admin endpoints")
+	}
+
+	token, err := readToken("admin", *adminTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return postHandler(token, func(r *http.Request) error {
+		t, _, err := ts.find(r.URL.Query().Get("target"))
+		if err != nil {
+			return err
+		}
+
+		d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || d < 0 {
+			return &httpError{code: http.StatusBadRequest, err: errors.New(`the duration query parameter must be a non-negative duration, such as "10m", or "0" to stop tracing`)}
+		}
+
+		if d == 0 {
+			logger.Info("stopped tracing exchanges with apcupsd", "target", t.Name, "remote_addr", r.RemoteAddr)
+		} else {
+			logger.Info("started tracing exchanges with apcupsd", "target", t.Name, "duration", d, "remote_addr", r.RemoteAddr)
+		}
+
+		ts.nisTraces.set(t.Name, time.Now().Add(d))
+		return nil
+	}), nil
+}
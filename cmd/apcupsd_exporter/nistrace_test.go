@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNISTrace(t *testing.T) {
+	defer func(c, f string) { *configFile, *adminTokenFile = c, f }(*configFile, *adminTokenFile)
+	defer func(d time.Duration) { *logTraceInterval = d }(*logTraceInterval)
+
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	dir := t.TempDir()
+	*configFile = filepath.Join(dir, "config.yml")
+	*adminTokenFile = filepath.Join(dir, "token")
+	*logTraceInterval = 0
+	for path, b := range map[string]string{
+		*configFile:     fmt.Sprintf("targets: [{name: ups1, address: %s}]", s.Addr()),
+		*adminTokenFile: "secret\n",
+	} {
+		if err := os.WriteFile(path, []byte(b), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(&levelHandler{
+		h:     slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: levelTrace, ReplaceAttr: replaceLevel}),
+		level: slog.LevelInfo,
+	})
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	h, err := nisTraceHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), ts)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	// traced collects from the target, and reports whether the exchange was
+	// logged.
+	traced := func() bool {
+		t.Helper()
+
+		buf.Reset()
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+
+		return strings.Contains(buf.String(), `level=TRACE msg="NIS exchange" target=ups1`)
+	}
+
+	post := func(query, auth string) int {
+		r := httptest.NewRequest(http.MethodPost, nisTracePath+"?"+query, nil)
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if traced() {
+		t.Fatal("exchange was logged before tracing started")
+	}
+
+	tests := []struct {
+		desc, query, auth string
+		code              int
+	}{
+		{desc: "wrong token", query: "target=ups1&duration=10m", auth: "Bearer wrong", code: http.StatusUnauthorized},
+		{desc: "unknown target", query: "target=ups9&duration=10m", auth: "Bearer secret", code: http.StatusNotFound},
+		{desc: "no duration", query: "target=ups1", auth: "Bearer secret", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := post(tt.query, tt.auth); code != tt.code {
+			t.Fatalf("%s: unexpected status code: want %d, got %d", tt.desc, tt.code, code)
+		}
+	}
+
+	if code := post("target=ups1&duration=10m", "Bearer secret"); code != http.StatusOK {
+		t.Fatalf("failed to start tracing: %d", code)
+	}
+	if !traced() {
+		t.Fatalf("exchange was not logged:\n%s", buf.String())
+	}
+
+	// Tracing set at runtime outlives reloads.
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if !traced() {
+		t.Fatalf("exchange was not logged after reload:\n%s", buf.String())
+	}
+
+	if code := post("target=ups1&duration=0", "Bearer secret"); code != http.StatusOK {
+		t.Fatalf("failed to stop tracing: %d", code)
+	}
+	if traced() {
+		t.Fatal("exchange was logged after tracing stopped")
+	}
+}
+
+func TestNISTraceSetExpires(t *testing.T) {
+	var ns nisTraceSet
+
+	ns.set("ups1", time.Now().Add(-time.Second))
+	if ns.enabled("ups1") {
+		t.Fatal("target is traced after tracing ended")
+	}
+
+	ns.until = map[string]time.Time{"ups1": time.Now().Add(-time.Second)}
+	if ns.enabled("ups1") || len(ns.until) != 0 {
+		t.Fatalf("expired tracing was not discarded: %v", ns.until)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// openAPIPath is the URL path of the OpenAPI specification of the HTTP APIs.
+const openAPIPath = "/api/openapi.json"
+
+// An openAPIOperation is an operation of an OpenAPI specification.
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+}
+
+// An openAPIParameter is a query parameter of an operation.
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      map[string]any `json:"schema"`
+}
+
+// An openAPIResponse is a response of an operation, keyed by status code.
+type openAPIResponse struct {
+	Description string                    `json:"description"`
+	Content     map[string]map[string]any `json:"content,omitempty"`
+}
+
+// content returns the content of a response of type typ with an optional
+// schema.
+func content(typ string, schema map[string]any) map[string]map[string]any {
+	if schema == nil {
+		schema = map[string]any{"type": "string"}
+	}
+
+	return map[string]map[string]any{typ: {"schema": schema}}
+}
+
+// Common parameters and responses of the operations.
+var (
+	targetParameter = openAPIParameter{
+		Name:        "target",
+		In:          "query",
+		Description: "Name of the target, which may be omitted if there is only one.",
+		Schema:      map[string]any{"type": "string"},
+	}
+
+	targetErrors = map[string]openAPIResponse{
+		"400": {Description: "The target was omitted, but there are several.", Content: content("text/plain", nil)},
+		"404": {Description: "There is no target of that name.", Content: content("text/plain", nil)},
+	}
+
+	bearerSecurity = []map[string][]string{{"bearer": {}}}
+)
+
+// withResponses returns the responses of rs merged with those of more.
+func withResponses(rs map[string]openAPIResponse, more ...map[string]openAPIResponse) map[string]openAPIResponse {
+	out := make(map[string]openAPIResponse)
+	for _, m := range append([]map[string]openAPIResponse{rs}, more...) {
+		for code, r := range m {
+			out[code] = r
+		}
+	}
+
+	return out
+}
+
+// An openAPIEndpoints describes which optional endpoints are enabled.
+type openAPIEndpoints struct {
+	history, lifecycle, selftest, maintenance, nisTrace, probe bool
+}
+
+// openAPISpec returns the OpenAPI specification of the HTTP endpoints enabled
+// by e, keyed by path and method.
+func openAPISpec(e openAPIEndpoints) map[string]any {
+	paths := map[string]map[string]openAPIOperation{
+		*metricsPath: {"get": {
+			Summary:   "Prometheus metrics of each target.",
+			Responses: map[string]openAPIResponse{"200": {Description: "Metrics in the Prometheus text exposition format.", Content: content("text/plain", nil)}},
+		}},
+		healthyPath: {"get": {
+			Summary:   "Reports that the exporter is running.",
+			Responses: map[string]openAPIResponse{"200": {Description: "The exporter is running.", Content: content("text/plain", nil)}},
+		}},
+		readyPath: {"get": {
+			Summary: "Reports whether the exporter is ready to serve metrics.",
+			Responses: map[string]openAPIResponse{
+				"200": {Description: "The exporter is ready.", Content: content("text/plain", nil)},
+				"503": {Description: "The exporter is waiting for the first successful collection.", Content: content("text/plain", nil)},
+			},
+		}},
+		statusPath: {"get": {
+			Summary:   "HTML page summarizing the health and key values of each target.",
+			Responses: map[string]openAPIResponse{"200": {Description: "The status page.", Content: content("text/html", nil)}},
+		}},
+		configPath: {"get": {
+			Summary:     "The effective configuration.",
+			Description: "The values of all flags and the configured targets, as YAML. Credentials are never shown.",
+			Responses:   map[string]openAPIResponse{"200": {Description: "The configuration.", Content: content("text/plain", nil)}},
+		}},
+		snapshotPath: {"get": {
+			Summary:    "The parsed state of a target.",
+			Parameters: []openAPIParameter{targetParameter},
+			Responses: withResponses(map[string]openAPIResponse{
+				"200": {Description: "The state of the target.", Content: content("application/json", map[string]any{"$ref": "#/components/schemas/Snapshot"})},
+			}, targetErrors),
+		}},
+		statusAPIPath: {"get": {
+			Summary:     "The health and key values of each target.",
+			Description: "Encoded as protobuf, as defined by the StatusResponse message of status.proto, if the client accepts " + protobufContentType + ".",
+			Responses: map[string]openAPIResponse{"200": {
+				Description: "The targets.",
+				Content: map[string]map[string]any{
+					"application/json":  {"schema": map[string]any{"$ref": "#/components/schemas/StatusResponse"}},
+					protobufContentType: {"schema": map[string]any{"type": "string", "format": "binary"}},
+				},
+			}},
+		}},
+		inventoryPath: {"get": {
+			Summary:     "The hardware inventory of each target.",
+			Description: "The model, serial number, firmware, battery date, nominal power, and groups of each UPS, from its last successful collection.",
+			Parameters: []openAPIParameter{
+				{Name: "format", In: "query", Description: "Format of the inventory.", Schema: map[string]any{"type": "string", "enum": []string{"json", "csv"}, "default": "json"}},
+			},
+			Responses: map[string]openAPIResponse{
+				"200": {
+					Description: "The inventory.",
+					Content: map[string]map[string]any{
+						"application/json": {"schema": map[string]any{"$ref": "#/components/schemas/InventoryResponse"}},
+						"text/csv":         {"schema": map[string]any{"type": "string"}},
+					},
+				},
+				"400": {Description: "The format is invalid.", Content: content("text/plain", nil)},
+			},
+		}},
+		openAPIPath: {"get": {
+			Summary:   "This OpenAPI specification.",
+			Responses: map[string]openAPIResponse{"200": {Description: "The specification.", Content: content("application/json", map[string]any{"type": "object"})}},
+		}},
+	}
+
+	if e.history {
+		paths[historyCSVPath] = map[string]openAPIOperation{"get": {
+			Summary:     "The stored history of a target, as CSV.",
+			Description: "Without a resolution, each stored sample; with one, the minimum, maximum, and average of the values within each interval.",
+			Parameters: []openAPIParameter{
+				targetParameter,
+				{Name: "from", In: "query", Description: "Earliest time of the history, as an RFC 3339 time or a date.", Schema: map[string]any{"type": "string"}},
+				{Name: "to", In: "query", Description: "Latest time of the history, as an RFC 3339 time or a date, by default now.", Schema: map[string]any{"type": "string"}},
+				{Name: "resolution", In: "query", Description: "Resolution of the history.", Schema: map[string]any{"type": "string", "enum": []string{"raw", "1m", "1h"}, "default": "raw"}},
+			},
+			Responses: withResponses(map[string]openAPIResponse{
+				"200": {Description: "The history.", Content: content("text/csv", nil)},
+			}, targetErrors),
+		}}
+	}
+
+	postResponses := map[string]openAPIResponse{
+		"200": {Description: "The request succeeded.", Content: content("text/plain", nil)},
+		"401": {Description: "The bearer token is missing or wrong.", Content: content("text/plain", nil)},
+		"405": {Description: "The request did not use POST.", Content: content("text/plain", nil)},
+		"500": {Description: "The request failed.", Content: content("text/plain", nil)},
+	}
+	if e.lifecycle {
+		paths[reloadPath] = map[string]openAPIOperation{"post": {
+			Summary:   "Reloads the configuration file.",
+			Security:  bearerSecurity,
+			Responses: postResponses,
+		}}
+		paths[quitPath] = map[string]openAPIOperation{"post": {
+			Summary:   "Shuts down the exporter.",
+			Security:  bearerSecurity,
+			Responses: postResponses,
+		}}
+		levelResponse := openAPIResponse{Description: "The current log level.", Content: content("text/plain", nil)}
+		paths[logLevelPath] = map[string]openAPIOperation{
+			"get": {
+				Summary:   "Returns the log level.",
+				Responses: map[string]openAPIResponse{"200": levelResponse},
+			},
+			"put": {
+				Summary: "Changes the log level until the exporter restarts.",
+				Parameters: []openAPIParameter{
+					{Name: "level", In: "query", Required: true, Description: `One of "trace", "debug", "info", "warn", or "error".`, Schema: map[string]any{"type": "string"}},
+				},
+				Security: bearerSecurity,
+				Responses: map[string]openAPIResponse{
+					"200": levelResponse,
+					"400": {Description: "The level is invalid.", Content: content("text/plain", nil)},
+					"401": postResponses["401"],
+				},
+			},
+		}
+	}
+	if e.selftest {
+		paths[selftestPath] = map[string]openAPIOperation{"post": {
+			Summary:    "Starts a self test of the UPS of a target.",
+			Parameters: []openAPIParameter{targetParameter},
+			Security:   bearerSecurity,
+			Responses: withResponses(postResponses, targetErrors, map[string]openAPIResponse{
+				"409": {Description: "A self test is already being started.", Content: content("text/plain", nil)},
+			}),
+		}}
+	}
+
+	if e.maintenance {
+		paths[maintenancePath] = map[string]openAPIOperation{"post": {
+			Summary:     "Places a target in maintenance for a duration.",
+			Description: "While a target is in maintenance, apcupsd_maintenance_mode reports 1 for it.",
+			Parameters: []openAPIParameter{
+				targetParameter,
+				{Name: "duration", In: "query", Required: true, Description: `Duration of the maintenance from now, such as "2h", or "0" to end it.`, Schema: map[string]any{"type": "string"}},
+				{Name: "reason", In: "query", Description: "Reason for the maintenance, which is logged.", Schema: map[string]any{"type": "string"}},
+			},
+			Security:  bearerSecurity,
+			Responses: withResponses(postResponses, targetErrors),
+		}}
+	}
+
+	if e.nisTrace {
+		paths[nisTracePath] = map[string]openAPIOperation{"post": {
+			Summary:     "Logs the exchanges with a target at the trace level for a duration.",
+			Description: "Each exchange with apcupsd is logged in hex and as text, subject to -log.trace-max-bytes and -log.trace-interval, regardless of -log.level.",
+			Parameters: []openAPIParameter{
+				targetParameter,
+				{Name: "duration", In: "query", Required: true, Description: `Duration of the tracing from now, such as "10m", or "0" to stop it.`, Schema: map[string]any{"type": "string"}},
+			},
+			Security:  bearerSecurity,
+			Responses: withResponses(postResponses, targetErrors),
+		}}
+	}
+
+	if e.probe {
+		paths[probePath] = map[string]openAPIOperation{"get": {
+			Summary:     "Prometheus metrics of the apcupsd passed by the request.",
+			Description: "The target is collected with the options of the configuration and the apcupsd flags, but keeps no state between probes.",
+			Parameters: []openAPIParameter{
+				{Name: "target", In: "query", Required: true, Description: `Address of apcupsd, such as "ups1.example.com:3551".`, Schema: map[string]any{"type": "string"}},
+			},
+			Responses: map[string]openAPIResponse{
+				"200": {Description: "Metrics in the Prometheus text exposition format.", Content: content("text/plain", nil)},
+				"400": {Description: "The target is missing or invalid.", Content: content("text/plain", nil)},
+			},
+		}}
+	}
+
+	for p, ops := range paths {
+		if _, ok := apiDeprecations[p]; !ok {
+			continue
+		}
+		for m, op := range ops {
+			op.Deprecated = true
+			ops[m] = op
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "apcupsd_exporter",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]any{
+				"Snapshot":          snapshotSchema,
+				"StatusResponse":    statusResponseSchema,
+				"InventoryResponse": inventoryResponseSchema,
+			},
+		},
+	}
+}
+
+// snapshotSchema is the schema of a snapshot.
+var snapshotSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"target":          map[string]any{"type": "string"},
+		"address":         map[string]any{"type": "string"},
+		"collected_at":    map[string]any{"type": "string", "format": "date-time", "nullable": true},
+		"last_success_at": map[string]any{"type": "string", "format": "date-time", "nullable": true},
+		"error":           map[string]any{"type": "string"},
+		"stale":           map[string]any{"type": "boolean"},
+		"age_seconds":     map[string]any{"type": "number"},
+		"parse_errors":    map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+		"status": map[string]any{
+			"type":        "object",
+			"nullable":    true,
+			"description": "The status reported by apcupsd, keyed by the fields of the Status type of github.com/mdlayher/apcupsd.",
+		},
+	},
+	"required": []string{"target", "address", "collected_at", "last_success_at", "stale", "status"},
+}
+
+// statusResponseSchema is the schema of the response of the status API.
+var statusResponseSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"targets": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"target":                    map[string]any{"type": "string"},
+					"address":                   map[string]any{"type": "string"},
+					"health":                    map[string]any{"type": "string", "enum": []string{"pending", "up", "stale", "down"}},
+					"collected_at":              map[string]any{"type": "string", "format": "date-time", "nullable": true},
+					"last_success_at":           map[string]any{"type": "string", "format": "date-time", "nullable": true},
+					"error":                     map[string]any{"type": "string"},
+					"model":                     map[string]any{"type": "string"},
+					"status":                    map[string]any{"type": "string"},
+					"line_volts":                map[string]any{"type": "number"},
+					"load_percent":              map[string]any{"type": "number"},
+					"battery_charge_percent":    map[string]any{"type": "number"},
+					"battery_time_left_seconds": map[string]any{"type": "number"},
+				},
+			},
+		},
+	},
+	"required": []string{"targets"},
+}
+
+// inventoryResponseSchema is the schema of the response of the inventory API.
+var inventoryResponseSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"targets": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"target":              map[string]any{"type": "string"},
+					"address":             map[string]any{"type": "string"},
+					"groups":              map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+					"last_success_at":     map[string]any{"type": "string", "format": "date-time", "nullable": true},
+					"model":               map[string]any{"type": "string"},
+					"serial_number":       map[string]any{"type": "string"},
+					"firmware":            map[string]any{"type": "string"},
+					"battery_date":        map[string]any{"type": "string"},
+					"nominal_power_watts": map[string]any{"type": "integer"},
+				},
+			},
+		},
+	},
+	"required": []string{"targets"},
+}
+
+// openAPIHandler serves the OpenAPI specification of the endpoints enabled by
+// e, so that clients of the HTTP APIs can be generated.
+func openAPIHandler(e openAPIEndpoints) http.Handler {
+	spec := openAPISpec(e)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(spec)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	tests := []struct {
+		desc   string
+		e      openAPIEndpoints
+		paths  []string
+		absent []string
+	}{
+		{
+			desc:   "default",
+			paths:  []string{*metricsPath, snapshotPath, statusPath, openAPIPath},
+			absent: []string{historyCSVPath, reloadPath, selftestPath, probePath},
+		},
+		{
+			desc:  "all",
+			e:     openAPIEndpoints{history: true, lifecycle: true, selftest: true, probe: true},
+			paths: []string{historyCSVPath, reloadPath, quitPath, selftestPath, probePath},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			openAPIHandler(tt.e).ServeHTTP(w, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+
+			var spec struct {
+				OpenAPI string                     `json:"openapi"`
+				Paths   map[string]json.RawMessage `json:"paths"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+				t.Fatalf("failed to decode specification: %v", err)
+			}
+			if spec.OpenAPI == "" {
+				t.Fatal("missing OpenAPI version")
+			}
+
+			for _, p := range tt.paths {
+				if _, ok := spec.Paths[p]; !ok {
+					t.Fatalf("missing path %s", p)
+				}
+			}
+			for _, p := range tt.absent {
+				if _, ok := spec.Paths[p]; ok {
+					t.Fatalf("unexpected path %s", p)
+				}
+			}
+		})
+	}
+}
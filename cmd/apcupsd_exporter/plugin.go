@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	plugins       pluginFlags
+	pluginTimeout = flag.Duration("plugin.timeout", 5*time.Second, "deadline for each execution of an exec plugin")
+)
+
+func init() {
+	flag.Var(&plugins, "plugin.exec", `exec plugin which writes additional metrics to stdout, as "name=command [args...]" (may be repeated)`)
+}
+
+// A plugin is an external program whose output is merged into the metrics.
+type plugin struct {
+	name string
+	args []string
+}
+
+// pluginFlags is a flag.Value which accumulates exec plugins.
+type pluginFlags []plugin
+
+// String implements flag.Value.
+func (ps *pluginFlags) String() string {
+	ss := make([]string, 0, len(*ps))
+	for _, p := range *ps {
+		ss = append(ss, p.name+"="+strings.Join(p.args, " "))
+	}
+
+	return strings.Join(ss, ", ")
+}
+
+// names returns the name of each plugin.
+func (ps *pluginFlags) names() []string {
+	ns := make([]string, 0, len(*ps))
+	for _, p := range *ps {
+		ns = append(ns, p.name)
+	}
+
+	return ns
+}
+
+// Set implements flag.Value.
+func (ps *pluginFlags) Set(s string) error {
+	name, cmd, ok := strings.Cut(s, "=")
+	args := strings.Fields(cmd)
+	if !ok || name == "" || len(args) == 0 {
+		return fmt.Errorf("invalid plugin %q: must be of the form name=command [args...]", s)
+	}
+
+	for _, p := range *ps {
+		if p.name == name {
+			return fmt.Errorf("duplicate plugin %q", name)
+		}
+	}
+
+	*ps = append(*ps, plugin{name: name, args: args})
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pollInterval = flag.Duration("collector.poll-interval", 0, "collect metrics from apcupsd in the background at this interval, and serve the most recently collected metrics on each scrape; 0 collects metrics on each scrape instead")
+
+var _ prometheus.Collector = &poller{}
+
+// A poller is a prometheus.Collector which collects the metrics of another
+// collector in the background at an interval, and serves the most recently
+// collected metrics.
+type poller struct {
+	c prometheus.Collector
+
+	// interval returns the current interval, which may change between
+	// polls, such as when the configuration is reloaded.
+	interval func() time.Duration
+
+	mu     sync.RWMutex
+	ms     []prometheus.Metric
+	polled time.Time
+}
+
+// run polls at each interval, and never returns.
+func (p *poller) run() {
+	d := p.interval()
+	t := time.NewTicker(d)
+	defer t.Stop()
+
+	for {
+		p.poll()
+		<-t.C
+
+		if nd := p.interval(); nd != d {
+			d = nd
+			t.Reset(d)
+		}
+	}
+}
+
+// poll collects the metrics of the underlying collector, replacing those
+// previously collected.
+func (p *poller) poll() {
+	start := time.Now()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		p.c.Collect(ch)
+	}()
+
+	var ms []prometheus.Metric
+	for m := range ch {
+		ms = append(ms, m)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ms, p.polled = ms, start
+}
+
+// next returns the time of the next poll, or the zero time if none has been
+// made yet.
+func (p *poller) next() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.polled.IsZero() {
+		return time.Time{}
+	}
+
+	return p.polled.Add(p.interval())
+}
+
+// Describe implements prometheus.Collector.
+func (p *poller) Describe(ch chan<- *prometheus.Desc) {
+	p.c.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p *poller) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, m := range p.ms {
+		ch <- m
+	}
+}
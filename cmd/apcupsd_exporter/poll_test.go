@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTargetPollInterval(t *testing.T) {
+	var servers []*apcupsdtest.Server
+	for i := 0; i < 2; i++ {
+		s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+		if err != nil {
+			t.Fatalf("failed to create fake NIS: %v", err)
+		}
+		defer s.Close()
+
+		servers = append(servers, s)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+targets:
+  - name: metered
+    address: %s
+  - name: local
+    address: %s
+    poll_interval: 1ms
+`, servers[0].Addr(), servers[1].Addr())
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prevConfig, prevInterval := *configFile, *pollInterval
+	*configFile, *pollInterval = path, time.Hour
+	defer func() { *configFile, *pollInterval = prevConfig, prevInterval }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	if d := ts.pollInterval(); d != time.Millisecond {
+		t.Fatalf("unexpected poll interval: %s", d)
+	}
+
+	p := &poller{c: ts, interval: ts.pollInterval}
+	p.poll()
+
+	for _, s := range servers {
+		s.SetHandler(apcupsdtest.Error(errors.New("gone")))
+	}
+	time.Sleep(5 * time.Millisecond)
+	p.poll()
+
+	// Only the target whose interval elapsed is polled again.
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(p)
+
+	want := `
+# HELP apcupsd_up Whether the last collection of UPS metrics from apcupsd was successful.
+# TYPE apcupsd_up gauge
+apcupsd_up{target="local"} 0
+apcupsd_up{target="metered"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_up"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	*pollInterval = 0
+	if _, err := newConfig(); err == nil {
+		t.Fatal("expected an error with a target poll interval but no -collector.poll-interval")
+	}
+}
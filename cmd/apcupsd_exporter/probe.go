@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probePath is the URL path of the endpoint which collects the metrics of an
+// apcupsd target passed with each request, in the style of the
+// blackbox_exporter.
+const probePath = "/probe"
+
+var enableProbe = flag.Bool("web.enable-probe", false, "enable the HTTP endpoint "+probePath+"?target=host:port, which collects the metrics of the apcupsd passed by each scrape, so that targets can be managed by Prometheus relabeling; any client allowed by -web.allow-cidr can make the exporter dial any address")
+
+var probeDurationDesc = prometheus.NewDesc(
+	"apcupsd_probe_duration_seconds",
+	"Number of seconds taken to collect the metrics of the probed target.",
+	nil, nil,
+)
+
+// probeHandler returns the handler of the probe endpoint.  Each probe creates
+// the collector of its target anew, with the options of the current
+// configuration and the apcupsd flags, so that no state is kept between
+// probes and the targets of the configuration are unaffected.
+func probeHandler(logger *slog.Logger, ts *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := r.URL.Query().Get("target")
+		if addr == "" {
+			http.Error(w, "the target query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		// Validate the target on its own, so that the current configuration
+		// is shared but left unmodified.
+		pc := &config{Targets: []targetConfig{{
+			Address:  addr,
+			Network:  *apcupsdNetwork,
+			Hostname: *apcupsdHostname,
+		}}}
+		if err := pc.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resolveNames(logger, pc)
+
+		cfg := *ts.config()
+		cfg.Targets = pc.Targets
+
+		// A target set without storage or history, so that probed targets
+		// are not recorded.  Probes are traced only as part of the trace of
+		// their caller, and independently of each other, as Prometheus
+		// makes many at once.
+		pts := &targetSet{logger: logger}
+		if p, ok := parseTraceParent(r.Header.Get("traceparent")); ok && ts.tracer != nil {
+			pts.tracer, pts.traceParent = ts.tracer, &p
+		}
+		tgt := pts.target(cfg.Targets[0], &cfg)
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(probeCollector{c: tgt.c})
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// A probeCollector collects the metrics of c, followed by the duration of
+// their collection.  Like a targetSet, it is an unchecked collector, because
+// the metrics of c may bear target labels which its descriptors lack.
+type probeCollector struct {
+	c prometheus.Collector
+}
+
+// Describe implements prometheus.Collector.  It sends no descriptors.
+func (pc probeCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (pc probeCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	pc.c.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+}
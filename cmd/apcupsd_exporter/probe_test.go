@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestProbeHandler(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	defer func(a string) { *apcupsdAddr = a }(*apcupsdAddr)
+	*apcupsdAddr = "127.0.0.1:1"
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	h := probeHandler(logger, ts)
+
+	probe := func(target string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, probePath+"?target="+url.QueryEscape(target), nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := probe(s.Addr().String())
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d: %s", code, body)
+	}
+	for _, want := range []string{
+		"apcupsd_up 1\n",
+		`apcupsd_battery_number_transfers_total{hostname="apcupsd",model="Back-UPS RS 1500G",ups_name="ups"} 2`,
+		"apcupsd_probe_duration_seconds ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("probe lacks %q:\n%s", want, body)
+		}
+	}
+
+	// Unreachable targets are reported by apcupsd_up, as on the metrics
+	// endpoint, rather than failing the probe.
+	if code, body := probe("127.0.0.1:2"); code != http.StatusOK || !strings.Contains(body, "apcupsd_up 0\n") {
+		t.Fatalf("unexpected probe of an unreachable target: %d: %s", code, body)
+	}
+
+	if code, _ := probe(""); code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code without a target: %d", code)
+	}
+}
+
+func TestProbeHandlerTraceParent(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prevEndpoint, prevSample := *tracingEndpoint, *tracingSample
+	*tracingEndpoint, *tracingSample = "http://localhost:4318", 1
+	defer func() { *tracingEndpoint, *tracingSample = prevEndpoint, prevSample }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	ts.tracer, err = newTracer(logger)
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+	h := probeHandler(logger, ts)
+
+	probe := func(traceparent string) []span {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, probePath+"?target="+url.QueryEscape(s.Addr().String()), nil)
+		if traceparent != "" {
+			r.Header.Set("traceparent", traceparent)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", w.Code)
+		}
+
+		spans := ts.tracer.spans
+		ts.tracer.spans = nil
+		return spans
+	}
+
+	// Probes are only traced as part of the trace of their caller, however
+	// the exporter samples its own traces.
+	if spans := probe(""); len(spans) != 0 {
+		t.Fatalf("unexpected spans of a probe without a caller: %d", len(spans))
+	}
+
+	spans := probe("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if len(spans) == 0 {
+		t.Fatal("probe sampled by its caller was not traced")
+	}
+	for _, sp := range spans {
+		if got := hex.EncodeToString(sp.traceID[:]); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("span %q is not part of the trace of the caller: %s", sp.name, got)
+		}
+	}
+	if got := hex.EncodeToString(spans[0].parentID[:]); got != "00f067aa0ba902b7" {
+		t.Fatalf("span %q is not a child of the span of the caller: %s", spans[0].name, got)
+	}
+}
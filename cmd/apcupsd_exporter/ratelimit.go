@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	rateLimit            = flag.Float64("web.rate-limit", 0, "maximum number of HTTP requests per second served to all clients; 0 disables the limit")
+	rateLimitBurst       = flag.Int("web.rate-limit-burst", 10, "number of HTTP requests allowed to exceed -web.rate-limit in a burst")
+	clientRateLimit      = flag.Float64("web.client-rate-limit", 0, "maximum number of HTTP requests per second served to each client IP address; 0 disables the limit")
+	clientRateLimitBurst = flag.Int("web.client-rate-limit-burst", 5, "number of HTTP requests allowed to exceed -web.client-rate-limit in a burst")
+)
+
+// clientIdle is the time after which the rate limiter of an idle client is
+// discarded.
+const clientIdle = 5 * time.Minute
+
+// A rateLimiter limits the rate of requests globally and per client.
+type rateLimiter struct {
+	global *rate.Limiter
+
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[netip.Addr]*clientLimiter
+	lastSweep time.Time
+}
+
+// A clientLimiter is the rate limiter of a single client.
+type clientLimiter struct {
+	l    *rate.Limiter
+	last time.Time
+}
+
+// withRateLimit wraps h with a handler which responds with HTTP 429 when
+// requests exceed the limits set by the rate limit flags, if any.
+func withRateLimit(h http.Handler) http.Handler {
+	if *rateLimit <= 0 && *clientRateLimit <= 0 {
+		return h
+	}
+
+	rl := &rateLimiter{
+		limit:     rate.Limit(*clientRateLimit),
+		burst:     *clientRateLimitBurst,
+		clients:   make(map[netip.Addr]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+	if *rateLimit > 0 {
+		rl.global = rate.NewLimiter(rate.Limit(*rateLimit), *rateLimitBurst)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.allow(r) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allow reports whether r is within the rate limits.
+func (rl *rateLimiter) allow(r *http.Request) bool {
+	if rl.limit > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		// Requests from unparseable addresses share a single limiter.
+		addr, _ := netip.ParseAddr(host)
+		if !rl.client(addr.Unmap()).Allow() {
+			return false
+		}
+	}
+
+	return rl.global == nil || rl.global.Allow()
+}
+
+// client returns the rate limiter for addr, discarding those of idle clients.
+func (rl *rateLimiter) client(addr netip.Addr) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > clientIdle {
+		for a, c := range rl.clients {
+			if now.Sub(c.last) > clientIdle {
+				delete(rl.clients, a)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, ok := rl.clients[addr]
+	if !ok {
+		c = &clientLimiter{l: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[addr] = c
+	}
+	c.last = now
+
+	return c.l
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	defer func(g float64, gb int, c float64, cb int) {
+		*rateLimit, *rateLimitBurst, *clientRateLimit, *clientRateLimitBurst = g, gb, c, cb
+	}(*rateLimit, *rateLimitBurst, *clientRateLimit, *clientRateLimitBurst)
+
+	// The limits replenish so slowly that only the bursts are served.
+	tests := []struct {
+		desc                     string
+		global, client           float64
+		globalBurst, clientBurst int
+		remotes                  []string
+		want                     []int
+	}{
+		{
+			desc:        "global",
+			global:      0.001,
+			globalBurst: 2,
+			remotes:     []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"},
+			want:        []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			desc:        "client",
+			client:      0.001,
+			clientBurst: 1,
+			remotes:     []string{"192.0.2.1:1", "192.0.2.1:2", "192.0.2.2:1", "[::ffff:192.0.2.2]:1"},
+			want:        []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			desc:        "both",
+			global:      0.001,
+			globalBurst: 2,
+			client:      0.001,
+			clientBurst: 1,
+			remotes:     []string{"192.0.2.1:1", "192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"},
+			want:        []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			desc:    "disabled",
+			remotes: []string{"192.0.2.1:1", "192.0.2.1:1", "192.0.2.1:1"},
+			want:    []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			*rateLimit, *rateLimitBurst = tt.global, tt.globalBurst
+			*clientRateLimit, *clientRateLimitBurst = tt.client, tt.clientBurst
+
+			h := withRateLimit(http.HandlerFunc(healthy))
+			for i, remote := range tt.remotes {
+				r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				r.RemoteAddr = remote
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+
+				if w.Code != tt.want[i] {
+					t.Fatalf("request %d from %s: unexpected status code: want %d, got %d", i, remote, tt.want[i], w.Code)
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+					t.Fatalf("request %d from %s: missing Retry-After header", i, remote)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterDiscardsIdleClients(t *testing.T) {
+	rl := &rateLimiter{
+		limit:     rate.Limit(1),
+		burst:     1,
+		clients:   make(map[netip.Addr]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+
+	idle, active := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	rl.client(idle)
+	rl.client(active)
+
+	// Age the idle client and the last sweep beyond clientIdle.
+	rl.clients[idle].last = time.Now().Add(-2 * clientIdle)
+	rl.lastSweep = time.Now().Add(-2 * clientIdle)
+	rl.client(active)
+
+	if _, ok := rl.clients[idle]; ok {
+		t.Fatal("idle client was not discarded")
+	}
+	if _, ok := rl.clients[active]; !ok {
+		t.Fatal("active client was discarded")
+	}
+}
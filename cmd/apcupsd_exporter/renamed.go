@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var renamedMetrics = flag.Bool("compat.renamed-metrics", false, "also export the metrics renamed in recent releases under their previous names, so that recording rules and dashboards can be migrated gradually")
+
+// A metricRename describes a metric renamed in a release of the exporter.
+type metricRename struct {
+	Old, New string
+	Release  string
+}
+
+// metricRenames are the metrics renamed in recent releases.  A rename is
+// removed from the list, and the previous name is no longer exported, two
+// releases after the one in which the metric was renamed.
+var metricRenames = []metricRename{}
+
+var _ prometheus.Gatherer = renamedGatherer{}
+
+// A renamedGatherer is a prometheus.Gatherer which also exports the metric
+// families of renames under their previous names.
+type renamedGatherer struct {
+	g       prometheus.Gatherer
+	renames []metricRename
+}
+
+// Gather implements prometheus.Gatherer.
+func (g renamedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.g.Gather()
+
+	byName := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		byName[mf.GetName()] = mf
+	}
+
+	var added bool
+	for _, r := range g.renames {
+		mf, ok := byName[r.New]
+		if !ok || byName[r.Old] != nil {
+			continue
+		}
+
+		// The metrics are shared with the family of the new name, and must
+		// not be modified.
+		old, help := r.Old, fmt.Sprintf("Deprecated: renamed to %s in release %s. %s", r.New, r.Release, mf.GetHelp())
+		mfs = append(mfs, &dto.MetricFamily{
+			Name:   &old,
+			Help:   &help,
+			Type:   mf.Type,
+			Metric: mf.Metric,
+		})
+		byName[old] = mfs[len(mfs)-1]
+		added = true
+	}
+	if added {
+		sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	}
+
+	return mfs, err
+}
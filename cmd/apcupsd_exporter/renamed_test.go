@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRenamedGatherer(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "apcupsd_battery_charge_ratio",
+		Help: "Current battery charge ratio.",
+	}, []string{"ups_name"})
+	g.WithLabelValues("bar").Set(0.5)
+	reg.MustRegister(g)
+
+	rg := renamedGatherer{g: reg, renames: []metricRename{
+		{Old: "apcupsd_battery_charge_fraction", New: "apcupsd_battery_charge_ratio", Release: "v1.2.0"},
+		// Renames of metrics which are not exported are ignored.
+		{Old: "apcupsd_foo", New: "apcupsd_bar", Release: "v1.2.0"},
+	}}
+
+	want := `
+# HELP apcupsd_battery_charge_fraction Deprecated: renamed to apcupsd_battery_charge_ratio in release v1.2.0. Current battery charge ratio.
+# TYPE apcupsd_battery_charge_fraction gauge
+apcupsd_battery_charge_fraction{ups_name="bar"} 0.5
+# HELP apcupsd_battery_charge_ratio Current battery charge ratio.
+# TYPE apcupsd_battery_charge_ratio gauge
+apcupsd_battery_charge_ratio{ups_name="bar"} 0.5
+`
+	if err := testutil.GatherAndCompare(rg, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
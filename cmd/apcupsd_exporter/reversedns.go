@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	reverseDNSLabel   = flag.String("collector.reverse-dns-label", "", `name of a label, such as "host", set on the metrics of each target addressed by IP to the host name its IP address resolves to, when the configuration is loaded (default: no label)`)
+	reverseDNSTimeout = flag.Duration("collector.reverse-dns-timeout", 2*time.Second, "deadline for the reverse DNS lookup of each target by -collector.reverse-dns-label")
+)
+
+// lookupAddr returns the names which an IP address resolves to.  It is a
+// variable so that tests can replace it.
+var lookupAddr = net.DefaultResolver.LookupAddr
+
+// resolveNames sets the reverse DNS names of the targets of cfg, if enabled
+// by -collector.reverse-dns-label.  The targets are resolved concurrently, so
+// that slow lookups do not delay loading a large configuration.
+func resolveNames(logger *slog.Logger, cfg *config) {
+	if *reverseDNSLabel == "" {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range cfg.Targets {
+		wg.Add(1)
+		go func(t *targetConfig) {
+			defer wg.Done()
+			t.reverseName = reverseName(logger, t.Address)
+		}(&cfg.Targets[i])
+	}
+	wg.Wait()
+}
+
+// reverseName returns the host name which the IP address of addr resolves to.
+// If the host of addr is a name already, or it cannot be resolved, the host
+// is returned as is.
+func reverseName(logger *slog.Logger, addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if net.ParseIP(host) == nil {
+		return host
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *reverseDNSTimeout)
+	defer cancel()
+
+	names, err := lookupAddr(ctx, host)
+	if err != nil || len(names) == 0 {
+		logger.Warn("failed to resolve the host name of target", "addr", addr, "err", err)
+		return host
+	}
+
+	return strings.TrimSuffix(names[0], ".")
+}
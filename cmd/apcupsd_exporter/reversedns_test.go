@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReverseDNSLabel(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	defer func(addr, label string, lookup func(context.Context, string) ([]string, error)) {
+		*apcupsdAddr, *reverseDNSLabel, lookupAddr = addr, label, lookup
+	}(*apcupsdAddr, *reverseDNSLabel, lookupAddr)
+	*apcupsdAddr = s.Addr().String()
+	*reverseDNSLabel = "host"
+
+	lookupAddr = func(_ context.Context, addr string) ([]string, error) {
+		if addr != "127.0.0.1" {
+			return nil, fmt.Errorf("unexpected address %q", addr)
+		}
+
+		return []string{"ups1.example.com."}, nil
+	}
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := "# HELP apcupsd_up Whether the last collection of UPS metrics from apcupsd was successful.\n# TYPE apcupsd_up gauge\napcupsd_up{host=\"ups1.example.com\"} 1\n"
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_up"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	// Failed lookups fall back to the address.
+	lookupAddr = func(context.Context, string) ([]string, error) { return nil, errors.New("no such host") }
+	if got := reverseName(slog.New(slog.NewTextHandler(io.Discard, nil)), "192.0.2.1:3551"); got != "192.0.2.1" {
+		t.Fatalf("unexpected name after a failed lookup: %q", got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// notifyService does nothing, as only Windows services are supported.
+func notifyService(chan<- os.Signal) error {
+	return nil
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// notifyService reports the exporter as running to the Windows service
+// control manager when started as a Windows service, and relays requests to
+// stop the service to c as SIGTERM.
+func notifyService(c chan<- os.Signal) error {
+	ok, err := svc.IsWindowsService()
+	if err != nil || !ok {
+		return err
+	}
+
+	go func() {
+		// The name is ignored for services running in their own process.
+		_ = svc.Run("apcupsd_exporter", serviceHandler(c))
+	}()
+
+	return nil
+}
+
+// A serviceHandler is a svc.Handler which relays stop requests to a channel.
+type serviceHandler chan<- os.Signal
+
+var _ svc.Handler = serviceHandler(nil)
+
+// Execute implements svc.Handler.
+func (h serviceHandler) Execute(_ []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for req := range r {
+		switch req.Cmd {
+		case svc.Interrogate:
+			s <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			s <- svc.Status{State: svc.StopPending}
+
+			select {
+			case h <- syscall.SIGTERM:
+			default:
+				// Already shutting down.
+			}
+			return false, 0
+		}
+	}
+
+	return false, 0
+}
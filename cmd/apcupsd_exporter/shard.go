@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+var (
+	shardIndex = flag.Int("shard.index", 0, "index of the shard of the targets of -config.file collected by this exporter, from 0 to -shard.total minus 1")
+	shardTotal = flag.Int("shard.total", 1, "number of exporters among which the targets of -config.file are split, each collecting a distinct shard set by -shard.index")
+)
+
+// shard removes the targets of c which belong to shards other than index of
+// total.  Targets are assigned to shards by rendezvous hashing of their names,
+// so that every exporter sharing a configuration file assigns them alike, and
+// changing the number of shards only moves the targets of the shards added or
+// removed.
+func (c *config) shard(index, total int) error {
+	if total < 1 || index < 0 || index >= total {
+		return fmt.Errorf("invalid shard %d of %d", index, total)
+	}
+	if total == 1 {
+		return nil
+	}
+
+	targets := c.Targets[:0]
+	for _, t := range c.Targets {
+		if targetShard(t.Name, total) == index {
+			targets = append(targets, t)
+		}
+	}
+	c.Targets = targets
+
+	return nil
+}
+
+// targetShard returns the shard of the target named name among total: the
+// one with the highest hash of the name and the shard.
+func targetShard(name string, total int) int {
+	var (
+		best  int
+		bestH uint64
+	)
+
+	for i := 0; i < total; i++ {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(strconv.Itoa(i)))
+
+		if v := h.Sum64(); i == 0 || v > bestH {
+			best, bestH = i, v
+		}
+	}
+
+	return best
+}
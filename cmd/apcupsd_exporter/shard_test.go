@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConfigShard(t *testing.T) {
+	var targets []targetConfig
+	for i := 0; i < 300; i++ {
+		targets = append(targets, targetConfig{Name: fmt.Sprintf("ups%d", i)})
+	}
+
+	// shards returns the shard of each target among total.
+	shards := func(total int) map[string]int {
+		m := make(map[string]int)
+		for i := 0; i < total; i++ {
+			c := &config{Targets: append([]targetConfig(nil), targets...)}
+			if err := c.shard(i, total); err != nil {
+				t.Fatalf("failed to shard: %v", err)
+			}
+			if len(c.Targets) < 50 {
+				t.Fatalf("shard %d of %d is unbalanced: %d targets", i, total, len(c.Targets))
+			}
+
+			for _, tc := range c.Targets {
+				if _, ok := m[tc.Name]; ok {
+					t.Fatalf("target %q is in several shards", tc.Name)
+				}
+				m[tc.Name] = i
+			}
+		}
+
+		if len(m) != len(targets) {
+			t.Fatalf("only %d of %d targets are in a shard", len(m), len(targets))
+		}
+		return m
+	}
+
+	// Adding a shard only moves targets to the new shard.
+	before, after := shards(3), shards(4)
+	for name, s := range after {
+		if s != 3 && s != before[name] {
+			t.Fatalf("target %q moved from shard %d to %d", name, before[name], s)
+		}
+	}
+
+	if err := (&config{}).shard(2, 2); err == nil {
+		t.Fatal("expected an error for an invalid shard")
+	}
+}
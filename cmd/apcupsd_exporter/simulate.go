@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+var (
+	simulate      = flag.String("test.simulate", "", `simulate a UPS following a scripted scenario instead of collecting from apcupsd, to validate dashboards, alerts, and notification routing without real hardware: `+scenarioNames())
+	simulateCycle = flag.Duration("test.simulate-cycle", 20*time.Minute, "duration of one cycle of the scenarios of simulated UPSes, which repeat")
+)
+
+// simulationStart is the start of the first cycle of the scenarios of
+// simulated UPSes, which continue across reloads of the configuration.
+var simulationStart = time.Now()
+
+// A scenario is a scripted behavior of a simulated UPS, which repeats in each
+// cycle.  Times within a cycle are fractions of it, from 0 to 1.
+type scenario struct {
+	// outages are the intervals of each cycle in which the UPS is on
+	// battery power, in order.
+	outages [][2]float64
+
+	// outageVolts is the line voltage during the outages, and reason is
+	// the reason reported for the transfers to battery.
+	outageVolts float64
+	reason      string
+
+	// line returns the status flags and line voltage at time f while the
+	// UPS is on line power.
+	line func(f float64) (status string, volts float64)
+}
+
+// scenarios are the scenarios of simulated UPSes, keyed by name.
+var scenarios = map[string]scenario{
+	// A healthy UPS with a slowly varying load.
+	"online": {
+		line: func(f float64) (string, float64) {
+			return "ONLINE", 230 + 2*math.Sin(2*math.Pi*f)
+		},
+	},
+
+	// A blackout which drains the battery until the UPS reports a low
+	// battery, followed by the return of utility power and a recharge.
+	"outage": {
+		outages: [][2]float64{{0.25, 0.5}},
+		reason:  "Low line voltage",
+		line: func(float64) (string, float64) {
+			return "ONLINE", 230
+		},
+	},
+
+	// A sagging line voltage, which the UPS first boosts and then briefly
+	// transfers to battery for.
+	"brownout": {
+		outages:     [][2]float64{{0.4, 0.43}},
+		outageVolts: 180,
+		reason:      "Low line voltage",
+		line: func(f float64) (string, float64) {
+			if f >= 0.3 && f < 0.6 {
+				return "ONLINE BOOST", 204
+			}
+			return "ONLINE", 230
+		},
+	},
+}
+
+// scenarioNames lists the names of the scenarios, for usage messages.
+func scenarioNames() string {
+	names := make([]string, 0, len(scenarios))
+	for n := range scenarios {
+		names = append(names, fmt.Sprintf("%q", n))
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// Rates of discharge and recharge of the battery of a simulated UPS, in
+// percent per cycle, its runtime at full charge and a load of 30%, and the
+// runtime left at which it reports a low battery.
+const (
+	simulatedDischargeRate = 360
+	simulatedRechargeRate  = 200
+	simulatedFullRuntime   = 40 * time.Minute
+	simulatedMinTimeLeft   = 5 * time.Minute
+)
+
+// A simulator is a simulated UPS following a scenario.
+type simulator struct {
+	sc    scenario
+	start time.Time
+	cycle time.Duration
+}
+
+// dialFunc returns a DialFunc which connects to a NIS serving the status of
+// the simulated UPS.
+func (sim *simulator) dialFunc() apcupsdexporter.DialFunc {
+	s := apcupsdtest.NewServer(func(cmd string) ([]string, error) {
+		if cmd != "status" {
+			return nil, nil
+		}
+
+		return sim.status(time.Now()), nil
+	})
+
+	return func(context.Context) (net.Conn, error) {
+		return s.PipeConn(), nil
+	}
+}
+
+// status returns the status lines of the simulated UPS at now.
+func (sim *simulator) status(now time.Time) []string {
+	elapsed := now.Sub(sim.start)
+	cycles := int(elapsed / sim.cycle)
+	cycleStart := sim.start.Add(time.Duration(cycles) * sim.cycle)
+	f := float64(now.Sub(cycleStart)) / float64(sim.cycle)
+	at := func(f float64) time.Time {
+		return cycleStart.Add(time.Duration(f * float64(sim.cycle)))
+	}
+
+	// Replay the outages of the cycle up to now, starting from a full
+	// battery.
+	var (
+		charge, last   = 100.0, 0.0
+		onBattery      bool
+		transfers      = cycles * len(sim.sc.outages)
+		onBatteryTotal time.Duration
+		xOn, xOff      time.Time
+	)
+	for _, o := range sim.sc.outages {
+		onBatteryTotal += time.Duration(float64(cycles) * (o[1] - o[0]) * float64(sim.cycle))
+	}
+	if cycles > 0 && len(sim.sc.outages) > 0 {
+		o := sim.sc.outages[len(sim.sc.outages)-1]
+		xOn, xOff = at(o[0]-1), at(o[1]-1)
+	}
+	for _, o := range sim.sc.outages {
+		if f < o[0] {
+			break
+		}
+		charge = math.Min(100, charge+(o[0]-last)*simulatedRechargeRate)
+
+		end := math.Min(f, o[1])
+		charge = math.Max(0, charge-(end-o[0])*simulatedDischargeRate)
+		transfers++
+		onBatteryTotal += time.Duration((end - o[0]) * float64(sim.cycle))
+		xOn, last = at(o[0]), end
+
+		if f < o[1] {
+			onBattery = true
+			break
+		}
+		xOff = at(o[1])
+	}
+	if !onBattery {
+		charge = math.Min(100, charge+(f-last)*simulatedRechargeRate)
+	}
+
+	load := 30 + 5*math.Sin(2*math.Pi*f)
+	timeLeft := time.Duration(charge / 100 * float64(simulatedFullRuntime) * 30 / load)
+
+	status, lineVolts := sim.sc.line(f)
+	lastXfer := "No transfers since turnon"
+	if transfers > 0 {
+		lastXfer = sim.sc.reason
+	}
+	var onBatterySince time.Duration
+	if onBattery {
+		status, lineVolts = "ONBATT", sim.sc.outageVolts
+		if timeLeft < simulatedMinTimeLeft {
+			status += " LOWBATT"
+		}
+		onBatterySince = now.Sub(xOn)
+	}
+
+	battVolts := 27.3
+	if onBattery {
+		battVolts = 24 + 2*charge/100
+	}
+
+	ts := func(t time.Time) string {
+		if t.IsZero() {
+			return "N/A"
+		}
+		return t.Format("2006-01-02 15:04:05 -0700")
+	}
+
+	return []string{
+		apcupsdtest.Line("APC", "001,036,0879"),
+		apcupsdtest.Line("DATE", ts(now)),
+		apcupsdtest.Line("HOSTNAME", "simulated"),
+		apcupsdtest.Line("VERSION", "3.14.14 (31 May 2016) simulated"),
+		apcupsdtest.Line("UPSNAME", "simulated"),
+		apcupsdtest.Line("CABLE", "USB Cable"),
+		apcupsdtest.Line("DRIVER", "USB UPS Driver"),
+		apcupsdtest.Line("UPSMODE", "Stand Alone"),
+		apcupsdtest.Line("STARTTIME", ts(sim.start)),
+		apcupsdtest.Line("MODEL", "Smart-UPS 1500"),
+		apcupsdtest.Line("STATUS", status),
+		apcupsdtest.Line("LINEV", fmt.Sprintf("%.1f Volts", lineVolts)),
+		apcupsdtest.Line("LOADPCT", fmt.Sprintf("%.1f Percent", load)),
+		apcupsdtest.Line("BCHARGE", fmt.Sprintf("%.1f Percent", charge)),
+		apcupsdtest.Line("TIMELEFT", fmt.Sprintf("%.1f Minutes", timeLeft.Minutes())),
+		apcupsdtest.Line("MBATTCHG", "5 Percent"),
+		apcupsdtest.Line("MINTIMEL", fmt.Sprintf("%d Minutes", int(simulatedMinTimeLeft.Minutes()))),
+		apcupsdtest.Line("MAXTIME", "0 Seconds"),
+		apcupsdtest.Line("SENSE", "Medium"),
+		apcupsdtest.Line("LOTRANS", "196.0 Volts"),
+		apcupsdtest.Line("HITRANS", "253.0 Volts"),
+		apcupsdtest.Line("ALARMDEL", "30 Seconds"),
+		apcupsdtest.Line("BATTV", fmt.Sprintf("%.1f Volts", battVolts)),
+		apcupsdtest.Line("LASTXFER", lastXfer),
+		apcupsdtest.Line("NUMXFERS", fmt.Sprint(transfers)),
+		apcupsdtest.Line("XONBATT", ts(xOn)),
+		apcupsdtest.Line("TONBATT", fmt.Sprintf("%d Seconds", int(math.Round(onBatterySince.Seconds())))),
+		apcupsdtest.Line("CUMONBATT", fmt.Sprintf("%d Seconds", int(math.Round(onBatteryTotal.Seconds())))),
+		apcupsdtest.Line("XOFFBATT", ts(xOff)),
+		apcupsdtest.Line("SELFTEST", "NO"),
+		apcupsdtest.Line("SERIALNO", "SIMULATED"),
+		apcupsdtest.Line("BATTDATE", sim.start.Format("2006-01-02")),
+		apcupsdtest.Line("NOMINV", "230 Volts"),
+		apcupsdtest.Line("NOMBATTV", "24.0 Volts"),
+		apcupsdtest.Line("NOMPOWER", "1000 Watts"),
+		apcupsdtest.Line("FIRMWARE", "simulated"),
+		apcupsdtest.Line("END APC", ts(now)),
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSimulator(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := &simulator{sc: scenarios["outage"], start: start, cycle: 100 * time.Minute}
+
+	tests := []struct {
+		at   time.Duration
+		want map[string]string
+	}{
+		{
+			at:   10 * time.Minute,
+			want: map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0 Percent", "NUMXFERS": "0", "XONBATT": "N/A"},
+		},
+		{
+			at:   30 * time.Minute,
+			want: map[string]string{"STATUS": "ONBATT", "LINEV": "0.0 Volts", "BCHARGE": "82.0 Percent", "NUMXFERS": "1", "TONBATT": "300 Seconds"},
+		},
+		{
+			at:   49*time.Minute + 30*time.Second,
+			want: map[string]string{"STATUS": "ONBATT LOWBATT", "BCHARGE": "11.8 Percent"},
+		},
+		{
+			at:   60 * time.Minute,
+			want: map[string]string{"STATUS": "ONLINE", "BCHARGE": "30.0 Percent", "XOFFBATT": "2024-01-01 00:50:00 +0000"},
+		},
+		{
+			at:   130 * time.Minute,
+			want: map[string]string{"STATUS": "ONBATT", "NUMXFERS": "2", "CUMONBATT": "1800 Seconds", "XOFFBATT": "2024-01-01 00:50:00 +0000"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := make(map[string]string)
+		for _, l := range sim.status(start.Add(tt.at)) {
+			k, v, _ := strings.Cut(l, ":")
+			got[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Fatalf("at %s: unexpected %s: %q, want %q", tt.at, k, got[k], v)
+			}
+		}
+	}
+
+	prev := *simulate
+	*simulate = "outage"
+	defer func() { *simulate = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	want := `
+# HELP apcupsd_up Whether the last collection of UPS metrics from apcupsd was successful.
+# TYPE apcupsd_up gauge
+apcupsd_up 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_up"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	*simulate = "meteor"
+	if _, err := newConfig(); err == nil {
+		t.Fatal("expected an error for an unknown scenario")
+	}
+}
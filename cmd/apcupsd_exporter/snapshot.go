@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// snapshotPath is the URL path of the debug endpoint which serves the parsed
+// state of a target.
+const snapshotPath = "/debug/snapshot"
+
+// A snapshot is the parsed state of a target, as served by snapshotHandler.
+type snapshot struct {
+	Target  string `json:"target"`
+	Address string `json:"address"`
+
+	// CollectedAt and LastSuccessAt are the times of the most recent
+	// collection and of the most recent successful one, if any.
+	CollectedAt   *time.Time `json:"collected_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+
+	// Error is the error of the most recent collection, if it failed, in
+	// which case Status is that of the last successful collection and is
+	// Stale.
+	Error      string  `json:"error,omitempty"`
+	Stale      bool    `json:"stale"`
+	AgeSeconds float64 `json:"age_seconds,omitempty"`
+
+	// ParseErrors are the errors parsing fields of the most recent status,
+	// keyed by field.  These fields are zero in Status.
+	ParseErrors map[string]string `json:"parse_errors,omitempty"`
+
+	Status *apcupsd.Status `json:"status"`
+}
+
+// snapshot returns the parsed state of the target configured by t.
+func (ts *targetStatus) snapshot(t targetConfig) snapshot {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	snap := snapshot{
+		Target:  t.Name,
+		Address: t.Address,
+		Status:  ts.last,
+	}
+
+	if !ts.collected.IsZero() {
+		collected := ts.collected
+		snap.CollectedAt = &collected
+	}
+	if !ts.lastSuccess.IsZero() {
+		lastSuccess := ts.lastSuccess
+		snap.LastSuccessAt = &lastSuccess
+	}
+
+	if ts.err != nil {
+		snap.Error = ts.err.Error()
+		snap.Stale = ts.last != nil
+		if snap.Stale {
+			snap.AgeSeconds = time.Since(ts.lastSuccess).Seconds()
+		}
+	}
+
+	if len(ts.parseErrors) > 0 {
+		snap.ParseErrors = make(map[string]string, len(ts.parseErrors))
+		for f, err := range ts.parseErrors {
+			snap.ParseErrors[f] = err.Error()
+		}
+	}
+
+	return snap
+}
+
+// health returns "up" if the last collection of the target succeeded, "stale"
+// if it failed but an earlier one succeeded, "down" if none succeeded, and
+// "pending" before the first collection.
+func (s snapshot) health() string {
+	switch {
+	case s.CollectedAt == nil:
+		return "pending"
+	case s.Stale:
+		return "stale"
+	case s.Error != "":
+		return "down"
+	default:
+		return "up"
+	}
+}
+
+// snapshotHandler serves the parsed state of the target named by the target
+// query parameter, which may be omitted if there is only one target, as JSON.
+// It shows the values the exporter derives its metrics from, to troubleshoot
+// metrics which are missing or zero.
+func snapshotHandler(ts *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, tgt, err := ts.find(r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(tgt.status.snapshot(t))
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestSnapshotHandler(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	p := &poller{c: ts}
+	p.poll()
+	s.SetHandler(apcupsdtest.Error(errors.New("gone")))
+	p.poll()
+
+	h := snapshotHandler(ts)
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, snapshotPath+"?target="+target, nil))
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d: %s", w.Code, w.Body)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("failed to decode snapshot: %v", err)
+	}
+
+	// The last successful status is served, marked as stale.
+	if !snap.Stale || snap.Error == "" || snap.Status == nil || snap.Status.Model != "Back-UPS RS 1500G" {
+		t.Fatalf("unexpected snapshot: %s", w.Body)
+	}
+
+	if w := get("unknown"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code for unknown target: %d", w.Code)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// An sshConfig configures an SSH tunnel through which a target is dialed.
+type sshConfig struct {
+	// Host is the address of the SSH server, with an optional port which
+	// defaults to 22.
+	Host string `yaml:"host"`
+	User string `yaml:"user"`
+
+	// KeyFile is the path of an unencrypted private key used to
+	// authenticate.
+	KeyFile string `yaml:"key_file"`
+
+	// KnownHostsFile is the path of a known_hosts file used to verify the
+	// key of the SSH server, by default ~/.ssh/known_hosts.  Verification
+	// is skipped if InsecureIgnoreHostKey is set.
+	KnownHostsFile        string `yaml:"known_hosts_file,omitempty"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key,omitempty"`
+
+	client *ssh.ClientConfig
+}
+
+// validate checks c for errors and loads its credentials.
+func (c *sshConfig) validate() error {
+	if c.Host == "" {
+		return errors.New("SSH host must be specified")
+	}
+	if _, _, err := net.SplitHostPort(c.Host); err != nil {
+		c.Host = net.JoinHostPort(c.Host, "22")
+	}
+	if c.User == "" {
+		return errors.New("SSH user must be specified")
+	}
+	if c.KeyFile == "" {
+		return errors.New("SSH key file must be specified")
+	}
+
+	key, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH key file %s: %v", c.KeyFile, err)
+	}
+
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if !c.InsecureIgnoreHostKey {
+		if c.KnownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("failed to locate known_hosts file: %v", err)
+			}
+			c.KnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+
+		hostKey, err = knownhosts.New(c.KnownHostsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load known_hosts file: %v", err)
+		}
+	}
+
+	c.client = &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKey,
+	}
+
+	return nil
+}
+
+// An sshTunnel dials connections through a long-lived SSH connection, which
+// is reestablished whenever it fails.
+type sshTunnel struct {
+	host string
+	cfg  *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHTunnel creates an sshTunnel using c.
+func newSSHTunnel(c *sshConfig) *sshTunnel {
+	return &sshTunnel{
+		host: c.Host,
+		cfg:  c.client,
+	}
+}
+
+// dialFunc returns a DialFunc which dials addr from the SSH server.
+func (t *sshTunnel) dialFunc(addr string) apcupsdexporter.DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		c, err := t.sshClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SSH server %s: %v", t.host, err)
+		}
+
+		conn, err := c.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			// The SSH connection may have failed, so reestablish it on
+			// the next dial.
+			t.reset(c)
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// sshClient returns the current SSH client, connecting if necessary.
+func (t *sshTunnel) sshClient(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the SSH handshake by ctx as well.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	sc, chans, reqs, err := ssh.NewClientConn(conn, t.host, t.cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	t.client = ssh.NewClient(sc, chans, reqs)
+	return t.client, nil
+}
+
+// reset closes c and clears it if it is still the current SSH client.
+func (t *sshTunnel) reset(c *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == c {
+		_ = c.Close()
+		t.client = nil
+	}
+}
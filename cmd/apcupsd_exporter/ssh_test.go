@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHTunnel(t *testing.T) {
+	nis, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer nis.Close()
+
+	hostKey, clientKey := newTestSSHKey(t), newTestSSHKey(t)
+	s := newTestSSHServer(t, hostKey, clientKey.PublicKey())
+	dir := testSSHFiles(t, s.addr, hostKey.PublicKey(), clientKey)
+
+	c, err := decodeConfig([]byte(fmt.Sprintf(`
+targets:
+  - address: %s
+    ssh:
+      host: %s
+      user: exporter
+      key_file: %s
+      known_hosts_file: %s
+`, nis.Addr(), s.addr, filepath.Join(dir, "id_ed25519"), filepath.Join(dir, "known_hosts"))))
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+
+	e := apcupsdexporter.NewWithDialFunc(c.Targets[0].dialFunc())
+
+	// Collections share a single SSH connection.
+	for i := 0; i < 2; i++ {
+		if n := testutil.CollectAndCount(e, "apcupsd_ups_load_percent"); n != 1 {
+			t.Fatalf("unexpected number of metrics through the tunnel: %d", n)
+		}
+	}
+	if n := s.connections(); n != 1 {
+		t.Fatalf("unexpected number of SSH connections: %d", n)
+	}
+
+	// A failed SSH connection is reestablished once it is found to fail.
+	s.closeAll()
+	testutil.CollectAndCount(e, "apcupsd_ups_load_percent")
+	if n := testutil.CollectAndCount(e, "apcupsd_ups_load_percent"); n != 1 {
+		t.Fatalf("unexpected number of metrics after reconnecting: %d", n)
+	}
+	if n := s.connections(); n != 2 {
+		t.Fatalf("unexpected number of SSH connections: %d", n)
+	}
+}
+
+func TestSSHConfigValidate(t *testing.T) {
+	hostKey, clientKey := newTestSSHKey(t), newTestSSHKey(t)
+	dir := testSSHFiles(t, "ups.example.com:22", hostKey.PublicKey(), clientKey)
+	key := filepath.Join(dir, "id_ed25519")
+
+	c := sshConfig{
+		Host:           "ups.example.com",
+		User:           "exporter",
+		KeyFile:        key,
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("failed to validate config: %v", err)
+	}
+	if c.Host != "ups.example.com:22" {
+		t.Fatalf("unexpected host: %q", c.Host)
+	}
+
+	// The known host key is accepted, and any other rejected.
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	if err := c.client.HostKeyCallback(c.Host, addr, hostKey.PublicKey()); err != nil {
+		t.Fatalf("known host key was rejected: %v", err)
+	}
+	if err := c.client.HostKeyCallback(c.Host, addr, clientKey.PublicKey()); err == nil {
+		t.Fatal("unknown host key was accepted")
+	}
+
+	tests := []struct {
+		desc string
+		c    sshConfig
+	}{
+		{desc: "no host", c: sshConfig{User: "exporter", KeyFile: key, InsecureIgnoreHostKey: true}},
+		{desc: "no user", c: sshConfig{Host: "ups", KeyFile: key, InsecureIgnoreHostKey: true}},
+		{desc: "no key", c: sshConfig{Host: "ups", User: "exporter", InsecureIgnoreHostKey: true}},
+		{desc: "missing key", c: sshConfig{Host: "ups", User: "exporter", KeyFile: filepath.Join(dir, "missing"), InsecureIgnoreHostKey: true}},
+		{desc: "invalid key", c: sshConfig{Host: "ups", User: "exporter", KeyFile: filepath.Join(dir, "known_hosts"), InsecureIgnoreHostKey: true}},
+		{desc: "missing known hosts", c: sshConfig{Host: "ups", User: "exporter", KeyFile: key, KnownHostsFile: filepath.Join(dir, "missing")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if err := tt.c.validate(); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+// A testSSHKey is an SSH key pair.
+type testSSHKey struct {
+	ssh.Signer
+	priv ed25519.PrivateKey
+}
+
+// newTestSSHKey returns a random SSH key pair.
+func newTestSSHKey(t *testing.T) testSSHKey {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	return testSSHKey{Signer: s, priv: priv}
+}
+
+// testSSHFiles writes the private key of client, and a known_hosts file
+// containing the key of host, to a temporary directory.
+func testSSHFiles(t *testing.T, host string, hostKey ssh.PublicKey, client testSSHKey) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	b, err := ssh.MarshalPrivateKey(client.priv, "")
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "id_ed25519"), pem.EncodeToMemory(b), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(host)}, hostKey) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "known_hosts"), []byte(line), 0o600); err != nil {
+		t.Fatalf("failed to write known_hosts: %v", err)
+	}
+
+	return dir
+}
+
+// A testSSHServer is an SSH server which forwards direct-tcpip channels.
+type testSSHServer struct {
+	addr string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// newTestSSHServer starts a testSSHServer with hostKey, which accepts
+// clients authenticating with the public key authorized.
+func newTestSSHServer(t *testing.T, hostKey ssh.Signer, authorized ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(k.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testSSHServer{addr: l.Addr().String()}
+	t.Cleanup(func() {
+		_ = l.Close()
+		s.closeAll()
+	})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+
+			go s.serve(c, cfg)
+		}
+	}()
+
+	return s
+}
+
+// serve serves the SSH connection c.
+func (s *testSSHServer) serve(c net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		var p struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if nc.ChannelType() != "direct-tcpip" || ssh.Unmarshal(nc.ExtraData(), &p) != nil {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+
+		dst, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port))))
+		if err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			_ = dst.Close()
+			continue
+		}
+		go ssh.DiscardRequests(creqs)
+
+		go func() {
+			_, _ = io.Copy(dst, ch)
+			_ = dst.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(ch, dst)
+			_ = ch.Close()
+		}()
+	}
+}
+
+// connections returns the number of SSH connections accepted.
+func (s *testSSHServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// closeAll closes all accepted SSH connections.
+func (s *testSSHServer) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// statusAPIPath is the URL path of the API endpoint which serves the key
+// values of each target.
+const statusAPIPath = apiV1Path + "/status"
+
+// protobufContentType is the media type of the protobuf encoding of the status
+// API, a StatusResponse message as defined in status.proto.
+const protobufContentType = "application/x-protobuf"
+
+// A targetSummary holds the health and key values of a target, as served by
+// the status API.
+type targetSummary struct {
+	Target  string `json:"target"`
+	Address string `json:"address"`
+	Health  string `json:"health"`
+
+	CollectedAt   *time.Time `json:"collected_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	Error         string     `json:"error,omitempty"`
+
+	// The fields below are those of the last successful collection, and are
+	// zero if there was none.
+	Model                  string  `json:"model"`
+	Status                 string  `json:"status"`
+	LineVolts              float64 `json:"line_volts"`
+	LoadPercent            float64 `json:"load_percent"`
+	BatteryChargePercent   float64 `json:"battery_charge_percent"`
+	BatteryTimeLeftSeconds float64 `json:"battery_time_left_seconds"`
+}
+
+// newTargetSummary creates the targetSummary of snap.
+func newTargetSummary(snap snapshot) targetSummary {
+	ts := targetSummary{
+		Target:        snap.Target,
+		Address:       snap.Address,
+		Health:        snap.health(),
+		CollectedAt:   snap.CollectedAt,
+		LastSuccessAt: snap.LastSuccessAt,
+		Error:         snap.Error,
+	}
+
+	if s := snap.Status; s != nil {
+		ts.Model = s.Model
+		ts.Status = s.Status
+		ts.LineVolts = s.LineVoltage
+		ts.LoadPercent = s.LoadPercent
+		ts.BatteryChargePercent = s.BatteryChargePercent
+		ts.BatteryTimeLeftSeconds = s.TimeLeft.Seconds()
+	}
+
+	return ts
+}
+
+// statusAPIHandler serves the health and key values of each target, as JSON
+// or, for clients which accept it, as protobuf, which is more compact and
+// cheaper to decode for clients which poll frequently.
+func statusAPIHandler(ts *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snaps := ts.snapshots()
+		sums := make([]targetSummary, 0, len(snaps))
+		for _, snap := range snaps {
+			sums = append(sums, newTargetSummary(snap))
+		}
+
+		w.Header().Set("Vary", "Accept")
+		if acceptsProtobuf(r.Header.Get("Accept")) {
+			w.Header().Set("Content-Type", protobufContentType)
+			_, _ = w.Write(marshalStatusResponse(sums))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			Targets []targetSummary `json:"targets"`
+		}{Targets: sums})
+	})
+}
+
+// acceptsProtobuf reports whether the Accept header accept lists the protobuf
+// encoding.  Quality values are ignored, since clients which accept protobuf
+// at all prefer it.
+func acceptsProtobuf(accept string) bool {
+	for _, v := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+
+		switch mt {
+		case protobufContentType, "application/protobuf", "application/vnd.google.protobuf":
+			return true
+		}
+	}
+
+	return false
+}
+
+// Health values of the protobuf encoding, as defined in status.proto.
+var protobufHealth = map[string]uint64{
+	"pending": 1,
+	"up":      2,
+	"stale":   3,
+	"down":    4,
+}
+
+// marshalStatusResponse encodes sums as a StatusResponse message, as defined
+// in status.proto.  Fields with zero values are omitted, as in proto3.
+func marshalStatusResponse(sums []targetSummary) []byte {
+	var b []byte
+	for _, s := range sums {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalTargetStatus(s))
+	}
+
+	return b
+}
+
+// marshalTargetStatus encodes s as a TargetStatus message.
+func marshalTargetStatus(s targetSummary) []byte {
+	var b []byte
+	appendString := func(num protowire.Number, v string) {
+		if v != "" {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		}
+	}
+	appendVarint := func(num protowire.Number, v uint64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, v)
+		}
+	}
+	appendTime := func(num protowire.Number, t *time.Time) {
+		if t != nil {
+			appendVarint(num, uint64(t.UnixMilli()))
+		}
+	}
+	appendDouble := func(num protowire.Number, v float64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(v))
+		}
+	}
+
+	appendString(1, s.Target)
+	appendString(2, s.Address)
+	appendVarint(3, protobufHealth[s.Health])
+	appendTime(4, s.CollectedAt)
+	appendTime(5, s.LastSuccessAt)
+	appendString(6, s.Error)
+	appendString(7, s.Model)
+	appendString(8, s.Status)
+	appendDouble(9, s.LineVolts)
+	appendDouble(10, s.LoadPercent)
+	appendDouble(11, s.BatteryChargePercent)
+	appendDouble(12, s.BatteryTimeLeftSeconds)
+
+	return b
+}
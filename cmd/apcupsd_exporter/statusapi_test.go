@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestStatusAPIHandler(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	(&poller{c: ts}).poll()
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, statusAPIPath, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		statusAPIHandler(ts).ServeHTTP(w, r)
+		return w
+	}
+
+	var res struct {
+		Targets []targetSummary `json:"targets"`
+	}
+	w := get("application/json")
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode JSON: %v", err)
+	}
+	if len(res.Targets) != 1 || res.Targets[0].Health != "up" || res.Targets[0].Model != "Back-UPS RS 1500G" {
+		t.Fatalf("unexpected JSON response: %s", w.Body)
+	}
+
+	w = get("application/json;q=0.5, application/x-protobuf")
+	if ct := w.Header().Get("Content-Type"); ct != protobufContentType {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+
+	// Decode the StatusResponse and its single TargetStatus.
+	b := w.Body.Bytes()
+	num, typ, n := protowire.ConsumeTag(b)
+	if num != 1 || typ != protowire.BytesType {
+		t.Fatalf("unexpected field %d of type %d", num, typ)
+	}
+	msg, m := protowire.ConsumeBytes(b[n:])
+	if m < 0 || n+m != len(b) {
+		t.Fatalf("malformed response: %x", b)
+	}
+
+	var (
+		health uint64
+		model  string
+		load   float64
+	)
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		msg = msg[n:]
+		switch num {
+		case 3:
+			health, n = protowire.ConsumeVarint(msg)
+		case 7:
+			model, n = protowire.ConsumeString(msg)
+		case 10:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(msg)
+			load = math.Float64frombits(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			t.Fatalf("malformed field %d", num)
+		}
+		msg = msg[n:]
+	}
+
+	if health != protobufHealth["up"] || model != res.Targets[0].Model || load != res.Targets[0].LoadPercent {
+		t.Fatalf("unexpected protobuf response: health %d, model %q, load %v", health, model, load)
+	}
+}
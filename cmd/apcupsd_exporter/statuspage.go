@@ -0,0 +1,105 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// statusPath is the URL path of the HTML page summarizing the health of each
+// target.
+const statusPath = "/status"
+
+// A statusRow is a target as shown on the status page.
+type statusRow struct {
+	snapshot
+
+	// Health is the health of the target, as reported by snapshot.health.
+	Health string
+
+	// NextPoll is the time of the next collection, or zero if targets are
+	// collected on each scrape.
+	NextPoll time.Time
+}
+
+// newStatusRow creates the statusRow of snap.
+func newStatusRow(snap snapshot, next time.Time) statusRow {
+	return statusRow{snapshot: snap, Health: snap.health(), NextPoll: next}
+}
+
+// statusHandler serves an HTML page summarizing the health, key values, and
+// last error of each target, as a quick overview which does not require a
+// dashboard.  next returns the time of the next collection, or the zero time
+// if targets are collected on each scrape.
+func statusHandler(ts *targetSet, next func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := next()
+
+		snaps := ts.snapshots()
+		rows := make([]statusRow, 0, len(snaps))
+		for _, snap := range snaps {
+			rows = append(rows, newStatusRow(snap, n))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, rows); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago": func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+
+		return time.Since(*t).Truncate(time.Second).String() + " ago"
+	},
+	"until": func(t time.Time) string {
+		if t.IsZero() {
+			return "on scrape"
+		}
+
+		return time.Until(t).Truncate(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="15">
+<title>apcupsd exporter status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.up { background: #c8e6c9; }
+.stale { background: #fff59d; }
+.down { background: #ffcdd2; }
+.pending { background: #e0e0e0; }
+</style>
+</head>
+<body>
+<h1>apcupsd exporter status</h1>
+<table>
+<tr>
+<th>Target</th><th>Health</th><th>Model</th><th>UPS status</th><th>Load</th><th>Battery charge</th><th>Time left</th><th>Line voltage</th><th>Last success</th><th>Next poll</th><th>Last error</th>
+</tr>
+{{- range .}}
+<tr class="{{.Health}}">
+<td><a href="/debug/snapshot?target={{.Target}}">{{.Target}}</a></td>
+<td>{{.Health}}</td>
+{{- with .Status}}
+<td>{{.Model}}</td><td>{{.Status}}</td><td>{{.LoadPercent}}%</td><td>{{.BatteryChargePercent}}%</td><td>{{.TimeLeft}}</td><td>{{.LineVoltage}} V</td>
+{{- else}}
+<td></td><td></td><td></td><td></td><td></td><td></td>
+{{- end}}
+<td>{{ago .LastSuccessAt}}</td>
+<td>{{until .NextPoll}}</td>
+<td>{{.Error}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
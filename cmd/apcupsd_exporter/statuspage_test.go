@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatusHandler(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	down, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	down.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := fmt.Sprintf(`
+targets:
+  - name: rack 1&2
+    address: %s
+  - name: <closet>
+    address: %s
+`, s.Addr(), down.Addr())
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	get := func(next time.Time) string {
+		w := httptest.NewRecorder()
+		statusHandler(ts, func() time.Time { return next }).ServeHTTP(w, httptest.NewRequest(http.MethodGet, statusPath, nil))
+
+		if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Fatalf("unexpected content type: %q", ct)
+		}
+		return w.Body.String()
+	}
+
+	// Targets which have not been collected yet are pending.
+	body := get(time.Time{})
+	if n := strings.Count(body, `<tr class="pending">`); n != 2 {
+		t.Fatalf("unexpected number of pending targets: %d\n%s", n, body)
+	}
+	if !strings.Contains(body, "<td>on scrape</td>") || !strings.Contains(body, "<td>never</td>") {
+		t.Fatalf("status page lacks the poll and success times of pending targets:\n%s", body)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+	_, _ = reg.Gather()
+
+	body = get(time.Now().Add(time.Minute))
+	for _, want := range []string{
+		// Target names are escaped in both links and text.
+		`<tr class="up">
+<td><a href="/debug/snapshot?target=rack%201%262">rack 1&amp;2</a></td>`,
+		`<td>Back-UPS RS 1500G</td><td>ONLINE</td><td>16%</td><td>100%</td>`,
+		`<tr class="down">
+<td><a href="/debug/snapshot?target=%3ccloset%3e">&lt;closet&gt;</a></td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("status page does not contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "<closet>") {
+		t.Fatalf("status page contains an unescaped target name:\n%s", body)
+	}
+}
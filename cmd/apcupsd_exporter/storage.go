@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultStoragePath is the storage directory used when neither -storage.path
+// nor systemd's StateDirectory= is set.
+const defaultStoragePath = "/var/lib/apcupsd_exporter"
+
+var storagePath = flag.String("storage.path", "", "directory in which state is persisted across restarts; if it is not writable, such as on a read-only root filesystem, state is kept in a temporary directory instead (default: the directory set by systemd StateDirectory=, or "+defaultStoragePath+")")
+
+// A storage is the directory in which the exporter persists state.
+type storage struct {
+	// path is the directory in which state is stored.
+	path string
+
+	// ephemeral reports whether path is a temporary fallback directory,
+	// whose contents are lost on restart.
+	ephemeral bool
+}
+
+var storageEphemeralDesc = prometheus.NewDesc(
+	"apcupsd_exporter_storage_ephemeral",
+	"Whether state is stored in a temporary directory because the storage path is not writable, and is lost on restart (1 for yes, 0 for no).",
+	[]string{"path"}, nil,
+)
+
+// openStorage opens the storage directory set by -storage.path, creating it
+// if needed.  If it cannot be written, a temporary directory is used instead,
+// so that the exporter still runs with a read-only root filesystem.
+func openStorage(logger *slog.Logger) (*storage, error) {
+	path := *storagePath
+	if path == "" {
+		path = defaultStoragePath
+
+		// systemd sets a colon-separated list of the directories created by
+		// StateDirectory=; see systemd.exec(5).
+		if dirs := os.Getenv("STATE_DIRECTORY"); dirs != "" {
+			path, _, _ = strings.Cut(dirs, ":")
+		}
+	}
+
+	err := checkWritable(path)
+	if err == nil {
+		return &storage{path: path}, nil
+	}
+
+	// Prefer the temporary directory, and otherwise fall back to /dev/shm,
+	// which is a tmpfs even when the temporary directory is read-only.
+	for _, dir := range []string{os.TempDir(), "/dev/shm"} {
+		fallback := filepath.Join(dir, "apcupsd_exporter")
+		if checkWritable(fallback) != nil {
+			continue
+		}
+
+		logger.Warn("storage path is not writable, state will be lost on restart",
+			"path", path, "fallback", fallback, "err", err)
+		return &storage{path: fallback, ephemeral: true}, nil
+	}
+
+	return nil, fmt.Errorf("storage path %q is not writable and no temporary directory is available: %v", path, err)
+}
+
+// checkWritable creates the directory dir if needed, and checks that files can
+// be written to it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
+
+var _ prometheus.Collector = &storage{}
+
+// Describe implements prometheus.Collector.
+func (s *storage) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storageEphemeralDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *storage) Collect(ch chan<- prometheus.Metric) {
+	var v float64
+	if s.ephemeral {
+		v = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(storageEphemeralDesc, prometheus.GaugeValue, v, s.path)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOpenStorage(t *testing.T) {
+	defer func(p string) { *storagePath = p }(*storagePath)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	dir := t.TempDir()
+	*storagePath = filepath.Join(dir, "state")
+	st, err := openStorage(logger)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	if st.path != *storagePath || st.ephemeral {
+		t.Fatalf("unexpected storage: %+v", st)
+	}
+	if _, err := os.Stat(st.path); err != nil {
+		t.Fatalf("storage directory was not created: %v", err)
+	}
+
+	// Without -storage.path, the directory of systemd's StateDirectory= is
+	// used.
+	*storagePath = ""
+	t.Setenv("STATE_DIRECTORY", filepath.Join(dir, "systemd")+":"+filepath.Join(dir, "other"))
+	st, err = openStorage(logger)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	if st.path != filepath.Join(dir, "systemd") || st.ephemeral {
+		t.Fatalf("unexpected storage: %+v", st)
+	}
+}
+
+func TestOpenStorageEphemeral(t *testing.T) {
+	defer func(p string) { *storagePath = p }(*storagePath)
+
+	// A regular file cannot be used as the storage directory, even by root.
+	dir := t.TempDir()
+	*storagePath = filepath.Join(dir, "file")
+	if err := os.WriteFile(*storagePath, nil, 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	tmp := filepath.Join(dir, "tmp")
+	t.Setenv("TMPDIR", tmp)
+
+	st, err := openStorage(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	if want := filepath.Join(tmp, "apcupsd_exporter"); st.path != want || !st.ephemeral {
+		t.Fatalf("unexpected storage: %+v", st)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(st)
+
+	want := `
+# HELP apcupsd_exporter_storage_ephemeral Whether state is stored in a temporary directory because the storage path is not writable, and is lost on restart (1 for yes, 0 for no).
+# TYPE apcupsd_exporter_storage_ephemeral gauge
+apcupsd_exporter_storage_ephemeral{path="` + st.path + `"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// systemdCommand implements the "systemd" subcommand, which manages systemd
+// units for apcupsd_exporter.
+func systemdCommand(args []string) error {
+	if len(args) == 0 || args[0] != "install" {
+		return errors.New(`usage: apcupsd_exporter [flags] systemd install [install flags]`)
+	}
+
+	fs := flag.NewFlagSet("systemd install", flag.ExitOnError)
+	var (
+		name      = fs.String("name", "apcupsd_exporter", "name of the systemd units to create")
+		dir       = fs.String("dir", "/etc/systemd/system", "directory in which systemd unit files are written")
+		binary    = fs.String("binary", "", "path to the apcupsd_exporter binary (default: the running executable)")
+		socket    = fs.Bool("socket", false, "also create a socket unit so systemd listens on -telemetry.addr on behalf of the exporter")
+		enable    = fs.Bool("enable", false, "enable the units after writing them")
+		start     = fs.Bool("start", false, "start the units after writing them")
+		dryRun    = fs.Bool("dry-run", false, "print the unit files to stdout instead of installing them")
+		systemctl = fs.String("systemctl", "systemctl", "path to the systemctl binary")
+	)
+	_ = fs.Parse(args[1:])
+
+	if *binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to determine executable path, specify -binary: %v", err)
+		}
+		*binary = exe
+	}
+
+	u := systemdUnit{
+		Name:   *name,
+		Binary: *binary,
+		Args:   setFlags(),
+	}
+	if *socket {
+		listen, err := socketListenStream(*telemetryAddr)
+		if err != nil {
+			return err
+		}
+		u.ListenStream = listen
+	}
+
+	files := []struct {
+		path string
+		tmpl *template.Template
+	}{{
+		path: filepath.Join(*dir, *name+".service"),
+		tmpl: serviceTemplate,
+	}}
+	if *socket {
+		files = append(files, struct {
+			path string
+			tmpl *template.Template
+		}{
+			path: filepath.Join(*dir, *name+".socket"),
+			tmpl: socketTemplate,
+		})
+	}
+
+	for _, f := range files {
+		if *dryRun {
+			fmt.Printf("# %s\n", f.path)
+			if err := f.tmpl.Execute(os.Stdout, u); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeUnit(f.path, f.tmpl, u); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", f.path)
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	if err := run(*systemctl, "daemon-reload"); err != nil {
+		return err
+	}
+
+	// When socket activated, the socket unit owns the listener and starts the
+	// service on the first connection.
+	unit := *name + ".service"
+	if *socket {
+		unit = *name + ".socket"
+	}
+
+	if *enable {
+		if err := run(*systemctl, "enable", unit); err != nil {
+			return err
+		}
+	}
+	if *start {
+		if err := run(*systemctl, "start", unit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A systemdUnit contains the parameters used to render systemd unit files.
+type systemdUnit struct {
+	Name         string
+	Binary       string
+	Args         []string
+	ListenStream string
+}
+
+// ExecStart renders the ExecStart line for the service unit.
+func (u systemdUnit) ExecStart() string {
+	ss := make([]string, 0, len(u.Args)+1)
+	ss = append(ss, systemdQuote(u.Binary))
+	for _, a := range u.Args {
+		ss = append(ss, systemdQuote(a))
+	}
+
+	return strings.Join(ss, " ")
+}
+
+var serviceTemplate = template.Must(template.New("service").Parse(`[Unit]
+Description=Prometheus exporter for apcupsd
+Documentation=https://github.com/mdlayher/apcupsd_exporter
+Wants=network-online.target
+After=network-online.target apcupsd.service
+{{- if .ListenStream}}
+Requires={{.Name}}.socket
+{{- end}}
+
+[Service]
+ExecStart={{.ExecStart}}
+Restart=on-failure
+DynamicUser=yes
+StateDirectory={{.Name}}
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+NoNewPrivileges=yes
+CapabilityBoundingSet=
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var socketTemplate = template.Must(template.New("socket").Parse(`[Unit]
+Description=Prometheus exporter for apcupsd (socket)
+Documentation=https://github.com/mdlayher/apcupsd_exporter
+
+[Socket]
+ListenStream={{.ListenStream}}
+
+[Install]
+WantedBy=sockets.target
+`))
+
+// writeUnit renders tmpl with u and atomically writes the result to path.
+func writeUnit(path string, tmpl *template.Template, u systemdUnit) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create unit file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := tmpl.Execute(f, u); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to render unit file %q: %v", path, err)
+	}
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// run executes a command, passing its output through to the terminal.
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %s %s: %v", name, strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// setFlags returns the command line flags which were explicitly set on the
+// command line, so they can be reproduced in a unit file.
+func setFlags() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+	})
+
+	return args
+}
+
+// socketListenStream converts a Go listen address into a systemd ListenStream
+// value.
+func socketListenStream(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid telemetry address %q: %v", addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("telemetry address %q must use a numeric port for socket activation", addr)
+	}
+
+	// systemd interprets a bare port as "all addresses".
+	if host == "" {
+		return port, nil
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// systemdQuote quotes s for use as a single argument on a systemd ExecStart
+// line.
+func systemdQuote(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`%`, `%%`,
+		`$`, `$$`,
+	)
+
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemdCommand(t *testing.T) {
+	systemctl, err := exec.LookPath("true")
+	if err != nil {
+		t.Skipf("skipping, no true binary: %v", err)
+	}
+
+	prev := *telemetryAddr
+	*telemetryAddr = "127.0.0.1:9162"
+	defer func() { *telemetryAddr = prev }()
+
+	dir := t.TempDir()
+	err = systemdCommand([]string{
+		"install",
+		"-name", "ups",
+		"-dir", dir,
+		"-binary", "/usr/local/bin/apcupsd exporter",
+		"-socket",
+		"-systemctl", systemctl,
+	})
+	if err != nil {
+		t.Fatalf("failed to install units: %v", err)
+	}
+
+	service, err := os.ReadFile(filepath.Join(dir, "ups.service"))
+	if err != nil {
+		t.Fatalf("failed to read service unit: %v", err)
+	}
+	for _, want := range []string{
+		`ExecStart="/usr/local/bin/apcupsd exporter"`,
+		"Requires=ups.socket",
+		"StateDirectory=ups",
+	} {
+		if !strings.Contains(string(service), want) {
+			t.Fatalf("service unit does not contain %q:\n%s", want, service)
+		}
+	}
+
+	socket, err := os.ReadFile(filepath.Join(dir, "ups.socket"))
+	if err != nil {
+		t.Fatalf("failed to read socket unit: %v", err)
+	}
+	if !strings.Contains(string(socket), "ListenStream=127.0.0.1:9162\n") {
+		t.Fatalf("unexpected socket unit:\n%s", socket)
+	}
+
+	// Only the units themselves are left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected number of files: %d", len(entries))
+	}
+}
+
+func TestSystemdCommandUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"uninstall"}} {
+		if err := systemdCommand(args); err == nil {
+			t.Fatalf("expected an error for %q, but none occurred", args)
+		}
+	}
+}
+
+func TestSocketListenStream(t *testing.T) {
+	tests := []struct {
+		addr, want string
+		ok         bool
+	}{
+		{addr: ":9162", want: "9162", ok: true},
+		{addr: "127.0.0.1:9162", want: "127.0.0.1:9162", ok: true},
+		{addr: "[::1]:9162", want: "[::1]:9162", ok: true},
+		{addr: "localhost"},
+		{addr: ":http"},
+		{addr: ":65536"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := socketListenStream(tt.addr)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to convert address: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				return
+			}
+			if got != tt.want {
+				t.Fatalf("unexpected ListenStream: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSystemdUnitExecStart(t *testing.T) {
+	u := systemdUnit{
+		Binary: "/usr/bin/apcupsd_exporter",
+		Args:   []string{`-apcupsd.addr=ups:3551`, `-web.route-prefix=/100%`, `-x=$HOME "quoted" \`},
+	}
+
+	want := `"/usr/bin/apcupsd_exporter" "-apcupsd.addr=ups:3551" "-web.route-prefix=/100%%" "-x=$$HOME \"quoted\" \\"`
+	if got := u.ExecStart(); got != want {
+		t.Fatalf("unexpected ExecStart:\n got: %s\nwant: %s", got, want)
+	}
+}
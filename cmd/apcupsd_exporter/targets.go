@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+var _ prometheus.Collector = &targetSet{}
+
+// A targetSet is a prometheus.Collector which collects metrics from each
+// apcupsd target of the current configuration.  The configuration can be
+// replaced at runtime using reload.
+//
+// Because the set of targets may change, a targetSet is an unchecked
+// collector and describes no metrics.
+type targetSet struct {
+	logger *slog.Logger
+
+	// stateDir, if set, is the directory in which the collector of each
+	// target persists its state.
+	stateDir string
+
+	// reloadMu serializes reloads, which reuse the current targets.
+	reloadMu sync.Mutex
+
+	mu      sync.RWMutex
+	cfg     *config
+	targets []*target
+	groups  *aggregateDescs
+
+	isReady atomic.Bool
+
+	// history, if set, stores the samples collected from each target.  It
+	// must be set before the first collection.
+	history *historyStore
+
+	// tracer, if set, traces each collection from each target.  It must be
+	// set before the first collection.
+	tracer *tracer
+
+	// traceParent, if set, is the span of a caller whose trace each
+	// collection joins, rather than that of a scrape served by
+	// tracer.withTraceParent.
+	traceParent *traceParent
+
+	// maintenance records the targets placed in maintenance at runtime.
+	maintenance maintenanceSet
+
+	// nisTraces records the targets traced at runtime.
+	nisTraces nisTraceSet
+
+	// discovery records the refreshes of the targets by each discovery
+	// mechanism, and discovered holds the targets of the last successful
+	// refresh of each.  discovered is guarded by reloadMu.
+	discovery  discoveryStats
+	discovered map[string][]targetConfig
+}
+
+// A target is the collector of a single apcupsd target, along with the result
+// of its most recent collection.
+type target struct {
+	c      *apcupsdexporter.Exporter
+	status targetStatus
+
+	// key identifies the configuration from which the target was created.
+	key string
+
+	// groups are the names of the groups of the target at each group
+	// level, or empty if it is not grouped at that level.
+	groups []string
+
+	// interval is the interval at which the target is polled, or zero if
+	// it is collected on each scrape.  polled holds the metrics of its last
+	// poll, made at polledAt.
+	interval time.Duration
+	pollMu   sync.Mutex
+	polled   []prometheus.Metric
+	polledAt time.Time
+}
+
+// collect sends the metrics of t to ch.  If t is polled, its metrics are only
+// collected again once its interval has elapsed since its last poll, within
+// half the interval tick at which the targets are polled, and the metrics of
+// its last poll are sent otherwise.
+func (t *target) collect(ch chan<- prometheus.Metric, tick time.Duration) {
+	if t.interval == 0 {
+		t.c.Collect(ch)
+		return
+	}
+
+	t.pollMu.Lock()
+	defer t.pollMu.Unlock()
+
+	if t.polledAt.IsZero() || time.Since(t.polledAt) >= t.interval-tick/2 {
+		start := time.Now()
+		mch := make(chan prometheus.Metric)
+		go func() {
+			defer close(mch)
+			t.c.Collect(mch)
+		}()
+
+		var ms []prometheus.Metric
+		for m := range mch {
+			ms = append(ms, m)
+		}
+		t.polled, t.polledAt = ms, start
+	}
+
+	for _, m := range t.polled {
+		ch <- m
+	}
+}
+
+// newTargetSet creates a targetSet and loads its initial configuration.  The
+// collectors of its targets persist their state in st, if set.
+func newTargetSet(logger *slog.Logger, st *storage) (*targetSet, error) {
+	ts := &targetSet{logger: logger}
+	if st != nil {
+		ts.stateDir = filepath.Join(st.path, "state")
+		if err := os.MkdirAll(ts.stateDir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	if err := ts.reload(); err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// reload loads the configuration and replaces the collectors of the targets
+// which were added or changed.  Targets whose configuration is unchanged keep
+// their collectors, along with their counters and the state they derive from
+// earlier collections.  If the configuration is invalid, the current targets
+// are kept.
+//
+// The series of targets removed by the new configuration are no longer
+// collected from the next scrape onward, rather than lingering until
+// Prometheus considers them stale.
+//
+// The targets of the discoverers of the configuration are discovered anew.
+func (ts *targetSet) reload() error {
+	ts.reloadMu.Lock()
+	defer ts.reloadMu.Unlock()
+
+	cfg, err := newConfig()
+	if err != nil {
+		ts.discovery.failed(discoveryMechanism())
+		return err
+	}
+	ts.refreshed(discoveryMechanism(), cfg.Targets)
+	if err := ts.discover(cfg); err != nil {
+		return err
+	}
+	resolveNames(ts.logger, cfg)
+
+	ts.mu.RLock()
+	current := make(map[string]*target, len(ts.targets))
+	for _, t := range ts.targets {
+		current[t.key] = t
+	}
+	ts.mu.RUnlock()
+
+	targets := make([]*target, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		key := targetKey(t, cfg)
+		if tgt, ok := current[key]; ok && key != "" {
+			targets = append(targets, tgt)
+			continue
+		}
+
+		tgt := ts.target(t, cfg)
+		tgt.key = key
+		targets = append(targets, tgt)
+	}
+
+	var groups *aggregateDescs
+	if len(cfg.GroupLevels) > 0 {
+		groups = newAggregateDescs("group", "the group's", append([]string{"level"}, cfg.GroupLevels...))
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.cfg != nil {
+		names := make(map[string]bool, len(cfg.Targets))
+		for _, t := range cfg.Targets {
+			names[t.Name] = true
+		}
+		for _, t := range ts.cfg.Targets {
+			if !names[t.Name] {
+				ts.logger.Info("removed target", "target", t.Name)
+			}
+		}
+	}
+
+	ts.cfg = cfg
+	ts.targets = targets
+	ts.groups = groups
+	return nil
+}
+
+// ready reports whether the status of every target has been collected
+// successfully at least once.  Once ready, a targetSet remains ready, even if
+// targets are added by a reload.
+func (ts *targetSet) ready() bool {
+	if ts.isReady.Load() {
+		return true
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	for _, t := range ts.targets {
+		if !t.status.everSucceeded() {
+			return false
+		}
+	}
+
+	ts.isReady.Store(true)
+	return true
+}
+
+// find returns the configuration and collector of the target named name,
+// which may be empty if there is only one target.
+func (ts *targetSet) find(name string) (targetConfig, *target, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	targets := ts.cfg.Targets
+	if name == "" {
+		if len(targets) != 1 {
+			return targetConfig{}, nil, &httpError{code: http.StatusBadRequest, err: errors.New("the target query parameter is required with multiple targets")}
+		}
+
+		return targets[0], ts.targets[0], nil
+	}
+
+	for i, t := range targets {
+		if t.Name == name {
+			return t, ts.targets[i], nil
+		}
+	}
+
+	return targetConfig{}, nil, &httpError{code: http.StatusNotFound, err: fmt.Errorf("unknown target %q", name)}
+}
+
+// snapshots returns the parsed state of each target.
+func (ts *targetSet) snapshots() []snapshot {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	snaps := make([]snapshot, 0, len(ts.targets))
+	for i, t := range ts.targets {
+		snaps = append(snaps, t.status.snapshot(ts.cfg.Targets[i]))
+	}
+
+	return snaps
+}
+
+// pollInterval returns the interval at which the targets are polled: the
+// shortest poll interval of any target.
+func (ts *targetSet) pollInterval() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if d := pollTick(ts.targets); d > 0 {
+		return d
+	}
+
+	return *pollInterval
+}
+
+// pollTick returns the shortest poll interval of targets, or zero if none is
+// polled.
+func pollTick(targets []*target) time.Duration {
+	var tick time.Duration
+	for _, t := range targets {
+		if t.interval > 0 && (tick == 0 || t.interval < tick) {
+			tick = t.interval
+		}
+	}
+
+	return tick
+}
+
+// config returns the current configuration.
+func (ts *targetSet) config() *config {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return ts.cfg
+}
+
+// targetKey returns a key identifying the configuration from which the
+// collector of t is created by cfg, or empty if it cannot be determined.
+func targetKey(t targetConfig, cfg *config) string {
+	// Maintenance windows do not affect the collector.
+	t.Maintenance = nil
+
+	b, err := yaml.Marshal(struct {
+		Target         targetConfig
+		ReverseName    string
+		GroupLevels    []string
+		ModelSpecs     map[string]modelSpecConfig
+		Overrides      []overrideConfig
+		Quirks         []quirkConfig
+		SpikeFilters   []spikeFilterConfig
+		DerivedMetrics []derivedMetricConfig
+	}{
+		Target:         t,
+		ReverseName:    t.reverseName,
+		GroupLevels:    cfg.GroupLevels,
+		ModelSpecs:     cfg.ModelSpecs,
+		Overrides:      cfg.Overrides,
+		Quirks:         cfg.Quirks,
+		SpikeFilters:   cfg.SpikeFilters,
+		DerivedMetrics: cfg.DerivedMetrics,
+	})
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// target creates the collector for t, with the groups and nominal values
+// configured by cfg.
+func (ts *targetSet) target(t targetConfig, cfg *config) *target {
+	specs := cfg.modelSpecs()
+	tgt := &target{
+		groups:   make([]string, len(cfg.GroupLevels)),
+		interval: t.PollInterval,
+	}
+	for i, l := range cfg.GroupLevels {
+		tgt.groups[i] = t.Groups[l]
+	}
+	if t.Simulate != "" {
+		ts.logger.Warn("simulating a UPS instead of collecting from apcupsd", "target", t.Name, "scenario", t.Simulate)
+	}
+	if t.Replay != "" {
+		ts.logger.Warn("replaying a NIS capture instead of collecting from apcupsd", "target", t.Name, "capture", t.Replay)
+	}
+	if t.Record != "" {
+		ts.logger.Info("recording exchanges with apcupsd", "target", t.Name, "capture", t.Record)
+	}
+
+	// The exchanges with the target are logged at the trace level while it
+	// is traced at runtime, as well as with -log.level=trace.
+	logger := withTrace(ts.logger.With("target", t.Name), func() bool {
+		return ts.nisTraces.enabled(t.Name)
+	})
+
+	opts := []apcupsdexporter.Option{
+		apcupsdexporter.WithCollectors(enabledCollectors()...),
+		apcupsdexporter.WithLogger(logger),
+		apcupsdexporter.WithInvalidMetricOnError(*invalidMetricOnError),
+		apcupsdexporter.WithUpstreamNames(*upstreamNames),
+		apcupsdexporter.WithCompactStatus(*compactStatus),
+		apcupsdexporter.WithShutdownDuration(t.ShutdownDuration),
+		apcupsdexporter.WithServeStale(*serveStale),
+		apcupsdexporter.WithTimeout(t.Timeout),
+		apcupsdexporter.WithErrorLogInterval(*logErrorInterval),
+		apcupsdexporter.WithHostnameFunc(t.hostnameFunc()),
+		apcupsdexporter.WithLocation(t.location),
+		apcupsdexporter.WithModelSpecs(specs),
+		apcupsdexporter.WithOverrides(cfg.overrides()...),
+		apcupsdexporter.WithQuirks(cfg.quirks()...),
+		apcupsdexporter.WithSpikeFilters(cfg.spikeFilters()...),
+		apcupsdexporter.WithDerivedMetrics(cfg.derivedMetrics()...),
+		apcupsdexporter.WithHooks(apcupsdexporter.Hooks{
+			Before: func(ctx context.Context) (context.Context, error) {
+				if ts.tracer != nil {
+					ctx = ts.tracer.start(ctx, ts.traceParent)
+				}
+				return ctx, nil
+			},
+			After: func(ctx context.Context, _ chan<- prometheus.Metric, s *apcupsd.Status, err error) {
+				tgt.status.set(withNominalPower(s, specs), err, apcupsdexporter.ParseErrors(ctx))
+				if ts.history != nil {
+					if herr := ts.history.record(t.Name, newSample(time.Now(), s, err)); herr != nil {
+						ts.logger.Warn("failed to store history", "target", t.Name, "err", herr)
+					}
+				}
+				if ts.tracer != nil {
+					ts.tracer.finish(ctx, t, s, err)
+				}
+			},
+		}),
+	}
+	if ts.stateDir != "" {
+		opts = append(opts, apcupsdexporter.WithStatePath(filepath.Join(ts.stateDir, url.QueryEscape(t.Name)+".json")))
+	}
+	if t.NominalRuntime > 0 {
+		opts = append(opts, apcupsdexporter.WithNominalRuntime(t.NominalRuntime, t.NominalRuntimeLoad))
+	}
+	labels := make(prometheus.Labels)
+	if multiTarget() {
+		// Distinguish the metrics of each configured target, and label them
+		// with its groups.
+		labels["target"] = t.Name
+		for l, g := range t.Groups {
+			labels[l] = g
+		}
+	}
+	if *addressLabel != "" {
+		labels[*addressLabel] = t.addressLabelValue()
+	}
+	if *reverseDNSLabel != "" {
+		labels[*reverseDNSLabel] = t.reverseName
+	}
+	if len(labels) > 0 {
+		// The targetSet is an unchecked collector, so the collectors of all
+		// targets can share their descriptors, rather than each holding
+		// descriptors with its own labels.
+		opts = append(opts, apcupsdexporter.WithTargetLabels(labels))
+	}
+
+	dial := apcupsdexporter.NewLoggingDialFunc(t.dialFunc(), logger, *logTraceMaxBytes, *logTraceInterval)
+	tgt.c = apcupsdexporter.NewWithDialFunc(dial, opts...)
+	return tgt
+}
+
+// Describe implements prometheus.Collector.  It sends no descriptors, making
+// the targetSet an unchecked collector.
+func (ts *targetSet) Describe(_ chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector, collecting metrics from all
+// targets concurrently, followed by the heartbeat, whether each target is in
+// maintenance, and the refreshes of the targets.  When targets are configured
+// by -config.file or -apcupsd.addresses, metrics aggregated across all
+// targets, and across the targets of each group, are collected as well.
+func (ts *targetSet) Collect(ch chan<- prometheus.Metric) {
+	ts.mu.RLock()
+	targets, groups, levels, cfgs := ts.targets, ts.groups, ts.cfg.GroupLevels, ts.cfg.Targets
+	ts.mu.RUnlock()
+
+	tick := pollTick(targets)
+	var wg sync.WaitGroup
+	wg.Add(len(targets))
+	for _, t := range targets {
+		go func(t *target) {
+			defer wg.Done()
+			t.collect(ch, tick)
+		}(t)
+	}
+	wg.Wait()
+	collectHeartbeat(ch, targets)
+	ts.maintenance.collect(ch, cfgs)
+	ts.discovery.collect(ch)
+
+	if !multiTarget() {
+		return
+	}
+
+	var f fleet
+	for _, t := range targets {
+		f.add(t)
+	}
+	f.collect(ch, fleetDescs)
+
+	if groups != nil {
+		collectGroups(ch, groups, levels, targets)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTargetSetReloadRemovesTargets(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+		if err != nil {
+			t.Fatalf("failed to create fake NIS: %v", err)
+		}
+		defer s.Close()
+
+		addrs = append(addrs, s.Addr().String())
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig := func(addrs ...string) {
+		b := []byte("targets:\n")
+		for i, a := range addrs {
+			b = fmt.Appendf(b, "  - name: ups%d\n    address: %s\n", i, a)
+		}
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	writeConfig(addrs...)
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	if n := upTargets(t, reg); n != 2 {
+		t.Fatalf("expected 2 targets before reload, but got %d", n)
+	}
+
+	writeConfig(addrs[0])
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	// The series of the removed target must disappear at the very next
+	// scrape, including from the fleet aggregates.
+	if n := upTargets(t, reg); n != 1 {
+		t.Fatalf("expected 1 target after reload, but got %d", n)
+	}
+
+	want := `
+# HELP apcupsd_fleet_targets Number of all configured apcupsd targets.
+# TYPE apcupsd_fleet_targets gauge
+apcupsd_fleet_targets 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_fleet_targets"); err != nil {
+		t.Fatalf("unexpected fleet metrics: %v", err)
+	}
+}
+
+func TestTargetSetReloadKeepsUnchangedTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig := func(hostname string) {
+		b := fmt.Appendf(nil, "targets:\n  - address: ups1\n  - address: ups2\n    hostname: %s\n", hostname)
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+	}
+
+	prev := *configFile
+	*configFile = path
+	defer func() { *configFile = prev }()
+
+	writeConfig("rack1")
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	before := ts.targets
+
+	writeConfig("rack1")
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if ts.targets[0] != before[0] || ts.targets[1] != before[1] {
+		t.Fatal("unchanged targets were recreated by a reload")
+	}
+
+	writeConfig("rack2")
+	if err := ts.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if ts.targets[0] != before[0] {
+		t.Fatal("unchanged target was recreated by a reload")
+	}
+	if ts.targets[1] == before[1] {
+		t.Fatal("changed target was not recreated by a reload")
+	}
+}
+
+func TestTargetSetState(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	st := &storage{path: t.TempDir()}
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), st)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	// The battery capacity estimate of the fully charged UPS is persisted.
+	path := filepath.Join(st.path, "state", url.QueryEscape(s.Addr().String())+".json")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+}
+
+// upTargets returns the number of apcupsd_up series gathered from g.
+func upTargets(t *testing.T, g prometheus.Gatherer) int {
+	t.Helper()
+
+	n, err := testutil.GatherAndCount(g, "apcupsd_up")
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	return n
+}
+
+func TestTargetSetReady(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Error(errors.New("not yet")))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prev := *apcupsdAddr
+	*apcupsdAddr = s.Addr().String()
+	defer func() { *apcupsdAddr = prev }()
+
+	ts, err := newTargetSet(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+
+	p := &poller{c: ts}
+	p.poll()
+	if ts.ready() {
+		t.Fatal("target set is ready before a successful collection")
+	}
+
+	s.SetHandler(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	p.poll()
+	if !ts.ready() {
+		t.Fatal("target set is not ready after a successful collection")
+	}
+
+	// Once ready, failures do not make the target set unready.
+	s.SetHandler(apcupsdtest.Error(errors.New("gone")))
+	p.poll()
+	if !ts.ready() {
+		t.Fatal("target set became unready after a failed collection")
+	}
+
+	// The poller serves the metrics of the last poll.
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(p)
+
+	want := `
+# HELP apcupsd_up Whether the last collection of UPS metrics from apcupsd was successful.
+# TYPE apcupsd_up gauge
+apcupsd_up 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "apcupsd_up"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
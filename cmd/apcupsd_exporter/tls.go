@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	tlsCertFile = flag.String("web.tls-cert-file", "", "path to a PEM certificate chain with which HTTP is served over TLS; the certificate is reloaded when it or its key change, and on SIGHUP")
+	tlsKeyFile  = flag.String("web.tls-key-file", "", "path to the PEM private key of -web.tls-cert-file")
+)
+
+// tlsCheckInterval is the minimum interval at which the certificate and key
+// files are checked for changes during TLS handshakes.
+const tlsCheckInterval = 10 * time.Second
+
+// A certReloader serves the certificate in a pair of files, and reloads it
+// when the files change, so that rotated certificates are served on
+// subsequent handshakes without a restart.
+type certReloader struct {
+	certFile, keyFile string
+	logger            *slog.Logger
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+
+	// mod holds the modification times of the certificate and key files
+	// when cert was loaded, and checked the time they were last checked.
+	mod     [2]time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the certificate set by -web.tls-cert-file and
+// -web.tls-key-file, returning nil if TLS is not enabled.
+func newCertReloader(logger *slog.Logger) (*certReloader, error) {
+	if *tlsCertFile == "" && *tlsKeyFile == "" {
+		return nil, nil
+	}
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		return nil, errors.New("-web.tls-cert-file and -web.tls-key-file must be set together")
+	}
+
+	r := &certReloader{certFile: *tlsCertFile, keyFile: *tlsKeyFile, logger: logger}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// tlsConfig returns the TLS configuration of a server which serves the
+// certificate of r.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// reload loads the certificate from its files.  If it cannot be loaded, the
+// previous certificate continues to be served.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	return r.load(mod)
+}
+
+// getCertificate implements tls.Config.GetCertificate, reloading the
+// certificate if its files changed since they were last checked.
+func (r *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= tlsCheckInterval {
+		r.checked = now
+
+		mod, err := r.modTimes()
+		if err == nil && mod != r.mod {
+			if err = r.load(mod); err == nil {
+				r.logger.Info("reloaded TLS certificate", "file", r.certFile)
+			}
+		}
+		if err != nil {
+			// The files may be rewritten one at a time, so retry on the
+			// next check.
+			r.logger.Warn("failed to reload TLS certificate, serving the previous one", "err", err)
+		}
+	}
+
+	return r.cert, nil
+}
+
+// modTimes returns the modification times of the certificate and key files.
+// The files are followed through symlinks, which are swapped when Kubernetes
+// updates a mounted secret.
+func (r *certReloader) modTimes() ([2]time.Time, error) {
+	var mod [2]time.Time
+	for i, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return mod, fmt.Errorf("failed to read TLS certificate: %v", err)
+		}
+		mod[i] = fi.ModTime()
+	}
+
+	return mod, nil
+}
+
+// load loads the certificate, whose files were modified at mod.  r.mu must be
+// held.
+func (r *certReloader) load(mod [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	r.cert, r.mod = &cert, mod
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	served := func(r *certReloader) *big.Int {
+		// Skip the interval between checks for changes.
+		r.checked = time.Time{}
+
+		cert, err := r.getCertificate(nil)
+		if err != nil {
+			t.Fatalf("failed to get certificate: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+
+		return leaf.SerialNumber
+	}
+
+	now := time.Now()
+	first := writeTestCert(t, certFile, keyFile, "ups.example.com", now.Add(-time.Minute))
+
+	defer func(c, k string) { *tlsCertFile, *tlsKeyFile = c, k }(*tlsCertFile, *tlsKeyFile)
+	*tlsCertFile, *tlsKeyFile = certFile, keyFile
+
+	r, err := newCertReloader(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	if got := served(r); got.Cmp(first) != 0 {
+		t.Fatalf("unexpected initial certificate: %v", got)
+	}
+
+	// A rotated certificate is served on the next handshake.
+	second := writeTestCert(t, certFile, keyFile, "ups.example.com", now)
+	if got := served(r); got.Cmp(second) != 0 {
+		t.Fatalf("rotated certificate was not served: %v", got)
+	}
+
+	// A broken key keeps the previous certificate.
+	if err := os.WriteFile(keyFile, []byte("broken"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("expected an error reloading a broken key")
+	}
+	if got := served(r); got.Cmp(second) != 0 {
+		t.Fatalf("previous certificate was not served: %v", got)
+	}
+}
+
+// writeTestCert writes a new self-signed certificate for name, which may be a
+// host name or IP address, and its key to certFile and keyFile, modified at
+// mod, and returns its serial number.
+func writeTestCert(t *testing.T, certFile, keyFile, name string, mod time.Time) *big.Int {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(mod.UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             mod.Add(-time.Hour),
+		NotAfter:              mod.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	for f, b := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(f, pem.EncodeToMemory(b), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", f, err)
+		}
+		if err := os.Chtimes(f, mod, mod); err != nil {
+			t.Fatalf("failed to set modification time: %v", err)
+		}
+	}
+
+	return tmpl.SerialNumber
+}
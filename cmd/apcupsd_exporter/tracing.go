@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	tracingEndpoint    = flag.String("tracing.otlp-endpoint", "", "base URL of an OpenTelemetry OTLP/HTTP receiver, such as http://localhost:4318, to which a trace of each collection from apcupsd is exported, with spans of its dial, request, and parse phases; empty disables tracing")
+	tracingServiceName = flag.String("tracing.service-name", "apcupsd_exporter", "service.name resource attribute of exported traces, which distinguishes the exporters of a fleet when combined with -tracing.instance")
+	tracingInstance    = flag.String("tracing.instance", "", "service.instance.id resource attribute of exported traces (default: the hostname)")
+	tracingSample      = flag.Float64("tracing.sample", 1, "fraction of successful collections to trace, between 0 and 1; failed collections are always traced, and those of scrapes with a traceparent header are traced if the caller sampled its trace")
+)
+
+const (
+	// tracingExportInterval is the interval at which batches of spans are
+	// exported.
+	tracingExportInterval = 5 * time.Second
+
+	// maxQueuedSpans bounds the spans awaiting export, so that an
+	// unreachable receiver does not exhaust memory.  Spans beyond it are
+	// dropped.
+	maxQueuedSpans = 4096
+
+	// maxExportBackoff bounds the backoff between retries of an export to
+	// an unavailable receiver, which starts at tracingExportInterval and
+	// doubles with each failure.
+	maxExportBackoff = 5 * time.Minute
+)
+
+// A tracer records the collections from apcupsd targets as spans, and exports
+// them in batches to an OTLP/HTTP receiver.
+type tracer struct {
+	url      string
+	resource []spanAttr
+	sample   float64
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	spans   []span
+	dropped int
+
+	// backoff is the current backoff after failed exports, and retryAt the
+	// time before which no export is attempted.
+	backoff time.Duration
+	retryAt time.Time
+
+	// scrapeMu serves the scrapes which join the trace of their caller
+	// exclusively, so that parent is only joined by their collections.
+	scrapeMu sync.RWMutex
+	parent   atomic.Pointer[traceParent]
+}
+
+// A span is a span of a trace, as defined by OpenTelemetry.
+type span struct {
+	traceID          [16]byte
+	spanID, parentID [8]byte
+	name             string
+	start, end       time.Time
+	attrs            []spanAttr
+
+	// err, if set, marks the span as failed.
+	err string
+}
+
+// A spanAttr is a string attribute of a span or resource.
+type spanAttr struct {
+	key, value string
+}
+
+// newTracer returns a tracer configured by the tracing flags, or nil if
+// tracing is disabled.
+func newTracer(logger *slog.Logger) (*tracer, error) {
+	if *tracingEndpoint == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(*tracingEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", *tracingEndpoint)
+	}
+	if s := *tracingSample; s < 0 || s > 1 {
+		return nil, fmt.Errorf("invalid tracing sample rate %v: must be between 0 and 1", s)
+	}
+
+	instance := *tracingInstance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	resource := []spanAttr{{"service.name", *tracingServiceName}}
+	if instance != "" {
+		resource = append(resource, spanAttr{"service.instance.id", instance})
+	}
+
+	return &tracer{
+		url:      strings.TrimSuffix(u.String(), "/") + "/v1/traces",
+		resource: resource,
+		sample:   *tracingSample,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+	}, nil
+}
+
+// A traceParent is the span of a caller, such as Prometheus, as propagated by
+// the traceparent header of the W3C Trace Context specification.
+type traceParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// parseTraceParent parses the value of a traceparent header, and reports
+// whether it is valid.
+func parseTraceParent(v string) (traceParent, bool) {
+	// The fields are version-traceid-parentid-flags in lowercase hex, to
+	// which versions after 00 may append fields.
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' || strings.ToLower(v) != v {
+		return traceParent{}, false
+	}
+
+	var (
+		p              traceParent
+		version, flags [1]byte
+	)
+	for _, f := range []struct {
+		dst []byte
+		src string
+	}{
+		{dst: version[:], src: v[:2]},
+		{dst: p.traceID[:], src: v[3:35]},
+		{dst: p.spanID[:], src: v[36:52]},
+		{dst: flags[:], src: v[53:55]},
+	} {
+		if _, err := hex.Decode(f.dst, []byte(f.src)); err != nil {
+			return traceParent{}, false
+		}
+	}
+
+	switch {
+	case version[0] == 0xff:
+		return traceParent{}, false
+	case version[0] == 0 && len(v) != 55:
+		return traceParent{}, false
+	case len(v) > 55 && v[55] != '-':
+		return traceParent{}, false
+	case p.traceID == [16]byte{} || p.spanID == [8]byte{}:
+		return traceParent{}, false
+	}
+
+	p.sampled = flags[0]&1 != 0
+	return p, true
+}
+
+// withTraceParent returns a handler which serves h, making the collections
+// of a request with a valid traceparent header join the trace of its caller.
+// Such requests are served one at a time and apart from others, so that only
+// their own collections join the trace.
+func (tr *tracer) withTraceParent(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := parseTraceParent(r.Header.Get("traceparent"))
+		if !ok {
+			tr.scrapeMu.RLock()
+			defer tr.scrapeMu.RUnlock()
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		tr.scrapeMu.Lock()
+		defer tr.scrapeMu.Unlock()
+
+		tr.parent.Store(&p)
+		defer tr.parent.Store(nil)
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+type traceStartKey struct{}
+
+// A traceStart is the start of a traced collection, and the span of the
+// caller whose trace it joins, if any.
+type traceStart struct {
+	at     time.Time
+	parent *traceParent
+}
+
+// start returns a context which records the start of a collection, which
+// joins the trace of parent if set, or else that of the scrape being served
+// by withTraceParent, if any.
+func (tr *tracer) start(ctx context.Context, parent *traceParent) context.Context {
+	if parent == nil {
+		parent = tr.parent.Load()
+	}
+
+	return context.WithValue(ctx, traceStartKey{}, traceStart{at: time.Now(), parent: parent})
+}
+
+// finish records the spans of the collection of ctx from the target t, which
+// retrieved s or failed with err, unless the collection is not sampled.
+func (tr *tracer) finish(ctx context.Context, t targetConfig, s *apcupsd.Status, err error) {
+	start, ok := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok {
+		return
+	}
+
+	// A caller decides whether its trace is sampled.
+	sampled := rand.Float64() < tr.sample
+	if start.parent != nil {
+		sampled = start.parent.sampled
+	}
+	if err == nil && !sampled {
+		return
+	}
+
+	spans := newCollectionSpans(t, start.at, time.Now(), apcupsdexporter.Phases(ctx), s, err)
+	if p := start.parent; p != nil {
+		for i := range spans {
+			spans[i].traceID = p.traceID
+		}
+		spans[0].parentID = p.spanID
+	}
+
+	tr.record(spans)
+}
+
+// newCollectionSpans returns the spans of a collection from the target t,
+// made between start and end: a root span of the collection, with a child
+// span for each of its phases.
+func newCollectionSpans(t targetConfig, start, end time.Time, phases []apcupsdexporter.Phase, s *apcupsd.Status, err error) []span {
+	root := span{
+		traceID: newTraceID(),
+		spanID:  newSpanID(),
+		name:    "apcupsd.collect",
+		start:   start,
+		end:     end,
+		attrs: []spanAttr{
+			{"apcupsd.target", t.Name},
+			{"server.address", t.Address},
+		},
+	}
+	if s != nil {
+		root.attrs = append(root.attrs, spanAttr{"apcupsd.ups_name", s.UPSName})
+	}
+	if err != nil {
+		root.err = err.Error()
+	}
+
+	spans := []span{root}
+	for i, p := range phases {
+		ps := span{
+			traceID:  root.traceID,
+			spanID:   newSpanID(),
+			parentID: root.spanID,
+			name:     "apcupsd." + p.Name,
+			start:    p.Start,
+			end:      p.Start.Add(p.Duration),
+		}
+		// Only the last phase reached can have failed.
+		if err != nil && i == len(phases)-1 {
+			ps.err = root.err
+		}
+
+		spans = append(spans, ps)
+	}
+
+	return spans
+}
+
+// newTraceID returns a random trace ID.
+func newTraceID() [16]byte {
+	var id [16]byte
+	_, _ = crand.Read(id[:])
+	return id
+}
+
+// newSpanID returns a random span ID.
+func newSpanID() [8]byte {
+	var id [8]byte
+	_, _ = crand.Read(id[:])
+	return id
+}
+
+// record queues spans for export.
+func (tr *tracer) record(spans []span) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if len(tr.spans)+len(spans) > maxQueuedSpans {
+		tr.dropped += len(spans)
+		return
+	}
+
+	tr.spans = append(tr.spans, spans...)
+}
+
+// run exports the queued spans at each tracingExportInterval, unless a
+// failed export is being backed off.
+func (tr *tracer) run() {
+	t := time.NewTicker(tracingExportInterval)
+	defer t.Stop()
+
+	for now := range t.C {
+		tr.mu.Lock()
+		wait := now.Before(tr.retryAt)
+		tr.mu.Unlock()
+		if wait {
+			continue
+		}
+
+		if err := tr.export(); err != nil {
+			tr.logger.Warn("failed to export traces", "err", err)
+		}
+	}
+}
+
+// A retryableError is an error of an export which may succeed if retried,
+// after at least the delay requested by the receiver, if any.
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+// Error implements error.
+func (e *retryableError) Error() string { return e.err.Error() }
+
+// export sends the queued spans to the OTLP receiver.  As the OTLP
+// specification requires, spans which fail to be exported because the
+// receiver is unreachable or temporarily unavailable are queued again, and
+// retried with exponential backoff.  Spans which the receiver rejects are
+// discarded.
+func (tr *tracer) export() error {
+	tr.mu.Lock()
+	spans, dropped := tr.spans, tr.dropped
+	tr.spans, tr.dropped = nil, 0
+	tr.mu.Unlock()
+
+	if dropped > 0 {
+		tr.logger.Warn("dropped spans exceeding the export queue", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	err := tr.send(spans)
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	var re *retryableError
+	if !errors.As(err, &re) {
+		tr.backoff, tr.retryAt = 0, time.Time{}
+		return err
+	}
+
+	// Queue the spans ahead of those recorded since, dropping the oldest
+	// if the queue overflows.
+	tr.spans = append(spans, tr.spans...)
+	if n := len(tr.spans) - maxQueuedSpans; n > 0 {
+		tr.spans = tr.spans[n:]
+		tr.dropped += n
+	}
+
+	tr.backoff = min(max(2*tr.backoff, tracingExportInterval), maxExportBackoff)
+	tr.retryAt = time.Now().Add(max(tr.backoff, re.after))
+	return err
+}
+
+// send sends spans to the OTLP receiver in a single request.
+func (tr *tracer) send(spans []span) error {
+	req, err := http.NewRequest(http.MethodPost, tr.url, bytes.NewReader(marshalTraces(tr.resource, spans)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", protobufContentType)
+
+	res, err := tr.client.Do(req)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		secs, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return &retryableError{
+			err:   fmt.Errorf("OTLP receiver returned HTTP %d", res.StatusCode),
+			after: time.Duration(secs) * time.Second,
+		}
+	default:
+		return fmt.Errorf("OTLP receiver returned HTTP %d", res.StatusCode)
+	}
+}
+
+// marshalTraces encodes spans as an ExportTraceServiceRequest message of the
+// OTLP protocol, with a single resource and instrumentation scope.  Fields
+// with zero values are omitted, as in proto3.
+func marshalTraces(resource []spanAttr, spans []span) []byte {
+	var res []byte
+	for _, a := range resource {
+		res = appendMessage(res, 1, marshalSpanAttr(a))
+	}
+
+	var name []byte
+	name = protowire.AppendTag(name, 1, protowire.BytesType)
+	name = protowire.AppendString(name, "github.com/mdlayher/apcupsd_exporter")
+
+	var scope []byte
+	scope = appendMessage(scope, 1, name)
+	for _, s := range spans {
+		scope = appendMessage(scope, 2, marshalSpan(s))
+	}
+
+	var rs []byte
+	rs = appendMessage(rs, 1, res)
+	rs = appendMessage(rs, 2, scope)
+
+	return appendMessage(nil, 1, rs)
+}
+
+// OTLP span kinds and status codes.
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+// marshalSpan encodes s as a Span message.
+func marshalSpan(s span) []byte {
+	var b []byte
+	appendBytes := func(num protowire.Number, v []byte) {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	appendTime := func(num protowire.Number, t time.Time) {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, uint64(t.UnixNano()))
+	}
+
+	appendBytes(1, s.traceID[:])
+	appendBytes(2, s.spanID[:])
+	if s.parentID != [8]byte{} {
+		appendBytes(4, s.parentID[:])
+	}
+	appendBytes(5, []byte(s.name))
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, otlpSpanKindInternal)
+	appendTime(7, s.start)
+	appendTime(8, s.end)
+	for _, a := range s.attrs {
+		appendBytes(9, marshalSpanAttr(a))
+	}
+
+	if s.err != "" {
+		var st []byte
+		st = protowire.AppendTag(st, 2, protowire.BytesType)
+		st = protowire.AppendString(st, s.err)
+		st = protowire.AppendTag(st, 3, protowire.VarintType)
+		st = protowire.AppendVarint(st, otlpStatusCodeError)
+		appendBytes(15, st)
+	}
+
+	return b
+}
+
+// marshalSpanAttr encodes a as a KeyValue message with a string value.
+func marshalSpanAttr(a spanAttr) []byte {
+	var v []byte
+	v = protowire.AppendTag(v, 1, protowire.BytesType)
+	v = protowire.AppendString(v, a.value)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, a.key)
+	return appendMessage(b, 2, v)
+}
+
+// appendMessage appends the embedded message m as field num to b.
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
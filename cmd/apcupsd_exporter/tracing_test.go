@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	apcupsdexporter "github.com/mdlayher/apcupsd_exporter"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestTargetTracing(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != protobufContentType {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer receiver.Close()
+
+	prevAddr, prevEndpoint := *apcupsdAddr, *tracingEndpoint
+	*apcupsdAddr, *tracingEndpoint = s.Addr().String(), receiver.URL
+	defer func() { *apcupsdAddr, *tracingEndpoint = prevAddr, prevEndpoint }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	ts.tracer, err = newTracer(logger)
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("failed to gather: %v", err)
+	}
+
+	if err := ts.tracer.export(); err != nil {
+		t.Fatalf("failed to export traces: %v", err)
+	}
+
+	// Decode the name and parent of each span of the single resource and
+	// scope of the ExportTraceServiceRequest.
+	var (
+		names  []string
+		rootID []byte
+	)
+	for _, rs := range protoMessages(t, <-bodies, 1) {
+		for _, ss := range protoMessages(t, rs, 2) {
+			for _, sp := range protoMessages(t, ss, 2) {
+				name := string(protoMessages(t, sp, 5)[0])
+				names = append(names, name)
+
+				id, parents := protoMessages(t, sp, 2)[0], protoMessages(t, sp, 4)
+				switch {
+				case name == "apcupsd.collect":
+					rootID = id
+				case len(parents) != 1 || string(parents[0]) != string(rootID):
+					t.Fatalf("span %q is not a child of the collection span", name)
+				}
+			}
+		}
+	}
+
+	if got, want := strings.Join(names, ","), "apcupsd.collect,apcupsd.dial,apcupsd.request,apcupsd.parse"; got != want {
+		t.Fatalf("unexpected spans:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestTracerTraceParent(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	prevAddr, prevEndpoint, prevSample := *apcupsdAddr, *tracingEndpoint, *tracingSample
+	*apcupsdAddr, *tracingEndpoint, *tracingSample = s.Addr().String(), "http://localhost:4318", 0
+	defer func() { *apcupsdAddr, *tracingEndpoint, *tracingSample = prevAddr, prevEndpoint, prevSample }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts, err := newTargetSet(logger, nil)
+	if err != nil {
+		t.Fatalf("failed to create target set: %v", err)
+	}
+	ts.tracer, err = newTracer(logger)
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+	h := ts.tracer.withTraceParent(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	scrape := func(traceparent string) []span {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if traceparent != "" {
+			r.Header.Set("traceparent", traceparent)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", w.Code)
+		}
+
+		spans := ts.tracer.spans
+		ts.tracer.spans = nil
+		return spans
+	}
+
+	// Collections are not sampled by the exporter, but by a caller which
+	// sampled its trace.
+	if spans := scrape(""); len(spans) != 0 {
+		t.Fatalf("unexpected spans of an unsampled collection: %d", len(spans))
+	}
+	if spans := scrape("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"); len(spans) != 0 {
+		t.Fatalf("unexpected spans of a collection unsampled by its caller: %d", len(spans))
+	}
+
+	spans := scrape("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if len(spans) == 0 {
+		t.Fatal("collection sampled by its caller was not traced")
+	}
+	for _, sp := range spans {
+		if got := hex.EncodeToString(sp.traceID[:]); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("span %q is not part of the trace of the caller: %s", sp.name, got)
+		}
+	}
+	if got := hex.EncodeToString(spans[0].parentID[:]); spans[0].name != "apcupsd.collect" || got != "00f067aa0ba902b7" {
+		t.Fatalf("span %q is not a child of the span of the caller: %s", spans[0].name, got)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		desc, v string
+		ok      bool
+		sampled bool
+	}{
+		{desc: "sampled", v: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: true, sampled: true},
+		{desc: "unsampled", v: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", ok: true},
+		{desc: "future version", v: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ok: true, sampled: true},
+		{desc: "empty", v: ""},
+		{desc: "short", v: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0"},
+		{desc: "version 00 with more fields", v: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{desc: "version ff", v: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{desc: "uppercase", v: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01"},
+		{desc: "not hex", v: "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01"},
+		{desc: "zero trace ID", v: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{desc: "zero parent ID", v: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{desc: "separator", v: "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			p, ok := parseTraceParent(tt.v)
+			if ok != tt.ok {
+				t.Fatalf("unexpected validity: want %v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+
+			if got := hex.EncodeToString(p.traceID[:]) + "-" + hex.EncodeToString(p.spanID[:]); got != tt.v[3:52] {
+				t.Fatalf("unexpected IDs: %s", got)
+			}
+			if p.sampled != tt.sampled {
+				t.Fatalf("unexpected sampled flag: want %v, got %v", tt.sampled, p.sampled)
+			}
+		})
+	}
+}
+
+func TestTracerExportRetry(t *testing.T) {
+	codes := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+	var requests int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if codes[requests] == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "60")
+		}
+		w.WriteHeader(codes[requests])
+		requests++
+	}))
+	defer receiver.Close()
+
+	prev := *tracingEndpoint
+	*tracingEndpoint = receiver.URL
+	defer func() { *tracingEndpoint = prev }()
+
+	tr, err := newTracer(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+
+	tc := targetConfig{Name: "ups", Address: "localhost:3551"}
+	tr.record(newCollectionSpans(tc, time.Now(), time.Now(), nil, nil, nil))
+
+	// The receiver is unavailable, so the spans must be kept for a retry
+	// no sooner than it requested.
+	if err := tr.export(); err == nil {
+		t.Fatal("expected an export error, but none occurred")
+	}
+	if len(tr.spans) != 1 {
+		t.Fatalf("unexpected queued spans after a failed export: %d", len(tr.spans))
+	}
+	if d := time.Until(tr.retryAt); d < 59*time.Second {
+		t.Fatalf("export retried too soon: %v", d)
+	}
+
+	if err := tr.export(); err != nil {
+		t.Fatalf("failed to retry export: %v", err)
+	}
+	if len(tr.spans) != 0 || tr.backoff != 0 || !tr.retryAt.IsZero() {
+		t.Fatalf("unexpected tracer state after a retried export: %d spans, backoff %v", len(tr.spans), tr.backoff)
+	}
+
+	// A rejected export must not be retried.
+	tr.record(newCollectionSpans(tc, time.Now(), time.Now(), nil, nil, nil))
+	if err := tr.export(); err == nil {
+		t.Fatal("expected an export error, but none occurred")
+	}
+	if len(tr.spans) != 0 {
+		t.Fatalf("unexpected queued spans after a rejected export: %d", len(tr.spans))
+	}
+	if requests != len(codes) {
+		t.Fatalf("unexpected number of requests: %d", requests)
+	}
+}
+
+func TestTracerExportQueueBounded(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer receiver.Close()
+
+	prev := *tracingEndpoint
+	*tracingEndpoint = receiver.URL
+	defer func() { *tracingEndpoint = prev }()
+
+	tr, err := newTracer(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+
+	tc := targetConfig{Name: "ups", Address: "localhost:3551"}
+	for i := 0; i < maxQueuedSpans; i++ {
+		tr.record(newCollectionSpans(tc, time.Now(), time.Now(), nil, nil, nil))
+	}
+	if err := tr.export(); err == nil {
+		t.Fatal("expected an export error, but none occurred")
+	}
+	backoff := tr.backoff
+
+	// Spans recorded while backing off must not grow the queue beyond its
+	// bound, and the backoff must grow with each failure.
+	tr.record(newCollectionSpans(tc, time.Now(), time.Now(), nil, nil, nil))
+	if err := tr.export(); err == nil {
+		t.Fatal("expected an export error, but none occurred")
+	}
+	if len(tr.spans) != maxQueuedSpans {
+		t.Fatalf("unexpected number of queued spans: %d", len(tr.spans))
+	}
+	if tr.backoff != 2*backoff {
+		t.Fatalf("unexpected backoff: want %v, got %v", 2*backoff, tr.backoff)
+	}
+}
+
+func TestNewCollectionSpansError(t *testing.T) {
+	start := time.Now()
+	phases := []apcupsdexporter.Phase{
+		{Name: "dial", Start: start, Duration: time.Millisecond},
+		{Name: "request", Start: start.Add(time.Millisecond), Duration: time.Millisecond},
+	}
+
+	spans := newCollectionSpans(targetConfig{Name: "ups"}, start, start.Add(2*time.Millisecond), phases, nil, errors.New("timeout"))
+	if len(spans) != 3 {
+		t.Fatalf("unexpected number of spans: %d", len(spans))
+	}
+
+	// The collection and the phase which failed are marked as failed.
+	for i, want := range []string{"timeout", "", "timeout"} {
+		if spans[i].err != want {
+			t.Fatalf("unexpected error of span %q: want %q, got %q", spans[i].name, want, spans[i].err)
+		}
+		if i > 0 && spans[i].parentID != spans[0].spanID {
+			t.Fatalf("span %q is not a child of the collection span", spans[i].name)
+		}
+	}
+}
+
+func TestNewTracerInvalid(t *testing.T) {
+	prevEndpoint, prevSample := *tracingEndpoint, *tracingSample
+	defer func() { *tracingEndpoint, *tracingSample = prevEndpoint, prevSample }()
+
+	tests := []struct {
+		desc     string
+		endpoint string
+		sample   float64
+	}{
+		{desc: "scheme", endpoint: "grpc://localhost:4317", sample: 1},
+		{desc: "host", endpoint: "http://", sample: 1},
+		{desc: "negative sample", endpoint: "http://localhost:4318", sample: -0.1},
+		{desc: "large sample", endpoint: "http://localhost:4318", sample: 1.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			*tracingEndpoint, *tracingSample = tt.endpoint, tt.sample
+			if _, err := newTracer(slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+// protoMessages returns the values of each length-delimited field num of the
+// protobuf message b.
+func protoMessages(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	t.Helper()
+
+	var out [][]byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			t.Fatalf("failed to decode tag: %v", protowire.ParseError(l))
+		}
+		b = b[l:]
+
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatalf("failed to decode field %d: %v", num, protowire.ParseError(l))
+			}
+			out = append(out, v)
+			b = b[l:]
+			continue
+		}
+
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			t.Fatalf("failed to decode field %d: %v", n, protowire.ParseError(l))
+		}
+		b = b[l:]
+	}
+
+	return out
+}
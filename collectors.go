@@ -0,0 +1,67 @@
+package apcupsdexporter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Names of the sub-collectors which make up a UPSCollector.
+const (
+	CollectorBattery     = "battery"
+	CollectorInputLine   = "input_line"
+	CollectorOutput      = "output"
+	CollectorStatus      = "status"
+	CollectorSelftest    = "selftest"
+	CollectorEnvironment = "environment"
+)
+
+// A statusCollector is a prometheus.Collector which produces metrics from a
+// single apcupsd.Status.  This allows a UPSCollector to retrieve the status
+// once per scrape and share it among all of its sub-collectors.
+type statusCollector interface {
+	prometheus.Collector
+	collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status)
+}
+
+// collectors is the registry of all available sub-collectors, keyed by name.
+var collectors = map[string]func(ss StatusSource, o *options) statusCollector{
+	CollectorBattery:     func(ss StatusSource, o *options) statusCollector { return newBatteryCollector(ss, o) },
+	CollectorInputLine:   func(ss StatusSource, o *options) statusCollector { return newInputLineCollector(ss, o) },
+	CollectorOutput:      func(ss StatusSource, o *options) statusCollector { return newOutputCollector(ss, o) },
+	CollectorStatus:      func(ss StatusSource, o *options) statusCollector { return newStatusCollector(ss, o) },
+	CollectorSelftest:    func(ss StatusSource, o *options) statusCollector { return newSelftestCollector(ss, o) },
+	CollectorEnvironment: func(ss StatusSource, o *options) statusCollector { return newEnvironmentCollector(ss, o) },
+}
+
+// CollectorNames returns the names of all available sub-collectors in
+// sorted order.
+func CollectorNames() []string {
+	names := make([]string, 0, len(collectors))
+	for n := range collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewCollector creates the sub-collector registered under name, which
+// retrieves UPS status information from ss.
+func NewCollector(name string, ss StatusSource, opts ...Option) (prometheus.Collector, error) {
+	fn, ok := collectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown collector %q", name)
+	}
+
+	return fn(ss, newOptions(opts)), nil
+}
+
+// describe sends each of ds to ch.
+func describe(ch chan<- *prometheus.Desc, ds ...*prometheus.Desc) {
+	for _, d := range ds {
+		ch <- d
+	}
+}
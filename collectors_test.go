@@ -0,0 +1,499 @@
+package apcupsdexporter
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSubCollectors(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			Hostname: "foo",
+			Model:    "APC UPS",
+			UPSName:  "bar",
+
+			BatteryChargePercent: 100.0,
+			LineVoltage:          121.1,
+			OutputVoltage:        120.9,
+			Status:               "ONBATT",
+			InternalTemp:         26.4,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		match   *regexp.Regexp
+		exclude *regexp.Regexp
+	}{
+		{
+			name:    CollectorBattery,
+			match:   regexp.MustCompile(`apcupsd_battery_charge_percent{hostname="foo",model="APC UPS",ups_name="bar"} 100`),
+			exclude: regexp.MustCompile(`apcupsd_line_volts`),
+		},
+		{
+			name:    CollectorInputLine,
+			match:   regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="APC UPS",ups_name="bar"} 121.1`),
+			exclude: regexp.MustCompile(`apcupsd_output_volts`),
+		},
+		{
+			name:    CollectorOutput,
+			match:   regexp.MustCompile(`apcupsd_output_volts{hostname="foo",model="APC UPS",ups_name="bar"} 120.9`),
+			exclude: regexp.MustCompile(`apcupsd_status`),
+		},
+		{
+			name:    CollectorStatus,
+			match:   regexp.MustCompile(`apcupsd_status{hostname="foo",model="APC UPS",status="ONBATT",ups_name="bar"} 1`),
+			exclude: regexp.MustCompile(`apcupsd_battery_charge_percent`),
+		},
+		{
+			name:    CollectorSelftest,
+			match:   regexp.MustCompile(`apcupsd_last_selftest_time_seconds{hostname="foo",model="APC UPS",ups_name="bar"} 0`),
+			exclude: regexp.MustCompile(`apcupsd_internal_temperature_celsius`),
+		},
+		{
+			name:    CollectorEnvironment,
+			match:   regexp.MustCompile(`apcupsd_internal_temperature_celsius{hostname="foo",model="APC UPS",ups_name="bar"} 26.4`),
+			exclude: regexp.MustCompile(`apcupsd_last_selftest_time_seconds`),
+		},
+	}
+
+	if len(tests) != len(CollectorNames()) {
+		t.Fatalf("unexpected number of collectors: %v", CollectorNames())
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCollector(tt.name, ss)
+			if err != nil {
+				t.Fatalf("failed to create collector: %v", err)
+			}
+
+			out := testCollector(t, c)
+
+			if !tt.match.Match(out) {
+				t.Fatalf("output failed to match regex (regexp: %v)", tt.match)
+			}
+			if tt.exclude.Match(out) {
+				t.Fatalf("output unexpectedly matched regex (regexp: %v)", tt.exclude)
+			}
+		})
+	}
+}
+
+func TestNewCollectorUnknown(t *testing.T) {
+	if _, err := NewCollector("foo", &testStatusSource{}); err == nil {
+		t.Fatal("expected an error for an unknown collector, but none occurred")
+	}
+}
+
+func TestSubCollectorStatusError(t *testing.T) {
+	c := NewBatteryCollector(
+		&testStatusSource{err: errors.New("no status")},
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+	)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	if _, err := reg.Gather(); err == nil {
+		t.Fatal("expected an error gathering metrics, but none occurred")
+	}
+}
+
+func TestBatteryCollectorReplacements(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			UPSName:     "ups",
+			BatteryDate: "2019-01-01",
+			Date:        time.Unix(1000, 0),
+		},
+	}
+	c := NewBatteryCollector(ss)
+
+	steps := []struct {
+		date    string
+		match   *regexp.Regexp
+		exclude *regexp.Regexp
+	}{
+		{
+			date:    "2019-01-01",
+			match:   regexp.MustCompile(`apcupsd_battery_replacements_total{hostname="",model="",ups_name="ups"} 0`),
+			exclude: regexp.MustCompile(`apcupsd_battery_last_replacement_time_seconds`),
+		},
+		{
+			// A missing battery date is not a replacement.
+			date:    "",
+			match:   regexp.MustCompile(`apcupsd_battery_replacements_total{hostname="",model="",ups_name="ups"} 0`),
+			exclude: regexp.MustCompile(`apcupsd_battery_last_replacement_time_seconds`),
+		},
+		{
+			date:  "2024-06-01",
+			match: regexp.MustCompile(`apcupsd_battery_replacements_total{hostname="",model="",ups_name="ups"} 1`),
+		},
+		{
+			date:  "2024-06-01",
+			match: regexp.MustCompile(`apcupsd_battery_last_replacement_time_seconds{hostname="",model="",ups_name="ups"} 1000`),
+		},
+	}
+
+	for i, st := range steps {
+		ss.s.BatteryDate = st.date
+		out := testCollector(t, c)
+
+		if !st.match.Match(out) {
+			t.Fatalf("step %d: output failed to match regex (regexp: %v)", i, st.match)
+		}
+		if st.exclude != nil && st.exclude.Match(out) {
+			t.Fatalf("step %d: output matched excluded regex (regexp: %v)", i, st.exclude)
+		}
+	}
+}
+
+func TestBatteryCollectorCapacityEstimatePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			UPSName:              "ups",
+			Status:               "ONLINE",
+			LoadPercent:          10,
+			BatteryChargePercent: 100,
+			TimeLeft:             45 * time.Minute,
+			Date:                 time.Unix(1, 0),
+		},
+	}
+	m := regexp.MustCompile(`apcupsd_battery_capacity_estimate_ratio{hostname="",model="",ups_name="ups"} (\S+)`)
+	if out := testCollector(t, NewBatteryCollector(ss, WithStatePath(path))); m.Match(out) {
+		t.Fatal("capacity estimated without enough history")
+	}
+
+	// A new collector, as after a restart, resumes from the persisted state.
+	ss.s.TimeLeft = 30 * time.Minute
+	ss.s.Date = time.Unix(1+int64(capacityHalfLife/time.Second), 0)
+	sm := m.FindSubmatch(testCollector(t, NewBatteryCollector(ss, WithStatePath(path))))
+	if sm == nil || string(sm[1]) != "0.8333333333333334" {
+		t.Fatalf("unexpected capacity estimate after restart: %q", sm)
+	}
+}
+
+func TestStatusCollectorCalibrations(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{UPSName: "ups"},
+	}
+	c := NewStatusCollector(ss)
+
+	steps := []struct {
+		status  string
+		date    int64
+		match   *regexp.Regexp
+		exclude *regexp.Regexp
+	}{
+		{
+			status:  "ONLINE",
+			date:    1000,
+			match:   regexp.MustCompile(`apcupsd_calibrations_total{hostname="",model="",ups_name="ups"} 0`),
+			exclude: regexp.MustCompile(`apcupsd_last_calibration_time_seconds`),
+		},
+		{
+			status:  "CAL ONLINE",
+			date:    2000,
+			match:   regexp.MustCompile(`apcupsd_last_calibration_time_seconds{hostname="",model="",ups_name="ups"} 2000`),
+			exclude: regexp.MustCompile(`apcupsd_last_calibration_duration_seconds`),
+		},
+		{
+			status: "CAL ONBATT",
+			date:   2300,
+			match:  regexp.MustCompile(`apcupsd_calibrations_total{hostname="",model="",ups_name="ups"} 1`),
+		},
+		{
+			status: "ONLINE",
+			date:   2600,
+			match:  regexp.MustCompile(`apcupsd_last_calibration_duration_seconds{hostname="",model="",ups_name="ups"} 600`),
+		},
+		{
+			status: "CAL ONLINE",
+			date:   5000,
+			match:  regexp.MustCompile(`apcupsd_calibrations_total{hostname="",model="",ups_name="ups"} 2`),
+			// The previous duration is cleared until this calibration
+			// completes.
+			exclude: regexp.MustCompile(`apcupsd_last_calibration_duration_seconds`),
+		},
+	}
+
+	for i, st := range steps {
+		ss.s.Status = st.status
+		ss.s.Date = time.Unix(st.date, 0)
+		out := testCollector(t, c)
+
+		if !st.match.Match(out) {
+			t.Fatalf("step %d: output failed to match regex (regexp: %v)", i, st.match)
+		}
+		if st.exclude != nil && st.exclude.Match(out) {
+			t.Fatalf("step %d: output matched excluded regex (regexp: %v)", i, st.exclude)
+		}
+	}
+}
+
+func TestStatusCollectorCalibrationInProgress(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{UPSName: "ups"},
+	}
+	c := NewStatusCollector(ss)
+
+	steps := []struct {
+		status  string
+		date    int64
+		match   *regexp.Regexp
+		exclude *regexp.Regexp
+	}{
+		{
+			// Already calibrating when first observed, so not counted.
+			status:  "CAL ONBATT",
+			date:    1000,
+			match:   regexp.MustCompile(`apcupsd_calibrations_total{hostname="",model="",ups_name="ups"} 0`),
+			exclude: regexp.MustCompile(`apcupsd_last_calibration_time_seconds`),
+		},
+		{
+			// Its duration is unknown, having started at an unknown time.
+			status:  "ONLINE",
+			date:    1300,
+			match:   regexp.MustCompile(`apcupsd_calibrations_total{hostname="",model="",ups_name="ups"} 0`),
+			exclude: regexp.MustCompile(`apcupsd_last_calibration_duration_seconds`),
+		},
+		{
+			status: "CAL ONLINE",
+			date:   2000,
+			match:  regexp.MustCompile(`apcupsd_calibrations_total{hostname="",model="",ups_name="ups"} 1`),
+		},
+	}
+
+	for i, st := range steps {
+		ss.s.Status = st.status
+		ss.s.Date = time.Unix(st.date, 0)
+		out := testCollector(t, c)
+
+		if !st.match.Match(out) {
+			t.Fatalf("step %d: output failed to match regex (regexp: %v)", i, st.match)
+		}
+		if st.exclude != nil && st.exclude.Match(out) {
+			t.Fatalf("step %d: output matched excluded regex (regexp: %v)", i, st.exclude)
+		}
+	}
+}
+
+func TestStatusCollectorShutdown(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{UPSName: "ups"},
+	}
+	c := NewStatusCollector(ss)
+
+	steps := []struct {
+		status  string
+		flags   string
+		date    int64
+		match   []*regexp.Regexp
+		exclude *regexp.Regexp
+	}{
+		{
+			status:  "ONBATT",
+			flags:   "0x05000010",
+			date:    1000,
+			match:   []*regexp.Regexp{regexp.MustCompile(`apcupsd_shutdown_in_progress{hostname="",model="",ups_name="ups"} 0`)},
+			exclude: regexp.MustCompile(`apcupsd_shutdown_(reason|start_time_seconds)`),
+		},
+		{
+			status: "SHUTTING DOWN",
+			flags:  "0x05280210",
+			date:   1300,
+			match: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_shutdown_in_progress{hostname="",model="",ups_name="ups"} 1`),
+				regexp.MustCompile(`apcupsd_shutdown_start_time_seconds{hostname="",model="",ups_name="ups"} 1300`),
+				regexp.MustCompile(`apcupsd_shutdown_reason{hostname="",model="",reason="battery_charge",ups_name="ups"} 1`),
+				regexp.MustCompile(`apcupsd_shutdown_reason{hostname="",model="",reason="runtime",ups_name="ups"} 1`),
+				regexp.MustCompile(`apcupsd_shutdown_reason{hostname="",model="",reason="remote",ups_name="ups"} 0`),
+			},
+		},
+		{
+			// The start of the shutdown is kept while it is in progress.
+			status: "SHUTTING DOWN",
+			flags:  "0x05280210",
+			date:   1330,
+			match:  []*regexp.Regexp{regexp.MustCompile(`apcupsd_shutdown_start_time_seconds{hostname="",model="",ups_name="ups"} 1300`)},
+		},
+		{
+			// Without STATFLAG, the reason is unknown.
+			status:  "SHUTTING DOWN",
+			date:    1360,
+			match:   []*regexp.Regexp{regexp.MustCompile(`apcupsd_shutdown_in_progress{hostname="",model="",ups_name="ups"} 1`)},
+			exclude: regexp.MustCompile(`apcupsd_shutdown_reason`),
+		},
+		{
+			status:  "ONLINE",
+			flags:   "0x05000008",
+			date:    2000,
+			match:   []*regexp.Regexp{regexp.MustCompile(`apcupsd_shutdown_in_progress{hostname="",model="",ups_name="ups"} 0`)},
+			exclude: regexp.MustCompile(`apcupsd_shutdown_start_time_seconds`),
+		},
+		{
+			// The shutdown bit alone indicates a shutdown.
+			status: "ONBATT",
+			flags:  "0x05800210",
+			date:   3000,
+			match: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_shutdown_start_time_seconds{hostname="",model="",ups_name="ups"} 3000`),
+				regexp.MustCompile(`apcupsd_shutdown_reason{hostname="",model="",reason="remote",ups_name="ups"} 1`),
+			},
+		},
+	}
+
+	for i, st := range steps {
+		ss.s.Status = st.status
+		ss.s.StatusFlags = st.flags
+		ss.s.Date = time.Unix(st.date, 0)
+		out := testCollector(t, c)
+
+		for _, m := range st.match {
+			if !m.Match(out) {
+				t.Fatalf("step %d: output failed to match regex (regexp: %v)", i, m)
+			}
+		}
+		if st.exclude != nil && st.exclude.Match(out) {
+			t.Fatalf("step %d: output matched excluded regex (regexp: %v)", i, st.exclude)
+		}
+	}
+}
+
+func TestStatusCollectorCompact(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{UPSName: "ups", Status: "ONBATT LOWBATT"},
+	}
+	out := testCollector(t, NewStatusCollector(ss, WithCompactStatus(true)))
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_status{hostname="",model="",status="ONBATT",ups_name="ups"} 1`),
+		regexp.MustCompile(`apcupsd_status{hostname="",model="",status="LOWBATT",ups_name="ups"} 1`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	if regexp.MustCompile(`apcupsd_status{.*} 0`).Match(out) {
+		t.Fatal("output contains a status flag which is not set")
+	}
+}
+
+func TestBatteryCollectorRuntimeMargin(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			UPSName:         "ups",
+			TimeLeft:        10 * time.Minute,
+			MinimumTimeLeft: 3 * time.Minute,
+		},
+	}
+	out := testCollector(t, NewBatteryCollector(ss, WithShutdownDuration(2*time.Minute)))
+
+	m := regexp.MustCompile(`apcupsd_runtime_margin_seconds{hostname="",model="",ups_name="ups"} 300`)
+	if !m.Match(out) {
+		t.Fatalf("output failed to match regex (regexp: %v)", m)
+	}
+
+	// Without TIMELEFT, the margin is unknown rather than negative.
+	ss.s.TimeLeft = 0
+	out = testCollector(t, NewBatteryCollector(ss, WithShutdownDuration(2*time.Minute)))
+	if m := regexp.MustCompile(`apcupsd_runtime_margin_seconds`); m.Match(out) {
+		t.Fatalf("output matched excluded regex (regexp: %v)", m)
+	}
+}
+
+func TestBatteryCollectorCapacityEstimate(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts []Option
+		want []string
+	}{
+		{
+			desc: "highest observed",
+			// Unknown until the samples span a half-life.
+			want: []string{"", "", "", "0.8333333333333334"},
+		},
+		{
+			desc: "nominal",
+			// 360 seconds at full load.
+			opts: []Option{WithNominalRuntime(60*time.Minute, 10)},
+			want: []string{"", "0.75", "0.75", "0.625"},
+		},
+	}
+
+	// Each step is a sample of charge, runtime left, and status date.
+	steps := []struct {
+		charge   float64
+		timeLeft time.Duration
+		date     int64
+	}{
+		// Not fully charged, so no estimate yet.
+		{charge: 80, timeLeft: 30 * time.Minute, date: 0},
+		{charge: 100, timeLeft: 45 * time.Minute, date: 0},
+		// Not fully charged, so ignored.
+		{charge: 90, timeLeft: 10 * time.Minute, date: int64(capacityHalfLife / time.Second)},
+		// One half-life later: halfway from 270 to 180 seconds at full load.
+		{charge: 100, timeLeft: 30 * time.Minute, date: int64(capacityHalfLife / time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ss := &testStatusSource{
+				s: &apcupsd.Status{UPSName: "ups", Status: "ONLINE", LoadPercent: 10},
+			}
+			c := NewBatteryCollector(ss, tt.opts...)
+
+			m := regexp.MustCompile(`apcupsd_battery_capacity_estimate_ratio{hostname="",model="",ups_name="ups"} (\S+)`)
+			for i, st := range steps {
+				ss.s.BatteryChargePercent = st.charge
+				ss.s.TimeLeft = st.timeLeft
+				ss.s.Date = time.Unix(1+st.date, 0)
+
+				var got string
+				if sm := m.FindSubmatch(testCollector(t, c)); sm != nil {
+					got = string(sm[1])
+				}
+				if got != tt.want[i] {
+					t.Fatalf("step %d: unexpected capacity estimate: %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestVoltsPerCell(t *testing.T) {
+	tests := []struct {
+		v, nominal float64
+		vpc        float64
+		ok         bool
+	}{
+		{v: 13.5, nominal: 12, vpc: 2.25, ok: true},
+		{v: 27.3, nominal: 24, vpc: 2.275, ok: true},
+		{v: 10.5, nominal: 12, vpc: 1.75, ok: true},
+		{v: 0, nominal: 24},
+		{v: 13.5, nominal: 0},
+		// Lithium batteries are not made of 2 V cells.
+		{v: 27.6, nominal: 25.6},
+	}
+
+	for _, tt := range tests {
+		vpc, ok := voltsPerCell(tt.v, tt.nominal)
+		if ok != tt.ok || math.Abs(vpc-tt.vpc) > 1e-9 {
+			t.Fatalf("voltsPerCell(%v, %v): want (%v, %v), got (%v, %v)",
+				tt.v, tt.nominal, tt.vpc, tt.ok, vpc, ok)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package apcupsdexporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultConfigPath is the default location of the apcupsd configuration
+// file.
+const DefaultConfigPath = "/etc/apcupsd/apcupsd.conf"
+
+// A ConfCollector is a Prometheus collector for the values of directives in
+// a local apcupsd configuration file, so that configuration drift across many
+// hosts can be detected centrally.  The file is read on each collection.
+//
+// Directives which are absent from the file are reported with the default
+// values used by apcupsd.
+type ConfCollector struct {
+	Up               *prometheus.Desc
+	BatteryLevel     *prometheus.Desc
+	TimeLeftSeconds  *prometheus.Desc
+	TimeoutSeconds   *prometheus.Desc
+	NetServerEnabled *prometheus.Desc
+
+	path string
+	o    *options
+}
+
+var _ prometheus.Collector = &ConfCollector{}
+
+// NewConfCollector creates a new ConfCollector which reads the apcupsd
+// configuration file at path.
+func NewConfCollector(path string, opts ...Option) *ConfCollector {
+	o := newOptions(opts)
+
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, "config", name),
+			help,
+			nil,
+			o.constLabels,
+		)
+	}
+
+	return &ConfCollector{
+		Up:               desc("up", "Whether the apcupsd configuration file was read successfully (1 for yes, 0 for no)."),
+		BatteryLevel:     desc("battery_level_percent", "Battery charge percentage at which apcupsd shuts down the system (BATTERYLEVEL)."),
+		TimeLeftSeconds:  desc("time_left_seconds", "Battery runtime left at which apcupsd shuts down the system (MINUTES)."),
+		TimeoutSeconds:   desc("timeout_seconds", "Time on battery after which apcupsd shuts down the system, or 0 if disabled (TIMEOUT)."),
+		NetServerEnabled: desc("netserver_enabled", "Whether the apcupsd Network Information Server is enabled (NETSERVER)."),
+
+		path: path,
+		o:    o,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *ConfCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch,
+		c.Up,
+		c.BatteryLevel,
+		c.TimeLeftSeconds,
+		c.TimeoutSeconds,
+		c.NetServerEnabled,
+	)
+}
+
+// Collect reads the configuration file and sends the metric values for each
+// metric created by the ConfCollector to the provided prometheus Metric
+// channel.
+func (c *ConfCollector) Collect(ch chan<- prometheus.Metric) {
+	cfg, err := c.read()
+	if err != nil {
+		c.o.logger.Error("failed reading apcupsd configuration", "path", c.path, "err", err)
+		ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(c.BatteryLevel, prometheus.GaugeValue, cfg.batteryLevel)
+	ch <- prometheus.MustNewConstMetric(c.TimeLeftSeconds, prometheus.GaugeValue, cfg.minutes*60)
+	ch <- prometheus.MustNewConstMetric(c.TimeoutSeconds, prometheus.GaugeValue, cfg.timeout)
+	ch <- prometheus.MustNewConstMetric(c.NetServerEnabled, prometheus.GaugeValue, cfg.netServer)
+}
+
+// read opens and parses the configuration file.
+func (c *ConfCollector) read() (*apcupsdConf, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseConf(f)
+}
+
+// An apcupsdConf holds the values of the directives of an apcupsd
+// configuration file exported by a ConfCollector.
+type apcupsdConf struct {
+	batteryLevel, minutes, timeout, netServer float64
+}
+
+// parseConf parses an apcupsd configuration file from r.  Directives are
+// matched case-insensitively, and unknown directives are ignored.
+func parseConf(r io.Reader) (*apcupsdConf, error) {
+	// Defaults from apcupsd.conf(5).
+	cfg := &apcupsdConf{
+		batteryLevel: 5,
+		minutes:      3,
+		netServer:    1,
+	}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var (
+			v   *float64
+			err error
+		)
+
+		switch strings.ToUpper(fields[0]) {
+		case "BATTERYLEVEL":
+			v = &cfg.batteryLevel
+		case "MINUTES":
+			v = &cfg.minutes
+		case "TIMEOUT":
+			v = &cfg.timeout
+		case "NETSERVER":
+			switch strings.ToLower(fields[1]) {
+			case "on":
+				cfg.netServer = 1
+			case "off":
+				cfg.netServer = 0
+			default:
+				err = fmt.Errorf("invalid value %q", fields[1])
+			}
+		default:
+			continue
+		}
+
+		if v != nil {
+			*v, err = strconv.ParseFloat(fields[1], 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s directive: %v", n, fields[0], err)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
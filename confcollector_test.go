@@ -0,0 +1,94 @@
+package apcupsdexporter
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestConfCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apcupsd.conf")
+	conf := `## apcupsd.conf v1.1 ##
+UPSNAME ups
+# MINUTES 10
+BATTERYLEVEL 20
+minutes 8
+TIMEOUT 0
+NETSERVER off
+`
+	if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	out := testCollector(t, NewConfCollector(path))
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_config_up 1`),
+		regexp.MustCompile(`apcupsd_config_battery_level_percent 20`),
+		regexp.MustCompile(`apcupsd_config_time_left_seconds 480`),
+		regexp.MustCompile(`apcupsd_config_timeout_seconds 0`),
+		regexp.MustCompile(`apcupsd_config_netserver_enabled 0`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
+func TestConfCollectorErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		conf string
+	}{
+		{
+			desc: "missing",
+		},
+		{
+			desc: "bad number",
+			conf: "MINUTES three\n",
+		},
+		{
+			desc: "bad netserver",
+			conf: "NETSERVER yes\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "apcupsd.conf")
+			if tt.conf != "" {
+				if err := os.WriteFile(path, []byte(tt.conf), 0o644); err != nil {
+					t.Fatalf("failed to write config: %v", err)
+				}
+			}
+
+			out := testCollector(t, NewConfCollector(path,
+				WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+			))
+
+			if m := regexp.MustCompile(`apcupsd_config_up 0`); !m.Match(out) {
+				t.Fatalf("output failed to match regex (regexp: %v)", m)
+			}
+			if strings.Contains(string(out), "apcupsd_config_battery_level_percent") {
+				t.Fatal("unexpected configuration metrics after error")
+			}
+		})
+	}
+}
+
+func TestParseConfDefaults(t *testing.T) {
+	cfg, err := parseConf(strings.NewReader("UPSCABLE usb\n"))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	want := apcupsdConf{batteryLevel: 5, minutes: 3, timeout: 0, netServer: 1}
+	if *cfg != want {
+		t.Fatalf("unexpected defaults:\n- want: %+v\n-  got: %+v", want, *cfg)
+	}
+}
@@ -0,0 +1,77 @@
+package apcupsdexporter
+
+import (
+	"math"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A DerivedMetric is a gauge whose value is computed from the UPS status by
+// an expression, such as the output power of a UPS in watts from its load
+// and nominal power, so that site-specific calculations do not require
+// recording rules.  It is labeled with the identity of the UPS, as the
+// metrics of the sub-collectors are.
+type DerivedMetric struct {
+	// Name is the name of the metric, which is prefixed with the namespace.
+	// It must not be the name of another metric of the exporter.
+	Name string
+
+	// Help is the help text of the metric.  If it is empty, the help text
+	// names the expression.
+	Help string
+
+	Expr *Expr
+}
+
+// WithDerivedMetrics adds metrics derived from the UPS status to a
+// UPSCollector or Exporter.  The value of a derived metric is omitted when it
+// is not finite, such as when dividing by a field which the UPS does not
+// report.
+func WithDerivedMetrics(ms ...DerivedMetric) Option {
+	return func(o *options) {
+		o.derivedMetrics = ms
+	}
+}
+
+// A derivedMetric is a DerivedMetric along with its descriptor.
+type derivedMetric struct {
+	d    *prometheus.Desc
+	expr *Expr
+}
+
+// newDerivedMetrics creates the descriptors of the derived metrics of o.
+func newDerivedMetrics(o *options) []derivedMetric {
+	ms := make([]derivedMetric, 0, len(o.derivedMetrics))
+	for _, m := range o.derivedMetrics {
+		help := m.Help
+		if help == "" {
+			help = "Derived from the UPS status by the expression: " + m.Expr.String()
+		}
+
+		ms = append(ms, derivedMetric{
+			d: newDesc(
+				prometheus.BuildFQName(o.namespace, "", m.Name),
+				help,
+				[]string{"ups_name", "hostname", "model"},
+				o.constLabels,
+			),
+			expr: m.Expr,
+		})
+	}
+
+	return ms
+}
+
+// collectDerived sends the values of the derived metrics computed from s to
+// ch.
+func (c *UPSCollector) collectDerived(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	for _, m := range c.derived {
+		v := m.expr.Eval(s)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
+		ch <- c.o.cache.metric(m.d, prometheus.GaugeValue, v, s)
+	}
+}
@@ -0,0 +1,121 @@
+package apcupsdexporter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// descs interns the descriptors of the metrics of UPSCollectors and their
+// sub-collectors, so that collectors whose metrics have identical names,
+// labels, and constant labels, such as those of many targets created with
+// WithTargetLabels, share a single descriptor of each metric.
+var descs = descCache{descs: make(map[string]*prometheus.Desc)}
+
+// A descCache is a set of interned descriptors, keyed by their identity.
+type descCache struct {
+	mu    sync.Mutex
+	descs map[string]*prometheus.Desc
+}
+
+// newDesc is like prometheus.NewDesc, but returns the interned descriptor of
+// an identical metric if there is one.
+func newDesc(fqName, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	// Separate the fields of the key with a byte which cannot occur in valid
+	// UTF-8, as in the identity of a prometheus.Desc.
+	var b strings.Builder
+	b.WriteString(fqName)
+	b.WriteByte(model.SeparatorByte)
+	b.WriteString(help)
+	for _, l := range variableLabels {
+		b.WriteByte(model.SeparatorByte)
+		b.WriteString(l)
+	}
+
+	names := make([]string, 0, len(constLabels))
+	for l := range constLabels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	for _, l := range names {
+		b.WriteByte(model.SeparatorByte)
+		b.WriteByte('=')
+		b.WriteString(l)
+		b.WriteByte(model.SeparatorByte)
+		b.WriteString(constLabels[l])
+	}
+	k := b.String()
+
+	descs.mu.Lock()
+	defer descs.mu.Unlock()
+
+	if d, ok := descs.descs[k]; ok {
+		return d
+	}
+
+	// Bound the number of descriptors as in metricCache, since collectors
+	// whose constant labels differ, such as those created with
+	// WithConstLabels for each of many targets, share none of them.
+	if len(descs.descs) >= maxCacheEntries {
+		descs.descs = make(map[string]*prometheus.Desc)
+	}
+
+	d := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+	descs.descs[k] = d
+	return d
+}
+
+// WithTargetLabels adds labels to all metrics of a UPSCollector or Exporter,
+// like WithConstLabels, but when the metrics are collected rather than in
+// their descriptors.  Collectors which differ only in these labels, such as
+// those of many apcupsd daemons each labeled with its own target name, then
+// share their descriptors, and only hold the label values of their own.
+//
+// Because its descriptors lack the labels, a collector created with
+// WithTargetLabels must not be registered on its own, but collected by an
+// unchecked collector, one whose Describe method sends no descriptors.
+func WithTargetLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		names := make([]string, 0, len(labels))
+		for l := range labels {
+			names = append(names, l)
+		}
+		sort.Strings(names)
+
+		o.targetLabels = make([]*dto.LabelPair, 0, len(names))
+		for _, l := range names {
+			name, value := l, labels[l]
+			o.targetLabels = append(o.targetLabels, &dto.LabelPair{Name: &name, Value: &value})
+		}
+	}
+}
+
+var _ prometheus.Metric = labeledMetric{}
+
+// A labeledMetric is a prometheus.Metric with additional label pairs, which
+// are shared between metrics and must not be modified.
+type labeledMetric struct {
+	prometheus.Metric
+	pairs []*dto.LabelPair
+}
+
+// Write implements prometheus.Metric.
+func (m labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+
+	// The label pairs of the metric may be shared as well, so merge them
+	// into a new slice, in the sorted order expected of label pairs.
+	pairs := make([]*dto.LabelPair, 0, len(out.Label)+len(m.pairs))
+	pairs = append(pairs, out.Label...)
+	pairs = append(pairs, m.pairs...)
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+
+	out.Label = pairs
+	return nil
+}
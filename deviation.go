@@ -0,0 +1,31 @@
+package apcupsdexporter
+
+import "math"
+
+// nominalDeviation returns the deviation of the voltage v from its nominal
+// value as a ratio, such as -0.05 for a voltage 5% below nominal, so that a
+// single alert threshold covers UPSes of every voltage class.  ok is false
+// if either voltage is not reported.
+func nominalDeviation(v, nominal float64) (ratio float64, ok bool) {
+	if v <= 0 || nominal <= 0 {
+		return 0, false
+	}
+
+	return (v - nominal) / nominal, true
+}
+
+// voltsPerCell returns the voltage per cell of a lead-acid battery at the
+// voltage v, whose string of 2 V cells makes up its nominal voltage, since
+// battery vendors specify float and end-of-discharge voltages per cell.  ok
+// is false if either voltage is not reported, or if nominal is not that of a
+// string of standard 12 V blocks, such as that of a lithium battery.
+func voltsPerCell(v, nominal float64) (vpc float64, ok bool) {
+	const block = 12
+
+	blocks := math.Round(nominal / block)
+	if v <= 0 || blocks < 1 || math.Abs(nominal-blocks*block) > 0.5 {
+		return 0, false
+	}
+
+	return v / (blocks * block / 2), true
+}
@@ -0,0 +1,85 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Target is an apcupsd Network Information Server discovered by a
+// Discoverer.
+type Target struct {
+	// Name optionally identifies the target, instead of its address.
+	Name string
+
+	// Address is the host and port of apcupsd.  The port defaults to 3551.
+	Address string
+}
+
+// A Discoverer discovers the apcupsd targets to collect metrics from, such as
+// from an inventory system.
+type Discoverer interface {
+	// Discover returns the current targets.  If it returns an error, the
+	// targets of its last successful call are kept.
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// A DiscovererFunc is a function which implements Discoverer.
+type DiscovererFunc func(ctx context.Context) ([]Target, error)
+
+// Discover implements Discoverer.
+func (fn DiscovererFunc) Discover(ctx context.Context) ([]Target, error) {
+	return fn(ctx)
+}
+
+var discoverers = struct {
+	mu sync.RWMutex
+	m  map[string]Discoverer
+}{m: make(map[string]Discoverer)}
+
+// RegisterDiscoverer makes a Discoverer available by name, so that the
+// apcupsd_exporter command can collect metrics from its targets when name is
+// listed by the discoverers of its configuration file.  It is typically called
+// from the init function of a package which the command imports for its side
+// effects.
+//
+// RegisterDiscoverer panics if d is nil or a Discoverer is already registered
+// by name.
+func RegisterDiscoverer(name string, d Discoverer) {
+	discoverers.mu.Lock()
+	defer discoverers.mu.Unlock()
+
+	if d == nil {
+		panic("apcupsdexporter: RegisterDiscoverer discoverer is nil")
+	}
+	if _, ok := discoverers.m[name]; ok {
+		panic(fmt.Sprintf("apcupsdexporter: RegisterDiscoverer called twice for discoverer %q", name))
+	}
+
+	discoverers.m[name] = d
+}
+
+// LookupDiscoverer returns the Discoverer registered by name, if any.
+func LookupDiscoverer(name string) (Discoverer, bool) {
+	discoverers.mu.RLock()
+	defer discoverers.mu.RUnlock()
+
+	d, ok := discoverers.m[name]
+	return d, ok
+}
+
+// DiscovererNames returns the names of all registered Discoverers in sorted
+// order.
+func DiscovererNames() []string {
+	discoverers.mu.RLock()
+	defer discoverers.mu.RUnlock()
+
+	names := make([]string, 0, len(discoverers.m))
+	for n := range discoverers.m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,38 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestRegisterDiscoverer(t *testing.T) {
+	want := []Target{{Name: "rack1", Address: "ups1:3551"}}
+	RegisterDiscoverer("test", DiscovererFunc(func(context.Context) ([]Target, error) {
+		return want, nil
+	}))
+
+	if !slices.Contains(DiscovererNames(), "test") {
+		t.Fatalf("discoverer is not registered: %v", DiscovererNames())
+	}
+
+	d, ok := LookupDiscoverer("test")
+	if !ok {
+		t.Fatal("failed to look up discoverer")
+	}
+	got, err := d.Discover(context.Background())
+	if err != nil || !slices.Equal(want, got) {
+		t.Fatalf("unexpected targets: %v, %v", got, err)
+	}
+
+	if _, ok := LookupDiscoverer("unknown"); ok {
+		t.Fatal("looked up an unregistered discoverer")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic registering a discoverer twice, but none occurred")
+		}
+	}()
+	RegisterDiscoverer("test", d)
+}
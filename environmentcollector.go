@@ -0,0 +1,61 @@
+package apcupsdexporter
+
+import (
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// An EnvironmentCollector is a Prometheus collector for metrics regarding the
+// environment of an APC UPS.
+type EnvironmentCollector struct {
+	InternalTemperatureCelsius *prometheus.Desc
+
+	ss StatusSource
+	o  *options
+}
+
+var _ statusCollector = &EnvironmentCollector{}
+
+// NewEnvironmentCollector creates a new EnvironmentCollector.
+func NewEnvironmentCollector(ss StatusSource, opts ...Option) *EnvironmentCollector {
+	return newEnvironmentCollector(ss, newOptions(opts))
+}
+
+// newEnvironmentCollector creates a new EnvironmentCollector using the input options.
+func newEnvironmentCollector(ss StatusSource, o *options) *EnvironmentCollector {
+	labels := []string{"ups_name", "hostname", "model"}
+
+	return &EnvironmentCollector{
+		InternalTemperatureCelsius: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "internal_temperature_celsius"),
+			"Internal temperature in °C.",
+			labels,
+			o.constLabels,
+		),
+
+		ss: ss,
+		o:  o,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *EnvironmentCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch, c.InternalTemperatureCelsius)
+}
+
+// Collect sends the metric values for each metric created by the
+// EnvironmentCollector to the provided prometheus Metric channel.
+func (c *EnvironmentCollector) Collect(ch chan<- prometheus.Metric) {
+	c.o.collectFrom(ch, c.ss, c.InternalTemperatureCelsius, c.collectStatus)
+}
+
+// collectStatus sends environment metrics derived from s to ch.
+func (c *EnvironmentCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	ch <- c.o.cache.metric(
+		c.InternalTemperatureCelsius,
+		prometheus.GaugeValue,
+		s.InternalTemp,
+		s,
+	)
+}
@@ -0,0 +1,81 @@
+package apcupsdexporter
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// An errorLog logs the errors of repeated collections, optionally suppressing
+// repetitions of the same error so that a long outage does not log an
+// identical line on every scrape.
+//
+// The first occurrence of an error is always logged.  Repetitions are then
+// summarized once per interval, and recovery from the error is logged.
+type errorLog struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	key        string
+	since      time.Time
+	logged     time.Time
+	count      int
+	suppressed int
+}
+
+// failed logs a collection which failed with err, classified as reason.
+func (el *errorLog) failed(logger *slog.Logger, reason string, err error) {
+	if el.interval <= 0 {
+		logger.Error("failed collecting UPS metrics", "reason", reason, "err", err)
+		return
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	now := time.Now()
+	key := reason + ": " + err.Error()
+	if key != el.key {
+		el.key, el.since, el.logged = key, now, now
+		el.count, el.suppressed = 1, 0
+
+		logger.Error("failed collecting UPS metrics", "reason", reason, "err", err)
+		return
+	}
+
+	el.count++
+	if now.Sub(el.logged) < el.interval {
+		el.suppressed++
+		return
+	}
+
+	logger.Error("still failing to collect UPS metrics",
+		"reason", reason,
+		"err", err,
+		"failures", el.count,
+		"since", el.since,
+		"suppressed", el.suppressed)
+
+	el.logged = now
+	el.suppressed = 0
+}
+
+// succeeded logs recovery from previously logged failures, if any.
+func (el *errorLog) succeeded(logger *slog.Logger) {
+	if el.interval <= 0 {
+		return
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	if el.key == "" {
+		return
+	}
+
+	logger.Info("recovered collecting UPS metrics",
+		"failures", el.count,
+		"duration", time.Since(el.since).Round(time.Second))
+
+	el.key = ""
+}
@@ -0,0 +1,127 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons for collection errors, reported by the collect_errors_total metric.
+// They distinguish a host which is down or unknown (dns, refused, connect,
+// timeout) from a daemon which is wedged or misbehaving (protocol, parse).
+const (
+	reasonDNS      = "dns"
+	reasonRefused  = "refused"
+	reasonConnect  = "connect"
+	reasonTimeout  = "timeout"
+	reasonRead     = "read"
+	reasonProtocol = "protocol"
+	reasonParse    = "parse"
+	reasonHook     = "hook"
+	reasonUnknown  = "unknown"
+)
+
+// reasons is the list of all collection error reasons.
+var reasons = []string{
+	reasonDNS,
+	reasonRefused,
+	reasonConnect,
+	reasonTimeout,
+	reasonRead,
+	reasonProtocol,
+	reasonParse,
+	reasonHook,
+	reasonUnknown,
+}
+
+// A reasonError is an error annotated with the reason reported in metrics.
+type reasonError struct {
+	reason string
+	err    error
+}
+
+// Error implements error.
+func (e *reasonError) Error() string { return e.err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *reasonError) Unwrap() error { return e.err }
+
+// errorReason classifies err as one of the collection error reasons.
+func errorReason(err error) string {
+	var (
+		re  *reasonError
+		ne  net.Error
+		dne *net.DNSError
+	)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &ne) && ne.Timeout():
+		return reasonTimeout
+	case errors.As(err, &dne):
+		return reasonDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reasonRefused
+	case errors.As(err, &re):
+		return re.reason
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		// The connection was closed in the middle of a response.
+		return reasonProtocol
+	case errors.As(err, &ne):
+		return reasonRead
+	default:
+		return reasonUnknown
+	}
+}
+
+// statusError annotates an error returned while retrieving the status from an
+// apcupsd client.  The client returns network errors as-is, so any other
+// error must have occurred while parsing its response.
+func statusError(err error) error {
+	if r := errorReason(err); r != reasonUnknown {
+		return err
+	}
+
+	return &reasonError{reason: reasonParse, err: err}
+}
+
+// newCollectErrorsTotal creates the counter of collection errors, with each
+// reason initialized to zero.
+func newCollectErrorsTotal(o *options) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Subsystem:   "exporter",
+			Name:        "collect_errors_total",
+			Help:        "Total number of errors encountered while collecting UPS metrics from apcupsd, by reason.",
+			ConstLabels: o.constLabels,
+		},
+		[]string{"reason"},
+	)
+
+	for _, r := range reasons {
+		c.WithLabelValues(r)
+	}
+
+	return c
+}
+
+// newParseErrorsTotal creates the counter of status fields which could not be
+// parsed.
+func newParseErrorsTotal(o *options) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Subsystem:   "exporter",
+			Name:        "parse_errors_total",
+			Help:        "Total number of status fields reported by apcupsd which could not be parsed, and were omitted from otherwise successful collections, by field.",
+			ConstLabels: o.constLabels,
+		},
+		[]string{"field"},
+	)
+}
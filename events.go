@@ -0,0 +1,126 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// An event is an entry of the event log of apcupsd.
+type event struct {
+	t   time.Time
+	msg string
+}
+
+// An eventSource is a source of the recent events logged by apcupsd.
+type eventSource interface {
+	events(ctx context.Context) ([]event, error)
+}
+
+var _ eventSource = &dialSource{}
+
+// events implements eventSource.
+func (ds *dialSource) events(ctx context.Context) ([]event, error) {
+	lines, err := ds.command(ctx, "events")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseEvents(lines, ds.loc), nil
+}
+
+// eventLayouts are the layouts of the timestamp which begins each event, with
+// and without a UTC offset.
+var eventLayouts = []string{
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// parseEvents parses the lines of an events response.  Timestamps without a
+// UTC offset are interpreted in loc, and lines without a timestamp are
+// ignored.
+func parseEvents(lines []string, loc *time.Location) []event {
+	es := make([]event, 0, len(lines))
+	for _, l := range lines {
+		for _, layout := range eventLayouts {
+			if len(l) < len(layout) {
+				continue
+			}
+
+			t, err := time.ParseInLocation(layout, l[:len(layout)], loc)
+			if err != nil {
+				continue
+			}
+
+			es = append(es, event{t: t, msg: strings.TrimSpace(l[len(layout):])})
+			break
+		}
+	}
+
+	return es
+}
+
+// An eventHistory tracks the power failures of each UPS since the exporter
+// started.  When a UPS is first seen, its history is seeded from the events
+// logged by apcupsd, which survive restarts of the exporter and of apcupsd,
+// so that a power failure shortly before a restart is not forgotten.
+type eventHistory struct {
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *eventState]
+}
+
+// An eventState is the power failure history of a single UPS.
+type eventState struct {
+	// failures is the number of power failures.
+	failures int
+
+	// lastFailure and lastReturn are the times of the last power failure
+	// and of the last return of utility power, if known.
+	lastFailure, lastReturn time.Time
+
+	transfers int
+}
+
+// observe records the transfers to battery reported by s, and returns the
+// power failure history of the UPS reporting s.  If the UPS was not seen
+// before, its history is first seeded with the events returned by seed.
+func (eh *eventHistory) observe(s *apcupsd.Status, seed func() []event) eventState {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+
+	id := identity(s)
+	st, ok := eh.states.get(id)
+	if !ok {
+		st = &eventState{transfers: s.NumberTransfers}
+		for _, e := range seed() {
+			switch {
+			case strings.HasPrefix(e.msg, "Power failure"):
+				st.failures++
+				st.lastFailure = e.t
+			case strings.HasPrefix(e.msg, "Mains returned"),
+				strings.HasPrefix(e.msg, "Power is back"):
+				st.lastReturn = e.t
+			}
+		}
+
+		eh.states.put(id, st)
+	}
+
+	// The transfer count is reset when apcupsd restarts.
+	if n := s.NumberTransfers - st.transfers; n > 0 && utilityTransfer(s.LastTransfer) {
+		st.failures += n
+	}
+	st.transfers = s.NumberTransfers
+
+	if s.XOnBattery.After(st.lastFailure) && utilityTransfer(s.LastTransfer) {
+		st.lastFailure = s.XOnBattery
+	}
+	if s.XOffBattery.After(st.lastReturn) && utilityTransfer(s.LastTransfer) {
+		st.lastReturn = s.XOffBattery
+	}
+
+	return *st
+}
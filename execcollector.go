@@ -0,0 +1,174 @@
+package apcupsdexporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// An ExecCollector is a Prometheus collector for metrics produced by an
+// external program, such as one which reads a PDU or an environmental sensor
+// attached to the same host as the UPS.
+//
+// The program is executed on each collection and must write metrics to
+// stdout in the Prometheus text exposition format.  The simplest form of this
+// format is one "name value" pair per line.  Each metric is labeled with the
+// name of the plugin and any constant labels set by WithConstLabels.
+//
+// Because the metrics produced by the program are not known in advance, an
+// ExecCollector is an unchecked collector and describes no metrics.
+type ExecCollector struct {
+	Up *prometheus.Desc
+
+	name string
+	args []string
+	o    *options
+}
+
+var _ prometheus.Collector = &ExecCollector{}
+
+// NewExecCollector creates a new ExecCollector for the plugin called name,
+// which executes the program and arguments in args.  Each execution is
+// bounded by the timeout set by WithTimeout.
+func NewExecCollector(name string, args []string, opts ...Option) *ExecCollector {
+	o := newOptions(opts)
+
+	return &ExecCollector{
+		Up: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, "plugin", "up"),
+			"Whether the metrics of an exec plugin were collected successfully (1 for yes, 0 for no).",
+			[]string{"plugin"},
+			o.constLabels,
+		),
+
+		name: name,
+		args: args,
+		o:    o,
+	}
+}
+
+// Describe implements prometheus.Collector.  It sends no descriptors, making
+// the ExecCollector an unchecked collector.
+func (c *ExecCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+// Collect executes the plugin and sends its metrics to the provided
+// prometheus Metric channel.
+func (c *ExecCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.o.timeout)
+	defer cancel()
+
+	mfs, err := c.run(ctx)
+	if err != nil {
+		c.o.logger.Error("failed collecting plugin metrics", "plugin", c.name, "err", err)
+		ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 0, c.name)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 1, c.name)
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			cm, err := c.metric(mf, m)
+			if err != nil {
+				c.o.logger.Warn("skipping invalid plugin metric",
+					"plugin", c.name, "metric", mf.GetName(), "err", err)
+				continue
+			}
+
+			ch <- cm
+		}
+	}
+}
+
+// run executes the plugin and parses its output.
+func (c *ExecCollector) run(ctx context.Context) (map[string]*dto.MetricFamily, error) {
+	if len(c.args) == 0 {
+		return nil, errors.New("no plugin command specified")
+	}
+
+	out, err := exec.CommandContext(ctx, c.args[0], c.args[1:]...).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(ee.Stderr))
+		}
+
+		return nil, err
+	}
+
+	var p expfmt.TextParser
+	mfs, err := p.TextToMetricFamilies(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing plugin output: %w", err)
+	}
+
+	return mfs, nil
+}
+
+// metric converts m, a member of mf, to a constant metric labeled with the
+// plugin name.
+func (c *ExecCollector) metric(mf *dto.MetricFamily, m *dto.Metric) (prometheus.Metric, error) {
+	names := make([]string, 0, len(m.GetLabel())+1)
+	values := make([]string, 0, len(m.GetLabel())+1)
+	for _, lp := range m.GetLabel() {
+		names = append(names, lp.GetName())
+		values = append(values, lp.GetValue())
+	}
+	names = append(names, "plugin")
+	values = append(values, c.name)
+
+	help := mf.GetHelp()
+	if help == "" {
+		help = fmt.Sprintf("Metric collected by the %s exec plugin.", c.name)
+	}
+
+	d := prometheus.NewDesc(mf.GetName(), help, names, c.o.constLabels)
+
+	var (
+		cm  prometheus.Metric
+		err error
+	)
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		cm, err = prometheus.NewConstMetric(d, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		cm, err = prometheus.NewConstMetric(d, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+	case dto.MetricType_UNTYPED:
+		cm, err = prometheus.NewConstMetric(d, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		qs := make(map[float64]float64, len(s.GetQuantile()))
+		for _, q := range s.GetQuantile() {
+			qs[q.GetQuantile()] = q.GetValue()
+		}
+
+		cm, err = prometheus.NewConstSummary(d, s.GetSampleCount(), s.GetSampleSum(), qs, values...)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		bs := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			bs[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+
+		cm, err = prometheus.NewConstHistogram(d, h.GetSampleCount(), h.GetSampleSum(), bs, values...)
+	default:
+		return nil, fmt.Errorf("unsupported metric type %s", mf.GetType())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m.TimestampMs != nil {
+		cm = prometheus.NewMetricWithTimestamp(time.UnixMilli(m.GetTimestampMs()), cm)
+	}
+
+	return cm, nil
+}
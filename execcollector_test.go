@@ -0,0 +1,127 @@
+package apcupsdexporter
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExecCollector(t *testing.T) {
+	tests := []struct {
+		desc    string
+		output  string
+		exit    int
+		matches []*regexp.Regexp
+	}{
+		{
+			desc:   "key/value",
+			output: "pdu_outlet_watts 42.5\nsensor_humidity_percent 40\n",
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_plugin_up{plugin="pdu"} 1`),
+				regexp.MustCompile(`pdu_outlet_watts{plugin="pdu"} 42.5`),
+				regexp.MustCompile(`sensor_humidity_percent{plugin="pdu"} 40`),
+			},
+		},
+		{
+			desc: "text format",
+			output: `# HELP pdu_outlet_energy_joules_total Energy consumed by an outlet.
+# TYPE pdu_outlet_energy_joules_total counter
+pdu_outlet_energy_joules_total{outlet="1"} 1000
+pdu_outlet_energy_joules_total{outlet="2"} 2000
+`,
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`# TYPE pdu_outlet_energy_joules_total counter`),
+				regexp.MustCompile(`pdu_outlet_energy_joules_total{outlet="1",plugin="pdu"} 1000`),
+				regexp.MustCompile(`pdu_outlet_energy_joules_total{outlet="2",plugin="pdu"} 2000`),
+			},
+		},
+		{
+			desc:   "exit status",
+			output: "pdu_outlet_watts 42.5\n",
+			exit:   1,
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_plugin_up{plugin="pdu"} 0`),
+			},
+		},
+		{
+			desc:   "invalid output",
+			output: "pdu_outlet_watts foo\n",
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_plugin_up{plugin="pdu"} 0`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := NewExecCollector("pdu", helperCommand(t, tt.output, tt.exit),
+				WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+			)
+
+			out := testCollector(t, c)
+
+			for _, m := range tt.matches {
+				if !m.Match(out) {
+					t.Fatalf("output failed to match regex (regexp: %v):\n%s", m, out)
+				}
+			}
+		})
+	}
+}
+
+func TestExecCollectorTimeout(t *testing.T) {
+	t.Setenv("APCUPSD_EXPORTER_HELPER_SLEEP", "1s")
+
+	c := NewExecCollector("pdu", helperCommand(t, "", 0),
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithTimeout(100*time.Millisecond),
+	)
+
+	start := time.Now()
+	out := testCollector(t, c)
+	if d := time.Since(start); d > 900*time.Millisecond {
+		t.Fatalf("plugin was not killed at its deadline, took %v", d)
+	}
+
+	m := regexp.MustCompile(`apcupsd_plugin_up{plugin="pdu"} 0`)
+	if !m.Match(out) {
+		t.Fatalf("output failed to match regex (regexp: %v)", m)
+	}
+}
+
+// helperCommand returns the arguments to execute TestHelperProcess as a
+// plugin which writes output and exits with status exit.
+func helperCommand(t *testing.T, output string, exit int) []string {
+	t.Helper()
+
+	t.Setenv("APCUPSD_EXPORTER_HELPER", "1")
+	t.Setenv("APCUPSD_EXPORTER_HELPER_OUTPUT", output)
+	t.Setenv("APCUPSD_EXPORTER_HELPER_EXIT", fmt.Sprint(exit))
+
+	// With -race, the test binary otherwise sleeps for a second at exit,
+	// which exceeds the deadline of testCollector.
+	t.Setenv("GORACE", "atexit_sleep_ms=0")
+
+	return []string{os.Args[0], "-test.run=TestHelperProcess"}
+}
+
+// TestHelperProcess is executed as a plugin by helperCommand.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("APCUPSD_EXPORTER_HELPER") != "1" {
+		t.Skip("not executed as a plugin")
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("APCUPSD_EXPORTER_HELPER_SLEEP")); err == nil {
+		time.Sleep(d)
+	}
+
+	fmt.Print(os.Getenv("APCUPSD_EXPORTER_HELPER_OUTPUT"))
+
+	var code int
+	_, _ = fmt.Sscan(os.Getenv("APCUPSD_EXPORTER_HELPER_EXIT"), &code)
+	os.Exit(code)
+}
@@ -0,0 +1,258 @@
+package apcupsdexporter
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// An Expr is an arithmetic expression over the numeric fields of a UPS
+// status, such as "load_percent / 100 * nominal_power".
+//
+// Expressions consist of numbers, the operators +, -, *, and /, parentheses,
+// and fields of the Status type of github.com/mdlayher/apcupsd, named in
+// snake case.  Durations are in seconds, times are Unix timestamps, booleans
+// are 0 or 1, and times and fields which the UPS does not report are 0.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ParseExpr parses the arithmetic expression s.
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{src: s}
+	root, err := p.parseSum()
+	if err == nil && p.peek() != 0 {
+		err = fmt.Errorf("unexpected %q", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q at offset %d: %v", s, p.pos, err)
+	}
+
+	return &Expr{src: s, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression for the UPS reporting s.  Division by zero
+// results in an infinity or NaN, as in Go.
+func (e *Expr) Eval(s *apcupsd.Status) float64 { return e.root.eval(s) }
+
+// An exprNode is a node of the syntax tree of an expression.
+type exprNode interface {
+	eval(s *apcupsd.Status) float64
+}
+
+type (
+	numberNode float64
+
+	fieldNode func(s *apcupsd.Status) float64
+
+	negNode struct{ x exprNode }
+
+	binaryNode struct {
+		op   byte
+		x, y exprNode
+	}
+)
+
+func (n numberNode) eval(*apcupsd.Status) float64  { return float64(n) }
+func (n fieldNode) eval(s *apcupsd.Status) float64 { return n(s) }
+func (n negNode) eval(s *apcupsd.Status) float64   { return -n.x.eval(s) }
+
+func (n binaryNode) eval(s *apcupsd.Status) float64 {
+	x, y := n.x.eval(s), n.y.eval(s)
+	switch n.op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	default:
+		return x / y
+	}
+}
+
+// An exprParser is a recursive descent parser of expressions.
+type exprParser struct {
+	src string
+	pos int
+}
+
+// peek skips whitespace and returns the next byte, or 0 at the end.
+func (p *exprParser) peek() byte {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos == len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+// skip advances past any of the bytes of chars.
+func (p *exprParser) skip(chars string) {
+	for p.pos < len(p.src) && strings.IndexByte(chars, p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// parseSum parses terms joined by + and -.
+func (p *exprParser) parseSum() (exprNode, error) {
+	x, err := p.parseProduct()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.src[p.pos]
+		p.pos++
+
+		var y exprNode
+		y, err = p.parseProduct()
+		x = binaryNode{op: op, x: x, y: y}
+	}
+
+	return x, err
+}
+
+// parseProduct parses factors joined by * and /.
+func (p *exprParser) parseProduct() (exprNode, error) {
+	x, err := p.parseFactor()
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op := p.src[p.pos]
+		p.pos++
+
+		var y exprNode
+		y, err = p.parseFactor()
+		x = binaryNode{op: op, x: x, y: y}
+	}
+
+	return x, err
+}
+
+// parseFactor parses a number, field, negation, or parenthesized expression.
+func (p *exprParser) parseFactor() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+		x, err := p.parseFactor()
+		return negNode{x: x}, err
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return x, nil
+	case c == '.' || c >= '0' && c <= '9':
+		start := p.pos
+		p.skip("0123456789.")
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			p.skip("+-")
+			p.skip("0123456789")
+		}
+		num := p.src[start:p.pos]
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			p.pos = start
+			return nil, fmt.Errorf("invalid number %q", num)
+		}
+		return numberNode(v), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		f, ok := statusFields[name]
+		if !ok {
+			p.pos = start
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", c)
+	}
+}
+
+// statusFields are the numeric fields of an apcupsd.Status, keyed by their
+// names in snake case.
+var statusFields = newStatusFields()
+
+// newStatusFields returns the numeric fields of an apcupsd.Status, keyed by
+// their names in snake case.
+func newStatusFields() map[string]fieldNode {
+	var (
+		durationType = reflect.TypeOf(time.Duration(0))
+		timeType     = reflect.TypeOf(time.Time{})
+	)
+
+	fields := make(map[string]fieldNode)
+	t := reflect.TypeOf(apcupsd.Status{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		i := i
+
+		var get fieldNode
+		switch {
+		case f.Type == durationType:
+			get = func(s *apcupsd.Status) float64 {
+				return time.Duration(reflect.ValueOf(s).Elem().Field(i).Int()).Seconds()
+			}
+		case f.Type == timeType:
+			get = func(s *apcupsd.Status) float64 {
+				return timestamp(reflect.ValueOf(s).Elem().Field(i).Interface().(time.Time))
+			}
+		case f.Type.Kind() == reflect.Float64:
+			get = func(s *apcupsd.Status) float64 {
+				return reflect.ValueOf(s).Elem().Field(i).Float()
+			}
+		case f.Type.Kind() == reflect.Int:
+			get = func(s *apcupsd.Status) float64 {
+				return float64(reflect.ValueOf(s).Elem().Field(i).Int())
+			}
+		case f.Type.Kind() == reflect.Bool:
+			get = func(s *apcupsd.Status) float64 {
+				if reflect.ValueOf(s).Elem().Field(i).Bool() {
+					return 1
+				}
+				return 0
+			}
+		default:
+			continue
+		}
+
+		fields[snakeCase(f.Name)] = get
+	}
+
+	return fields
+}
+
+// snakeCase converts the Go identifier name to snake case, keeping acronyms
+// together, so that "XOnBattery" becomes "x_on_battery".
+func snakeCase(name string) string {
+	var b strings.Builder
+	rs := []rune(name)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package apcupsdexporter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestExpr(t *testing.T) {
+	s := &apcupsd.Status{
+		LoadPercent:     16,
+		NominalPower:    865,
+		TimeLeft:        2 * time.Minute,
+		MinimumTimeLeft: 30 * time.Second,
+		XOnBattery:      time.Unix(1000, 0),
+		Selftest:        true,
+	}
+
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{expr: "load_percent / 100 * nominal_power", want: 138.4},
+		{expr: "time_left - minimum_time_left", want: 90},
+		{expr: "x_on_battery + selftest", want: 1001},
+		{expr: "-(1 + 2) * 3 - -4", want: -5},
+		{expr: "1.5e2 / 3", want: 50},
+		{expr: "line_voltage", want: 0},
+		{expr: "1 / line_voltage", want: math.Inf(1)},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.expr, err)
+		}
+		if got := e.Eval(s); math.Abs(got-tt.want) > 1e-9 && got != tt.want {
+			t.Fatalf("unexpected value of %q: %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"load_percent +",
+		"(1 + 2",
+		"1 2",
+		"model",
+		"load_pct",
+		"1.2.3",
+		"2 % 3",
+	} {
+		if _, err := ParseExpr(expr); err == nil {
+			t.Fatalf("expected an error parsing %q, but none occurred", expr)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package apcupsdexporter
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Register registers each of cs, typically an Exporter and any ExecCollectors,
+// on reg and returns an HTTP handler which serves the metrics gathered by g.
+// This allows the exporter to be embedded in an application which serves its
+// own metrics, rather than using the default Prometheus registry.  Scrapers
+// which accept the OpenMetrics format are served it, with the unit of each
+// metric.
+//
+// Metrics about the handler itself are also registered on reg.  If any
+// collector cannot be registered, those already registered are unregistered
+// and an error is returned.
+func Register(reg prometheus.Registerer, g prometheus.Gatherer, cs ...prometheus.Collector) (http.Handler, error) {
+	for i, c := range cs {
+		if err := reg.Register(c); err != nil {
+			for _, c := range cs[:i] {
+				reg.Unregister(c)
+			}
+
+			return nil, err
+		}
+	}
+
+	return promhttp.InstrumentMetricHandler(
+		reg,
+		metricsHandler(g),
+	), nil
+}
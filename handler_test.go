@@ -0,0 +1,110 @@
+package apcupsdexporter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegister(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+
+	c := NewUPSCollector(&testStatusSource{s: &apcupsd.Status{UPSName: "bar"}})
+	h, err := Register(reg, reg, c)
+	if err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to HTTP GET metrics: %v", err)
+	}
+	defer res.Body.Close()
+
+	out, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 1`),
+		regexp.MustCompile(`promhttp_metric_handler_requests_total{code="200"} 0`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	// Registering the same collectors again must fail and leave the
+	// registry unchanged.
+	other := NewUPSCollector(&testStatusSource{}, WithNamespace("other"))
+	if _, err := Register(reg, reg, other, c); err == nil {
+		t.Fatal("expected an error registering a duplicate collector, but none occurred")
+	}
+	if !reg.Unregister(c) || reg.Unregister(other) {
+		t.Fatal("registry was modified by failed registration")
+	}
+}
+
+func TestRegisterOpenMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+
+	c := NewUPSCollector(&testStatusSource{s: &apcupsd.Status{
+		UPSName:                 "bar",
+		TimeLeft:                2 * time.Minute,
+		CumulativeTimeOnBattery: 30 * time.Second,
+		LoadPercent:             16,
+	}})
+	h, err := Register(reg, reg, c)
+	if err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to HTTP GET metrics: %v", err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	out, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile("# TYPE apcupsd_battery_time_left_seconds gauge\n# UNIT apcupsd_battery_time_left_seconds seconds\n"),
+		regexp.MustCompile("# TYPE apcupsd_battery_cumulative_time_on_seconds counter\n# UNIT apcupsd_battery_cumulative_time_on_seconds seconds\napcupsd_battery_cumulative_time_on_seconds_total"),
+		regexp.MustCompile("# TYPE apcupsd_ups_load_percent gauge\n# UNIT apcupsd_ups_load_percent percent\n"),
+		regexp.MustCompile("# TYPE apcupsd_up gauge\napcupsd_up 1.0\n"),
+		regexp.MustCompile("# EOF\n$"),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v):\n%s", m, out)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Hooks are functions invoked around each collection of UPS metrics by a
+// UPSCollector or Exporter.  They allow callers to add tracing, enrichment,
+// or rate limiting to collections without modifying the collector.  Any nil
+// function is skipped.
+type Hooks struct {
+	// Before is invoked before the UPS status is retrieved.  The returned
+	// context is used to retrieve the status, so Before may attach values
+	// such as tracing spans or apply a shorter deadline.
+	//
+	// If Before returns an error, the status is not retrieved and the
+	// collection fails with reason "hook".
+	Before func(ctx context.Context) (context.Context, error)
+
+	// After is invoked once the collection completes, with either the
+	// retrieved status or the error which caused the collection to fail.
+	// Metrics sent on ch by After are added to the collection.
+	After func(ctx context.Context, ch chan<- prometheus.Metric, s *apcupsd.Status, err error)
+
+	// Describe sends the descriptors of any metrics sent by After.  If it
+	// is nil, such metrics are not described, which is only permitted by
+	// non-pedantic registries.
+	Describe func(ch chan<- *prometheus.Desc)
+}
+
+// WithHooks adds Hooks which are invoked around each collection.  When
+// multiple Hooks are added, the Before functions are invoked in the order
+// the Hooks were added, and the After functions in the reverse order.
+func WithHooks(h Hooks) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, h)
+	}
+}
+
+// before invokes each Before hook in order, returning the resulting context.
+func (o *options) before(ctx context.Context) (context.Context, error) {
+	for _, h := range o.hooks {
+		if h.Before == nil {
+			continue
+		}
+
+		var err error
+		ctx, err = h.Before(ctx)
+		if err != nil {
+			return ctx, &reasonError{reason: reasonHook, err: err}
+		}
+	}
+
+	return ctx, nil
+}
+
+// ParseErrors returns the errors parsing the fields of the UPS status
+// retrieved by the collection of ctx, keyed by the name of the field, for use
+// by the After function of Hooks.  Fields which cannot be parsed are omitted
+// from the status.  Only status sources which speak the NIS protocol directly
+// report parse errors.
+func ParseErrors(ctx context.Context) map[string]error {
+	fes := traceNIS(ctx).fieldErrors
+	if len(fes) == 0 {
+		return nil
+	}
+
+	errs := make(map[string]error, len(fes))
+	for _, fe := range fes {
+		errs[fe.field] = fe.err
+	}
+
+	return errs
+}
+
+// A Phase is a timed phase of the retrieval of the UPS status.
+type Phase struct {
+	// Name is "dial", "request", or "parse".
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Phases returns the phases of the retrieval of the UPS status by the
+// collection of ctx, in order, for use by the After function of Hooks, such
+// as to record them as tracing spans.  Phases which were not reached because
+// an earlier one failed are omitted.  Only status sources which speak the NIS
+// protocol directly report phases.
+func Phases(ctx context.Context) []Phase {
+	tr := traceNIS(ctx)
+
+	var ps []Phase
+	add := func(name string, start time.Time, d time.Duration) {
+		if !start.IsZero() {
+			ps = append(ps, Phase{Name: name, Start: start, Duration: d})
+		}
+	}
+	add("dial", tr.dialStart, tr.dial)
+	add("request", tr.exchangeStart, tr.exchange)
+	add("parse", tr.parseStart, tr.parse)
+
+	return ps
+}
+
+// after invokes each After hook in reverse order.
+func (o *options) after(ctx context.Context, ch chan<- prometheus.Metric, s *apcupsd.Status, err error) {
+	for i := len(o.hooks) - 1; i >= 0; i-- {
+		if h := o.hooks[i]; h.After != nil {
+			h.After(ctx, ch, s, err)
+		}
+	}
+}
+
+// describeHooks invokes each Describe hook.
+func (o *options) describeHooks(ch chan<- *prometheus.Desc) {
+	for _, h := range o.hooks {
+		if h.Describe != nil {
+			h.Describe(ch)
+		}
+	}
+}
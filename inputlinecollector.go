@@ -0,0 +1,114 @@
+package apcupsdexporter
+
+import (
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// An InputLineCollector is a Prometheus collector for metrics regarding the
+// AC input line of an APC UPS.
+type InputLineCollector struct {
+	LineVolts                      *prometheus.Desc
+	LineNominalVolts               *prometheus.Desc
+	LineVoltsNominalDeviationRatio *prometheus.Desc
+	PowerQualityScore              *prometheus.Desc
+
+	ss      StatusSource
+	o       *options
+	quality powerQuality
+}
+
+var _ statusCollector = &InputLineCollector{}
+
+// NewInputLineCollector creates a new InputLineCollector.
+func NewInputLineCollector(ss StatusSource, opts ...Option) *InputLineCollector {
+	return newInputLineCollector(ss, newOptions(opts))
+}
+
+// newInputLineCollector creates a new InputLineCollector using the input options.
+func newInputLineCollector(ss StatusSource, o *options) *InputLineCollector {
+	labels := []string{"ups_name", "hostname", "model"}
+
+	return &InputLineCollector{
+		LineVolts: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "line_volts"),
+			"Current AC input line voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		LineNominalVolts: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "line_nominal_volts"),
+			"Nominal AC input line voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		LineVoltsNominalDeviationRatio: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "line_volts_nominal_deviation_ratio"),
+			"Deviation of the AC input line voltage from its nominal voltage, as a ratio of the nominal voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		PowerQualityScore: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "power_quality_score"),
+			"Quality of the AC input line power over the last 24 hours, from 0 (worst) to 100 (best), combining transfers to battery caused by the utility, TRIM and BOOST activity, and line frequency deviation.",
+			labels,
+			o.constLabels,
+		),
+
+		ss: ss,
+		o:  o,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *InputLineCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch,
+		c.LineVolts,
+		c.LineNominalVolts,
+		c.LineVoltsNominalDeviationRatio,
+		c.PowerQualityScore,
+	)
+}
+
+// Collect sends the metric values for each metric created by the
+// InputLineCollector to the provided prometheus Metric channel.
+func (c *InputLineCollector) Collect(ch chan<- prometheus.Metric) {
+	c.o.collectFrom(ch, c.ss, c.LineVolts, c.collectStatus)
+}
+
+// collectStatus sends input line metrics derived from s to ch.
+func (c *InputLineCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	ch <- c.o.cache.metric(
+		c.LineVolts,
+		prometheus.GaugeValue,
+		s.LineVoltage,
+		s,
+	)
+
+	ch <- c.o.cache.metric(
+		c.LineNominalVolts,
+		prometheus.GaugeValue,
+		s.NominalInputVoltage,
+		s,
+	)
+
+	if ratio, ok := nominalDeviation(s.LineVoltage, s.NominalInputVoltage); ok {
+		ch <- c.o.cache.metric(
+			c.LineVoltsNominalDeviationRatio,
+			prometheus.GaugeValue,
+			ratio,
+			s,
+		)
+	}
+
+	ch <- c.o.cache.metric(
+		c.PowerQualityScore,
+		prometheus.GaugeValue,
+		c.quality.observe(s),
+		s,
+	)
+}
@@ -0,0 +1,127 @@
+package apcupsdexporter
+
+import (
+	"sync"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxCacheEntries bounds the number of entries in a metricCache, so that a
+// UPS which continually changes its identity cannot grow the cache without
+// limit.
+const maxCacheEntries = 4096
+
+// A metricCache caches the label pairs of the metrics produced for each UPS
+// identity, so that repeated collections of the same UPS do not need to
+// validate and allocate its label values again.
+type metricCache struct {
+	mu    sync.Mutex
+	pairs map[pairsKey][]*dto.LabelPair
+}
+
+// A upsIdentity is the set of values which identify a UPS in metric labels.
+type upsIdentity struct {
+	upsName, hostname, model string
+}
+
+// A pairsKey identifies the label pairs of a single metric.
+type pairsKey struct {
+	d     *prometheus.Desc
+	id    upsIdentity
+	extra string
+}
+
+// newMetricCache creates an empty metricCache.
+func newMetricCache() *metricCache {
+	return &metricCache{
+		pairs: make(map[pairsKey][]*dto.LabelPair),
+	}
+}
+
+// identity returns the upsIdentity of s.
+func identity(s *apcupsd.Status) upsIdentity {
+	return upsIdentity{
+		upsName:  s.UPSName,
+		hostname: s.Hostname,
+		model:    s.Model,
+	}
+}
+
+// metric creates a constant metric for d with value v, labeled with the
+// identity of the UPS reporting s.
+func (mc *metricCache) metric(d *prometheus.Desc, vt prometheus.ValueType, v float64, s *apcupsd.Status) prometheus.Metric {
+	return mc.metricWith(d, vt, v, s, "")
+}
+
+// metricWith creates a constant metric like metric, but with an additional
+// label value following the UPS identity labels.  If extra is empty, no
+// additional label is added.
+func (mc *metricCache) metricWith(d *prometheus.Desc, vt prometheus.ValueType, v float64, s *apcupsd.Status, extra string) prometheus.Metric {
+	k := pairsKey{d: d, id: identity(s), extra: extra}
+
+	mc.mu.Lock()
+	pairs, ok := mc.pairs[k]
+	mc.mu.Unlock()
+	if ok {
+		return &constMetric{d: d, vt: vt, v: v, pairs: pairs}
+	}
+
+	// On a cache miss, let the prometheus package validate the label values
+	// and produce the label pairs to be reused by later collections.
+	lvs := []string{s.UPSName, s.Hostname, s.Model}
+	if extra != "" {
+		lvs = append(lvs, extra)
+	}
+
+	m, err := prometheus.NewConstMetric(d, vt, v, lvs...)
+	if err != nil {
+		return prometheus.NewInvalidMetric(d, err)
+	}
+
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		return prometheus.NewInvalidMetric(d, err)
+	}
+
+	mc.mu.Lock()
+	if len(mc.pairs) >= maxCacheEntries {
+		mc.pairs = make(map[pairsKey][]*dto.LabelPair)
+	}
+	mc.pairs[k] = out.Label
+	mc.mu.Unlock()
+
+	return m
+}
+
+var _ prometheus.Metric = &constMetric{}
+
+// A constMetric is a prometheus.Metric with a fixed value and precomputed
+// label pairs.  The label pairs may be shared between metrics and must not be
+// modified.
+type constMetric struct {
+	d     *prometheus.Desc
+	vt    prometheus.ValueType
+	v     float64
+	pairs []*dto.LabelPair
+}
+
+// Desc implements prometheus.Metric.
+func (m *constMetric) Desc() *prometheus.Desc { return m.d }
+
+// Write implements prometheus.Metric.
+func (m *constMetric) Write(out *dto.Metric) error {
+	out.Label = m.pairs
+
+	switch m.vt {
+	case prometheus.CounterValue:
+		out.Counter = &dto.Counter{Value: &m.v}
+	case prometheus.GaugeValue:
+		out.Gauge = &dto.Gauge{Value: &m.v}
+	default:
+		out.Untyped = &dto.Untyped{Value: &m.v}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package apcupsdexporter
+
+import (
+	"strings"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// Sources of nominal values, reported by the source label of nominal value
+// metrics.
+const (
+	sourceUPS     = "ups"
+	sourceModelDB = "model_db"
+)
+
+// A ModelSpec holds the nominal values of a UPS model, as specified by its
+// manufacturer.  Zero values are unknown.
+type ModelSpec struct {
+	NominalPowerWatts      float64
+	NominalApparentPowerVA float64
+	BatteryEnergyWattHours float64
+}
+
+// ModelSpecs maps UPS model names, as reported in the MODEL field by apcupsd,
+// to their nominal values.  Model names are matched case-insensitively.
+type ModelSpecs map[string]ModelSpec
+
+// DefaultModelSpecs are the nominal values of common UPS models which do not
+// report their nominal power to apcupsd, such as many consumer Back-UPS
+// models.  The battery energy is that of the original replacement battery
+// cartridge.
+var DefaultModelSpecs = ModelSpecs{
+	"Back-UPS CS 350":   {NominalPowerWatts: 210, NominalApparentPowerVA: 350, BatteryEnergyWattHours: 84},
+	"Back-UPS CS 500":   {NominalPowerWatts: 300, NominalApparentPowerVA: 500, BatteryEnergyWattHours: 84},
+	"Back-UPS CS 650":   {NominalPowerWatts: 400, NominalApparentPowerVA: 650, BatteryEnergyWattHours: 108},
+	"Back-UPS ES 400":   {NominalPowerWatts: 240, NominalApparentPowerVA: 400, BatteryEnergyWattHours: 84},
+	"Back-UPS ES 550":   {NominalPowerWatts: 330, NominalApparentPowerVA: 550, BatteryEnergyWattHours: 84},
+	"Back-UPS ES 550G":  {NominalPowerWatts: 330, NominalApparentPowerVA: 550, BatteryEnergyWattHours: 84},
+	"Back-UPS ES 700":   {NominalPowerWatts: 405, NominalApparentPowerVA: 700, BatteryEnergyWattHours: 108},
+	"Back-UPS ES 700G":  {NominalPowerWatts: 405, NominalApparentPowerVA: 700, BatteryEnergyWattHours: 108},
+	"Back-UPS ES 850G":  {NominalPowerWatts: 520, NominalApparentPowerVA: 850, BatteryEnergyWattHours: 108},
+	"Back-UPS RS 900G":  {NominalPowerWatts: 540, NominalApparentPowerVA: 900, BatteryEnergyWattHours: 168},
+	"Back-UPS RS 1500G": {NominalPowerWatts: 865, NominalApparentPowerVA: 1500, BatteryEnergyWattHours: 216},
+	"Back-UPS XS 1000":  {NominalPowerWatts: 600, NominalApparentPowerVA: 1000, BatteryEnergyWattHours: 168},
+	"Back-UPS XS 1500G": {NominalPowerWatts: 865, NominalApparentPowerVA: 1500, BatteryEnergyWattHours: 216},
+}
+
+// With returns a copy of ms with the entries of each of overrides replacing
+// those of ms in turn.
+func (ms ModelSpecs) With(overrides ...ModelSpecs) ModelSpecs {
+	out := make(ModelSpecs, len(ms))
+	for _, m := range append([]ModelSpecs{ms}, overrides...) {
+		for model, spec := range m {
+			out[normalizeModel(model)] = spec
+		}
+	}
+
+	return out
+}
+
+// Lookup returns the nominal values of model, if known.
+func (ms ModelSpecs) Lookup(model string) (ModelSpec, bool) {
+	if spec, ok := ms[model]; ok {
+		return spec, true
+	}
+
+	spec, ok := ms[normalizeModel(model)]
+	return spec, ok
+}
+
+// normalizeModel normalizes a model name for matching.
+func normalizeModel(model string) string {
+	return strings.ToLower(strings.TrimSpace(model))
+}
+
+// nominalPower returns the nominal power of the UPS reporting s in watts, and
+// its source: an Override, the UPS itself, or the model specifications if the
+// UPS does not report it.
+func (o *options) nominalPower(s *apcupsd.Status) (float64, string) {
+	if ov, ok := o.override(s); ok && ov.NominalPowerWatts > 0 {
+		return ov.NominalPowerWatts, sourceOverride
+	}
+
+	if s.NominalPower > 0 {
+		return float64(s.NominalPower), sourceUPS
+	}
+
+	if spec, ok := o.modelSpecs.Lookup(s.Model); ok && spec.NominalPowerWatts > 0 {
+		return spec.NominalPowerWatts, sourceModelDB
+	}
+
+	return 0, sourceUPS
+}
+
+// batteryEnergy returns the nominal energy of the battery of the UPS reporting
+// s in watt-hours, and its source, if known.
+func (o *options) batteryEnergy(s *apcupsd.Status) (float64, string, bool) {
+	if ov, ok := o.override(s); ok && ov.BatteryEnergyWattHours > 0 {
+		return ov.BatteryEnergyWattHours, sourceOverride, true
+	}
+
+	if spec, ok := o.modelSpecs.Lookup(s.Model); ok && spec.BatteryEnergyWattHours > 0 {
+		return spec.BatteryEnergyWattHours, sourceModelDB, true
+	}
+
+	return 0, "", false
+}
@@ -0,0 +1,466 @@
+package apcupsdexporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// A DialFunc is a function which dials a connection to an apcupsd NIS.
+// DialFuncs are invoked on each Prometheus scrape, so that connections can
+// be short-lived and less likely to time out or fail.
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// NewDialFunc creates a DialFunc which dials apcupsd at addr on network.
+//
+// Typically, network will be one of: "tcp", "tcp4", or "tcp6".
+func NewDialFunc(network, addr string) DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// NewIPDialFunc creates a DialFunc which dials apcupsd at addr over TCP.  When
+// the host of addr resolves to addresses of both IP protocols, those of the
+// preferred protocol ("ip4" or "ip6") are dialed first.  If fallback is false,
+// addresses of the other protocol are never dialed.
+func NewIPDialFunc(addr, preferred string, fallback bool) DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		var ips []net.IPAddr
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IPAddr{{IP: ip}}
+		} else {
+			ips, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ips = orderIPs(ips, preferred, fallback)
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no %s address found for %q", preferred, host)
+		}
+
+		// Dial each address in turn until one succeeds.
+		var (
+			d    net.Dialer
+			errs []error
+		)
+		for _, ip := range ips {
+			c, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return c, nil
+			}
+
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		return nil, errors.Join(errs...)
+	}
+}
+
+// orderIPs returns the addresses of ips of the preferred IP protocol, followed
+// by those of the other protocol if fallback is true.
+func orderIPs(ips []net.IPAddr, preferred string, fallback bool) []net.IPAddr {
+	var primary, secondary []net.IPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == (preferred == "ip4") {
+			primary = append(primary, ip)
+		} else {
+			secondary = append(secondary, ip)
+		}
+	}
+
+	if !fallback {
+		return primary
+	}
+
+	return append(primary, secondary...)
+}
+
+// A RawStatusSource is a type which can retrieve the UPS status as reported
+// by apcupsd, without interpreting any of its fields.
+type RawStatusSource interface {
+	RawStatus(ctx context.Context) (map[string]string, error)
+}
+
+var (
+	_ ContextStatusSource = &dialSource{}
+	_ RawStatusSource     = &dialSource{}
+)
+
+// A dialSource is a StatusSource which dials a short-lived connection to
+// apcupsd using a DialFunc each time the status is retrieved, and speaks the
+// NIS protocol directly so that the raw status lines are available.
+type dialSource struct {
+	dial DialFunc
+	loc  *time.Location
+}
+
+// Status implements StatusSource.
+func (ds *dialSource) Status() (*apcupsd.Status, error) {
+	return ds.StatusContext(context.Background())
+}
+
+// StatusContext implements ContextStatusSource.
+func (ds *dialSource) StatusContext(ctx context.Context) (*apcupsd.Status, error) {
+	lines, err := ds.command(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	tr := traceNIS(ctx)
+	tr.parseStart = time.Now()
+	s, fes, err := parseStatus(zoneTimes(lines, ds.loc))
+	tr.parse = time.Since(tr.parseStart)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.fieldErrors = fes
+	return s, nil
+}
+
+// RawStatus implements RawStatusSource.
+func (ds *dialSource) RawStatus(ctx context.Context) (map[string]string, error) {
+	lines, err := ds.command(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	return rawStatus(lines), nil
+}
+
+// An nisTrace records the start and latency of the phases of a NIS exchange,
+// and the fields of its status response which could not be parsed.
+type nisTrace struct {
+	dialStart, exchangeStart, parseStart time.Time
+	dial, exchange, parse                time.Duration
+	fieldErrors                          []fieldError
+}
+
+type nisTraceKey struct{}
+
+// withNISTrace returns a context which records the latency of a NIS exchange
+// made with it in tr.
+func withNISTrace(ctx context.Context, tr *nisTrace) context.Context {
+	return context.WithValue(ctx, nisTraceKey{}, tr)
+}
+
+// traceNIS returns the nisTrace attached to ctx, or a trace which is
+// discarded if none is attached.
+func traceNIS(ctx context.Context) *nisTrace {
+	if tr, ok := ctx.Value(nisTraceKey{}).(*nisTrace); ok {
+		return tr
+	}
+
+	return &nisTrace{}
+}
+
+// command dials apcupsd, sends cmd, and returns the lines of its response.
+// All I/O on the connection is bounded by ctx.
+func (ds *dialSource) command(ctx context.Context, cmd string) ([]string, error) {
+	tr := traceNIS(ctx)
+
+	tr.dialStart = time.Now()
+	c, err := ds.dial(ctx)
+	tr.dial = time.Since(tr.dialStart)
+	if err != nil {
+		return nil, &reasonError{
+			reason: reasonConnect,
+			err:    fmt.Errorf("error dialing apcupsd: %w", err),
+		}
+	}
+	defer c.Close()
+
+	stop := bindConn(ctx, c)
+	defer stop()
+
+	tr.exchangeStart = time.Now()
+	lines, err := nisCommand(c, cmd)
+	tr.exchange = time.Since(tr.exchangeStart)
+	if err != nil && ctx.Err() != nil {
+		// Report the cause of the interrupted I/O rather than the
+		// resulting network error.
+		return nil, ctx.Err()
+	}
+
+	return splitLines(lines), err
+}
+
+// paddingReplacer removes the padding of the messages of older daemons.
+var paddingReplacer = strings.NewReplacer("\x00", "", "\r", "")
+
+// splitLines returns the lines held by the messages msgs of a NIS response,
+// each terminated by a newline.  apcupsd sends each line as a message of its
+// own, but older daemons may send several lines in a single message, or pad
+// their messages with NUL bytes or carriage returns, which are tolerated so
+// that their fields are not lost or reported as parse errors.
+func splitLines(msgs []string) []string {
+	clean := true
+	for _, m := range msgs {
+		if strings.ContainsAny(m, "\x00\r") || strings.Contains(strings.TrimSuffix(m, "\n"), "\n") {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return msgs
+	}
+
+	var lines []string
+	for _, m := range msgs {
+		m = paddingReplacer.Replace(m)
+		for _, l := range strings.Split(m, "\n") {
+			if strings.TrimSpace(l) != "" {
+				lines = append(lines, l+"\n")
+			}
+		}
+	}
+
+	return lines
+}
+
+// bindConn applies the deadline of ctx to c, and interrupts any pending I/O
+// on c when ctx is done.  The returned function releases the binding.
+func bindConn(ctx context.Context, c net.Conn) func() bool {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+	}
+
+	return context.AfterFunc(ctx, func() {
+		// A deadline in the past interrupts any pending I/O.  Connections
+		// which do not support deadlines, such as SSH channels, are closed
+		// instead.
+		if err := c.SetDeadline(time.Unix(1, 0)); err != nil {
+			_ = c.Close()
+		}
+	})
+}
+
+// nisCommand sends cmd to a NIS over rw and returns each message of its
+// response, up to the zero-length message which terminates it.
+func nisCommand(rw io.ReadWriter, cmd string) ([]string, error) {
+	if err := writeMessage(rw, []byte(cmd)); err != nil {
+		return nil, err
+	}
+
+	var (
+		lines []string
+		lenb  [2]byte
+		buf   []byte
+	)
+
+	for {
+		if _, err := io.ReadFull(rw, lenb[:]); err != nil {
+			if err == io.EOF {
+				// The connection was closed before the response ended.
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		n := int(binary.BigEndian.Uint16(lenb[:]))
+		if n == 0 {
+			return lines, nil
+		}
+
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		if _, err := io.ReadFull(rw, buf[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		lines = append(lines, string(buf[:n]))
+	}
+}
+
+// writeMessage writes a single length-prefixed NIS message to w.
+func writeMessage(w io.Writer, b []byte) error {
+	if len(b) > math.MaxUint16 {
+		return fmt.Errorf("NIS message too large: %d bytes", len(b))
+	}
+
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// rawStatus parses the "KEY : value" lines of a status response into a map.
+// Lines which are not key/value pairs are ignored.
+func rawStatus(lines []string) map[string]string {
+	m := make(map[string]string, len(lines))
+	for _, l := range lines {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return m
+}
+
+// parseStatus parses the lines of a status response into an apcupsd.Status,
+// by replaying them to an apcupsd client.
+//
+// Lines which cannot be parsed on their own, such as a field with an
+// unexpected value, are skipped and returned as fieldErrors, so that a single
+// malformed field does not prevent reporting the others.  The response is
+// only rejected if none of its lines can be parsed.
+func parseStatus(lines []string) (*apcupsd.Status, []fieldError, error) {
+	s, err := replayStatus(lines)
+	if err == nil {
+		return s, nil, nil
+	}
+
+	var (
+		good []string
+		fes  []fieldError
+	)
+
+	for _, l := range lines {
+		if _, err := replayStatus([]string{l}); err != nil {
+			fes = append(fes, newFieldError(l, err))
+			continue
+		}
+
+		good = append(good, l)
+	}
+
+	if len(good) == 0 {
+		return nil, nil, &reasonError{reason: reasonParse, err: err}
+	}
+
+	s, err = replayStatus(good)
+	if err != nil {
+		return nil, nil, &reasonError{reason: reasonParse, err: err}
+	}
+
+	return s, fes, nil
+}
+
+// replayStatus parses lines into an apcupsd.Status by replaying them to an
+// apcupsd client.
+func replayStatus(lines []string) (*apcupsd.Status, error) {
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if err := writeMessage(&buf, []byte(l)); err != nil {
+			return nil, err
+		}
+	}
+	_ = writeMessage(&buf, nil)
+
+	return apcupsd.New(&replayConn{r: &buf}).Status()
+}
+
+// timeKeys are the keys of the status fields which hold timestamps.
+var timeKeys = map[string]bool{
+	"DATE":      true,
+	"STARTTIME": true,
+	"XONBATT":   true,
+	"XOFFBATT":  true,
+	"LASTSTEST": true,
+	"ENDAPC":    true,
+}
+
+// zonelessLayouts are the layouts of timestamps reported without a UTC offset
+// by some builds of apcupsd.  Time zone abbreviations are ambiguous, so they
+// are treated as absent.
+var zonelessLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.ANSIC,
+	time.UnixDate,
+}
+
+// zoneTimes returns lines with each timestamp which lacks a UTC offset
+// rewritten to the layout expected by the apcupsd client, with the offset of
+// loc at that time.
+func zoneTimes(lines []string, loc *time.Location) []string {
+	var out []string
+	for i, l := range lines {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok || !timeKeys[strings.TrimSpace(k)] {
+			continue
+		}
+
+		v = strings.TrimSpace(v)
+		for _, layout := range zonelessLayouts {
+			t, err := time.Parse(layout, v)
+			if err != nil {
+				continue
+			}
+
+			if out == nil {
+				// Don't modify the caller's lines.
+				out = append([]string(nil), lines...)
+			}
+
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+			out[i] = k + ": " + t.Format("2006-01-02 15:04:05 -0700")
+			break
+		}
+	}
+
+	if out == nil {
+		return lines
+	}
+
+	return out
+}
+
+// A fieldError is an error parsing a single field of a status response.
+type fieldError struct {
+	field string
+	err   error
+}
+
+// newFieldError creates a fieldError for the status line l.  Lines which are
+// not key/value pairs are reported as the field "invalid", to bound the
+// number of distinct fields.
+func newFieldError(l string, err error) fieldError {
+	k, _, ok := strings.Cut(l, ":")
+	if !ok {
+		k = "invalid"
+	}
+
+	return fieldError{field: strings.TrimSpace(k), err: err}
+}
+
+var _ io.ReadWriteCloser = &replayConn{}
+
+// A replayConn is an io.ReadWriteCloser which discards writes and replays a
+// previously received NIS response on reads.
+type replayConn struct {
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *replayConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *replayConn) Close() error                { return nil }
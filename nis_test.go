@@ -0,0 +1,175 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestOrderIPs(t *testing.T) {
+	var (
+		v4 = net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+		v6 = net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+	)
+
+	tests := []struct {
+		desc      string
+		preferred string
+		fallback  bool
+		want      []net.IPAddr
+	}{
+		{
+			desc:      "prefer ip4",
+			preferred: "ip4",
+			fallback:  true,
+			want:      []net.IPAddr{v4, v6},
+		},
+		{
+			desc:      "prefer ip6",
+			preferred: "ip6",
+			fallback:  true,
+			want:      []net.IPAddr{v6, v4},
+		},
+		{
+			desc:      "only ip4",
+			preferred: "ip4",
+			want:      []net.IPAddr{v4},
+		},
+		{
+			desc:      "only ip6",
+			preferred: "ip6",
+			want:      []net.IPAddr{v6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := orderIPs([]net.IPAddr{v4, v6}, tt.preferred, tt.fallback)
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Fatalf("unexpected addresses:\n- want: %v\n-  got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewIPDialFunc(t *testing.T) {
+	s, err := apcupsdtest.NewTCPServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	if err != nil {
+		t.Fatalf("failed to create fake NIS: %v", err)
+	}
+	defer s.Close()
+
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse address: %v", err)
+	}
+
+	ctx := context.Background()
+
+	c, err := NewIPDialFunc(net.JoinHostPort("127.0.0.1", port), "ip6", true)(ctx)
+	if err != nil {
+		t.Fatalf("failed to dial with fallback: %v", err)
+	}
+	_ = c.Close()
+
+	if _, err := NewIPDialFunc(net.JoinHostPort("127.0.0.1", port), "ip6", false)(ctx); err == nil {
+		t.Fatal("expected an error dialing an IPv4 address as IPv6 only, but none occurred")
+	}
+}
+
+func TestZoneTimes(t *testing.T) {
+	loc := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		desc string
+		in   string
+		want string
+	}{
+		{
+			desc: "offset",
+			in:   apcupsdtest.Line("XONBATT", "2022-03-14 10:00:00 +0100"),
+			want: apcupsdtest.Line("XONBATT", "2022-03-14 10:00:00 +0100"),
+		},
+		{
+			desc: "no offset",
+			in:   apcupsdtest.Line("XONBATT", "2022-03-14 10:00:00"),
+			want: "XONBATT  : 2022-03-14 10:00:00 -0500",
+		},
+		{
+			desc: "ANSI C",
+			in:   apcupsdtest.Line("STARTTIME", "Mon Mar 14 10:00:00 2022"),
+			want: "STARTTIME: 2022-03-14 10:00:00 -0500",
+		},
+		{
+			desc: "abbreviation",
+			in:   apcupsdtest.Line("LASTSTEST", "Mon Mar 14 10:00:00 CET 2022"),
+			want: "LASTSTEST: 2022-03-14 10:00:00 -0500",
+		},
+		{
+			desc: "not a time",
+			in:   apcupsdtest.Line("UPSNAME", "2022-03-14 10:00:00"),
+			want: apcupsdtest.Line("UPSNAME", "2022-03-14 10:00:00"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := zoneTimes([]string{tt.in}, loc)[0]; got != tt.want {
+				t.Fatalf("unexpected line:\n- want: %q\n-  got: %q", tt.want, got)
+			}
+		})
+	}
+
+	s, _, err := parseStatus(zoneTimes([]string{apcupsdtest.Line("XONBATT", "2022-03-14 10:00:00")}, loc))
+	if err != nil {
+		t.Fatalf("failed to parse status: %v", err)
+	}
+
+	if want := time.Date(2022, time.March, 14, 15, 0, 0, 0, time.UTC); !s.XOnBattery.Equal(want) {
+		t.Fatalf("unexpected transfer time: want %v, got %v", want, s.XOnBattery)
+	}
+}
+
+func TestDialSourceOldDaemon(t *testing.T) {
+	// Older daemons may send several lines per message, and pad messages
+	// with NUL bytes or carriage returns.
+	s := apcupsdtest.NewServer(apcupsdtest.Status(
+		"APC      : 001,030,0712\r\nHOSTNAME : old\r\n",
+		"VERSION  : 3.12.4 (19 August 2006) redhat\nUPSNAME  : ups\nMODEL    : SMART-UPS 1000\n\x00",
+		"ALARMDEL : Always\n",
+		"LINEV    : 230.0 Volts\n",
+	))
+	defer s.Close()
+
+	ds := &dialSource{
+		dial: func(context.Context) (net.Conn, error) { return s.PipeConn(), nil },
+		loc:  time.UTC,
+	}
+
+	var tr nisTrace
+	st, err := ds.StatusContext(withNISTrace(context.Background(), &tr))
+	if err != nil {
+		t.Fatalf("failed to retrieve status: %v", err)
+	}
+
+	if st.Hostname != "old" || st.Model != "SMART-UPS 1000" || st.LineVoltage != 230 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if len(tr.fieldErrors) != 1 || tr.fieldErrors[0].field != "ALARMDEL" {
+		t.Fatalf("unexpected field errors: %v", tr.fieldErrors)
+	}
+
+	if version, format := daemonVersion(st); version != "3.12.4" || format != "001" {
+		t.Fatalf("unexpected daemon version: %q, %q", version, format)
+	}
+
+	// Responses of current daemons are left as sent.
+	lines := []string{apcupsdtest.Line("UPSNAME", "ups"), apcupsdtest.Line("MODEL", "Back-UPS RS 1500G")}
+	if got := splitLines(lines); &got[0] != &lines[0] {
+		t.Fatal("lines of a current daemon were copied")
+	}
+}
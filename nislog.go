@@ -0,0 +1,172 @@
+package apcupsdexporter
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelTrace is the level at which a DialFunc created by NewLoggingDialFunc
+// logs exchanges with apcupsd, below slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
+// NewLoggingDialFunc creates a DialFunc which dials apcupsd using dial, and
+// logs the bytes sent and received over each of its connections to logger at
+// LevelTrace when the connection is closed, both in hex and as the text of
+// their NIS messages.  Each is truncated to maxBytes.
+//
+// At most one connection is logged per interval, along with the number of
+// connections which were not logged since, so that tracing a target which is
+// scraped often does not flood the log; an interval of 0 logs every
+// connection.  Connections are only logged while logger is enabled for
+// LevelTrace, which may change at runtime.
+func NewLoggingDialFunc(dial DialFunc, logger *slog.Logger, maxBytes int, interval time.Duration) DialFunc {
+	l := &nisLogger{logger: logger, maxBytes: maxBytes, interval: interval}
+
+	return func(ctx context.Context) (net.Conn, error) {
+		start := time.Now()
+		c, err := dial(ctx)
+		if err != nil || !logger.Enabled(ctx, LevelTrace) {
+			return c, err
+		}
+
+		suppressed, ok := l.allow(start)
+		if !ok {
+			return c, nil
+		}
+
+		return &loggingConn{Conn: c, l: l, start: start, suppressed: suppressed}, nil
+	}
+}
+
+// A nisLogger limits the rate at which the connections of a DialFunc are
+// logged.
+type nisLogger struct {
+	logger   *slog.Logger
+	maxBytes int
+	interval time.Duration
+
+	mu         sync.Mutex
+	logged     time.Time
+	suppressed int
+}
+
+// allow reports whether a connection dialed at now should be logged, and if
+// so, the number of connections which were not logged since the last.
+func (l *nisLogger) allow(now time.Time) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval > 0 && !l.logged.IsZero() && now.Sub(l.logged) < l.interval {
+		l.suppressed++
+		return 0, false
+	}
+
+	suppressed := l.suppressed
+	l.logged, l.suppressed = now, 0
+	return suppressed, true
+}
+
+// A loggingConn is a net.Conn which retains the first bytes sent and received
+// over it, and logs them when it is closed.
+type loggingConn struct {
+	net.Conn
+	l          *nisLogger
+	start      time.Time
+	suppressed int
+
+	mu         sync.Mutex
+	sent, recv capped
+	closed     bool
+}
+
+// Read implements net.Conn.
+func (c *loggingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	c.recv.write(b[:n], c.l.maxBytes)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *loggingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	c.mu.Lock()
+	c.sent.write(b[:n], c.l.maxBytes)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// Close implements net.Conn.
+func (c *loggingConn) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	sent, recv := c.sent, c.recv
+	c.mu.Unlock()
+
+	err := c.Conn.Close()
+	if closed {
+		return err
+	}
+
+	c.l.logger.Log(context.Background(), LevelTrace, "NIS exchange",
+		"remote_addr", c.RemoteAddr(),
+		"duration", time.Since(c.start),
+		"sent_bytes", sent.n,
+		"sent_hex", hex.EncodeToString(sent.b),
+		"sent_text", messageText(sent.b),
+		"received_bytes", recv.n,
+		"received_hex", hex.EncodeToString(recv.b),
+		"received_text", messageText(recv.b),
+		"truncated", sent.n > len(sent.b) || recv.n > len(recv.b),
+		"suppressed", c.suppressed)
+
+	return err
+}
+
+// capped holds the first bytes written to it, and the number of all bytes
+// written.
+type capped struct {
+	b []byte
+	n int
+}
+
+// write appends b, retaining at most limit bytes.
+func (c *capped) write(b []byte, limit int) {
+	c.n += len(b)
+	if n := limit - len(c.b); n > 0 {
+		c.b = append(c.b, b[:min(n, len(b))]...)
+	}
+}
+
+// messageText returns the NIS messages which b begins with, one per line,
+// omitting the zero-length message which ends a response and any trailing
+// partial message.
+func messageText(b []byte) string {
+	var sb strings.Builder
+	for {
+		m, rest, ok := nextMessage(b)
+		if !ok {
+			break
+		}
+		b = rest
+
+		if len(m) == 0 {
+			continue
+		}
+		sb.WriteString(strings.TrimSuffix(string(m), "\n"))
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
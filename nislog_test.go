@@ -0,0 +1,126 @@
+package apcupsdexporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+)
+
+func TestLoggingDialFunc(t *testing.T) {
+	s := apcupsdtest.NewServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	defer s.Close()
+
+	dial := func(context.Context) (net.Conn, error) {
+		return s.PipeConn(), nil
+	}
+
+	// logs collects the statuses retrieved using a DialFunc created with the
+	// input parameters, and returns the records logged.
+	logs := func(t *testing.T, level slog.Level, maxBytes int, interval time.Duration, n int) []map[string]any {
+		t.Helper()
+
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level}))
+		ds := &dialSource{dial: NewLoggingDialFunc(dial, logger, maxBytes, interval), loc: time.UTC}
+		for i := 0; i < n; i++ {
+			if _, err := ds.Status(); err != nil {
+				t.Fatalf("failed to retrieve status: %v", err)
+			}
+		}
+
+		var rs []map[string]any
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if l == "" {
+				continue
+			}
+
+			var r map[string]any
+			if err := json.Unmarshal([]byte(l), &r); err != nil {
+				t.Fatalf("failed to decode log record: %v", err)
+			}
+			rs = append(rs, r)
+		}
+
+		return rs
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if rs := logs(t, slog.LevelDebug, 4096, 0, 1); len(rs) != 0 {
+			t.Fatalf("unexpected records: %v", rs)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		rs := logs(t, LevelTrace, 4096, 0, 2)
+		if len(rs) != 2 {
+			t.Fatalf("unexpected number of records: %d", len(rs))
+		}
+
+		r := rs[0]
+		if r["msg"] != "NIS exchange" || r["truncated"] != false {
+			t.Fatalf("unexpected record: %v", r)
+		}
+		if want := hex.EncodeToString([]byte("\x00\x06status")); r["sent_hex"] != want {
+			t.Fatalf("unexpected sent hex: want %q, got %q", want, r["sent_hex"])
+		}
+		if r["sent_text"] != "status\n" {
+			t.Fatalf("unexpected sent text: %q", r["sent_text"])
+		}
+		if text, _ := r["received_text"].(string); !strings.Contains(text, "MODEL    : Back-UPS RS 1500G\n") {
+			t.Fatalf("unexpected received text: %q", text)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		r := logs(t, LevelTrace, 16, 0, 1)[0]
+		if r["truncated"] != true {
+			t.Fatalf("record is not truncated: %v", r)
+		}
+		if hex, _ := r["received_hex"].(string); len(hex) != 32 {
+			t.Fatalf("unexpected received hex: %q", hex)
+		}
+		if n, _ := r["received_bytes"].(float64); n <= 16 {
+			t.Fatalf("unexpected received bytes: %v", n)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		rs := logs(t, LevelTrace, 4096, time.Hour, 3)
+		if len(rs) != 1 {
+			t.Fatalf("unexpected number of records: %d", len(rs))
+		}
+	})
+}
+
+func TestNISLoggerAllow(t *testing.T) {
+	l := &nisLogger{interval: time.Minute}
+	now := time.Unix(1000, 0)
+
+	steps := []struct {
+		at         time.Duration
+		ok         bool
+		suppressed int
+	}{
+		{at: 0, ok: true},
+		{at: 10 * time.Second},
+		{at: 20 * time.Second},
+		{at: time.Minute, ok: true, suppressed: 2},
+		{at: 2 * time.Minute, ok: true},
+	}
+
+	for i, st := range steps {
+		suppressed, ok := l.allow(now.Add(st.at))
+		if ok != st.ok || suppressed != st.suppressed {
+			t.Fatalf("step %d: unexpected result: want (%d, %v), got (%d, %v)",
+				i, st.suppressed, st.ok, suppressed, ok)
+		}
+	}
+}
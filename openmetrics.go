@@ -0,0 +1,134 @@
+package apcupsdexporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricUnits are the units of metrics, as the suffixes of their names, which
+// are announced in UNIT metadata.  Longer suffixes precede those they end
+// with.
+var metricUnits = []string{
+	"watt_hours",
+	"seconds",
+	"volts",
+	"watts",
+	"celsius",
+	"percent",
+	"ratio",
+	"bytes",
+}
+
+// metricUnit returns the unit of the metric family mf, or the empty string if
+// its name ends with no known unit.
+func metricUnit(mf *dto.MetricFamily) string {
+	name := mf.GetName()
+	if mf.GetType() == dto.MetricType_COUNTER {
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	for _, u := range metricUnits {
+		if strings.HasSuffix(name, "_"+u) {
+			return u
+		}
+	}
+
+	return ""
+}
+
+// metricsHandler serves the metrics gathered by g as promhttp does, except
+// that scrapers which accept the OpenMetrics format are served it along with
+// the UNIT metadata of each metric, which the OpenMetrics encoder of the
+// Prometheus libraries omits.
+func metricsHandler(g prometheus.Gatherer) http.Handler {
+	h := promhttp.HandlerFor(g, promhttp.HandlerOpts{EnableOpenMetrics: true})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if expfmt.NegotiateIncludingOpenMetrics(r.Header) != expfmt.FmtOpenMetrics {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		mfs, err := g.Gather()
+		if err != nil {
+			http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var buf bytes.Buffer
+		for _, mf := range mfs {
+			if err := writeOpenMetrics(&buf, mf); err != nil {
+				http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := expfmt.FinalizeOpenMetrics(&buf); err != nil {
+			http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+
+		var out io.Writer = w
+		if gzipAccepted(r.Header) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+
+		_, _ = out.Write(buf.Bytes())
+	})
+}
+
+// writeOpenMetrics writes mf to buf in the OpenMetrics format, with UNIT
+// metadata following its TYPE if it has a known unit.
+func writeOpenMetrics(buf *bytes.Buffer, mf *dto.MetricFamily) error {
+	start := buf.Len()
+	if _, err := expfmt.MetricFamilyToOpenMetrics(buf, mf); err != nil {
+		return err
+	}
+
+	unit := metricUnit(mf)
+	if unit == "" {
+		return nil
+	}
+
+	// The TYPE line is the first line, or follows the HELP line, whose text
+	// is escaped so that it holds no newlines.
+	out := buf.Bytes()[start:]
+	i := bytes.Index(out, []byte("# TYPE "))
+	if i < 0 {
+		return nil
+	}
+	end := i + bytes.IndexByte(out[i:], '\n') + 1
+	name := strings.Fields(string(out[i:end]))[2]
+
+	family := append([]byte{}, out[end:]...)
+	buf.Truncate(start + end)
+	buf.WriteString("# UNIT " + name + " " + unit + "\n")
+	buf.Write(family)
+
+	return nil
+}
+
+// gzipAccepted reports whether the Accept-Encoding header of a request allows
+// gzip compression, as promhttp decides.
+func gzipAccepted(header http.Header) bool {
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+
+	return false
+}
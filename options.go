@@ -0,0 +1,285 @@
+package apcupsdexporter
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// An Option configures a UPSCollector, one of its sub-collectors, or an
+// Exporter.
+type Option func(o *options)
+
+// options contains the configuration applied by Options.
+type options struct {
+	namespace    string
+	constLabels  prometheus.Labels
+	targetLabels []*dto.LabelPair
+	logger       *slog.Logger
+	timestamps   bool
+	collectors   []string
+	timeout      time.Duration
+
+	invalidMetricOnError bool
+	hooks                []Hooks
+	hostname             func(s *apcupsd.Status) string
+	nominalRuntime       float64
+	statePath            string
+	shutdownDuration     time.Duration
+	errorLogInterval     time.Duration
+	location             *time.Location
+	staleMaxAge          time.Duration
+	modelSpecs           ModelSpecs
+	overrides            []Override
+	upstreamNames        bool
+	compactStatus        bool
+	derivedMetrics       []DerivedMetric
+	quirks               []Quirk
+	spikeFilters         []SpikeFilter
+
+	cache *metricCache
+}
+
+// newOptions applies opts to the default options.
+func newOptions(opts []Option) *options {
+	o := &options{
+		namespace:  namespace,
+		logger:     slog.Default(),
+		collectors: CollectorNames(),
+		timeout:    5 * time.Second,
+		location:   time.Local,
+		modelSpecs: DefaultModelSpecs.With(),
+		cache:      newMetricCache(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithNamespace sets the namespace of all metric names, replacing the default
+// of "apcupsd".
+func WithNamespace(ns string) Option {
+	return func(o *options) {
+		o.namespace = ns
+	}
+}
+
+// WithConstLabels adds constant labels to all metrics.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// WithLogger sets the logger used to report collection errors and warnings.
+// By default, slog.Default is used.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithLogHandler is like WithLogger, but creates a logger which writes to h.
+func WithLogHandler(h slog.Handler) Option {
+	return WithLogger(slog.New(h))
+}
+
+// WithTimestamps enables or disables explicit timestamps on all metrics.
+// When enabled, metrics are timestamped with the time apcupsd last updated
+// the UPS status, rather than the time of the scrape.
+func WithTimestamps(enable bool) Option {
+	return func(o *options) {
+		o.timestamps = enable
+	}
+}
+
+// WithCollectors sets the names of the sub-collectors used by a UPSCollector.
+// By default, all collectors returned by CollectorNames are used.  Unknown
+// names are ignored.
+func WithCollectors(names ...string) Option {
+	return func(o *options) {
+		o.collectors = names
+	}
+}
+
+// WithTimeout sets the deadline for each collection of UPS metrics by a
+// UPSCollector or Exporter.  By default, collections time out after 5
+// seconds.
+//
+// If the StatusSource of a UPSCollector implements ContextStatusSource, the
+// deadline is passed to it so that the underlying dial and I/O are canceled.
+// Otherwise, the result of a status retrieval which exceeds the deadline is
+// abandoned.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithInvalidMetricOnError enables or disables reporting collection errors
+// of a UPSCollector as an invalid metric, which fails the entire scrape.  By
+// default, errors are instead reported by the up and collect_errors_total
+// metrics so that the remainder of the scrape succeeds.
+//
+// Sub-collectors which are registered on their own always report errors as
+// an invalid metric.
+func WithInvalidMetricOnError(enable bool) Option {
+	return func(o *options) {
+		o.invalidMetricOnError = enable
+	}
+}
+
+// WithHostnameFunc sets a function which determines the value of the hostname
+// label from each retrieved status, replacing the hostname reported by
+// apcupsd.  This is useful when apcupsd reports a name such as "localhost"
+// which does not match the name of the host in an inventory.
+func WithHostnameFunc(fn func(s *apcupsd.Status) string) Option {
+	return func(o *options) {
+		o.hostname = fn
+	}
+}
+
+// WithErrorLogInterval enables deduplication of the errors logged by a
+// UPSCollector or Exporter.  The first occurrence of an error is logged, and
+// further occurrences of the same error are summarized at most once per
+// interval d, along with recovery from the error.  By default, or if d is 0,
+// every error is logged.
+func WithErrorLogInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.errorLogInterval = d
+	}
+}
+
+// WithNominalRuntime sets the runtime d which the UPS battery provides when
+// new at a load of loadPercent, as specified for the UPS model, against which
+// a BatteryCollector estimates the remaining battery capacity.  By default,
+// the capacity is estimated against the highest runtime observed, once the
+// observations span a week.
+func WithNominalRuntime(d time.Duration, loadPercent float64) Option {
+	return func(o *options) {
+		o.nominalRuntime = runtimeAtFullLoad(d, loadPercent)
+	}
+}
+
+// WithStatePath sets the path of a file in which a BatteryCollector persists
+// the state which it builds up over weeks, such as its battery capacity
+// estimate, so that the state survives restarts.  The directory of path must
+// exist.  By default, the state is only kept in memory.
+func WithStatePath(path string) Option {
+	return func(o *options) {
+		o.statePath = path
+	}
+}
+
+// WithShutdownDuration sets the time d which the systems protected by a UPS
+// take to shut down once apcupsd initiates their shutdown, which a
+// BatteryCollector subtracts from the battery runtime left, along with the
+// runtime left at which apcupsd initiates the shutdown, to report the runtime
+// margin.  By default, the systems are assumed to shut down instantly.
+func WithShutdownDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.shutdownDuration = d
+	}
+}
+
+// collectFrom retrieves the current status from ss and passes it to fn.  If
+// the status cannot be retrieved, an invalid metric using d is sent to ch.
+func (o *options) collectFrom(
+	ch chan<- prometheus.Metric,
+	ss StatusSource,
+	d *prometheus.Desc,
+	fn func(ch chan<- prometheus.Metric, s *apcupsd.Status),
+) {
+	s, err := ss.Status()
+	if err != nil {
+		o.logger.Error("failed collecting UPS metrics", "err", err)
+		ch <- prometheus.NewInvalidMetric(d, err)
+		return
+	}
+
+	o.collectStatus(ch, o.applyOverrides(s), fn)
+}
+
+// collectStatus passes s to fn, applying the hostname override to s and the
+// status timestamp to each metric sent by fn if enabled.
+func (o *options) collectStatus(
+	ch chan<- prometheus.Metric,
+	s *apcupsd.Status,
+	fn func(ch chan<- prometheus.Metric, s *apcupsd.Status),
+) {
+	if o.hostname != nil {
+		// Copy s so that the caller's status is left unmodified.
+		sc := *s
+		sc.Hostname = o.hostname(s)
+		s = &sc
+	}
+
+	if !o.timestamps {
+		fn(ch, s)
+		return
+	}
+
+	t := statusTime(s)
+
+	// Forward each metric produced by fn with the status timestamp applied.
+	tch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range tch {
+			ch <- prometheus.NewMetricWithTimestamp(t, m)
+		}
+	}()
+
+	fn(tch, s)
+	close(tch)
+	<-done
+}
+
+// WithModelSpecs adds the nominal values of UPS models to those of
+// DefaultModelSpecs, replacing the entries of any models in both.  They are
+// reported for UPSes which do not report them to apcupsd.
+func WithModelSpecs(specs ModelSpecs) Option {
+	return func(o *options) {
+		o.modelSpecs = DefaultModelSpecs.With(specs)
+	}
+}
+
+// WithServeStale enables serving the metrics of the last successful
+// collection when UPS metrics cannot be collected, as long as they are no
+// older than maxAge, rather than omitting them.  The apcupsd_data_stale and
+// apcupsd_data_age_seconds metrics report whether, and since when, the
+// metrics are stale, while apcupsd_up still reports the failure.  A maxAge of
+// zero disables serving stale metrics, which is the default.
+func WithServeStale(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.staleMaxAge = maxAge
+	}
+}
+
+// WithCompactStatus enables or disables exporting only the status flags
+// which are set, rather than a series for each status flag whose value is 1
+// if the flag is set and 0 otherwise.  This reduces the number of series per
+// UPS from one per flag to typically one, at the cost of queries which must
+// treat an absent flag as clear, and of series which start and stop as flags
+// change.  By default, every flag is exported.
+func WithCompactStatus(enable bool) Option {
+	return func(o *options) {
+		o.compactStatus = enable
+	}
+}
+
+// WithLocation sets the time zone in which timestamps reported by apcupsd are
+// interpreted if they lack a UTC offset, as on some builds of apcupsd.  By
+// default, the local time zone of the exporter is used.  WithLocation only
+// applies to Exporters created using NewWithDialFunc.
+func WithLocation(loc *time.Location) Option {
+	return func(o *options) {
+		o.location = loc
+	}
+}
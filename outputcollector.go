@@ -0,0 +1,136 @@
+package apcupsdexporter
+
+import (
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// An OutputCollector is a Prometheus collector for metrics regarding the AC
+// output and load of an APC UPS.
+type OutputCollector struct {
+	OutputVolts                      *prometheus.Desc
+	OutputVoltsNominalDeviationRatio *prometheus.Desc
+	UPSLoadPercent                   *prometheus.Desc
+	NominalPowerWatts                *prometheus.Desc
+	NominalApparentPowerVA           *prometheus.Desc
+
+	ss StatusSource
+	o  *options
+}
+
+var _ statusCollector = &OutputCollector{}
+
+// NewOutputCollector creates a new OutputCollector.
+func NewOutputCollector(ss StatusSource, opts ...Option) *OutputCollector {
+	return newOutputCollector(ss, newOptions(opts))
+}
+
+// newOutputCollector creates a new OutputCollector using the input options.
+func newOutputCollector(ss StatusSource, o *options) *OutputCollector {
+	labels := []string{"ups_name", "hostname", "model"}
+
+	return &OutputCollector{
+		OutputVolts: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "output_volts"),
+			"Current AC output voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		OutputVoltsNominalDeviationRatio: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "output_volts_nominal_deviation_ratio"),
+			"Deviation of the AC output voltage from the nominal AC input line voltage, to which the UPS regulates its output, as a ratio of the nominal voltage.",
+			labels,
+			o.constLabels,
+		),
+
+		UPSLoadPercent: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "ups_load_percent"),
+			"Current UPS load percentage.",
+			labels,
+			o.constLabels,
+		),
+
+		NominalPowerWatts: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "nominal_power_watts"),
+			"Nominal power output in watts, as set by an override (source \"override\"), reported by the UPS (source \"ups\"), or otherwise as specified for its model (source \"model_db\").",
+			append(labels, "source"),
+			o.constLabels,
+		),
+
+		NominalApparentPowerVA: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "nominal_apparent_power_va"),
+			"Nominal apparent power output in volt-amperes, as specified for the UPS model.",
+			append(labels, "source"),
+			o.constLabels,
+		),
+
+		ss: ss,
+		o:  o,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *OutputCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch,
+		c.OutputVolts,
+		c.OutputVoltsNominalDeviationRatio,
+		c.UPSLoadPercent,
+		c.NominalPowerWatts,
+		c.NominalApparentPowerVA,
+	)
+}
+
+// Collect sends the metric values for each metric created by the
+// OutputCollector to the provided prometheus Metric channel.
+func (c *OutputCollector) Collect(ch chan<- prometheus.Metric) {
+	c.o.collectFrom(ch, c.ss, c.OutputVolts, c.collectStatus)
+}
+
+// collectStatus sends output metrics derived from s to ch.
+func (c *OutputCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	ch <- c.o.cache.metric(
+		c.OutputVolts,
+		prometheus.GaugeValue,
+		s.OutputVoltage,
+		s,
+	)
+
+	// apcupsd does not report the nominal output voltage, which is that of
+	// the input line.
+	if ratio, ok := nominalDeviation(s.OutputVoltage, s.NominalInputVoltage); ok {
+		ch <- c.o.cache.metric(
+			c.OutputVoltsNominalDeviationRatio,
+			prometheus.GaugeValue,
+			ratio,
+			s,
+		)
+	}
+
+	ch <- c.o.cache.metric(
+		c.UPSLoadPercent,
+		prometheus.GaugeValue,
+		s.LoadPercent,
+		s,
+	)
+
+	power, source := c.o.nominalPower(s)
+	ch <- c.o.cache.metricWith(
+		c.NominalPowerWatts,
+		prometheus.GaugeValue,
+		power,
+		s,
+		source,
+	)
+
+	if spec, ok := c.o.modelSpecs.Lookup(s.Model); ok && spec.NominalApparentPowerVA > 0 {
+		ch <- c.o.cache.metricWith(
+			c.NominalApparentPowerVA,
+			prometheus.GaugeValue,
+			spec.NominalApparentPowerVA,
+			s,
+			sourceModelDB,
+		)
+	}
+}
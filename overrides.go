@@ -0,0 +1,87 @@
+package apcupsdexporter
+
+import (
+	"github.com/mdlayher/apcupsd"
+)
+
+// sourceOverride is the source of nominal values set by an Override.
+const sourceOverride = "override"
+
+// An Override replaces the nominal values reported by a UPS, such as those of
+// firmware which reports them wrongly.  It matches UPSes by serial number if
+// SerialNumber is set, and otherwise by model, compared case-insensitively.
+// Zero values are left as reported.
+type Override struct {
+	Model        string
+	SerialNumber string
+
+	NominalPowerWatts      float64
+	NominalInputVolts      float64
+	NominalBatteryVolts    float64
+	BatteryEnergyWattHours float64
+}
+
+// matches reports whether o applies to the UPS reporting s.
+func (o Override) matches(s *apcupsd.Status) bool {
+	if o.SerialNumber != "" {
+		return o.SerialNumber == s.SerialNumber
+	}
+
+	return o.Model != "" && normalizeModel(o.Model) == normalizeModel(s.Model)
+}
+
+// WithOverrides sets overrides of the nominal values reported by UPSes.  They
+// are applied as soon as the status of a UPS is retrieved, so that metrics
+// derived from the nominal values, and Hooks, see the corrected values.  If
+// several overrides match a UPS, those matching its serial number take
+// precedence over those matching its model, and otherwise the first one
+// applies.
+func WithOverrides(overrides ...Override) Option {
+	return func(o *options) {
+		o.overrides = overrides
+	}
+}
+
+// override returns the override which applies to the UPS reporting s, if any.
+func (o *options) override(s *apcupsd.Status) (Override, bool) {
+	var (
+		match Override
+		ok    bool
+	)
+
+	for _, ov := range o.overrides {
+		if !ov.matches(s) {
+			continue
+		}
+		if ov.SerialNumber != "" {
+			return ov, true
+		}
+		if !ok {
+			match, ok = ov, true
+		}
+	}
+
+	return match, ok
+}
+
+// applyOverrides returns s with the nominal values of the override which
+// applies to it, if any.  s itself is left unmodified.
+func (o *options) applyOverrides(s *apcupsd.Status) *apcupsd.Status {
+	ov, ok := o.override(s)
+	if !ok {
+		return s
+	}
+
+	sc := *s
+	if ov.NominalPowerWatts > 0 {
+		sc.NominalPower = int(ov.NominalPowerWatts)
+	}
+	if ov.NominalInputVolts > 0 {
+		sc.NominalInputVoltage = ov.NominalInputVolts
+	}
+	if ov.NominalBatteryVolts > 0 {
+		sc.NominalBatteryVoltage = ov.NominalBatteryVolts
+	}
+
+	return &sc
+}
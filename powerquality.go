@@ -0,0 +1,160 @@
+package apcupsdexporter
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+const (
+	// qualityWindow is the period over which power quality is scored, kept
+	// as qualityBuckets buckets of equal length.
+	qualityWindow  = 24 * time.Hour
+	qualityBuckets = 24
+
+	// The maximum penalties deducted from a perfect score of 100 for each
+	// kind of power quality problem.
+	transferPenalty  = 40
+	trimBoostPenalty = 30
+	frequencyPenalty = 30
+
+	// transferWeight is the penalty for each transfer to battery caused by
+	// the utility, up to transferPenalty.
+	transferWeight = 10
+)
+
+// A powerQuality scores the quality of the utility power supplied to each UPS
+// from 0 (worst) to 100 (best), over a sliding window.  The score combines:
+//
+//   - transfers to battery caused by the utility, such as voltage sags and
+//     swells, as opposed to self tests
+//   - the fraction of the time the UPS spends correcting the voltage with
+//     TRIM or BOOST
+//   - the average deviation of the line frequency from its nominal value,
+//     reaching the full penalty at 1 Hz
+type powerQuality struct {
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *qualityState]
+
+	// last is the most recent score, if known.
+	last  float64
+	known bool
+}
+
+// A qualityState holds the power quality observations of a single UPS.
+type qualityState struct {
+	transfers int
+	buckets   [qualityBuckets]qualityBucket
+}
+
+// A qualityBucket aggregates the observations of one part of the window.
+type qualityBucket struct {
+	start            time.Time
+	samples          int
+	trimBoost        int
+	frequencyDev     float64
+	frequencySamples int
+	utilityTransfers int
+}
+
+// observe records the observations of s, and returns the power quality score
+// of the UPS reporting s.
+func (pq *powerQuality) observe(s *apcupsd.Status) float64 {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	id := identity(s)
+	st, ok := pq.states.get(id)
+	if !ok {
+		st = &qualityState{transfers: s.NumberTransfers}
+		pq.states.put(id, st)
+	}
+
+	now := statusTime(s)
+	size := qualityWindow / qualityBuckets
+	start := now.Truncate(size)
+	b := &st.buckets[int(start.UnixNano()/int64(size))%qualityBuckets]
+	if !b.start.Equal(start) {
+		*b = qualityBucket{start: start}
+	}
+
+	b.samples++
+	if strings.Contains(s.Status, "TRIM") || strings.Contains(s.Status, "BOOST") {
+		b.trimBoost++
+	}
+	if s.LineFrequency > 0 {
+		b.frequencyDev += frequencyDeviation(s.LineFrequency)
+		b.frequencySamples++
+	}
+
+	// The transfer count is reset when apcupsd restarts.
+	if n := s.NumberTransfers - st.transfers; n > 0 && utilityTransfer(s.LastTransfer) {
+		b.utilityTransfers += n
+	}
+	st.transfers = s.NumberTransfers
+
+	pq.last, pq.known = st.score(now), true
+	return pq.last
+}
+
+// lastScore returns the most recently observed power quality score, if any.
+func (pq *powerQuality) lastScore() (float64, bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	return pq.last, pq.known
+}
+
+// score computes the score from the buckets of st within the window ending
+// at now.
+func (st *qualityState) score(now time.Time) float64 {
+	var (
+		samples, trimBoost, freqSamples, transfers int
+		freqDev                                    float64
+	)
+
+	for _, b := range st.buckets {
+		if b.samples == 0 || now.Sub(b.start) >= qualityWindow {
+			continue
+		}
+
+		samples += b.samples
+		trimBoost += b.trimBoost
+		freqDev += b.frequencyDev
+		freqSamples += b.frequencySamples
+		transfers += b.utilityTransfers
+	}
+
+	score := 100.0
+	score -= math.Min(float64(transfers*transferWeight), transferPenalty)
+	if samples > 0 {
+		score -= trimBoostPenalty * float64(trimBoost) / float64(samples)
+	}
+	if freqSamples > 0 {
+		score -= frequencyPenalty * math.Min(freqDev/float64(freqSamples), 1)
+	}
+
+	return math.Max(score, 0)
+}
+
+// frequencyDeviation returns the deviation of the line frequency f in Hz from
+// the nearer of the nominal frequencies of 50 and 60 Hz.
+func frequencyDeviation(f float64) float64 {
+	return math.Min(math.Abs(f-50), math.Abs(f-60))
+}
+
+// utilityTransfer reports whether the last transfer to battery, as described
+// by apcupsd's LASTXFER, was caused by the utility rather than deliberately.
+func utilityTransfer(reason string) bool {
+	r := strings.ToLower(reason)
+	for _, s := range []string{"self test", "forced by software", "no transfers"} {
+		if strings.Contains(r, s) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package apcupsdexporter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestPowerQuality(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		desc  string
+		steps []apcupsd.Status
+		want  float64
+	}{
+		{
+			desc: "perfect",
+			steps: []apcupsd.Status{
+				{Status: "ONLINE", LineFrequency: 60, NumberTransfers: 3, LastTransfer: "Low line voltage"},
+			},
+			want: 100,
+		},
+		{
+			desc: "utility transfers",
+			steps: []apcupsd.Status{
+				{Status: "ONLINE", NumberTransfers: 3},
+				{Status: "ONBATT", NumberTransfers: 5, LastTransfer: "High line voltage"},
+			},
+			want: 80,
+		},
+		{
+			desc: "self test",
+			steps: []apcupsd.Status{
+				{Status: "ONLINE", NumberTransfers: 3},
+				{Status: "ONBATT", NumberTransfers: 4, LastTransfer: "Automatic or explicit self test"},
+			},
+			want: 100,
+		},
+		{
+			desc: "apcupsd restarted",
+			steps: []apcupsd.Status{
+				{Status: "ONLINE", NumberTransfers: 3},
+				{Status: "ONLINE", NumberTransfers: 0},
+				{Status: "ONLINE", NumberTransfers: 1, LastTransfer: "Low line voltage"},
+			},
+			want: 90,
+		},
+		{
+			desc: "trim and boost",
+			steps: []apcupsd.Status{
+				{Status: "ONLINE TRIM"},
+				{Status: "ONLINE"},
+				{Status: "ONLINE BOOST"},
+				{Status: "ONLINE"},
+			},
+			want: 85,
+		},
+		{
+			desc: "frequency",
+			steps: []apcupsd.Status{
+				{Status: "ONLINE", LineFrequency: 49.5},
+				{Status: "ONLINE", LineFrequency: 50},
+			},
+			// Average deviation of 0.25 Hz.
+			want: 92.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var (
+				pq  powerQuality
+				got float64
+			)
+			for i, s := range tt.steps {
+				s.Date = start.Add(time.Duration(i) * time.Minute)
+				got = pq.observe(&s)
+			}
+
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("unexpected score: %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerQualityWindow(t *testing.T) {
+	var pq powerQuality
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pq.observe(&apcupsd.Status{Date: start, Status: "ONLINE TRIM"})
+	if got := pq.observe(&apcupsd.Status{Date: start.Add(time.Hour), Status: "ONLINE"}); got != 85 {
+		t.Fatalf("unexpected score within window: %v", got)
+	}
+
+	// Observations older than the window no longer count.
+	if got := pq.observe(&apcupsd.Status{Date: start.Add(qualityWindow), Status: "ONLINE"}); got != 100 {
+		t.Fatalf("unexpected score after window: %v", got)
+	}
+}
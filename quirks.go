@@ -0,0 +1,175 @@
+package apcupsdexporter
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A Quirk enables a correction of the values which the firmware of some
+// UPSes reports wrongly, by name.  It matches UPSes whose model is Model,
+// compared case-insensitively, and whose firmware begins with Firmware.
+// Empty fields match any UPS.
+type Quirk struct {
+	Name     string
+	Model    string
+	Firmware string
+}
+
+// matches reports whether q applies to the UPS reporting s.
+func (q Quirk) matches(s *apcupsd.Status) bool {
+	if q.Model != "" && normalizeModel(q.Model) != normalizeModel(s.Model) {
+		return false
+	}
+
+	return strings.HasPrefix(s.Firmware, q.Firmware)
+}
+
+// A quirk is a correction of a bogus value reported by some firmware.
+type quirk struct {
+	// bogus reports whether s holds the bogus value.
+	bogus func(s *apcupsd.Status) bool
+
+	// fix corrects the bogus value of s in place, if set.
+	fix func(s *apcupsd.Status)
+
+	// drop is the field whose metrics are omitted while it holds the bogus
+	// value, if any.
+	drop string
+}
+
+// quirks are the known quirks, by name.  A quirk which drops a field also
+// resets it to its zero value, which the estimators of the sub-collectors
+// ignore.
+var quirks = map[string]quirk{
+	// Some firmware reports an input line voltage of 0 while the UPS is
+	// online, which is impossible.
+	"line_volts_zero_online": {
+		bogus: func(s *apcupsd.Status) bool {
+			return s.LineVoltage == 0 && strings.Contains(s.Status, "ONLINE") && !strings.Contains(s.Status, "ONBATT")
+		},
+		drop: "LINEV",
+	},
+
+	// Some firmware reports a runtime left of days for single samples,
+	// far beyond that of any UPS at a load apcupsd can measure.
+	"time_left_spike": {
+		bogus: func(s *apcupsd.Status) bool { return s.TimeLeft > 24*time.Hour },
+		fix:   func(s *apcupsd.Status) { s.TimeLeft = 0 },
+		drop:  "TIMELEFT",
+	},
+
+	// Some firmware reports a battery charge above 100 percent while
+	// float charging, which is reported as 100 percent instead.
+	"battery_charge_over_100": {
+		bogus: func(s *apcupsd.Status) bool { return s.BatteryChargePercent > 100 },
+		fix:   func(s *apcupsd.Status) { s.BatteryChargePercent = 100 },
+	},
+}
+
+// QuirkNames returns the names of all known quirks in sorted order.
+func QuirkNames() []string {
+	names := make([]string, 0, len(quirks))
+	for n := range quirks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// WithQuirks enables corrections of the values which the firmware of some
+// UPSes reports wrongly, such as an input line voltage of 0 while online.
+// Quirks whose names are not among QuirkNames are ignored.  No quirks are
+// enabled by default, so that values are reported as apcupsd reports them.
+//
+// Quirks are applied by UPSCollectors and Exporters to the metrics of their
+// sub-collectors and derived metrics, but not to the status passed to Hooks.
+func WithQuirks(qs ...Quirk) Option {
+	return func(o *options) {
+		o.quirks = qs
+	}
+}
+
+// applyQuirks returns s with the bogus values of each quirk which applies to
+// it corrected, along with the fields whose metrics must be omitted.  s itself
+// is left unmodified.
+func (o *options) applyQuirks(s *apcupsd.Status) (*apcupsd.Status, []string) {
+	var (
+		sc   *apcupsd.Status
+		drop []string
+	)
+
+	for _, q := range o.quirks {
+		qk, ok := quirks[q.Name]
+		if !ok || !q.matches(s) || !qk.bogus(s) {
+			continue
+		}
+
+		if sc == nil {
+			c := *s
+			sc = &c
+		}
+
+		o.logger.Debug("corrected bogus value reported by UPS firmware", "quirk", q.Name, "firmware", s.Firmware)
+		if qk.fix != nil {
+			qk.fix(sc)
+		}
+		if qk.drop != "" {
+			drop = append(drop, qk.drop)
+		}
+	}
+
+	if sc == nil {
+		return s, nil
+	}
+
+	return sc, drop
+}
+
+// fieldDescs returns the descriptors of the metrics of the sub-collectors cs
+// which report each field which a quirk may drop.
+func fieldDescs(cs []statusCollector) map[string][]*prometheus.Desc {
+	descs := make(map[string][]*prometheus.Desc)
+	for _, c := range cs {
+		switch c := c.(type) {
+		case *InputLineCollector:
+			descs["LINEV"] = append(descs["LINEV"], c.LineVolts, c.LineVoltsNominalDeviationRatio)
+		case *BatteryCollector:
+			descs["TIMELEFT"] = append(descs["TIMELEFT"], c.BatteryTimeLeftSeconds)
+		}
+	}
+
+	return descs
+}
+
+// withoutFields returns a channel whose metrics are forwarded to ch, except
+// those reporting any of fields, and a function which must be called once
+// all metrics have been sent to wait for them to be forwarded.
+func (c *UPSCollector) withoutFields(ch chan<- prometheus.Metric, fields []string) (chan<- prometheus.Metric, func()) {
+	omit := make(map[*prometheus.Desc]bool)
+	for _, f := range fields {
+		for _, d := range c.fieldDescs[f] {
+			omit[d] = true
+		}
+	}
+
+	fch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range fch {
+			if !omit[m.Desc()] {
+				ch <- m
+			}
+		}
+	}()
+
+	return fch, func() {
+		close(fch)
+		<-done
+	}
+}
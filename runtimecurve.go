@@ -0,0 +1,99 @@
+package apcupsdexporter
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// curveSmoothing is the weight of each new runtime sample in the smoothed
+// runtime of its load bucket.
+const curveSmoothing = 0.1
+
+// A runtimeCurve predicts the runtime of each UPS at its current load from a
+// curve fitted to the runtimes it has reported at other loads, smoothing the
+// runtime reported by UPS firmware, which jumps as the load fluctuates.
+//
+// The curve is the power law runtime = a * load^-b, which approximates the
+// Peukert effect of lead-acid batteries, fitted by least squares in log-log
+// space.  Runtimes are scaled to a full charge before fitting, and the
+// prediction is scaled back to the current charge.
+type runtimeCurve struct {
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *curveState]
+}
+
+// A curveState holds the smoothed runtime at full charge of a single UPS for
+// each whole load percentage at which it has been observed.
+type curveState struct {
+	buckets map[int]float64
+}
+
+// observe records a runtime sample from s if it is usable, and returns the
+// runtime predicted for the UPS reporting s at its current load and charge,
+// if enough samples have been recorded to fit a curve.
+func (rc *runtimeCurve) observe(s *apcupsd.Status) (time.Duration, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if s.LoadPercent <= 0 || s.BatteryChargePercent <= 0 {
+		return 0, false
+	}
+
+	id := identity(s)
+	st, ok := rc.states.get(id)
+	if !ok {
+		st = &curveState{buckets: make(map[int]float64)}
+		rc.states.put(id, st)
+	}
+
+	// Runtime reported during a calibration or while on battery reflects
+	// the discharge in progress rather than the battery's capacity, so only
+	// sample it on line power.
+	b := int(math.Round(s.LoadPercent))
+	if b > 0 && s.TimeLeft > 0 && !strings.Contains(s.Status, "ONBATT") && !strings.Contains(s.Status, "CAL") {
+		rt := s.TimeLeft.Seconds() * 100 / s.BatteryChargePercent
+		if prev, ok := st.buckets[b]; ok {
+			rt = prev + curveSmoothing*(rt-prev)
+		}
+		st.buckets[b] = rt
+	}
+
+	a, exp, ok := st.fit()
+	if !ok {
+		return 0, false
+	}
+
+	rt := a * math.Pow(s.LoadPercent, -exp) * s.BatteryChargePercent / 100
+	return time.Duration(rt * float64(time.Second)), true
+}
+
+// fit fits the power law runtime = a * load^-b to the buckets of st, which
+// requires samples at two or more loads.  Each load is weighted equally,
+// regardless of how often it was observed.
+func (st *curveState) fit() (a, b float64, ok bool) {
+	n := float64(len(st.buckets))
+	if n < 2 {
+		return 0, 0, false
+	}
+
+	var sx, sy, sxx, sxy float64
+	for load, rt := range st.buckets {
+		x, y := math.Log(float64(load)), math.Log(rt)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0, false
+	}
+
+	slope := (n*sxy - sx*sy) / d
+	return math.Exp((sy - slope*sx) / n), -slope, true
+}
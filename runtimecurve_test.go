@@ -0,0 +1,61 @@
+package apcupsdexporter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+func TestRuntimeCurve(t *testing.T) {
+	var rc runtimeCurve
+
+	status := func(load, charge float64, timeLeft time.Duration, flags string) *apcupsd.Status {
+		return &apcupsd.Status{
+			UPSName:              "ups",
+			Status:               flags,
+			LoadPercent:          load,
+			BatteryChargePercent: charge,
+			TimeLeft:             timeLeft,
+		}
+	}
+
+	if _, ok := rc.observe(status(10, 100, time.Hour, "ONLINE")); ok {
+		t.Fatal("expected no prediction from samples at a single load")
+	}
+
+	// Samples on battery are ignored.
+	if _, ok := rc.observe(status(40, 100, time.Minute, "ONBATT")); ok {
+		t.Fatal("expected no prediction from samples on battery")
+	}
+
+	// Sampled at half charge, which is scaled to 600 seconds at full charge.
+	if _, ok := rc.observe(status(40, 50, 5*time.Minute, "ONLINE")); !ok {
+		t.Fatal("expected a prediction from samples at two loads")
+	}
+
+	tests := []struct {
+		load, charge float64
+		want         float64
+	}{
+		// The fitted curve passes through both samples.
+		{load: 10, charge: 100, want: 3600},
+		{load: 40, charge: 100, want: 600},
+		// 3600 * 2^-(ln 6 / ln 4).
+		{load: 20, charge: 100, want: 1469.69},
+		{load: 20, charge: 50, want: 734.85},
+	}
+
+	// Statuses without a runtime add no samples, so each prediction uses the
+	// curve fitted to the samples above.
+	for _, tt := range tests {
+		got, ok := rc.observe(status(tt.load, tt.charge, 0, "ONLINE"))
+		if !ok {
+			t.Fatalf("expected a prediction at %v%% load", tt.load)
+		}
+		if diff := math.Abs(got.Seconds() - tt.want); diff > 0.01 {
+			t.Fatalf("unexpected prediction at %v%% load and %v%% charge: %v, want %vs", tt.load, tt.charge, got, tt.want)
+		}
+	}
+}
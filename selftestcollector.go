@@ -0,0 +1,61 @@
+package apcupsdexporter
+
+import (
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A SelftestCollector is a Prometheus collector for metrics regarding the
+// self tests of an APC UPS.
+type SelftestCollector struct {
+	LastSelftestTimeSeconds *prometheus.Desc
+
+	ss StatusSource
+	o  *options
+}
+
+var _ statusCollector = &SelftestCollector{}
+
+// NewSelftestCollector creates a new SelftestCollector.
+func NewSelftestCollector(ss StatusSource, opts ...Option) *SelftestCollector {
+	return newSelftestCollector(ss, newOptions(opts))
+}
+
+// newSelftestCollector creates a new SelftestCollector using the input options.
+func newSelftestCollector(ss StatusSource, o *options) *SelftestCollector {
+	labels := []string{"ups_name", "hostname", "model"}
+
+	return &SelftestCollector{
+		LastSelftestTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "last_selftest_time_seconds"),
+			"UNIX timestamp of last selftest since apcupsd startup.",
+			labels,
+			o.constLabels,
+		),
+
+		ss: ss,
+		o:  o,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *SelftestCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch, c.LastSelftestTimeSeconds)
+}
+
+// Collect sends the metric values for each metric created by the
+// SelftestCollector to the provided prometheus Metric channel.
+func (c *SelftestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.o.collectFrom(ch, c.ss, c.LastSelftestTimeSeconds, c.collectStatus)
+}
+
+// collectStatus sends self test metrics derived from s to ch.
+func (c *SelftestCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	ch <- c.o.cache.metric(
+		c.LastSelftestTimeSeconds,
+		prometheus.GaugeValue,
+		timestamp(s.LastSelftest),
+		s,
+	)
+}
@@ -0,0 +1,217 @@
+package apcupsdexporter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+)
+
+// A SpikeFilter suppresses single-sample spikes of a gauge field of the UPS
+// status, such as the runtime left or internal temperature reported by some
+// firmware, so that alerts do not fire on noise.  Exactly one of MedianOf and
+// MaxChangePerSecond must be set.
+type SpikeFilter struct {
+	// Field is the name of the field in snake case, as in expressions of
+	// derived metrics, such as "time_left" or "internal_temp".  It must be
+	// one of SpikeFilterFields.
+	Field string
+
+	// MedianOf, if set, reports the median of the last MedianOf samples of
+	// the field, which delays real changes by about half as many samples.
+	MedianOf int
+
+	// MaxChangePerSecond, if set, holds the previous value of the field for
+	// one sample when it changes faster than this rate per second, and
+	// accepts the new value if the next sample confirms it.
+	MaxChangePerSecond float64
+}
+
+// Validate checks f for errors.
+func (f SpikeFilter) Validate() error {
+	if _, ok := spikeFields[f.Field]; !ok {
+		return fmt.Errorf("unknown field %q", f.Field)
+	}
+
+	switch {
+	case f.MedianOf < 0 || f.MaxChangePerSecond < 0 || math.IsNaN(f.MaxChangePerSecond):
+		return fmt.Errorf("field %q: the filter must not be negative", f.Field)
+	case (f.MedianOf > 0) == (f.MaxChangePerSecond > 0):
+		return fmt.Errorf("field %q: exactly one of the median and the maximum rate of change must be set", f.Field)
+	case f.MedianOf == 1:
+		return fmt.Errorf("field %q: the median must be of at least 2 samples", f.Field)
+	}
+
+	return nil
+}
+
+// WithSpikeFilters filters spikes of fields of the UPS status before any
+// metrics are derived from them, and before they are passed to Hooks.
+// Filters which are invalid are ignored.  No fields are filtered by default.
+//
+// Spikes are filtered by UPSCollectors and Exporters, which keep the recent
+// samples of each UPS, but not by sub-collectors used on their own.
+func WithSpikeFilters(filters ...SpikeFilter) Option {
+	return func(o *options) {
+		o.spikeFilters = filters
+	}
+}
+
+// A spikeField accesses a gauge field of an apcupsd.Status.
+type spikeField struct {
+	get func(s *apcupsd.Status) float64
+	set func(s *apcupsd.Status, v float64)
+}
+
+// spikeFields are the gauge fields of an apcupsd.Status which may be filtered,
+// keyed by their names in snake case.
+var spikeFields = newSpikeFields()
+
+// newSpikeFields returns the fields of an apcupsd.Status holding floating
+// point numbers or durations, keyed by their names in snake case.
+func newSpikeFields() map[string]spikeField {
+	durationType := reflect.TypeOf(time.Duration(0))
+
+	fields := make(map[string]spikeField)
+	t := reflect.TypeOf(apcupsd.Status{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		i := i
+
+		var sf spikeField
+		switch {
+		case f.Type == durationType:
+			sf = spikeField{
+				get: func(s *apcupsd.Status) float64 {
+					return time.Duration(reflect.ValueOf(s).Elem().Field(i).Int()).Seconds()
+				},
+				set: func(s *apcupsd.Status, v float64) {
+					reflect.ValueOf(s).Elem().Field(i).SetInt(int64(v * float64(time.Second)))
+				},
+			}
+		case f.Type.Kind() == reflect.Float64:
+			sf = spikeField{
+				get: func(s *apcupsd.Status) float64 {
+					return reflect.ValueOf(s).Elem().Field(i).Float()
+				},
+				set: func(s *apcupsd.Status, v float64) {
+					reflect.ValueOf(s).Elem().Field(i).SetFloat(v)
+				},
+			}
+		default:
+			continue
+		}
+
+		fields[snakeCase(f.Name)] = sf
+	}
+
+	return fields
+}
+
+// SpikeFilterFields returns the names of the fields which may be filtered by
+// a SpikeFilter in sorted order.
+func SpikeFilterFields() []string {
+	names := make([]string, 0, len(spikeFields))
+	for n := range spikeFields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// A spikeTracker holds the recent samples of the fields filtered for each UPS.
+type spikeTracker struct {
+	mu     sync.Mutex
+	states boundedMap[spikeKey, *spikeState]
+}
+
+// A spikeKey identifies a field of a single UPS.
+type spikeKey struct {
+	id    upsIdentity
+	field string
+}
+
+// A spikeState is the recent history of a field of a single UPS.
+type spikeState struct {
+	// samples are the most recent samples, for a median.
+	samples []float64
+
+	// last is the last accepted value at time at, and pending is set if the
+	// last sample was held, for a maximum rate of change.
+	last    float64
+	at      time.Time
+	pending bool
+}
+
+// filter returns s with spikes of the fields of filters suppressed.  s itself
+// is left unmodified.
+func (st *spikeTracker) filter(s *apcupsd.Status, filters []SpikeFilter) *apcupsd.Status {
+	if len(filters) == 0 {
+		return s
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sc := *s
+	for _, f := range filters {
+		if f.Validate() != nil {
+			continue
+		}
+
+		k := spikeKey{id: identity(s), field: f.Field}
+		state, ok := st.states.get(k)
+		if !ok {
+			state = &spikeState{}
+			st.states.put(k, state)
+		}
+
+		sf := spikeFields[f.Field]
+		sf.set(&sc, state.observe(f, sf.get(s), statusTime(s)))
+	}
+
+	return &sc
+}
+
+// observe records the sample v of the field filtered by f at time t, and
+// returns its filtered value.
+func (state *spikeState) observe(f SpikeFilter, v float64, t time.Time) float64 {
+	if f.MedianOf > 0 {
+		state.samples = append(state.samples, v)
+		if len(state.samples) > f.MedianOf {
+			state.samples = state.samples[len(state.samples)-f.MedianOf:]
+		}
+
+		return median(state.samples)
+	}
+
+	if !state.at.IsZero() && !state.pending {
+		dt := t.Sub(state.at).Seconds()
+		if dt <= 0 || math.Abs(v-state.last)/dt > f.MaxChangePerSecond {
+			state.pending = true
+			return state.last
+		}
+	}
+
+	state.last, state.at, state.pending = v, t, false
+	return v
+}
+
+// median returns the median of vs, which is left unmodified.
+func median(vs []float64) float64 {
+	sorted := slices.Clone(vs)
+	slices.Sort(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,325 @@
+package apcupsdexporter
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/apcupsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upsStatus is the list of status flags which may be reported by apcupsd.
+var upsStatus = []string{
+	"CAL",           // Calibration mode
+	"TRIM",          // Smart trim active
+	"BOOST",         // Smart boost active
+	"ONLINE",        // UPS is online
+	"ONBATT",        // UPS is on battery
+	"OVERLOAD",      // UPS is overloaded
+	"LOWBATT",       // UPS has a low battery
+	"REPLACEBATT",   // UPS battery needs to be replaced
+	"NOBATT",        // UPS has no battery
+	"SLAVE",         // UPS is a slave
+	"SLAVEDOWN",     // UPS is a slave and is down
+	"COMMLOST",      // Communication has been lost
+	"SHUTTING DOWN", // UPS is shutting down
+}
+
+// statFlagShutdown is the bit of STATFLAG which apcupsd sets once it has
+// initiated a shutdown of the system.
+const statFlagShutdown = 0x200
+
+// shutdownReasons are the bits of STATFLAG which record why apcupsd initiated
+// a shutdown, by the value of the reason label.
+var shutdownReasons = []struct {
+	reason string
+	bit    uint64
+}{
+	{reason: "battery_charge", bit: 0x80000},   // BATTERYLEVEL reached
+	{reason: "battery_timeout", bit: 0x100000}, // TIMEOUT on battery reached
+	{reason: "runtime", bit: 0x200000},         // MINUTES of runtime reached
+	{reason: "emergency", bit: 0x400000},       // Battery failure while on battery
+	{reason: "remote", bit: 0x800000},          // Shutdown requested by a master
+}
+
+// A StatusCollector is a Prometheus collector for the status flags reported
+// by an APC UPS.
+type StatusCollector struct {
+	Status                         *prometheus.Desc
+	CalibrationsTotal              *prometheus.Desc
+	LastCalibrationTimeSeconds     *prometheus.Desc
+	LastCalibrationDurationSeconds *prometheus.Desc
+	ShutdownInProgress             *prometheus.Desc
+	ShutdownReason                 *prometheus.Desc
+	ShutdownStartTimeSeconds       *prometheus.Desc
+
+	ss           StatusSource
+	o            *options
+	calibrations calibrationTracker
+	shutdowns    shutdownTracker
+}
+
+var _ statusCollector = &StatusCollector{}
+
+// NewStatusCollector creates a new StatusCollector.
+func NewStatusCollector(ss StatusSource, opts ...Option) *StatusCollector {
+	return newStatusCollector(ss, newOptions(opts))
+}
+
+// newStatusCollector creates a new StatusCollector using the input options.
+func newStatusCollector(ss StatusSource, o *options) *StatusCollector {
+	labels := []string{"ups_name", "hostname", "model"}
+
+	return &StatusCollector{
+		Status: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "status"),
+			"Current UPS status.",
+			[]string{"ups_name", "hostname", "model", "status"},
+			o.constLabels,
+		),
+
+		CalibrationsTotal: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "calibrations_total"),
+			"Total number of UPS runtime calibrations (CAL status) which started after the exporter started.",
+			labels,
+			o.constLabels,
+		),
+
+		LastCalibrationTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "last_calibration_time_seconds"),
+			"UNIX timestamp at which the last observed UPS runtime calibration started.",
+			labels,
+			o.constLabels,
+		),
+
+		LastCalibrationDurationSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "last_calibration_duration_seconds"),
+			"Duration in seconds of the last completed UPS runtime calibration.",
+			labels,
+			o.constLabels,
+		),
+
+		ShutdownInProgress: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "shutdown_in_progress"),
+			"Whether apcupsd has initiated a shutdown of the system (1 for yes, 0 for no).",
+			labels,
+			o.constLabels,
+		),
+
+		ShutdownReason: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "shutdown_reason"),
+			"Whether apcupsd initiated the shutdown in progress for the reason (1 for yes, 0 for no).",
+			[]string{"ups_name", "hostname", "model", "reason"},
+			o.constLabels,
+		),
+
+		ShutdownStartTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "shutdown_start_time_seconds"),
+			"UNIX timestamp at which the shutdown in progress was first observed.",
+			labels,
+			o.constLabels,
+		),
+
+		ss: ss,
+		o:  o,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *StatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch,
+		c.Status,
+		c.CalibrationsTotal,
+		c.LastCalibrationTimeSeconds,
+		c.LastCalibrationDurationSeconds,
+		c.ShutdownInProgress,
+		c.ShutdownReason,
+		c.ShutdownStartTimeSeconds,
+	)
+}
+
+// Collect sends the metric values for each metric created by the
+// StatusCollector to the provided prometheus Metric channel.
+func (c *StatusCollector) Collect(ch chan<- prometheus.Metric) {
+	c.o.collectFrom(ch, c.ss, c.Status, c.collectStatus)
+}
+
+// collectStatus sends status flag metrics derived from s to ch.
+func (c *StatusCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	for _, status := range upsStatus {
+		value := float64(0)
+		if strings.Contains(s.Status, status) {
+			value = float64(1)
+		} else if c.o.compactStatus {
+			continue
+		}
+		ch <- c.o.cache.metricWith(
+			c.Status,
+			prometheus.GaugeValue,
+			value,
+			s,
+			status,
+		)
+	}
+	cal := c.calibrations.observe(s)
+
+	ch <- c.o.cache.metric(
+		c.CalibrationsTotal,
+		prometheus.CounterValue,
+		float64(cal.total),
+		s,
+	)
+
+	if cal.total > 0 {
+		ch <- c.o.cache.metric(
+			c.LastCalibrationTimeSeconds,
+			prometheus.GaugeValue,
+			timestamp(cal.start),
+			s,
+		)
+	}
+
+	// The duration is only known once a calibration completes.
+	if cal.duration > 0 {
+		ch <- c.o.cache.metric(
+			c.LastCalibrationDurationSeconds,
+			prometheus.GaugeValue,
+			cal.duration.Seconds(),
+			s,
+		)
+	}
+
+	c.collectShutdown(ch, s)
+}
+
+// collectShutdown sends metrics describing a shutdown initiated by apcupsd,
+// derived from s, to ch.
+func (c *StatusCollector) collectShutdown(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	// STATFLAG is absent from the status of some apcupsd versions, in which
+	// case only the STATUS field indicates a shutdown and its reason is
+	// unknown.
+	flags, err := strconv.ParseUint(strings.TrimPrefix(s.StatusFlags, "0x"), 16, 64)
+	known := err == nil
+
+	shutdown := strings.Contains(s.Status, "SHUTTING DOWN") || (known && flags&statFlagShutdown != 0)
+	start := c.shutdowns.observe(s, shutdown)
+
+	var value float64
+	if shutdown {
+		value = 1
+	}
+	ch <- c.o.cache.metric(
+		c.ShutdownInProgress,
+		prometheus.GaugeValue,
+		value,
+		s,
+	)
+
+	if !shutdown {
+		return
+	}
+
+	ch <- c.o.cache.metric(
+		c.ShutdownStartTimeSeconds,
+		prometheus.GaugeValue,
+		timestamp(start),
+		s,
+	)
+
+	if !known {
+		return
+	}
+	for _, r := range shutdownReasons {
+		value := float64(0)
+		if flags&r.bit != 0 {
+			value = 1
+		}
+		ch <- c.o.cache.metricWith(
+			c.ShutdownReason,
+			prometheus.GaugeValue,
+			value,
+			s,
+			r.reason,
+		)
+	}
+}
+
+// A calibrationTracker detects UPS runtime calibrations from the episodes in
+// which each UPS reports the CAL status flag.
+type calibrationTracker struct {
+	mu     sync.Mutex
+	states boundedMap[upsIdentity, *calibrationState]
+}
+
+// A calibrationState is the calibration history of a single UPS.
+type calibrationState struct {
+	active   bool
+	total    int
+	start    time.Time
+	duration time.Duration
+}
+
+// observe records the status flags of s, and returns the calibration history
+// of the UPS reporting s.
+func (ct *calibrationTracker) observe(s *apcupsd.Status) calibrationState {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	id := identity(s)
+	cal := strings.Contains(s.Status, "CAL")
+	st, ok := ct.states.get(id)
+	if !ok {
+		// A calibration in progress when the UPS is first observed, such
+		// as after the exporter restarts, started at an unknown time and
+		// may have been counted already, so only later ones are counted.
+		st = &calibrationState{active: cal}
+		ct.states.put(id, st)
+		return *st
+	}
+
+	switch {
+	case cal && !st.active:
+		st.active = true
+		st.total++
+		st.start = statusTime(s)
+		st.duration = 0
+	case !cal && st.active:
+		st.active = false
+		if !st.start.IsZero() {
+			st.duration = statusTime(s).Sub(st.start)
+		}
+	}
+
+	return *st
+}
+
+// A shutdownTracker records the time at which each UPS was first observed
+// reporting a shutdown in progress.
+type shutdownTracker struct {
+	mu     sync.Mutex
+	starts boundedMap[upsIdentity, time.Time]
+}
+
+// observe records whether the UPS reporting s is shutting down, and returns
+// the time at which its shutdown in progress was first observed.
+func (st *shutdownTracker) observe(s *apcupsd.Status, shutdown bool) time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	id := identity(s)
+	if !shutdown {
+		st.starts.delete(id)
+		return time.Time{}
+	}
+
+	start, ok := st.starts.get(id)
+	if !ok {
+		start = statusTime(s)
+		st.starts.put(id, start)
+	}
+
+	return start
+}
@@ -1,12 +1,14 @@
 package apcupsdexporter
 
 import (
-	"log"
+	"context"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mdlayher/apcupsd"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 )
 
 var _ StatusSource = &apcupsd.Client{}
@@ -17,354 +19,551 @@ type StatusSource interface {
 	Status() (*apcupsd.Status, error)
 }
 
+var _ ContextStatusSource = &contextSource{}
+
+// A ContextStatusSource is a StatusSource which can retrieve UPS status
+// information subject to the deadline and cancellation of a context.
+type ContextStatusSource interface {
+	StatusSource
+	StatusContext(ctx context.Context) (*apcupsd.Status, error)
+}
+
+// A contextSource adapts a StatusSource to a ContextStatusSource.  When the
+// context is done, the result of the pending status retrieval is abandoned.
+type contextSource struct {
+	StatusSource
+}
+
+// StatusContext implements ContextStatusSource.
+func (cs contextSource) StatusContext(ctx context.Context) (*apcupsd.Status, error) {
+	return statusContext(ctx, cs.Status)
+}
+
+// statusContext invokes fn in a goroutine and returns its result, or the
+// context's error if ctx is done first.
+func statusContext(ctx context.Context, fn func() (*apcupsd.Status, error)) (*apcupsd.Status, error) {
+	type result struct {
+		s   *apcupsd.Status
+		err error
+	}
+
+	// Buffered so the goroutine can exit even if its result is abandoned.
+	resC := make(chan result, 1)
+	go func() {
+		s, err := fn()
+		resC <- result{s: s, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-resC:
+		return res.s, res.err
+	}
+}
+
+var _ ContextStatusSource = &sharedSource{}
+
+// A sharedSource is a ContextStatusSource which coalesces concurrent status
+// retrievals into a single retrieval whose result is shared by each caller,
+// so that simultaneous scrapes, such as those of a highly available pair of
+// Prometheus servers, query apcupsd only once.
+type sharedSource struct {
+	ContextStatusSource
+	g singleflight.Group
+}
+
+// StatusContext implements ContextStatusSource.  The shared retrieval is
+// bounded by the context of the caller which started it, while each caller
+// stops waiting when its own context is done.
+//
+// The NIS trace of the shared retrieval is copied to that of each caller.
+func (ss *sharedSource) StatusContext(ctx context.Context) (*apcupsd.Status, error) {
+	type result struct {
+		s  *apcupsd.Status
+		tr nisTrace
+	}
+
+	resC := ss.g.DoChan("status", func() (interface{}, error) {
+		var res result
+		s, err := ss.ContextStatusSource.StatusContext(withNISTrace(ctx, &res.tr))
+		res.s = s
+		return res, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-resC:
+		res, _ := r.Val.(result)
+		*traceNIS(ctx) = res.tr
+		return res.s, r.Err
+	}
+}
+
 // A UPSCollector is a Prometheus collector for metrics regarding an APC UPS.
+// It combines each of the sub-collectors registered in this package, such as
+// BatteryCollector and StatusCollector, and retrieves the UPS status only
+// once per collection.  Concurrent collections share a single retrieval of
+// the UPS status.
 type UPSCollector struct {
-	Info *prometheus.Desc
-
-	UPSLoadPercent                      *prometheus.Desc
-	BatteryChargePercent                *prometheus.Desc
-	LineVolts                           *prometheus.Desc
-	LineNominalVolts                    *prometheus.Desc
-	OutputVolts                         *prometheus.Desc
-	BatteryVolts                        *prometheus.Desc
-	BatteryNominalVolts                 *prometheus.Desc
-	BatteryNumberTransfersTotal         *prometheus.Desc
-	BatteryTimeLeftSeconds              *prometheus.Desc
-	BatteryTimeOnSeconds                *prometheus.Desc
-	BatteryCumulativeTimeOnSecondsTotal *prometheus.Desc
-	LastTransferOnBatteryTimeSeconds    *prometheus.Desc
-	LastTransferOffBatteryTimeSeconds   *prometheus.Desc
-	LastSelftestTimeSeconds             *prometheus.Desc
-	NominalPowerWatts                   *prometheus.Desc
-	Status                              *prometheus.Desc
-	InternalTemperatureCelsius          *prometheus.Desc
-
-	ss StatusSource
+	Info                        *prometheus.Desc
+	Up                          *prometheus.Desc
+	DaemonInfo                  *prometheus.Desc
+	DaemonStartTimeSeconds      *prometheus.Desc
+	DaemonUptimeSeconds         *prometheus.Desc
+	NISLatencySeconds           *prometheus.Desc
+	PowerFailuresTotal          *prometheus.Desc
+	LastPowerFailureTimeSeconds *prometheus.Desc
+	DataStale                   *prometheus.Desc
+	DataAgeSeconds              *prometheus.Desc
+	CollectErrorsTotal          *prometheus.CounterVec
+	ParseErrorsTotal            *prometheus.CounterVec
+
+	cs     []statusCollector
+	ss     ContextStatusSource
+	es     eventSource
+	o      *options
+	errLog *errorLog
+	last   lastStatus
+	events eventHistory
+	spikes spikeTracker
+
+	// upstream holds the translations of metrics to the labels of the
+	// original apcupsd_exporter, if enabled by WithUpstreamNames.
+	upstream map[*prometheus.Desc]upstreamMetric
+
+	// derived holds the metrics added by WithDerivedMetrics.
+	derived []derivedMetric
+
+	// fieldDescs holds the descriptors of the metrics reporting each field
+	// which a quirk enabled by WithQuirks may drop.
+	fieldDescs map[string][]*prometheus.Desc
 }
 
 var _ prometheus.Collector = &UPSCollector{}
 
-// NewUPSCollector creates a new UPSCollector.
-func NewUPSCollector(ss StatusSource) *UPSCollector {
-	labels := []string{"ups_name", "hostname", "model"}
-
-	return &UPSCollector{
-		Info: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "info"),
-			"Metadata about a given UPS.",
-			[]string{"ups_name", "hostname", "model"},
-			nil,
-		),
-
-		Status: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "status"),
-			"Current UPS status.",
-			[]string{"ups_name", "hostname", "model", "status"},
-			nil,
-		),
+// NewUPSCollector creates a new UPSCollector, which may be customized using
+// Options.
+func NewUPSCollector(ss StatusSource, opts ...Option) *UPSCollector {
+	return newUPSCollector(ss, newOptions(opts))
+}
 
-		UPSLoadPercent: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "ups_load_percent"),
-			"Current UPS load percentage.",
-			labels,
-			nil,
-		),
+// newUPSCollector creates a new UPSCollector using the input options.
+func newUPSCollector(ss StatusSource, o *options) *UPSCollector {
+	cs := make([]statusCollector, 0, len(o.collectors))
+	for _, n := range o.collectors {
+		fn, ok := collectors[n]
+		if !ok {
+			continue
+		}
 
-		BatteryChargePercent: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_charge_percent"),
-			"Current UPS battery charge percentage.",
-			labels,
-			nil,
-		),
+		cs = append(cs, fn(ss, o))
+	}
 
-		LineVolts: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "line_volts"),
-			"Current AC input line voltage.",
-			labels,
-			nil,
-		),
+	css, ok := ss.(ContextStatusSource)
+	if !ok {
+		css = contextSource{StatusSource: ss}
+	}
 
-		LineNominalVolts: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "line_nominal_volts"),
-			"Nominal AC input line voltage.",
-			labels,
-			nil,
-		),
+	// Only status sources which speak the NIS protocol directly can
+	// retrieve events.
+	es, _ := ss.(eventSource)
 
-		OutputVolts: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "output_volts"),
-			"Current AC output voltage.",
-			labels,
-			nil,
+	c := &UPSCollector{
+		Info: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "info"),
+			"Metadata about a given UPS.",
+			[]string{"ups_name", "hostname", "model"},
+			o.constLabels,
 		),
 
-		BatteryVolts: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_volts"),
-			"Current UPS battery voltage.",
-			labels,
+		Up: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "up"),
+			"Whether the last collection of UPS metrics from apcupsd was successful.",
 			nil,
+			o.constLabels,
 		),
 
-		BatteryNominalVolts: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_nominal_volts"),
-			"Nominal UPS battery voltage.",
-			labels,
-			nil,
+		DaemonInfo: newDesc(
+			prometheus.BuildFQName(o.namespace, "daemon", "info"),
+			"Metadata about the apcupsd daemon reporting a given UPS: its version, and the format version of its status records, from the VERSION and APC fields.",
+			[]string{"ups_name", "hostname", "model", "version", "status_format"},
+			o.constLabels,
 		),
 
-		BatteryNumberTransfersTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_number_transfers_total"),
-			"Total number of transfers to UPS battery power.",
-			labels,
-			nil,
+		DaemonStartTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "daemon", "start_time_seconds"),
+			"UNIX timestamp at which the apcupsd daemon started.",
+			[]string{"ups_name", "hostname", "model"},
+			o.constLabels,
 		),
 
-		BatteryTimeLeftSeconds: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_time_left_seconds"),
-			"Number of seconds remaining of UPS battery power.",
-			labels,
-			nil,
+		DaemonUptimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "daemon", "uptime_seconds"),
+			"Number of seconds the apcupsd daemon has been running.",
+			[]string{"ups_name", "hostname", "model"},
+			o.constLabels,
 		),
 
-		BatteryTimeOnSeconds: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_time_on_seconds"),
-			"Number of seconds the UPS has been providing battery power due to an AC input line outage.",
-			labels,
-			nil,
+		NISLatencySeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "nis", "latency_seconds"),
+			"Duration of each phase of the last status exchange with the apcupsd Network Information Server: dialing the connection, and exchanging the status.",
+			[]string{"phase"},
+			o.constLabels,
 		),
 
-		BatteryCumulativeTimeOnSecondsTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "battery_cumulative_time_on_seconds_total"),
-			"Total number of seconds the UPS has provided battery power due to AC input line outages.",
-			labels,
-			nil,
+		PowerFailuresTotal: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "power_failures_total"),
+			"Total number of utility power failures which caused transfers to battery, including those in the events logged by apcupsd before the exporter started.",
+			[]string{"ups_name", "hostname", "model"},
+			o.constLabels,
 		),
 
-		LastTransferOnBatteryTimeSeconds: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "last_transfer_on_battery_time_seconds"),
-			"UNIX timestamp of last transfer to battery since apcupsd startup.",
-			labels,
-			nil,
+		LastPowerFailureTimeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "", "last_power_failure_time_seconds"),
+			"UNIX timestamp of the last utility power failure which caused a transfer to battery.",
+			[]string{"ups_name", "hostname", "model"},
+			o.constLabels,
 		),
 
-		LastTransferOffBatteryTimeSeconds: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "last_transfer_off_battery_time_seconds"),
-			"UNIX timestamp of last transfer from battery since apcupsd startup.",
-			labels,
+		DataStale: newDesc(
+			prometheus.BuildFQName(o.namespace, "data", "stale"),
+			"Whether the UPS metrics are those of the last successful collection, served because the current collection failed (1 for yes, 0 for no).",
 			nil,
+			o.constLabels,
 		),
 
-		LastSelftestTimeSeconds: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "last_selftest_time_seconds"),
-			"UNIX timestamp of last selftest since apcupsd startup.",
-			labels,
+		DataAgeSeconds: newDesc(
+			prometheus.BuildFQName(o.namespace, "data", "age_seconds"),
+			"Number of seconds since the UPS metrics were collected.",
 			nil,
+			o.constLabels,
 		),
 
-		NominalPowerWatts: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "nominal_power_watts"),
-			"Nominal power output in watts.",
-			labels,
-			nil,
-		),
+		CollectErrorsTotal: newCollectErrorsTotal(o),
+		ParseErrorsTotal:   newParseErrorsTotal(o),
 
-		InternalTemperatureCelsius: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "internal_temperature_celsius"),
-			"Internal temperature in °C.",
-			labels,
-			nil,
-		),
+		cs:     cs,
+		ss:     &sharedSource{ContextStatusSource: css},
+		es:     es,
+		o:      o,
+		errLog: &errorLog{interval: o.errorLogInterval},
 
-		ss: ss,
+		derived:    newDerivedMetrics(o),
+		fieldDescs: fieldDescs(cs),
+	}
+	if o.upstreamNames {
+		c.upstream = newUpstreamMetrics(c)
 	}
+
+	return c
 }
 
 // Describe sends the descriptors of each metric over to the provided channel.
 // The corresponding metric values are sent separately.
 func (c *UPSCollector) Describe(ch chan<- *prometheus.Desc) {
-	ds := []*prometheus.Desc{
-		c.Info,
-		c.Status,
-		c.UPSLoadPercent,
-		c.BatteryChargePercent,
-		c.LineVolts,
-		c.LineNominalVolts,
-		c.OutputVolts,
-		c.BatteryVolts,
-		c.BatteryNominalVolts,
-		c.BatteryNumberTransfersTotal,
-		c.BatteryTimeLeftSeconds,
-		c.BatteryTimeOnSeconds,
-		c.BatteryCumulativeTimeOnSecondsTotal,
-		c.LastTransferOnBatteryTimeSeconds,
-		c.LastTransferOffBatteryTimeSeconds,
-		c.LastSelftestTimeSeconds,
-		c.NominalPowerWatts,
-		c.InternalTemperatureCelsius,
+	if c.upstream == nil {
+		c.describe(ch)
+		return
 	}
 
-	for _, d := range ds {
-		ch <- d
+	// Describe the translated metrics instead of their counterparts.
+	dch := make(chan *prometheus.Desc)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for d := range dch {
+			if um, ok := c.upstream[d]; ok {
+				d = um.d
+			}
+			ch <- d
+		}
+	}()
+
+	c.describe(dch)
+	close(dch)
+	<-done
+}
+
+// describe sends the descriptors of each metric to ch.
+func (c *UPSCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Info
+	ch <- c.Up
+	ch <- c.DaemonInfo
+	ch <- c.DaemonStartTimeSeconds
+	ch <- c.DaemonUptimeSeconds
+	ch <- c.NISLatencySeconds
+	ch <- c.PowerFailuresTotal
+	ch <- c.LastPowerFailureTimeSeconds
+	ch <- c.DataStale
+	ch <- c.DataAgeSeconds
+	c.CollectErrorsTotal.Describe(ch)
+	c.ParseErrorsTotal.Describe(ch)
+
+	for _, sc := range c.cs {
+		sc.Describe(ch)
+	}
+	for _, m := range c.derived {
+		ch <- m.d
 	}
+
+	c.o.describeHooks(ch)
 }
 
 // Collect sends the metric values for each metric created by the UPSCollector
 // to the provided prometheus Metric channel.
 func (c *UPSCollector) Collect(ch chan<- prometheus.Metric) {
-	s, err := c.ss.Status()
-	if err != nil {
-		log.Printf("failed collecting UPS metrics: %v", err)
-		ch <- prometheus.NewInvalidMetric(c.Info, err)
+	if c.upstream == nil && c.o.targetLabels == nil {
+		c.collect(ch)
 		return
 	}
 
-	upsStatus := []string{
-		"CAL",           // Calibration mode
-		"TRIM",          // Smart trim active
-		"BOOST",         // Smart boost active
-		"ONLINE",        // UPS is online
-		"ONBATT",        // UPS is on battery
-		"OVERLOAD",      // UPS is overloaded
-		"LOWBATT",       // UPS has a low battery
-		"REPLACEBATT",   // UPS battery needs to be replaced
-		"NOBATT",        // UPS has no battery
-		"SLAVE",         // UPS is a slave
-		"SLAVEDOWN",     // UPS is a slave and is down
-		"COMMLOST",      // Communication has been lost
-		"SHUTTING DOWN", // UPS is shutting down
+	// Translate the metrics which the original apcupsd_exporter also
+	// exports, and add the target labels.
+	mch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range mch {
+			if um, ok := c.upstream[m.Desc()]; ok {
+				m = um.translate(m)
+			}
+			if c.o.targetLabels != nil {
+				m = labeledMetric{Metric: m, pairs: c.o.targetLabels}
+			}
+			ch <- m
+		}
+	}()
+
+	c.collect(mch)
+	close(mch)
+	<-done
+}
+
+// collect sends the metric values for each metric to ch.
+func (c *UPSCollector) collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.o.timeout)
+	defer cancel()
+
+	var tr nisTrace
+	ctx = withNISTrace(ctx, &tr)
+
+	ctx, err := c.o.before(ctx)
+	var s *apcupsd.Status
+	if err == nil {
+		s, err = c.ss.StatusContext(ctx)
 	}
+	if err == nil {
+		s = c.spikes.filter(c.o.applyOverrides(s), c.o.spikeFilters)
+	}
+	defer func() { c.o.after(ctx, ch, s, err) }()
+
+	if err != nil {
+		reason := errorReason(err)
+		c.errLog.failed(c.o.logger, reason, err)
+		c.CollectErrorsTotal.WithLabelValues(reason).Inc()
+
+		ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 0)
+		c.CollectErrorsTotal.Collect(ch)
+		c.ParseErrorsTotal.Collect(ch)
+
+		if ls, age, ok := c.last.get(c.o.staleMaxAge); ok {
+			ch <- prometheus.MustNewConstMetric(c.DataStale, prometheus.GaugeValue, 1)
+			ch <- prometheus.MustNewConstMetric(c.DataAgeSeconds, prometheus.GaugeValue, age.Seconds())
+			c.o.collectStatus(ch, ls, c.collectStatus)
+			return
+		}
 
-	for _, status := range upsStatus {
-		value := float64(0)
-		if strings.Contains(s.Status, status) {
-			value = float64(1)
+		if c.o.invalidMetricOnError {
+			ch <- prometheus.NewInvalidMetric(c.Info, err)
 		}
-		ch <- prometheus.MustNewConstMetric(
-			c.Status,
-			prometheus.GaugeValue,
-			value,
-			s.UPSName, s.Hostname, s.Model, status,
-		)
+		return
+	}
+
+	c.errLog.succeeded(c.o.logger)
+	if c.o.staleMaxAge > 0 {
+		c.last.set(s)
+		ch <- prometheus.MustNewConstMetric(c.DataStale, prometheus.GaugeValue, 0)
+		ch <- prometheus.MustNewConstMetric(c.DataAgeSeconds, prometheus.GaugeValue, 0)
 	}
 
-	ch <- prometheus.MustNewConstMetric(
+	// Fields which could not be parsed are omitted, rather than failing the
+	// collection of all others.
+	for _, fe := range tr.fieldErrors {
+		c.o.logger.Debug("failed to parse UPS status field", "field", fe.field, "err", fe.err)
+		c.ParseErrorsTotal.WithLabelValues(fe.field).Inc()
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 1)
+	c.CollectErrorsTotal.Collect(ch)
+	c.ParseErrorsTotal.Collect(ch)
+
+	// Only status sources which speak the NIS protocol directly record
+	// their latency.
+	if tr.exchange > 0 {
+		ch <- prometheus.MustNewConstMetric(c.NISLatencySeconds, prometheus.GaugeValue, tr.dial.Seconds(), "dial")
+		ch <- prometheus.MustNewConstMetric(c.NISLatencySeconds, prometheus.GaugeValue, tr.exchange.Seconds(), "exchange")
+	}
+
+	c.o.collectStatus(ch, s, c.collectStatus)
+}
+
+// collectStatus sends the metrics of each sub-collector derived from s to ch.
+func (c *UPSCollector) collectStatus(ch chan<- prometheus.Metric, s *apcupsd.Status) {
+	s, drop := c.o.applyQuirks(s)
+	if len(drop) > 0 {
+		fch, wait := c.withoutFields(ch, drop)
+		defer wait()
+		ch = fch
+	}
+
+	ch <- c.o.cache.metric(
 		c.Info,
 		prometheus.GaugeValue,
 		1,
-		s.UPSName, s.Hostname, s.Model,
+		s,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
-		c.UPSLoadPercent,
-		prometheus.GaugeValue,
-		s.LoadPercent,
-		s.UPSName, s.Hostname, s.Model,
-	)
+	if version, format := daemonVersion(s); version != "" || format != "" {
+		ch <- prometheus.MustNewConstMetric(
+			c.DaemonInfo,
+			prometheus.GaugeValue,
+			1,
+			s.UPSName, s.Hostname, s.Model, version, format,
+		)
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryChargePercent,
-		prometheus.GaugeValue,
-		s.BatteryChargePercent,
-		s.UPSName, s.Hostname, s.Model,
-	)
+	// Older versions of apcupsd do not report their start time.
+	if !s.StartTime.IsZero() {
+		ch <- c.o.cache.metric(
+			c.DaemonStartTimeSeconds,
+			prometheus.GaugeValue,
+			timestamp(s.StartTime),
+			s,
+		)
 
-	ch <- prometheus.MustNewConstMetric(
-		c.LineVolts,
-		prometheus.GaugeValue,
-		s.LineVoltage,
-		s.UPSName, s.Hostname, s.Model,
-	)
+		ch <- c.o.cache.metric(
+			c.DaemonUptimeSeconds,
+			prometheus.GaugeValue,
+			statusTime(s).Sub(s.StartTime).Seconds(),
+			s,
+		)
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.LineNominalVolts,
-		prometheus.GaugeValue,
-		s.NominalInputVoltage,
-		s.UPSName, s.Hostname, s.Model,
-	)
+	h := c.events.observe(s, c.recentEvents)
 
-	ch <- prometheus.MustNewConstMetric(
-		c.OutputVolts,
-		prometheus.GaugeValue,
-		s.OutputVoltage,
-		s.UPSName, s.Hostname, s.Model,
+	ch <- c.o.cache.metric(
+		c.PowerFailuresTotal,
+		prometheus.CounterValue,
+		float64(h.failures),
+		s,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryVolts,
-		prometheus.GaugeValue,
-		s.BatteryVoltage,
-		s.UPSName, s.Hostname, s.Model,
-	)
+	if !h.lastFailure.IsZero() {
+		ch <- c.o.cache.metric(
+			c.LastPowerFailureTimeSeconds,
+			prometheus.GaugeValue,
+			timestamp(h.lastFailure),
+			s,
+		)
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryNominalVolts,
-		prometheus.GaugeValue,
-		s.NominalBatteryVoltage,
-		s.UPSName, s.Hostname, s.Model,
-	)
+	// apcupsd forgets the last transfer when it restarts, such as after a
+	// power failure long enough to shut down its host, so fall back to the
+	// times of its logged events.
+	if (s.XOnBattery.IsZero() && !h.lastFailure.IsZero()) || (s.XOffBattery.IsZero() && !h.lastReturn.IsZero()) {
+		sc := *s
+		if sc.XOnBattery.IsZero() {
+			sc.XOnBattery = h.lastFailure
+		}
+		if sc.XOffBattery.IsZero() {
+			sc.XOffBattery = h.lastReturn
+		}
+		s = &sc
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryNumberTransfersTotal,
-		prometheus.CounterValue,
-		float64(s.NumberTransfers),
-		s.UPSName, s.Hostname, s.Model,
-	)
+	for _, sc := range c.cs {
+		sc.collectStatus(ch, s)
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryTimeLeftSeconds,
-		prometheus.GaugeValue,
-		s.TimeLeft.Seconds(),
-		s.UPSName, s.Hostname, s.Model,
-	)
+	c.collectDerived(ch, s)
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryTimeOnSeconds,
-		prometheus.GaugeValue,
-		s.TimeOnBattery.Seconds(),
-		s.UPSName, s.Hostname, s.Model,
-	)
+// recentEvents retrieves the recent events logged by apcupsd, if supported by
+// the status source.
+func (c *UPSCollector) recentEvents() []event {
+	if c.es == nil {
+		return nil
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.BatteryCumulativeTimeOnSecondsTotal,
-		prometheus.CounterValue,
-		s.CumulativeTimeOnBattery.Seconds(),
-		s.UPSName, s.Hostname, s.Model,
-	)
+	ctx, cancel := context.WithTimeout(context.Background(), c.o.timeout)
+	defer cancel()
 
-	ch <- prometheus.MustNewConstMetric(
-		c.LastTransferOnBatteryTimeSeconds,
-		prometheus.GaugeValue,
-		timestamp(s.XOnBattery),
-		s.UPSName, s.Hostname, s.Model,
-	)
+	es, err := c.es.events(ctx)
+	if err != nil {
+		c.o.logger.Debug("failed to retrieve apcupsd events", "err", err)
+		return nil
+	}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.LastTransferOffBatteryTimeSeconds,
-		prometheus.GaugeValue,
-		timestamp(s.XOffBattery),
-		s.UPSName, s.Hostname, s.Model,
-	)
+	return es
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.LastSelftestTimeSeconds,
-		prometheus.GaugeValue,
-		timestamp(s.LastSelftest),
-		s.UPSName, s.Hostname, s.Model,
-	)
+// A lastStatus records the status of the last successful collection, to be
+// served when later collections fail.
+type lastStatus struct {
+	mu sync.Mutex
+	s  *apcupsd.Status
+	at time.Time
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		c.NominalPowerWatts,
-		prometheus.GaugeValue,
-		float64(s.NominalPower),
-		s.UPSName, s.Hostname, s.Model,
-	)
+// set records s as the status of a successful collection.
+func (ls *lastStatus) set(s *apcupsd.Status) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
 
-	ch <- prometheus.MustNewConstMetric(
-		c.InternalTemperatureCelsius,
-		prometheus.GaugeValue,
-		s.InternalTemp,
-		s.UPSName, s.Hostname, s.Model,
-	)
+	ls.s, ls.at = s, time.Now()
+}
+
+// get returns the last recorded status and its age, if one was recorded no
+// longer than maxAge ago.
+func (ls *lastStatus) get(maxAge time.Duration) (*apcupsd.Status, time.Duration, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.s == nil {
+		return nil, 0, false
+	}
+
+	age := time.Since(ls.at)
+	if age > maxAge {
+		return nil, 0, false
+	}
+
+	return ls.s, age, true
+}
+
+// daemonVersion returns the version of the apcupsd daemon which reported s,
+// such as "3.14.14" from "3.14.14 (31 May 2016) debian", and the format
+// version of its status records, such as "001" from "001,036,0879".  Either
+// is empty if the daemon does not report it.
+func daemonVersion(s *apcupsd.Status) (version, format string) {
+	if f := strings.Fields(s.Version); len(f) > 0 {
+		version = f[0]
+	}
+	format, _, _ = strings.Cut(strings.TrimSpace(s.APC), ",")
+
+	return version, format
+}
+
+// statusTime returns the time at which s was reported by apcupsd, or the
+// current time if it is unknown.
+func statusTime(s *apcupsd.Status) time.Time {
+	if s.Date.IsZero() {
+		return time.Now()
+	}
+
+	return s.Date
 }
 
 func timestamp(t time.Time) float64 {
@@ -1,11 +1,23 @@
 package apcupsdexporter
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/mdlayher/apcupsd"
+	"github.com/mdlayher/apcupsd_exporter/apcupsdtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestUPSCollector(t *testing.T) {
@@ -48,25 +60,44 @@ func TestUPSCollector(t *testing.T) {
 				},
 			},
 			matches: []*regexp.Regexp{
-				regexp.MustCompile(`apcupsd_battery_charge_percent{ups="bar"} 100`),
-				regexp.MustCompile(`apcupsd_battery_cumulative_time_on_seconds_total{ups="bar"} 30`),
-				regexp.MustCompile(`apcupsd_battery_nominal_volts{ups="bar"} 12`),
-				regexp.MustCompile(`apcupsd_battery_time_left_seconds{ups="bar"} 120`),
-				regexp.MustCompile(`apcupsd_battery_time_on_seconds{ups="bar"} 10`),
-				regexp.MustCompile(`apcupsd_battery_volts{ups="bar"} 13.2`),
-				regexp.MustCompile(`apcupsd_battery_number_transfers_total{ups="bar"} 1`),
-				regexp.MustCompile(`apcupsd_info{hostname="foo",model="APC UPS",ups="bar"} 1`),
+				regexp.MustCompile(`apcupsd_battery_charge_percent{hostname="foo",model="APC UPS",ups_name="bar"} 100`),
+				regexp.MustCompile(`apcupsd_battery_cumulative_time_on_seconds_total{hostname="foo",model="APC UPS",ups_name="bar"} 30`),
+				regexp.MustCompile(`apcupsd_battery_nominal_volts{hostname="foo",model="APC UPS",ups_name="bar"} 12`),
+				regexp.MustCompile(`apcupsd_battery_time_left_seconds{hostname="foo",model="APC UPS",ups_name="bar"} 120`),
+				regexp.MustCompile(`apcupsd_battery_time_on_seconds{hostname="foo",model="APC UPS",ups_name="bar"} 10`),
+				regexp.MustCompile(`apcupsd_battery_volts{hostname="foo",model="APC UPS",ups_name="bar"} 13.2`),
+				regexp.MustCompile(`apcupsd_battery_number_transfers_total{hostname="foo",model="APC UPS",ups_name="bar"} 1`),
+				regexp.MustCompile(`apcupsd_info{hostname="foo",model="APC UPS",ups_name="bar"} 1`),
 
-				regexp.MustCompile(`apcupsd_line_nominal_volts{ups="bar"} 120`),
-				regexp.MustCompile(`apcupsd_line_volts{ups="bar"} 121.1`),
-				regexp.MustCompile(`apcupsd_output_volts{ups="bar"} 120.9`),
-				regexp.MustCompile(`apcupsd_ups_load_percent{ups="bar"} 16`),
-				regexp.MustCompile(`apcupsd_last_transfer_on_battery_time_seconds{ups="bar"} 100001`),
-				regexp.MustCompile(`apcupsd_last_transfer_off_battery_time_seconds{ups="bar"} 100002`),
-				regexp.MustCompile(`apcupsd_last_selftest_time_seconds{ups="bar"} 100003`),
-				regexp.MustCompile(`apcupsd_nominal_power_watts{ups="bar"} 50`),
-				regexp.MustCompile(`apcupsd_internal_temperature_celsius{ups="bar"} 26.4`),
-				regexp.MustCompile(`apcupsd_ups_status{status="ONLINE"} 1`),
+				regexp.MustCompile(`apcupsd_line_nominal_volts{hostname="foo",model="APC UPS",ups_name="bar"} 120`),
+				regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="APC UPS",ups_name="bar"} 121.1`),
+				regexp.MustCompile(`apcupsd_output_volts{hostname="foo",model="APC UPS",ups_name="bar"} 120.9`),
+				regexp.MustCompile(`apcupsd_ups_load_percent{hostname="foo",model="APC UPS",ups_name="bar"} 16`),
+				regexp.MustCompile(`apcupsd_last_transfer_on_battery_time_seconds{hostname="foo",model="APC UPS",ups_name="bar"} 100001`),
+				regexp.MustCompile(`apcupsd_last_transfer_off_battery_time_seconds{hostname="foo",model="APC UPS",ups_name="bar"} 100002`),
+				regexp.MustCompile(`apcupsd_last_selftest_time_seconds{hostname="foo",model="APC UPS",ups_name="bar"} 100003`),
+				regexp.MustCompile(`apcupsd_nominal_power_watts{hostname="foo",model="APC UPS",source="ups",ups_name="bar"} 50`),
+				regexp.MustCompile(`apcupsd_internal_temperature_celsius{hostname="foo",model="APC UPS",ups_name="bar"} 26.4`),
+				regexp.MustCompile(`apcupsd_line_volts_nominal_deviation_ratio{hostname="foo",model="APC UPS",ups_name="bar"} 0.00916`),
+				regexp.MustCompile(`apcupsd_output_volts_nominal_deviation_ratio{hostname="foo",model="APC UPS",ups_name="bar"} 0.0075`),
+				regexp.MustCompile(`apcupsd_battery_volts_nominal_deviation_ratio{hostname="foo",model="APC UPS",ups_name="bar"} 0.0999`),
+				regexp.MustCompile(`apcupsd_battery_volts_per_cell{hostname="foo",model="APC UPS",ups_name="bar"} 2\.(2|1999)`),
+				regexp.MustCompile(`apcupsd_status{hostname="foo",model="APC UPS",status="ONLINE",ups_name="bar"} 1`),
+			},
+		},
+		{
+			desc: "model database",
+			ss: &testStatusSource{
+				s: &apcupsd.Status{
+					Hostname: "foo",
+					Model:    "Back-UPS ES 700G",
+					UPSName:  "bar",
+				},
+			},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_nominal_power_watts{hostname="foo",model="Back-UPS ES 700G",source="model_db",ups_name="bar"} 405`),
+				regexp.MustCompile(`apcupsd_nominal_apparent_power_va{hostname="foo",model="Back-UPS ES 700G",source="model_db",ups_name="bar"} 700`),
+				regexp.MustCompile(`apcupsd_battery_nominal_energy_watt_hours{hostname="foo",model="Back-UPS ES 700G",source="model_db",ups_name="bar"} 108`),
 			},
 		},
 	}
@@ -84,12 +115,716 @@ func TestUPSCollector(t *testing.T) {
 	}
 }
 
+func TestUPSCollectorOptions(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			Date:         time.Unix(100000, 0),
+			Hostname:     "foo",
+			Model:        "APC UPS",
+			UPSName:      "bar",
+			LineVoltage:  121.1,
+			InternalTemp: 26.4,
+		},
+	}
+
+	c := NewUPSCollector(ss,
+		WithNamespace("myups"),
+		WithConstLabels(prometheus.Labels{"site": "home"}),
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithTimestamps(true),
+		WithCollectors(CollectorInputLine),
+	)
+
+	out := testCollector(t, c)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`myups_info{hostname="foo",model="APC UPS",site="home",ups_name="bar"} 1 100000000`),
+		regexp.MustCompile(`myups_line_volts{hostname="foo",model="APC UPS",site="home",ups_name="bar"} 121.1 100000000`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	if regexp.MustCompile(`internal_temperature_celsius`).Match(out) {
+		t.Fatal("output contains metrics from a disabled collector")
+	}
+}
+
+func TestUPSCollectorHostname(t *testing.T) {
+	s := &apcupsd.Status{
+		Hostname:    "localhost",
+		Model:       "APC UPS",
+		UPSName:     "bar",
+		LineVoltage: 121.1,
+	}
+
+	c := NewUPSCollector(&testStatusSource{s: s},
+		WithCollectors(CollectorInputLine),
+		WithHostnameFunc(func(s *apcupsd.Status) string {
+			return s.UPSName + ".example.com"
+		}),
+	)
+
+	out := testCollector(t, c)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_info{hostname="bar.example.com",model="APC UPS",ups_name="bar"} 1`),
+		regexp.MustCompile(`apcupsd_line_volts{hostname="bar.example.com",model="APC UPS",ups_name="bar"} 121.1`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	if s.Hostname != "localhost" {
+		t.Fatalf("status was modified: %q", s.Hostname)
+	}
+}
+
+func TestUPSCollectorUpstreamNames(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			Date:         time.Unix(100000, 0),
+			Hostname:     "foo",
+			Model:        "APC UPS",
+			UPSName:      "bar",
+			LineVoltage:  121.1,
+			NominalPower: 50.0,
+			Status:       "ONLINE",
+		},
+	}
+
+	c := NewUPSCollector(ss,
+		WithConstLabels(prometheus.Labels{"site": "home"}),
+		WithTimestamps(true),
+		WithCollectors(CollectorInputLine, CollectorOutput, CollectorStatus),
+		WithUpstreamNames(true),
+	)
+
+	out := testCollector(t, c)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_info{hostname="foo",model="APC UPS",site="home",ups="bar"} 1 100000000`),
+		regexp.MustCompile(`apcupsd_line_volts{site="home",ups="bar"} 121.1 100000000`),
+		regexp.MustCompile(`apcupsd_nominal_power_watts{site="home",ups="bar"} 50 100000000`),
+		// Metrics which the original exporter lacks are unchanged.
+		regexp.MustCompile(`apcupsd_status{hostname="foo",model="APC UPS",site="home",status="ONLINE",ups_name="bar"} 1 100000000`),
+		regexp.MustCompile(`apcupsd_up{site="home"} 1`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	if regexp.MustCompile(`apcupsd_line_volts{.*ups_name=`).Match(out) {
+		t.Fatal("output contains a translated metric with its original labels")
+	}
+}
+
+func TestUPSCollectorTargetLabels(t *testing.T) {
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			Hostname:    "foo",
+			Model:       "APC UPS",
+			UPSName:     "bar",
+			LineVoltage: 121.1,
+		},
+	}
+
+	newCollector := func(target string) *UPSCollector {
+		return NewUPSCollector(ss,
+			WithConstLabels(prometheus.Labels{"site": "home"}),
+			WithTargetLabels(prometheus.Labels{"target": target}),
+			WithCollectors(CollectorInputLine),
+		)
+	}
+	c1, c2 := newCollector("ups1"), newCollector("ups2")
+
+	if c1.Info != c2.Info || c1.CollectErrorsTotal == c2.CollectErrorsTotal {
+		t.Fatal("collectors which differ only in target labels must share descriptors, but not state")
+	}
+
+	out := testCollector(t, uncheckedCollector{c1, c2})
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="APC UPS",site="home",target="ups1",ups_name="bar"} 121.1`),
+		regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="APC UPS",site="home",target="ups2",ups_name="bar"} 121.1`),
+		regexp.MustCompile(`apcupsd_up{site="home",target="ups2"} 1`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
+// An uncheckedCollector is an unchecked prometheus.Collector which collects
+// each of its collectors.
+type uncheckedCollector []prometheus.Collector
+
+func (uncheckedCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (cs uncheckedCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range cs {
+		c.Collect(ch)
+	}
+}
+
+func TestUPSCollectorOverrides(t *testing.T) {
+	s := &apcupsd.Status{
+		Hostname:              "foo",
+		Model:                 "Back-UPS ES 700G",
+		UPSName:               "bar",
+		SerialNumber:          "4B1234P56789",
+		NominalPower:          700,
+		NominalBatteryVoltage: 24,
+	}
+
+	c := NewUPSCollector(&testStatusSource{s: s},
+		WithCollectors(CollectorBattery, CollectorOutput),
+		WithOverrides(
+			Override{Model: "back-ups es 700g", NominalPowerWatts: 400},
+			Override{SerialNumber: "4B1234P56789", NominalPowerWatts: 405, NominalBatteryVolts: 12},
+		),
+	)
+
+	out := testCollector(t, c)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_nominal_power_watts{hostname="foo",model="Back-UPS ES 700G",source="override",ups_name="bar"} 405`),
+		regexp.MustCompile(`apcupsd_battery_nominal_volts{hostname="foo",model="Back-UPS ES 700G",ups_name="bar"} 12`),
+		regexp.MustCompile(`apcupsd_battery_nominal_energy_watt_hours{hostname="foo",model="Back-UPS ES 700G",source="model_db",ups_name="bar"} 108`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	if s.NominalPower != 700 {
+		t.Fatalf("status source was modified: nominal power %d", s.NominalPower)
+	}
+}
+
+func TestUPSCollectorQuirks(t *testing.T) {
+	s := &apcupsd.Status{
+		Hostname:             "foo",
+		Model:                "Back-UPS RS 1500G",
+		UPSName:              "bar",
+		Firmware:             "878.L4 .D USB FW:L4",
+		Status:               "ONLINE",
+		LineVoltage:          0,
+		NominalInputVoltage:  120,
+		TimeLeft:             100 * time.Hour,
+		BatteryChargePercent: 104,
+	}
+
+	tests := []struct {
+		desc    string
+		quirks  []Quirk
+		match   []*regexp.Regexp
+		exclude []*regexp.Regexp
+	}{
+		{
+			desc: "disabled",
+			match: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="Back-UPS RS 1500G",ups_name="bar"} 0`),
+				regexp.MustCompile(`apcupsd_battery_time_left_seconds{hostname="foo",model="Back-UPS RS 1500G",ups_name="bar"} 360000`),
+				regexp.MustCompile(`apcupsd_battery_charge_percent{hostname="foo",model="Back-UPS RS 1500G",ups_name="bar"} 104`),
+			},
+		},
+		{
+			desc: "enabled",
+			quirks: []Quirk{
+				{Name: "line_volts_zero_online", Model: "back-ups rs 1500g", Firmware: "878.L4"},
+				{Name: "time_left_spike"},
+				{Name: "battery_charge_over_100"},
+			},
+			match: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_line_nominal_volts{hostname="foo",model="Back-UPS RS 1500G",ups_name="bar"} 120`),
+				regexp.MustCompile(`apcupsd_battery_charge_percent{hostname="foo",model="Back-UPS RS 1500G",ups_name="bar"} 100`),
+			},
+			exclude: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_line_volts{`),
+				regexp.MustCompile(`apcupsd_battery_time_left_seconds{`),
+			},
+		},
+		{
+			desc: "other firmware",
+			quirks: []Quirk{
+				{Name: "line_volts_zero_online", Firmware: "9.1"},
+				{Name: "unknown"},
+			},
+			match: []*regexp.Regexp{
+				regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="Back-UPS RS 1500G",ups_name="bar"} 0`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			out := testCollector(t, NewUPSCollector(&testStatusSource{s: s}, WithQuirks(tt.quirks...)))
+
+			for _, m := range tt.match {
+				if !m.Match(out) {
+					t.Fatalf("output failed to match regex (regexp: %v)", m)
+				}
+			}
+			for _, m := range tt.exclude {
+				if m.Match(out) {
+					t.Fatalf("output matched excluded regex (regexp: %v)", m)
+				}
+			}
+		})
+	}
+
+	if s.TimeLeft != 100*time.Hour || s.BatteryChargePercent != 104 {
+		t.Fatalf("status source was modified: %+v", s)
+	}
+}
+
+func TestUPSCollectorLogger(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewUPSCollector(
+		&testStatusSource{err: errors.New("connection refused")},
+		WithLogHandler(slog.NewTextHandler(&buf, nil)),
+	)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	_, _ = reg.Gather()
+
+	want := `level=ERROR msg="failed collecting UPS metrics" reason=unknown err="connection refused"`
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("log output does not contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestUPSCollectorErrorLogInterval(t *testing.T) {
+	var buf bytes.Buffer
+	ss := &testStatusSource{err: errors.New("connection refused")}
+	c := NewUPSCollector(ss,
+		WithLogHandler(slog.NewTextHandler(&buf, nil)),
+		WithErrorLogInterval(time.Hour),
+	)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	for i := 0; i < 3; i++ {
+		_, _ = reg.Gather()
+	}
+
+	// Repetitions of the same error within the interval are suppressed, but
+	// still counted.
+	if n := strings.Count(buf.String(), "failed collecting UPS metrics"); n != 1 {
+		t.Fatalf("expected 1 logged error, but got %d:\n%s", n, buf.String())
+	}
+	if got := testutil.ToFloat64(c.CollectErrorsTotal.WithLabelValues("unknown")); got != 3 {
+		t.Fatalf("unexpected error count: %v", got)
+	}
+
+	ss.err = nil
+	ss.s = &apcupsd.Status{}
+	_, _ = reg.Gather()
+
+	want := `level=INFO msg="recovered collecting UPS metrics" failures=3`
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("log output does not contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestUPSCollectorServeStale(t *testing.T) {
+	ss := &testStatusSource{s: &apcupsd.Status{
+		Hostname:    "foo",
+		Model:       "APC UPS",
+		UPSName:     "bar",
+		LineVoltage: 121.1,
+	}}
+	c := NewUPSCollector(ss,
+		WithCollectors(CollectorInputLine),
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithServeStale(time.Hour),
+	)
+
+	out := testCollector(t, c)
+	if m := regexp.MustCompile(`apcupsd_data_stale 0`); !m.Match(out) {
+		t.Fatalf("output failed to match regex (regexp: %v)", m)
+	}
+
+	// The metrics of the last successful collection are served after a
+	// failure, marked as stale.
+	ss.s, ss.err = nil, errors.New("connection refused")
+	out = testCollector(t, c)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 0`),
+		regexp.MustCompile(`apcupsd_data_stale 1`),
+		regexp.MustCompile(`apcupsd_data_age_seconds \S+`),
+		regexp.MustCompile(`apcupsd_line_volts{hostname="foo",model="APC UPS",ups_name="bar"} 121.1`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
+func TestUPSCollectorTimeout(t *testing.T) {
+	c := NewUPSCollector(
+		&testStatusSource{s: &apcupsd.Status{}, delay: time.Second},
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithTimeout(10*time.Millisecond),
+	)
+
+	start := time.Now()
+	out := testCollector(t, c)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("collection was not abandoned at its deadline, took %v", d)
+	}
+
+	m := regexp.MustCompile(`apcupsd_exporter_collect_errors_total{reason="timeout"} 1`)
+	if !m.Match(out) {
+		t.Fatalf("output failed to match regex (regexp: %v)", m)
+	}
+}
+
+func TestUPSCollectorConcurrent(t *testing.T) {
+	ss := &testStatusSource{
+		s:     &apcupsd.Status{UPSName: "ups"},
+		delay: 100 * time.Millisecond,
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewUPSCollector(ss))
+
+	var wg sync.WaitGroup
+	errC := make(chan error, 4)
+	for i := 0; i < cap(errC); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := reg.Gather()
+			errC <- err
+		}()
+	}
+	wg.Wait()
+	close(errC)
+
+	for err := range errC {
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+	}
+
+	if n := ss.calls.Load(); n != 1 {
+		t.Fatalf("expected concurrent collections to retrieve status once, but retrieved %d times", n)
+	}
+}
+
+func TestUPSCollectorHooks(t *testing.T) {
+	type key struct{}
+
+	var (
+		calls []string
+		got   *apcupsd.Status
+	)
+
+	extra := prometheus.NewDesc("apcupsd_hook_extra", "Extra metric sent by a hook.", nil, nil)
+	want := &apcupsd.Status{UPSName: "bar"}
+
+	c := NewUPSCollector(&testStatusSource{s: want},
+		WithHooks(Hooks{
+			Before: func(ctx context.Context) (context.Context, error) {
+				calls = append(calls, "before 1")
+				return context.WithValue(ctx, key{}, "span"), nil
+			},
+			After: func(ctx context.Context, ch chan<- prometheus.Metric, s *apcupsd.Status, err error) {
+				calls = append(calls, "after 1")
+				if v := ctx.Value(key{}); v != "span" {
+					t.Errorf("unexpected context value: %v", v)
+				}
+
+				got = s
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				ch <- prometheus.MustNewConstMetric(extra, prometheus.GaugeValue, 1)
+			},
+			Describe: func(ch chan<- *prometheus.Desc) {
+				ch <- extra
+			},
+		}),
+		WithHooks(Hooks{
+			Before: func(ctx context.Context) (context.Context, error) {
+				calls = append(calls, "before 2")
+				return ctx, nil
+			},
+			After: func(_ context.Context, _ chan<- prometheus.Metric, _ *apcupsd.Status, _ error) {
+				calls = append(calls, "after 2")
+			},
+		}),
+	)
+
+	out := testCollector(t, c)
+
+	if want := "before 1,before 2,after 2,after 1"; strings.Join(calls, ",") != want {
+		t.Fatalf("unexpected hook order:\n- want: %s\n-  got: %s", want, strings.Join(calls, ","))
+	}
+	if got != want {
+		t.Fatalf("unexpected status passed to hook: %v", got)
+	}
+
+	m := regexp.MustCompile(`apcupsd_hook_extra 1`)
+	if !m.Match(out) {
+		t.Fatalf("output failed to match regex (regexp: %v)", m)
+	}
+}
+
+func TestUPSCollectorHooksError(t *testing.T) {
+	var (
+		ss      = &testStatusSource{s: &apcupsd.Status{}}
+		hookErr = errors.New("rate limited")
+		gotErr  error
+	)
+
+	c := NewUPSCollector(ss,
+		WithLogHandler(slog.NewTextHandler(io.Discard, nil)),
+		WithHooks(Hooks{
+			Before: func(ctx context.Context) (context.Context, error) {
+				return ctx, hookErr
+			},
+			After: func(_ context.Context, _ chan<- prometheus.Metric, s *apcupsd.Status, err error) {
+				if s != nil {
+					t.Errorf("unexpected status passed to hook: %v", s)
+				}
+				gotErr = err
+			},
+		}),
+	)
+
+	out := testCollector(t, c)
+
+	if !errors.Is(gotErr, hookErr) {
+		t.Fatalf("unexpected error passed to hook: %v", gotErr)
+	}
+
+	for _, m := range []*regexp.Regexp{
+		regexp.MustCompile(`apcupsd_up 0`),
+		regexp.MustCompile(`apcupsd_exporter_collect_errors_total{reason="hook"} 1`),
+	} {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+}
+
 var _ StatusSource = &testStatusSource{}
 
+func TestUPSCollectorSpikeFilters(t *testing.T) {
+	ss := &testStatusSource{}
+	c := NewUPSCollector(ss, WithSpikeFilters(
+		SpikeFilter{Field: "time_left", MaxChangePerSecond: 10},
+		SpikeFilter{Field: "internal_temp", MedianOf: 3},
+	))
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		timeLeft     time.Duration
+		internalTemp float64
+		match        []string
+	}{
+		{
+			timeLeft:     30 * time.Minute,
+			internalTemp: 30,
+			match:        []string{`apcupsd_battery_time_left_seconds{[^}]*} 1800`, `apcupsd_internal_temperature_celsius{[^}]*} 30`},
+		},
+		{
+			// Both spike for a single sample.
+			timeLeft:     100 * time.Hour,
+			internalTemp: 900,
+			match:        []string{`apcupsd_battery_time_left_seconds{[^}]*} 1800`, `apcupsd_internal_temperature_celsius{[^}]*} 465`},
+		},
+		{
+			timeLeft:     29 * time.Minute,
+			internalTemp: 31,
+			match:        []string{`apcupsd_battery_time_left_seconds{[^}]*} 1740`, `apcupsd_internal_temperature_celsius{[^}]*} 31`},
+		},
+		{
+			// A lasting change is accepted once confirmed.
+			timeLeft: 5 * time.Minute,
+			match:    []string{`apcupsd_battery_time_left_seconds{[^}]*} 1740`},
+		},
+		{
+			timeLeft: 5 * time.Minute,
+			match:    []string{`apcupsd_battery_time_left_seconds{[^}]*} 300`},
+		},
+	}
+
+	for i, st := range steps {
+		ss.s = &apcupsd.Status{
+			Hostname:     "foo",
+			Model:        "Back-UPS RS 1500G",
+			UPSName:      "bar",
+			Date:         start.Add(time.Duration(i) * time.Minute),
+			TimeLeft:     st.timeLeft,
+			InternalTemp: st.internalTemp,
+		}
+
+		out := testCollector(t, c)
+		for _, m := range st.match {
+			if !regexp.MustCompile(m).Match(out) {
+				t.Fatalf("step %d: output failed to match regex (regexp: %v)", i, m)
+			}
+		}
+	}
+
+	if ss.s.TimeLeft != 5*time.Minute {
+		t.Fatal("status of the source was modified")
+	}
+}
+
+func TestSpikeFilterValidate(t *testing.T) {
+	tests := []struct {
+		f  SpikeFilter
+		ok bool
+	}{
+		{f: SpikeFilter{Field: "time_left", MedianOf: 3}, ok: true},
+		{f: SpikeFilter{Field: "internal_temp", MaxChangePerSecond: 0.5}, ok: true},
+		{f: SpikeFilter{Field: "status", MedianOf: 3}},
+		{f: SpikeFilter{Field: "time_left"}},
+		{f: SpikeFilter{Field: "time_left", MedianOf: 3, MaxChangePerSecond: 1}},
+		{f: SpikeFilter{Field: "time_left", MedianOf: 1}},
+		{f: SpikeFilter{Field: "time_left", MaxChangePerSecond: -1}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.f.Validate(); (err == nil) != tt.ok {
+			t.Fatalf("%+v: unexpected error: %v", tt.f, err)
+		}
+	}
+}
+
 type testStatusSource struct {
-	s *apcupsd.Status
+	s     *apcupsd.Status
+	err   error
+	delay time.Duration
+	calls atomic.Int32
 }
 
 func (ss *testStatusSource) Status() (*apcupsd.Status, error) {
-	return ss.s, nil
+	ss.calls.Add(1)
+	time.Sleep(ss.delay)
+	return ss.s, ss.err
+}
+
+func BenchmarkUPSCollector(b *testing.B) {
+	c := NewUPSCollector(&testStatusSource{
+		s: &apcupsd.Status{
+			Hostname: "foo",
+			Model:    "APC UPS",
+			UPSName:  "bar",
+			Status:   "ONLINE",
+		},
+	})
+
+	benchmarkCollector(b, c)
+}
+
+// BenchmarkNewUPSCollector measures the memory held by the collector of each
+// of many targets, labeled with constant labels or target labels.
+func BenchmarkNewUPSCollector(b *testing.B) {
+	ss := &testStatusSource{s: &apcupsd.Status{}}
+
+	tests := []struct {
+		name string
+		opt  func(labels prometheus.Labels) Option
+	}{
+		{name: "const labels", opt: WithConstLabels},
+		{name: "target labels", opt: WithTargetLabels},
+	}
+
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = NewUPSCollector(ss, tt.opt(prometheus.Labels{"target": strconv.Itoa(i)}))
+			}
+		})
+	}
+}
+
+func BenchmarkExporter(b *testing.B) {
+	s := apcupsdtest.NewServer(apcupsdtest.Status(apcupsdtest.DefaultStatus...))
+	defer s.Close()
+
+	e := New(func(_ context.Context) (*apcupsd.Client, error) {
+		return s.Pipe(), nil
+	})
+
+	benchmarkCollector(b, e)
+}
+
+// benchmarkCollector repeatedly collects metrics from c, discarding them.
+func benchmarkCollector(b *testing.B, c prometheus.Collector) {
+	b.Helper()
+
+	ch := make(chan prometheus.Metric, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range ch {
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.Collect(ch)
+	}
+
+	close(ch)
+	<-done
+}
+
+func TestUPSCollectorDerivedMetrics(t *testing.T) {
+	watts, err := ParseExpr("load_percent / 100 * nominal_power")
+	if err != nil {
+		t.Fatalf("failed to parse expression: %v", err)
+	}
+	perVolt, err := ParseExpr("1 / line_voltage")
+	if err != nil {
+		t.Fatalf("failed to parse expression: %v", err)
+	}
+
+	ss := &testStatusSource{
+		s: &apcupsd.Status{
+			UPSName:      "ups",
+			LoadPercent:  16,
+			NominalPower: 865,
+		},
+	}
+	out := testCollector(t, NewUPSCollector(ss, WithDerivedMetrics(
+		DerivedMetric{Name: "output_watts", Help: "Output power in watts.", Expr: watts},
+		DerivedMetric{Name: "per_volt", Expr: perVolt},
+	)))
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`# HELP apcupsd_output_watts Output power in watts.`),
+		regexp.MustCompile(`apcupsd_output_watts{hostname="",model="",ups_name="ups"} 138.4`),
+	}
+	for _, m := range matches {
+		if !m.Match(out) {
+			t.Fatalf("output failed to match regex (regexp: %v)", m)
+		}
+	}
+
+	// The line voltage is not reported, so its inverse is omitted.
+	if m := regexp.MustCompile(`apcupsd_per_volt{`); m.Match(out) {
+		t.Fatalf("output unexpectedly matched regex (regexp: %v)", m)
+	}
 }
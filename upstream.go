@@ -0,0 +1,125 @@
+package apcupsdexporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// WithUpstreamNames enables or disables exporting the metrics of a
+// UPSCollector which the original apcupsd_exporter also exports with its
+// labels: a single ups label holding the UPS name, except for the info
+// metric, which is labeled with ups, hostname, and model.  This eases a
+// migration from that exporter, as its recording rules and dashboards keep
+// working.
+//
+// A metric cannot be exported with two sets of labels, so the translated
+// metrics replace their counterparts, while metrics which the original
+// exporter lacks are exported unchanged.  WithUpstreamNames has no effect on
+// sub-collectors which are registered on their own.
+func WithUpstreamNames(enable bool) Option {
+	return func(o *options) {
+		o.upstreamNames = enable
+	}
+}
+
+// An upstreamMetric describes the translation of a metric to the labels of
+// the original apcupsd_exporter.
+type upstreamMetric struct {
+	d *prometheus.Desc
+
+	// from holds the names of the labels of the metric whose values become
+	// those of the labels of d, in order.
+	from []string
+}
+
+// newUpstreamMetrics returns the translations of the metrics of c which the
+// original apcupsd_exporter also exports, keyed by their descriptors.
+func newUpstreamMetrics(c *UPSCollector) map[*prometheus.Desc]upstreamMetric {
+	o := c.o
+	ms := map[*prometheus.Desc]upstreamMetric{
+		c.Info: {
+			d: newDesc(
+				prometheus.BuildFQName(o.namespace, "", "info"),
+				"Metadata about a given UPS.",
+				[]string{"ups", "hostname", "model"},
+				o.constLabels,
+			),
+			from: []string{"ups_name", "hostname", "model"},
+		},
+	}
+
+	add := func(d *prometheus.Desc, name, help string) {
+		ms[d] = upstreamMetric{
+			d: newDesc(
+				prometheus.BuildFQName(o.namespace, "", name),
+				help,
+				[]string{"ups"},
+				o.constLabels,
+			),
+			from: []string{"ups_name"},
+		}
+	}
+
+	for _, sc := range c.cs {
+		switch sc := sc.(type) {
+		case *BatteryCollector:
+			add(sc.BatteryChargePercent, "battery_charge_percent", "Current battery charge percentage.")
+			add(sc.BatteryVolts, "battery_volts", "Current battery voltage.")
+			add(sc.BatteryNominalVolts, "battery_nominal_volts", "Nominal battery voltage.")
+			add(sc.BatteryNumberTransfersTotal, "battery_number_transfers_total", "Total number of transfers to UPS battery power.")
+			add(sc.BatteryTimeLeftSeconds, "battery_time_left_seconds", "Number of seconds remaining of UPS battery power.")
+			add(sc.BatteryTimeOnSeconds, "battery_time_on_seconds", "Number of seconds the UPS has been providing battery power due to an AC input line outage.")
+			add(sc.BatteryCumulativeTimeOnSecondsTotal, "battery_cumulative_time_on_seconds_total", "Total number of seconds the UPS has provided battery power due to AC input line outages.")
+			add(sc.LastTransferOnBatteryTimeSeconds, "last_transfer_on_battery_time_seconds", "UNIX timestamp of last transfer to battery since apcupsd startup.")
+			add(sc.LastTransferOffBatteryTimeSeconds, "last_transfer_off_battery_time_seconds", "UNIX timestamp of last transfer from battery since apcupsd startup.")
+		case *InputLineCollector:
+			add(sc.LineVolts, "line_volts", "Current AC input line voltage.")
+			add(sc.LineNominalVolts, "line_nominal_volts", "Nominal AC input line voltage.")
+		case *OutputCollector:
+			add(sc.OutputVolts, "output_volts", "Current AC output voltage.")
+			add(sc.UPSLoadPercent, "ups_load_percent", "Current UPS load percentage.")
+			add(sc.NominalPowerWatts, "nominal_power_watts", "Nominal power output in watts.")
+		case *SelftestCollector:
+			add(sc.LastSelftestTimeSeconds, "last_selftest_time_seconds", "UNIX timestamp of last selftest since apcupsd startup.")
+		case *EnvironmentCollector:
+			add(sc.InternalTemperatureCelsius, "internal_temperature_celsius", "Internal temperature in °C.")
+		}
+	}
+
+	return ms
+}
+
+// translate returns m with the name and labels of the original
+// apcupsd_exporter.
+func (um upstreamMetric) translate(m prometheus.Metric) prometheus.Metric {
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		return prometheus.NewInvalidMetric(um.d, err)
+	}
+
+	values := make(map[string]string, len(out.Label))
+	for _, p := range out.Label {
+		values[p.GetName()] = p.GetValue()
+	}
+	lvs := make([]string, 0, len(um.from))
+	for _, l := range um.from {
+		lvs = append(lvs, values[l])
+	}
+
+	vt, v := prometheus.GaugeValue, out.GetGauge().GetValue()
+	if out.Counter != nil {
+		vt, v = prometheus.CounterValue, out.GetCounter().GetValue()
+	}
+
+	tm, err := prometheus.NewConstMetric(um.d, vt, v, lvs...)
+	if err != nil {
+		return prometheus.NewInvalidMetric(um.d, err)
+	}
+	if out.TimestampMs != nil {
+		tm = prometheus.NewMetricWithTimestamp(time.UnixMilli(out.GetTimestampMs()), tm)
+	}
+
+	return tm
+}